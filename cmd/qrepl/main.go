@@ -9,7 +9,8 @@ import (
 func main() {
 	var printLexerOutput = flag.Bool("lexer-output", false, "Print output of lexer")
 	var printParserOutput = flag.Bool("parser-output", false, "Print output of parser")
+	var noColor = flag.Bool("no-color", false, "Print without colors, which is the default when the output is not a terminal")
 	flag.Parse()
 
-	qrepl.Run(*printLexerOutput, *printParserOutput)
+	qrepl.Run(*printLexerOutput, *printParserOutput, *noColor)
 }
@@ -10,7 +10,35 @@ func main() {
 	var cwd = flag.String("cwd", "", "Set the current working directory")
 	var printLexerOutput = flag.Bool("lexer-output", false, "Print output of lexer")
 	var printParserOutput = flag.Bool("parser-output", false, "Print output of parser")
+	var printConstOutput = flag.Bool("const-output", false, "Print the values of all constants")
+	var run = flag.Bool("run", false, "Compile to bytecode and run the main function")
+	var disasm = flag.Bool("disasm", false, "Print the bytecode of every function")
+	var emit = flag.String("emit", "", "Generate code in a language: c, ir, llvm, wat or wasm")
+	var output = flag.String("o", "", "Write the generated code to a file, C and LLVM code is compiled with cc or clang unless it ends with .c or .ll")
+	var o1 = flag.Bool("O1", false, "Optimise the intermediate representation")
+	var o2 = flag.Bool("O2", false, "Optimise the intermediate representation and inline small functions")
+	flag.Bool("O0", false, "Do not optimise the intermediate representation")
+	var noColor = flag.Bool("no-color", false, "Print without colors, which is the default when the output is not a terminal")
 	flag.Parse()
 
-	quartzc.Run(*cwd, *printLexerOutput, *printParserOutput)
+	optimize := 0
+	switch {
+	case *o2:
+		optimize = 2
+	case *o1:
+		optimize = 1
+	}
+
+	quartzc.Run(quartzc.Options{
+		Cwd:               *cwd,
+		PrintLexerOutput:  *printLexerOutput,
+		PrintParserOutput: *printParserOutput,
+		PrintConstOutput:  *printConstOutput,
+		Run:               *run,
+		Disasm:            *disasm,
+		Emit:              *emit,
+		Output:            *output,
+		Optimize:          optimize,
+		NoColor:           *noColor,
+	})
 }
@@ -2,7 +2,10 @@ module github.com/henryk-kramer/quartz-lang
 
 go 1.24.2
 
-require github.com/fatih/color v1.18.0
+require (
+	github.com/fatih/color v1.18.0
+	golang.org/x/text v0.34.0
+)
 
 require (
 	github.com/mattn/go-colorable v0.1.14 // indirect
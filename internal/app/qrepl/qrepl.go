@@ -6,16 +6,32 @@ import (
 	"os"
 	"strings"
 
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/analyzer"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/checker"
 	"github.com/henryk-kramer/quartz-lang/internal/pkg/cli"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/consteval"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/interpreter"
 	"github.com/henryk-kramer/quartz-lang/internal/pkg/lexer"
 	"github.com/henryk-kramer/quartz-lang/internal/pkg/parser"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/resolver"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
 )
 
 func Run(
 	printLexerOutput bool,
 	printParserOutput bool,
+	noColor bool,
 ) {
 	var console = cli.New(*bufio.NewScanner(os.Stdin))
+	if noColor {
+		console.DisableColor()
+	}
+
+	// Every accepted input is checked again together with the new one, so
+	// later inputs can use the declarations and bindings of earlier ones
+	var history []parser.Program
+	var files = util.NewFileSet()
+	var interp = interpreter.New()
 
 	for {
 		input := console.Read()
@@ -25,7 +41,7 @@ func Run(
 			continue
 		}
 
-		tokens := lexer.Run(input, "CLI")
+		tokens := lexer.Run(files.AddFile("CLI", input))
 
 		if printLexerOutput {
 			console.WriteDebug("---- Lexer Tokens ----")
@@ -38,12 +54,62 @@ func Run(
 			}
 		}
 
-		program, errors := parser.Run(tokens)
+		program, errors := parser.RunInteractive(tokens)
 
 		if printParserOutput {
 			console.WriteDebug("---- Parser AST ----")
 			console.WriteDebug("%s", program)
-			console.WriteError("%s", errors)
+		}
+
+		for _, err := range errors {
+			console.Report(err)
+		}
+
+		if len(errors) > 0 {
+			continue
+		}
+
+		programs := append(history[:len(history):len(history)], program)
+
+		var semanticErrors []error
+
+		resolution, resolveErrors := resolver.Run(programs)
+		for _, err := range resolveErrors {
+			semanticErrors = append(semanticErrors, err)
+		}
+
+		for _, err := range analyzer.Run(programs, resolution) {
+			semanticErrors = append(semanticErrors, err)
+		}
+
+		constants, constErrors := consteval.Run(programs, resolution)
+		for _, err := range constErrors {
+			semanticErrors = append(semanticErrors, err)
+		}
+
+		info, typeErrors := checker.Run(programs, resolution, constants)
+		for _, err := range typeErrors {
+			semanticErrors = append(semanticErrors, err)
+		}
+
+		for _, err := range semanticErrors {
+			console.Report(err)
+		}
+
+		if len(semanticErrors) > 0 {
+			continue
+		}
+
+		history = programs
+
+		value, t, err := interp.Run(program, info, constants)
+		if err != nil {
+			console.Report(err)
+			continue
+		}
+
+		if value != nil {
+			console.WriteSuccess("%s: %s", value, t)
 		}
 	}
 }
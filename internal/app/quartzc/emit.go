@@ -0,0 +1,147 @@
+package quartzc
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/cgen"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/cli"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/ir"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/llvm"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/wasm"
+)
+
+// emitC generates C code. An output ending with .c is written as is, any
+// other output is the executable the code is compiled to with cc, or the
+// compiler in $CC.
+func emitC(console *cli.Cli, program *ir.Program, output string) {
+	code, errors := cgen.Generate(program)
+
+	for _, err := range errors {
+		console.Report(err)
+	}
+
+	if len(errors) > 0 {
+		return
+	}
+
+	if output == "" {
+		console.Write("%s", code)
+		return
+	}
+
+	source := output
+	if !strings.HasSuffix(output, ".c") {
+		source = output + ".c"
+	}
+
+	if err := os.WriteFile(source, []byte(code), 0644); err != nil {
+		console.WriteError("%s", err)
+		return
+	}
+
+	if source == output {
+		return
+	}
+
+	compiler := os.Getenv("CC")
+	if compiler == "" {
+		compiler = "cc"
+	}
+
+	result, err := exec.Command(compiler, "-std=c99", "-O2", "-o", output, source, "-lm").CombinedOutput()
+	if err != nil {
+		console.WriteError("%s failed: %s\n%s", compiler, err, result)
+	}
+}
+
+// emitWasm generates a WebAssembly module, as text or in the binary format
+// which can only be written to a file.
+func emitWasm(console *cli.Cli, program *ir.Program, output string, binary bool) {
+	if binary && output == "" {
+		console.WriteError("The binary format needs an output file, set it with -o")
+		return
+	}
+
+	module, errors := wasm.Generate(program)
+
+	for _, err := range errors {
+		console.Report(err)
+	}
+
+	if len(errors) > 0 {
+		return
+	}
+
+	if output == "" {
+		console.Write("%s", module.Text())
+		return
+	}
+
+	content := []byte(module.Text())
+	if binary {
+		content = module.Binary()
+	}
+
+	if err := os.WriteFile(output, content, 0644); err != nil {
+		console.WriteError("%s", err)
+	}
+}
+
+// emitLLVM generates LLVM IR. An output ending with .ll is written as is, any
+// other output is the executable the IR is compiled to with clang, or the
+// compiler in $CLANG.
+func emitLLVM(console *cli.Cli, program *ir.Program, output string) {
+	code, errors := llvm.Generate(program)
+
+	for _, err := range errors {
+		console.Report(err)
+	}
+
+	if len(errors) > 0 {
+		return
+	}
+
+	if output == "" {
+		console.Write("%s", code)
+		return
+	}
+
+	source := output
+	if !strings.HasSuffix(output, ".ll") {
+		source = output + ".ll"
+	}
+
+	if err := os.WriteFile(source, []byte(code), 0644); err != nil {
+		console.WriteError("%s", err)
+		return
+	}
+
+	if source == output {
+		return
+	}
+
+	compiler := os.Getenv("CLANG")
+	if compiler == "" {
+		compiler = "clang"
+	}
+
+	result, err := exec.Command(compiler, "-O2", "-o", output, source).CombinedOutput()
+	if err != nil {
+		console.WriteError("%s failed: %s\n%s", compiler, err, result)
+	}
+}
+
+// emitIR prints the optimised SSA form of the programs or writes it to the
+// output.
+func emitIR(console *cli.Cli, program *ir.Program, output string) {
+	if output == "" {
+		console.Write("%s", program)
+		return
+	}
+
+	if err := os.WriteFile(output, []byte(program.String()), 0644); err != nil {
+		console.WriteError("%s", err)
+	}
+}
@@ -7,18 +7,45 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/analyzer"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/bytecode"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/checker"
 	"github.com/henryk-kramer/quartz-lang/internal/pkg/cli"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/consteval"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/ir"
 	"github.com/henryk-kramer/quartz-lang/internal/pkg/lexer"
 	"github.com/henryk-kramer/quartz-lang/internal/pkg/parser"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/resolver"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/types"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
 )
 
-func Run(
-	userDefinedCwd string,
-	printLexerOutput bool,
-	printParserOutput bool,
-) {
+type Options struct {
+	Cwd               string
+	PrintLexerOutput  bool
+	PrintParserOutput bool
+	PrintConstOutput  bool
+	// Run compiles the programs to bytecode and runs their main function,
+	// Disasm prints the bytecode
+	Run    bool
+	Disasm bool
+	// Emit names the language code is generated in, it is written to Output
+	// or printed if there is none
+	Emit   string
+	Output string
+	// Optimize is the level the intermediate representation is optimised
+	// with before any code is generated from it, 0 leaves it as it is built
+	Optimize int
+	// NoColor prints without colors even if the output is a terminal
+	NoColor bool
+}
+
+func Run(options Options) {
 	cwd, _ := os.Getwd()
-	cwd = filepath.Join(cwd, userDefinedCwd)
+	if options.Output != "" && !filepath.IsAbs(options.Output) {
+		options.Output = filepath.Join(cwd, options.Output)
+	}
+	cwd = filepath.Join(cwd, options.Cwd)
 	os.Chdir(cwd)
 
 	var filePaths []string
@@ -36,14 +63,21 @@ func Run(
 	})
 
 	var console = cli.New(*bufio.NewScanner(os.Stdin))
+	if options.NoColor {
+		console.DisableColor()
+	}
+
+	var files = util.NewFileSet()
+	var programs []parser.Program
+	failed := false
 
 	for _, filePath := range filePaths {
 		contentBytes, _ := os.ReadFile(filePath)
-		content := string(contentBytes)
+		file := files.AddFile(filePath, string(contentBytes))
 
-		tokens := lexer.Run(content, filePath)
+		tokens := lexer.Run(file)
 
-		if printLexerOutput {
+		if options.PrintLexerOutput {
 			console.WriteDebug("---- Lexer Tokens ----")
 			for _, token := range tokens {
 				if token.Type == lexer.WHITESPACE || token.Type == lexer.NEWLINE || token.Type == lexer.TAB {
@@ -60,10 +94,140 @@ func Run(
 
 		program, errors := parser.Run(tokens)
 
-		if printParserOutput {
+		if options.PrintParserOutput {
 			console.WriteDebug("---- Parser AST ----")
 			console.WriteDebug("%s", program)
-			console.WriteError("%s", errors)
+		}
+
+		for _, err := range errors {
+			console.Report(err)
+			failed = true
+		}
+
+		programs = append(programs, program)
+	}
+
+	// Recovery drops the declarations and statements around a syntax error,
+	// so the semantic passes would only report errors that follow from it
+	if failed {
+		return
+	}
+
+	resolution, resolveErrors := resolver.Run(programs)
+
+	for _, err := range resolveErrors {
+		console.Report(err)
+		failed = true
+	}
+
+	for _, err := range analyzer.Run(programs, resolution) {
+		console.Report(err)
+		failed = true
+	}
+
+	values, constErrors := consteval.Run(programs, resolution)
+
+	for _, err := range constErrors {
+		console.Report(err)
+		failed = true
+	}
+
+	info, typeErrors := checker.Run(programs, resolution, values)
+
+	for _, err := range typeErrors {
+		console.Report(err)
+		failed = true
+	}
+
+	if options.PrintConstOutput {
+		console.WriteDebug("---- Constants ----")
+		for _, program := range programs {
+			for _, scope := range program.Scopes {
+				for _, constant := range scope.Constants {
+					value, ok := values[constant]
+					if !ok {
+						continue
+					}
+
+					name := constant.Identifer.Name
+					if scope.Namespace != nil {
+						name = scope.Namespace.Path.String() + "::" + name
+					}
+
+					console.WriteDebug("%s = %s (%s)", name, value, value.Type)
+				}
+			}
+		}
+	}
+
+	if failed {
+		return
+	}
+
+	if options.Emit == "" && !options.Run && !options.Disasm {
+		return
+	}
+
+	// Every backend lowers the same optimised intermediate representation
+	program, buildErrors := ir.Build(programs, info, values)
+
+	for _, err := range buildErrors {
+		console.Report(err)
+	}
+
+	if len(buildErrors) > 0 {
+		return
+	}
+
+	ir.Optimize(program, options.Optimize)
+
+	switch options.Emit {
+	case "":
+	case "c":
+		emitC(console, program, options.Output)
+	case "ir":
+		emitIR(console, program, options.Output)
+	case "llvm":
+		emitLLVM(console, program, options.Output)
+	case "wat", "wasm":
+		emitWasm(console, program, options.Output, options.Emit == "wasm")
+	default:
+		console.WriteError("Unknown output language %s", options.Emit)
+	}
+
+	if !options.Run && !options.Disasm {
+		return
+	}
+
+	compiled, compileErrors := bytecode.Compile(program)
+
+	for _, err := range compileErrors {
+		console.Report(err)
+	}
+
+	if len(compileErrors) > 0 {
+		return
+	}
+
+	if options.Disasm {
+		console.WriteDebug("---- Bytecode ----")
+		console.WriteDebug("%s", compiled)
+	}
+
+	if options.Run {
+		if compiled.Main < 0 {
+			console.WriteError("No main function to run")
+			return
+		}
+
+		value, err := bytecode.New(compiled).Run()
+		if err != nil {
+			console.Report(err)
+			return
+		}
+
+		if result := compiled.Functions[compiled.Main].Result; result != types.Void {
+			console.WriteSuccess("%s: %s", bytecode.Format(value, result), result)
 		}
 	}
 }
@@ -0,0 +1,31 @@
+package analyzer
+
+import (
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/parser"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/resolver"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
+)
+
+type analyzer struct {
+	resolution *resolver.Resolution
+	errors     []diagnostics.Diagnostic
+}
+
+// Run runs the semantic checks that do not need type information on all
+// given programs. The resolution of the programs is used to look up what
+// paths and imports refer to. Exhaustiveness depends on the type of the
+// subject and is checked by the type checker through CheckCase.
+func Run(programs []parser.Program, resolution *resolver.Resolution) []diagnostics.Diagnostic {
+	a := analyzer{resolution: resolution}
+	a.checkBindings(programs)
+
+	return a.errors
+}
+
+// errorf reports an error, the returned error can be given labels, notes
+// and help until the next one is reported.
+func (a *analyzer) errorf(pos util.Position, code string, format string, args ...any) *diagnostics.Diagnostic {
+	a.errors = append(a.errors, diagnostics.Errorf(pos, code, format, args...))
+	return &a.errors[len(a.errors)-1]
+}
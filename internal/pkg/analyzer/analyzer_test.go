@@ -0,0 +1,154 @@
+package analyzer_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/analyzer"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/lexer"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/parser"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/resolver"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
+)
+
+type testStruct struct {
+	input  string
+	errors []string
+}
+
+func testHelper(t *testing.T, tests []testStruct) {
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			program, errors := parser.Run(lexer.Run(util.NewFileSet().AddFile("", test.input)))
+			if len(errors) > 0 {
+				t.Fatalf("unexpected parser errors: %s", errors)
+			}
+
+			programs := []parser.Program{program}
+			resolution, _ := resolver.Run(programs)
+
+			var msgs []string
+			for _, err := range analyzer.Run(programs, resolution) {
+				msgs = append(msgs, err.Msg)
+			}
+
+			if !reflect.DeepEqual(msgs, test.errors) {
+				t.Errorf("\n%s\n%q\n%s\n%q", "---- EXPECTED ----", test.errors, "---- ACTUAL ----", msgs)
+			}
+		})
+	}
+}
+
+// caseHelper checks every case of the input with the subject inferred from
+// its patterns.
+func caseHelper(t *testing.T, tests []testStruct) {
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			program, errors := parser.Run(lexer.Run(util.NewFileSet().AddFile("", test.input)))
+			if len(errors) > 0 {
+				t.Fatalf("unexpected parser errors: %s", errors)
+			}
+
+			var msgs []string
+			for _, scope := range program.Scopes {
+				parser.Inspect(scope, func(node any) bool {
+					if expression, ok := node.(*parser.Case); ok {
+						for _, err := range analyzer.CheckCase(expression, analyzer.InferSubject(expression)) {
+							msgs = append(msgs, err.Msg)
+						}
+					}
+
+					return true
+				})
+			}
+
+			if !reflect.DeepEqual(msgs, test.errors) {
+				t.Errorf("\n%s\n%q\n%s\n%q", "---- EXPECTED ----", test.errors, "---- ACTUAL ----", msgs)
+			}
+		})
+	}
+}
+
+func TestExhaustiveness(t *testing.T) {
+	caseHelper(t, []testStruct{
+		// Exhaustive
+		{"fn a(b: bool) { case b { true -> 1, false -> 0 } }", nil},
+		{"fn a(b: i32) { case b { 0 -> 1, _ -> 0 } }", nil},
+		{"fn a(b: i32) { case b { 0 -> 1, n -> n } }", nil},
+		{"fn a(b: bool) { case b { true -> 1, false -> 0, nil -> 2 } }", nil},
+		{"fn a(b: P) { case b { P { x: _, y } -> y, nil -> 0 } }", nil},
+
+		// Not exhaustive
+		{"fn a(b: bool) { case b { true -> 1 } }", []string{"Case is not exhaustive, missing false"}},
+		{"fn a(b: bool) { case b { false -> 1, nil -> 0 } }", []string{"Case is not exhaustive, missing true"}},
+		{"fn a(b: i32) { case b { 0 -> 1, 1 -> 0 } }", []string{"Case is not exhaustive, missing a _ branch for all other values"}},
+		{"fn a(b: P) { case b { P { x: 0 } -> 1 } }", []string{"Case is not exhaustive, missing a struct pattern that matches every value"}},
+		{"fn a(b: P) { case b { P { x } -> x } }", nil},
+
+		// Unreachable
+		{"fn a(b: i32) { case b { _ -> 1, 0 -> 0 } }", []string{"Unreachable branch, an earlier branch already matches every value"}},
+	})
+
+	program, _ := parser.Run(lexer.Run(util.NewFileSet().AddFile("", "fn a() { case nil { true -> 1, false -> 0 } }")))
+	expression := program.Scopes[0].Functions[0].Body.Statements[0].(*parser.ExpressionStatement).Expression.(*parser.Case)
+
+	errors := analyzer.CheckCase(expression, analyzer.Subject{Nil: true})
+	if len(errors) != 1 || errors[0].Msg != "Case is not exhaustive, missing nil" {
+		t.Errorf("expected a missing nil error but got %s", errors)
+	}
+}
+
+func TestBindings(t *testing.T) {
+	testHelper(t, []testStruct{
+		// Allowed
+		{"fn a() {\n\tlet! b = 1\n\tb = 2\n}", nil},
+		{"let! b = 1\nfn a() {\n\tb = 2\n}", nil},
+		{"fn a() {\n\tlet! b = P { x: 1 }\n\tb.x = 2\n}", nil},
+		{"fn a() {\n\tlet! b = 1\n\tb += 2\n}", nil},
+		{"fn a() {\n\tlet b = 1\n\tif b == 1 {\n\t\tlet! b = 2\n\t\tb = 3\n\t}\n}", nil},
+
+		// Reassignment
+		{"fn a() {\n\tlet b = 1\n\tb = 2\n}", []string{"Cannot assign to b, it is declared with let"}},
+		{"let b = 1\nfn a() {\n\tb = 2\n}", []string{"Cannot assign to b, it is declared with let"}},
+		{"const b = 1\nfn a() {\n\tb = 2\n}", []string{"Cannot assign to constant b"}},
+		{"fn a(b: i32) {\n\tb = 2\n}", []string{"Cannot assign to parameter b, parameters are immutable"}},
+		{"fn a() {\n\tlet b = P { x: 1 }\n\tb.x = 2\n}", []string{"Cannot assign to b, it is declared with let"}},
+		{"fn a() {\n\tlet b = 1\n\tb += 2\n}", []string{"Cannot assign to b, it is declared with let"}},
+		{"fn a() {\n\tlet b = P { x: 1 }\n\tb.x *= 2\n}", []string{"Cannot assign to b, it is declared with let"}},
+		{"fn a() {\n\ta = 2\n}", []string{"Cannot assign to function a"}},
+		{"fn a(b: i32) {\n\tcase b {\n\t\tc -> { c = 1 }\n\t}\n}", []string{"Cannot assign to c, bindings of patterns are immutable"}},
+		{"impl P {\n\tfn a(self) {\n\t\tself.x = 1\n\t}\n}", []string{"Cannot assign to self, it is immutable"}},
+
+		// Constant initialisers
+		{"const a = 1\nconst b = (a + 2) * -a", nil},
+		{"let a = 1\nconst b = a", []string{"a is not a constant and cannot be used in a constant initialiser"}},
+		{"fn f() {}\nconst b = f()", []string{"Constant initialisers can only contain literals, operators and other constants"}},
+		{"fn f(a: i32) {\n\tconst b = a\n}", []string{"a is not a constant and cannot be used in a constant initialiser"}},
+		{"namespace m\npub fn f() {}\nnamespace n\nconst b = m::f", []string{"m::f is not a constant and cannot be used in a constant initialiser"}},
+	})
+}
+
+func TestDiagnostic(t *testing.T) {
+	program, _ := parser.Run(lexer.Run(util.NewFileSet().AddFile("main.ql", "fn a() {\n\tlet b = P { x: 1 }\n\tb.x = 2\n}")))
+
+	errors := analyzer.Run([]parser.Program{program}, nil)
+	if len(errors) != 1 {
+		t.Fatalf("expected one error but got %s", errors)
+	}
+
+	want := "error[E0300]: Cannot assign to b, it is declared with let\n" +
+		" --> main.ql:3:6\n" +
+		"  |\n" +
+		"2 |     let b = P { x: 1 }\n" +
+		"  |     --- declared with let\n" +
+		"3 |     b.x = 2\n" +
+		"  |         ^ cannot be assigned\n" +
+		"  |\n" +
+		"  = note: Fields and bytes can only be assigned if the binding that holds them is mutable\n" +
+		"  = help: Declare b with let! to make it mutable\n"
+
+	if got := diagnostics.Render(errors[0], diagnostics.Plain); got != want {
+		t.Errorf("\n%s\n%s\n%s\n%s", "---- EXPECTED ----", want, "---- ACTUAL ----", got)
+	}
+}
@@ -0,0 +1,360 @@
+package analyzer
+
+import (
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/parser"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/resolver"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
+)
+
+type bindingKind int
+
+const (
+	constantBinding bindingKind = iota
+	immutableBinding
+	mutableBinding
+	parameterBinding
+	patternBinding
+	functionBinding
+	typeBinding
+)
+
+// binding is a name in scope, Pos is where it is declared: let or let! for
+// bindings and the name for everything else.
+type binding struct {
+	kind bindingKind
+	pos  util.Position
+}
+
+type environment struct {
+	parent   *environment
+	bindings map[string]binding
+}
+
+func newEnvironment(parent *environment) *environment {
+	return &environment{parent: parent, bindings: map[string]binding{}}
+}
+
+func (env *environment) lookup(name string) (binding, bool) {
+	for ; env != nil; env = env.parent {
+		if binding, ok := env.bindings[name]; ok {
+			return binding, true
+		}
+	}
+
+	return binding{}, false
+}
+
+func symbolBinding(symbol *resolver.Symbol) binding {
+	switch symbol.Kind {
+	case resolver.CONSTANT:
+		return binding{constantBinding, symbol.Pos}
+	case resolver.FUNCTION:
+		return binding{functionBinding, symbol.Pos}
+	default:
+		return binding{typeBinding, symbol.Pos}
+	}
+}
+
+// checkBindings rejects assignments to bindings declared with let or const and
+// constant initialisers that cannot be evaluated at compile time. Every
+// namespace sees the declarations of all its scopes, even from other files,
+// and falls back to the names its scope imports.
+func (a *analyzer) checkBindings(programs []parser.Program) {
+	namespaces := map[string]*environment{}
+
+	for _, program := range programs {
+		for _, scope := range program.Scopes {
+			name := ""
+			if scope.Namespace != nil {
+				name = scope.Namespace.Path.String()
+			}
+
+			env, ok := namespaces[name]
+			if !ok {
+				env = newEnvironment(nil)
+				namespaces[name] = env
+			}
+
+			declare(env, scope)
+
+			// Top level bindings are visible in every function of the
+			// namespace
+			for _, statement := range scope.Statements {
+				if binding, ok := statement.(*parser.Binding); ok {
+					env.bindings[binding.Identifer.Name] = bindingOf(binding)
+				}
+			}
+		}
+	}
+
+	for _, program := range programs {
+		for _, scope := range program.Scopes {
+			name := ""
+			if scope.Namespace != nil {
+				name = scope.Namespace.Path.String()
+			}
+
+			imports := newEnvironment(nil)
+			if a.resolution != nil {
+				for alias, symbol := range a.resolution.Imports[scope] {
+					imports.bindings[alias] = symbolBinding(symbol)
+				}
+			}
+
+			// The namespace environment is shared, so only its parent is
+			// swapped for the imports of the scope that is checked
+			env := namespaces[name]
+			env.parent = imports
+
+			a.checkDeclarations(scope, env)
+
+			for _, statement := range scope.Statements {
+				a.checkStatement(statement, env)
+			}
+		}
+	}
+}
+
+func declare(env *environment, scope *parser.Scope) {
+	for _, constant := range scope.Constants {
+		env.bindings[constant.Identifer.Name] = binding{constantBinding, constant.Identifer.Pos}
+	}
+
+	for _, function := range scope.Functions {
+		env.bindings[function.Identifer.Name] = binding{functionBinding, function.Identifer.Pos}
+	}
+
+	for _, structure := range scope.Structs {
+		env.bindings[structure.Identifer.Name] = binding{typeBinding, structure.Identifer.Pos}
+	}
+
+	for _, trait := range scope.Traits {
+		env.bindings[trait.Identifer.Name] = binding{typeBinding, trait.Identifer.Pos}
+	}
+}
+
+func bindingOf(statement *parser.Binding) binding {
+	if statement.Mutable {
+		return binding{mutableBinding, statement.Pos}
+	}
+
+	return binding{immutableBinding, statement.Pos}
+}
+
+func (a *analyzer) checkDeclarations(scope *parser.Scope, env *environment) {
+	for _, constant := range scope.Constants {
+		a.checkConstant(constant.Expression.Expression, env)
+	}
+
+	for _, function := range scope.Functions {
+		a.checkFunction(function, env)
+	}
+
+	for _, trait := range scope.Traits {
+		for _, method := range trait.Methods {
+			a.checkFunction(method, env)
+		}
+	}
+
+	for _, impl := range scope.Impls {
+		for _, method := range impl.Methods {
+			a.checkFunction(method, env)
+		}
+	}
+}
+
+func (a *analyzer) checkFunction(function *parser.Function, parent *environment) {
+	if function.Body == nil {
+		return
+	}
+
+	env := newEnvironment(parent)
+	for _, parameter := range function.Parameters {
+		env.bindings[parameter.Identifer.Name] = binding{parameterBinding, parameter.Identifer.Pos}
+	}
+
+	a.checkScope(function.Body, env)
+}
+
+func (a *analyzer) checkScope(scope *parser.Scope, parent *environment) {
+	env := newEnvironment(parent)
+	declare(env, scope)
+
+	a.checkDeclarations(scope, env)
+
+	for _, statement := range scope.Statements {
+		a.checkStatement(statement, env)
+
+		if binding, ok := statement.(*parser.Binding); ok {
+			env.bindings[binding.Identifer.Name] = bindingOf(binding)
+		}
+	}
+}
+
+func (a *analyzer) checkStatement(statement parser.Statement, env *environment) {
+	switch statement := statement.(type) {
+	case *parser.ExpressionStatement:
+		a.checkExpression(statement.Expression, env)
+	case *parser.Return:
+		a.checkExpression(statement.Expression, env)
+	case *parser.Binding:
+		a.checkExpression(statement.Expression, env)
+	case *parser.Assignment:
+		a.checkExpression(statement.Target, env)
+		a.checkExpression(statement.Expression, env)
+		a.checkAssignment(statement, env)
+	}
+}
+
+func (a *analyzer) checkAssignment(assignment *parser.Assignment, env *environment) {
+	// Assigning to a field or an index changes the variable that holds it
+	target := assignment.Target
+	nested := false
+	for {
+		switch expression := target.(type) {
+		case *parser.FieldAccess:
+			target, nested = expression.Target, true
+			continue
+		case *parser.Index:
+			target, nested = expression.Target, true
+			continue
+		case *parser.SelfExpression:
+			err := a.errorf(assignment.Pos, diagnostics.IMMUTABLE_ASSIGNMENT, "Cannot assign to self, it is immutable")
+			if self, ok := env.lookup("self"); ok {
+				err.At(self.pos, "declared here")
+			}
+			return
+		case *parser.IdentifierExpression:
+			name := expression.Identifer.Name
+
+			declared, ok := env.lookup(name)
+			if !ok {
+				return
+			}
+
+			var err *diagnostics.Diagnostic
+			switch declared.kind {
+			case constantBinding:
+				a.errorf(assignment.Pos, diagnostics.INVALID_ASSIGNMENT, "Cannot assign to constant %s", name).At(declared.pos, "declared here")
+				return
+			case functionBinding:
+				a.errorf(assignment.Pos, diagnostics.INVALID_ASSIGNMENT, "Cannot assign to function %s", name).At(declared.pos, "declared here")
+				return
+			case typeBinding:
+				a.errorf(assignment.Pos, diagnostics.INVALID_ASSIGNMENT, "Cannot assign to type %s", name).At(declared.pos, "declared here")
+				return
+			case immutableBinding:
+				err = a.errorf(assignment.Pos, diagnostics.IMMUTABLE_ASSIGNMENT, "Cannot assign to %s, it is declared with let", name).At(declared.pos, "declared with let")
+				err.Helpf("Declare %s with let! to make it mutable", name)
+			case parameterBinding:
+				err = a.errorf(assignment.Pos, diagnostics.IMMUTABLE_ASSIGNMENT, "Cannot assign to parameter %s, parameters are immutable", name).At(declared.pos, "declared here")
+				err.Helpf("Copy %s into a binding declared with let! to change it", name)
+			case patternBinding:
+				err = a.errorf(assignment.Pos, diagnostics.IMMUTABLE_ASSIGNMENT, "Cannot assign to %s, bindings of patterns are immutable", name).At(declared.pos, "bound here")
+				err.Helpf("Copy %s into a binding declared with let! to change it", name)
+			default:
+				return
+			}
+
+			if nested {
+				err.Notef("Fields and bytes can only be assigned if the binding that holds them is mutable")
+			}
+		}
+
+		return
+	}
+}
+
+func (a *analyzer) checkExpression(expression parser.Expression, env *environment) {
+	switch expression := expression.(type) {
+	case *parser.Binary:
+		a.checkExpression(expression.Left, env)
+		a.checkExpression(expression.Right, env)
+	case *parser.Unary:
+		a.checkExpression(expression.Operand, env)
+	case *parser.Grouping:
+		a.checkExpression(expression.Expression, env)
+	case *parser.Interpolation:
+		a.checkExpression(expression.Concatenation(), env)
+	case *parser.Call:
+		a.checkExpression(expression.Callee, env)
+		for _, argument := range expression.Arguments {
+			a.checkExpression(argument, env)
+		}
+	case *parser.Index:
+		a.checkExpression(expression.Target, env)
+		a.checkExpression(expression.Index, env)
+	case *parser.FieldAccess:
+		a.checkExpression(expression.Target, env)
+	case *parser.StructLiteral:
+		for _, field := range expression.Fields {
+			a.checkExpression(field.Expression, env)
+		}
+	case *parser.If:
+		a.checkExpression(expression.Condition, env)
+		a.checkScope(expression.Then, env)
+		if expression.Else != nil {
+			a.checkScope(expression.Else, env)
+		}
+	case *parser.Cond:
+		for _, branch := range expression.Branches {
+			a.checkExpression(branch.Condition, env)
+			a.checkScope(branch.Body, env)
+		}
+		if expression.Else != nil {
+			a.checkScope(expression.Else, env)
+		}
+	case *parser.Case:
+		a.checkExpression(expression.Subject, env)
+		for _, branch := range expression.Branches {
+			branchEnv := newEnvironment(env)
+			declarePattern(branchEnv, branch.Pattern)
+			a.checkScope(branch.Body, branchEnv)
+		}
+	}
+}
+
+func declarePattern(env *environment, pattern parser.Pattern) {
+	switch pattern := pattern.(type) {
+	case *parser.BindingPattern:
+		env.bindings[pattern.Identifer.Name] = binding{patternBinding, pattern.Identifer.Pos}
+	case *parser.StructPattern:
+		for _, field := range pattern.Fields {
+			declarePattern(env, field.Pattern)
+		}
+	}
+}
+
+// Constant initialisers are evaluated at compile time, so they can only be
+// built from literals, operators and other constants.
+func (a *analyzer) checkConstant(expression parser.Expression, env *environment) {
+	switch expression := expression.(type) {
+	case *parser.Literal:
+	case *parser.Grouping:
+		a.checkConstant(expression.Expression, env)
+	case *parser.Interpolation:
+		a.checkConstant(expression.Concatenation(), env)
+	case *parser.Unary:
+		a.checkConstant(expression.Operand, env)
+	case *parser.Binary:
+		a.checkConstant(expression.Left, env)
+		a.checkConstant(expression.Right, env)
+	case *parser.IdentifierExpression:
+		if declared, ok := env.lookup(expression.Identifer.Name); ok && declared.kind != constantBinding {
+			a.errorf(expression.Identifer.Pos, diagnostics.NOT_CONSTANT, "%s is not a constant and cannot be used in a constant initialiser", expression.Identifer.Name).
+				At(declared.pos, "declared here")
+		}
+	case *parser.PathExpression:
+		if a.resolution == nil {
+			return
+		}
+
+		if symbol, ok := a.resolution.Paths[expression]; ok && symbol.Kind != resolver.CONSTANT {
+			a.errorf(expression.Identifer.Pos, diagnostics.NOT_CONSTANT, "%s is not a constant and cannot be used in a constant initialiser", symbol.Name).
+				At(symbol.Pos, "declared here")
+		}
+	default:
+		a.errorf(parser.ExpressionPos(expression), diagnostics.NOT_CONSTANT, "Constant initialisers can only contain literals, operators and other constants")
+	}
+}
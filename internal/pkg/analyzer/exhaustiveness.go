@@ -0,0 +1,148 @@
+package analyzer
+
+import (
+	"strings"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/lexer"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/parser"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
+)
+
+// Subject describes the values the subject of a case can take, as far as
+// they matter for exhaustiveness.
+type Subject struct {
+	// The subject is a bool, so true and false cover all values
+	Bool bool
+	// The subject is nil, the only value of its type, so a nil pattern
+	// covers it
+	Nil bool
+	// The subject is a struct, so an irrefutable struct pattern covers all
+	// values
+	Struct bool
+}
+
+// InferSubject guesses the subject from the patterns of a case. It is used
+// where no type information is available: a bool literal pattern makes the
+// subject a bool and a struct pattern makes it a struct.
+func InferSubject(expression *parser.Case) Subject {
+	var subject Subject
+
+	for _, branch := range expression.Branches {
+		switch pattern := branch.Pattern.(type) {
+		case *parser.LiteralPattern:
+			if pattern.Literal.Kind == lexer.KEYWORD_TRUE || pattern.Literal.Kind == lexer.KEYWORD_FALSE {
+				subject.Bool = true
+			}
+		case *parser.StructPattern:
+			subject.Struct = true
+		}
+	}
+
+	return subject
+}
+
+// CheckCase reports missing values if the branches of a case do not cover
+// every value of the subject, and branches that can never match because an
+// earlier branch already matches everything.
+func CheckCase(expression *parser.Case, subject Subject) []diagnostics.Diagnostic {
+	a := analyzer{}
+	a.checkCase(expression, subject)
+	return a.errors
+}
+
+func (a *analyzer) checkCase(expression *parser.Case, subject Subject) {
+	var coversTrue, coversFalse, coversNil, coversStruct, coversAll bool
+	var catchAll parser.Pattern
+
+	for _, branch := range expression.Branches {
+		if coversAll {
+			a.errorf(patternPos(branch.Pattern), diagnostics.UNREACHABLE_BRANCH, "Unreachable branch, an earlier branch already matches every value").
+				At(patternPos(catchAll), "matches every value")
+			continue
+		}
+
+		switch pattern := branch.Pattern.(type) {
+		case *parser.LiteralPattern:
+			switch pattern.Literal.Kind {
+			case lexer.KEYWORD_TRUE:
+				coversTrue = true
+			case lexer.KEYWORD_FALSE:
+				coversFalse = true
+			}
+		case *parser.NilPattern:
+			coversNil = true
+		case *parser.BindingPattern, *parser.MutedPattern:
+			coversAll, catchAll = true, pattern
+		case *parser.StructPattern:
+			if irrefutable(pattern) {
+				coversStruct = true
+			}
+		}
+	}
+
+	if coversAll {
+		return
+	}
+
+	var missing []string
+
+	switch {
+	case subject.Nil:
+		if !coversNil {
+			missing = append(missing, "nil")
+		}
+	case subject.Bool:
+		if !coversTrue {
+			missing = append(missing, "true")
+		}
+		if !coversFalse {
+			missing = append(missing, "false")
+		}
+	case subject.Struct:
+		if !coversStruct {
+			missing = append(missing, "a struct pattern that matches every value")
+		}
+	default:
+		missing = append(missing, "a _ branch for all other values")
+	}
+
+	if len(missing) > 0 {
+		a.errorf(expression.Pos, diagnostics.NON_EXHAUSTIVE_CASE, "Case is not exhaustive, missing %s", strings.Join(missing, ", ")).
+			Notef("A case has to match every value of its subject").
+			Helpf("Add a _ branch at the end to match all other values")
+	}
+}
+
+func irrefutable(pattern parser.Pattern) bool {
+	switch pattern := pattern.(type) {
+	case *parser.BindingPattern, *parser.MutedPattern:
+		return true
+	case *parser.StructPattern:
+		for _, field := range pattern.Fields {
+			if !irrefutable(field.Pattern) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+func patternPos(pattern parser.Pattern) util.Position {
+	switch pattern := pattern.(type) {
+	case *parser.LiteralPattern:
+		return pattern.Literal.Pos
+	case *parser.NilPattern:
+		return pattern.Pos
+	case *parser.BindingPattern:
+		return pattern.Identifer.Pos
+	case *parser.MutedPattern:
+		return pattern.Identifer.Pos
+	case *parser.StructPattern:
+		return pattern.Type.Identifer.Pos
+	}
+
+	return util.Position{}
+}
@@ -0,0 +1,272 @@
+package bytecode
+
+import (
+	"math"
+	"math/big"
+	"math/bits"
+	"strings"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/consteval"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/lexer"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/types"
+)
+
+var operators = map[Opcode]lexer.TokenType{}
+
+func init() {
+	for op, opcode := range instructions {
+		operators[opcode] = op.Operator()
+	}
+}
+
+// primitive converts a value on the stack to a constant of the given type
+// and value converts it back.
+func primitive(v Value, basic *types.Basic) consteval.Value {
+	switch {
+	case basic.IsSigned():
+		return consteval.Value{Type: basic, Int: big.NewInt(int64(v.Int))}
+	case basic.IsInteger():
+		return consteval.Value{Type: basic, Int: new(big.Int).SetUint64(v.Int)}
+	case basic.IsFloat():
+		return consteval.Value{Type: basic, Float: v.Float}
+	case basic == types.Bool:
+		return consteval.Value{Type: basic, Bool: v.Int != 0}
+	case basic == types.Bin:
+		return consteval.Value{Type: basic, Bytes: v.Ref.(string)}
+	case basic == types.Sym:
+		return consteval.Value{Type: basic, Sym: v.Ref.(string)}
+	}
+
+	return consteval.Value{Type: basic}
+}
+
+// format returns the text a value is interpolated as.
+func format(v Value, kind byte) Value {
+	return Value{Ref: consteval.Format(primitive(v, basics[kind]))}
+}
+
+// evaluate applies an operator the same way constants are evaluated. It is
+// used for the operators without a fast path and to report the errors of
+// those that failed.
+func evaluate(op Opcode, basic *types.Basic, left Value, right Value) (Value, error) {
+	var result consteval.Value
+	var err error
+
+	if op == OP_NEG || op == OP_NOT {
+		result, err = consteval.Unary(operators[op], primitive(left, basic))
+	} else if op >= OP_SHL && op <= OP_CSHR {
+		// The amount of a shift is read as an i64
+		result, err = consteval.Binary(operators[op], primitive(left, basic), primitive(right, types.I64))
+	} else {
+		result, err = consteval.Binary(operators[op], primitive(left, basic), primitive(right, basic))
+	}
+
+	if err != nil {
+		return Value{}, err
+	}
+
+	return value(result), nil
+}
+
+func boolean(b bool) Value {
+	if b {
+		return Value{Int: 1}
+	}
+
+	return Value{}
+}
+
+// binary applies an arithmetic, bitwise or comparison operator to two
+// values of the given kind.
+func binary(op Opcode, kind byte, left Value, right Value) (Value, error) {
+	if op >= OP_EQ {
+		return compare(op, kind, left, right), nil
+	}
+
+	basic := basics[kind]
+
+	switch {
+	case basic.IsSigned() && op < OP_POW:
+		if result, ok := signed(op, basic, int64(left.Int), int64(right.Int)); ok {
+			return Value{Int: uint64(result)}, nil
+		}
+	case basic.IsUnsigned() && op < OP_POW:
+		if result, ok := unsigned(op, basic, left.Int, right.Int); ok {
+			return Value{Int: result}, nil
+		}
+	case basic.IsInteger() && op >= OP_AND && op <= OP_XOR:
+		switch op {
+		case OP_AND:
+			return Value{Int: left.Int & right.Int}, nil
+		case OP_OR:
+			return Value{Int: left.Int | right.Int}, nil
+		default:
+			return Value{Int: left.Int ^ right.Int}, nil
+		}
+	}
+
+	return evaluate(op, basic, left, right)
+}
+
+// signed computes +, -, * and / of signed integers and reports if the
+// result fits into their type.
+func signed(op Opcode, basic *types.Basic, x int64, y int64) (int64, bool) {
+	var result int64
+
+	switch op {
+	case OP_ADD:
+		result = x + y
+		if (x > 0 && y > 0 && result < 0) || (x < 0 && y < 0 && result >= 0) {
+			return 0, false
+		}
+	case OP_SUB:
+		result = x - y
+		if (x >= 0 && y < 0 && result < 0) || (x < 0 && y > 0 && result >= 0) {
+			return 0, false
+		}
+	case OP_MUL:
+		result = x * y
+		if x != 0 && (result/x != y || (x == -1 && y == math.MinInt64)) {
+			return 0, false
+		}
+	case OP_DIV:
+		if y == 0 || (x == math.MinInt64 && y == -1) {
+			return 0, false
+		}
+		result = x / y
+	}
+
+	shift := 64 - basic.Bits
+	return result, result<<shift>>shift == result
+}
+
+func unsigned(op Opcode, basic *types.Basic, x uint64, y uint64) (uint64, bool) {
+	var result, overflow uint64
+
+	switch op {
+	case OP_ADD:
+		result, overflow = bits.Add64(x, y, 0)
+	case OP_SUB:
+		result, overflow = bits.Sub64(x, y, 0)
+	case OP_MUL:
+		overflow, result = bits.Mul64(x, y)
+	case OP_DIV:
+		if y == 0 {
+			return 0, false
+		}
+		result = x / y
+	}
+
+	return result, overflow == 0 && (basic.Bits == 64 || result>>basic.Bits == 0)
+}
+
+// unary applies - or not to a value of the given kind.
+func unary(op Opcode, kind byte, operand Value) (Value, error) {
+	basic := basics[kind]
+
+	switch {
+	case op == OP_NOT && basic == types.Bool:
+		return boolean(operand.Int == 0), nil
+	case op == OP_NOT && basic.IsSigned():
+		return Value{Int: ^operand.Int}, nil
+	case op == OP_NOT && basic.IsUnsigned():
+		return Value{Int: operand.Int ^ (math.MaxUint64 >> (64 - basic.Bits))}, nil
+	case op == OP_NEG && basic.IsFloat():
+		return Value{Float: -operand.Float}, nil
+	case op == OP_NEG && basic.IsSigned():
+		if result, ok := signed(OP_SUB, basic, 0, int64(operand.Int)); ok {
+			return Value{Int: uint64(result)}, nil
+		}
+	case op == OP_NEG && operand.Int == 0:
+		return operand, nil
+	}
+
+	return evaluate(op, basic, operand, Value{})
+}
+
+// compare applies a comparison, values of KIND_REF are structs or
+// functions, structs are equal if all their fields are.
+func compare(op Opcode, kind byte, left Value, right Value) Value {
+	var order int
+
+	switch basic := basics[kind]; {
+	case kind == KIND_REF:
+		if !equal(left.Ref, right.Ref) {
+			order = 1
+		}
+	case basic.IsSigned():
+		order = cmp(int64(left.Int), int64(right.Int))
+	case basic.IsUnsigned():
+		order = cmp(left.Int, right.Int)
+	case basic.IsFloat():
+		return compareFloats(op, left.Float, right.Float)
+	case basic == types.Bin:
+		order = strings.Compare(left.Ref.(string), right.Ref.(string))
+	case basic == types.Bool && left.Int != right.Int,
+		basic == types.Sym && left.Ref != right.Ref:
+		order = 1
+	}
+
+	switch op {
+	case OP_EQ:
+		return boolean(order == 0)
+	case OP_NE:
+		return boolean(order != 0)
+	case OP_LT:
+		return boolean(order < 0)
+	case OP_LE:
+		return boolean(order <= 0)
+	case OP_GT:
+		return boolean(order > 0)
+	default:
+		return boolean(order >= 0)
+	}
+}
+
+// compareFloats compares floats directly, NaN is unordered.
+func compareFloats(op Opcode, left float64, right float64) Value {
+	switch op {
+	case OP_EQ:
+		return boolean(left == right)
+	case OP_NE:
+		return boolean(left != right)
+	case OP_LT:
+		return boolean(left < right)
+	case OP_LE:
+		return boolean(left <= right)
+	case OP_GT:
+		return boolean(left > right)
+	default:
+		return boolean(left >= right)
+	}
+}
+
+func cmp[T int64 | uint64](left T, right T) int {
+	switch {
+	case left < right:
+		return -1
+	case left > right:
+		return 1
+	}
+
+	return 0
+}
+
+func equal(left any, right any) bool {
+	switch left := left.(type) {
+	case *Struct:
+		other, ok := right.(*Struct)
+		if !ok || left.Type != other.Type {
+			return false
+		}
+
+		for idx, field := range left.Type.Fields {
+			if compare(OP_EQ, byte(kind(field.Type)), left.Fields[idx], other.Fields[idx]).Int == 0 {
+				return false
+			}
+		}
+		return true
+	}
+
+	return left == right
+}
@@ -0,0 +1,142 @@
+package bytecode_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/bytecode"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/testutil"
+)
+
+type testStruct struct {
+	input string
+	want  string
+}
+
+// compile compiles the input after running the optimisations of the given
+// level on its IR.
+func compile(t *testing.T, input string, level int) *bytecode.Program {
+	built := testutil.Build(t, level, input)
+
+	compiled, compileErrors := bytecode.Compile(built)
+	if len(compileErrors) > 0 {
+		t.Fatalf("unexpected compile errors: %s", compileErrors)
+	}
+
+	return compiled
+}
+
+// run compiles the input and returns the result of its main function as
+// "value: type" or the runtime error.
+func run(t *testing.T, input string, level int) string {
+	program := compile(t, input, level)
+
+	value, err := bytecode.New(program).Run()
+	if err != nil {
+		return err.(diagnostics.Diagnostic).Msg
+	}
+
+	result := program.Functions[program.Main].Result
+	return fmt.Sprintf("%s: %s", bytecode.Format(value, result), result)
+}
+
+func testHelper(t *testing.T, tests []testStruct) {
+	for _, test := range tests {
+		for _, level := range []int{0, 2} {
+			t.Run(fmt.Sprintf("O%d/%s", level, test.input), func(t *testing.T) {
+				if actual := run(t, test.input, level); actual != test.want {
+					t.Errorf("expected %q but got %q", test.want, actual)
+				}
+			})
+		}
+	}
+}
+
+func TestExpressions(t *testing.T) {
+	testHelper(t, []testStruct{
+		{"fn main() -> i64 { 1 + 2 * 3 }", "7: i64"},
+		{"fn main() -> u8 {\n\tlet a: u8 = 200\n\ta + 55\n}", "255: u8"},
+		{"fn main() -> u16 {\n\tlet a: u8 = 200\n\tlet b: u16 = 1000\n\ta + b\n}", "1200: u16"},
+		{"fn main() -> i64 {\n\tlet a: i8 = -5\n\tlet b: i64 = 3\n\ta * b\n}", "-15: i64"},
+		{"fn main() -> num { 7.0 / 2 }", "3.5: num"},
+		{"fn main() -> num {\n\tlet a: i32 = 3\n\tlet b: num = 0.5\n\ta + b\n}", "3.5: num"},
+		{"fn main() -> f32 {\n\tlet a: f32 = 0.5\n\ta * 3\n}", "1.5: f32"},
+		{"fn main() -> bool { 1 < 2 and not false }", "true: bool"},
+		{"fn main() -> bool {\n\tlet a: i8 = -1\n\tlet b: i16 = 1\n\ta < b\n}", "true: bool"},
+		{"fn main() -> bin { \"ab\" + \"c\" }", "\"abc\": bin"},
+		{"fn main() -> bin {\n\tlet a = \"b\"\n\t\"a{a}\\{c\\}\"\n}", "\"ab{c}\": bin"},
+		{"fn main() -> u8 { \"abc\"[1] }", "98: u8"},
+		{"fn f(a: i8, b: u64, c: bool, d: sym) -> bin { \"{a} {b} {c} {d} {1}\" }\nfn main() -> bin { f(-5, 18446744073709551615, true, 'ok) }", "\"-5 18446744073709551615 true ok 1\": bin"},
+		{"fn f(a: f64, b: f64, c: f32, d: f64) -> bin { \"{a} {b} {c} {d} {2.5}\" }\nfn main() -> bin { f(0.1, 1e6, 1.1, 0.00001) }", "\"0.1 1e+06 1.1 1e-05 2.5\": bin"},
+		{"fn main() -> bin { b\"\\x41{b}\" }", "\"A{b}\": bin"},
+		{"fn main() -> sym { 'ok }", "'ok: sym"},
+		{"fn main() -> bool {\n\tlet a = 'ok\n\ta == 'ok and a != 'error\n}", "true: bool"},
+		{"fn main() -> bin {\n\tlet! s = \"abc\"\n\ts[0] = 65\n\ts\n}", "\"Abc\": bin"},
+		{"fn main() -> u8 {\n\tlet a: u8 = 1\n\ta cshr 1\n}", "128: u8"},
+		{"fn main() -> i8 {\n\tlet a: i8 = -128\n\ta ashr 2\n}", "-32: i8"},
+		{"fn main() -> u8 {\n\tlet a: u8 = 5\n\tnot a\n}", "250: u8"},
+		{"fn main() -> i64 { 2 ^ 10 }", "1024: i64"},
+		{"fn main() -> i8 {\n\tlet a = -128i8\n\ta + 0x7F\n}", "-1: i8"},
+		{"fn main() -> f32 { 0.5f32 * 3 }", "1.5: f32"},
+		{"fn main() -> i64 { nil ?? 3 }", "3: i64"},
+		{"const N: u8 = 7\nlet g = N * 2\nfn main() -> u8 { g + N }", "21: u8"},
+
+		// Runtime errors
+		{"fn main() -> u8 {\n\tlet a: u8 = 200\n\ta + 56\n}", "Overflow, 256 does not fit into u8"},
+		{"fn main() -> i64 {\n\tlet a: i64 = 9223372036854775807\n\ta + 1\n}", "Overflow, 9223372036854775808 does not fit into i64"},
+		{"fn main() -> i64 {\n\tlet a = 0\n\t1 / a\n}", "Division by zero"},
+		{"fn main() -> u8 { \"abc\"[3] }", "Index 3 is out of range for length 3"},
+		{"fn main() -> u8 {\n\tlet i: i8 = -1\n\t\"abc\"[i]\n}", "Index -1 is out of range for length 3"},
+	})
+}
+
+func TestControlFlow(t *testing.T) {
+	testHelper(t, []testStruct{
+		{"fn main() -> bin { if 1 < 2 { \"yes\" } else { \"no\" } }", "\"yes\": bin"},
+		{"fn main() -> i64 {\n\tlet a = 5\n\tcond {\n\t\ta < 3 -> 1\n\t\ta < 10 -> 2\n\t\telse -> 3\n\t}\n}", "2: i64"},
+		{"fn main() -> bin {\n\tlet a = 7\n\tcase a {\n\t\t0 -> \"zero\"\n\t\t-7 -> \"minus seven\"\n\t\tn -> \"other\"\n\t}\n}", "\"other\": bin"},
+		{"fn main() -> i64 {\n\tlet a = 'error\n\tcase a {\n\t\t'ok -> 1\n\t\t'error -> 2\n\t\t_ -> 3\n\t}\n}", "2: i64"},
+		{"fn main() -> i64 {\n\tlet! a = 1\n\tif a == 1 {\n\t\ta = 2\n\t}\n\ta\n}", "2: i64"},
+		{"fn f(n: i64) -> i64 {\n\tif n < 2 {\n\t\treturn n\n\t}\n\tf(n - 1) + f(n - 2)\n}\nfn main() -> i64 { f(20) }", "6765: i64"},
+		{"fn inc(n: i64) -> i64 { n + 1 }\nfn main() -> i64 {\n\tlet g = inc\n\tg(g(1))\n}", "3: i64"},
+		{"fn main() -> bool {\n\tlet a = 0\n\ta != 0 && 1 / a > 0\n}", "false: bool"},
+		{"fn main() -> i64 {\n\tlet! a = 1\n\tlet b = if a == 1 { a = 3\n 4 } else { 5 }\n\ta * b\n}", "12: i64"},
+		{"fn f(n: i64) -> i64 { f(n + 1) }\nfn main() -> i64 { f(0) }", "Stack overflow, too many nested calls"},
+	})
+}
+
+func TestStructs(t *testing.T) {
+	shapes := "struct P { x: i32, y: i32 }\ntrait Shape {\n\tfn area(self) -> i32\n\tfn name(self) -> bin { \"shape\" }\n}\nimpl Shape for P {\n\tfn area(self) -> i32 { self.x * self.y }\n}\n"
+
+	testHelper(t, []testStruct{
+		{shapes + "fn main() -> P { P { x: 2, y: 3 } }", "P { x: 2, y: 3 }: P"},
+		{shapes + "fn main() -> i32 {\n\tlet! p = P { x: 2, y: 3 }\n\tp.x = 5\n\tp.area()\n}", "15: i32"},
+		{shapes + "fn main() -> i32 {\n\tlet! p = P { x: 2, y: 3 }\n\tlet q = p\n\tp.x = 5\n\tq.x\n}", "2: i32"},
+		{shapes + "fn f(s: Shape) -> i32 { s.area() }\nfn main() -> i32 { f(P { x: 4, y: 4 }) }", "16: i32"},
+		{shapes + "fn f(s: Shape) -> bin { s.name() }\nfn main() -> bin { f(P { x: 1, y: 1 }) }", "\"shape\": bin"},
+		{shapes + "fn main() -> bool { P { x: 1, y: 2 } == P { x: 1, y: 2 } }", "true: bool"},
+		{shapes + "struct L { p: P }\nfn main() -> i32 {\n\tlet! l = L { p: P { x: 1, y: 2 } }\n\tlet m = l\n\tl.p.x = 5\n\tl.p.x + m.p.x\n}", "6: i32"},
+		{shapes + "fn main() -> i32 {\n\tlet p = P { x: 1, y: 2 }\n\tcase p {\n\t\tP { x: 0 } -> 0\n\t\tP { x, y } -> x + y\n\t}\n}", "3: i32"},
+		{shapes + "impl P {\n\tfn origin() -> P { P { x: 0, y: 0 } }\n}\nfn main() -> P { P.origin() }", "P { x: 0, y: 0 }: P"},
+	})
+}
+
+func TestDisassemble(t *testing.T) {
+	program := compile(t, "fn add(a: i64, b: i64) -> i64 { a + b }", 0)
+
+	want := "== add (arity 2, locals 2) ==\n" +
+		"0000  LOAD_LOCAL           0\n" +
+		"0003  LOAD_LOCAL           1\n" +
+		"0006  ADD                  i64\n" +
+		"0008  RETURN\n"
+
+	if actual := program.Functions[0].Disassemble(program); actual != want {
+		t.Errorf("expected %q but got %q", want, actual)
+	}
+
+	if !strings.Contains(program.String(), "== <init> (arity 0, locals 0) ==") {
+		t.Errorf("expected the initialiser in %q", program.String())
+	}
+}
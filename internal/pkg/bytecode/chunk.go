@@ -0,0 +1,309 @@
+package bytecode
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/types"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
+)
+
+type Opcode byte
+
+// Operands follow their opcode: u16 operands take two bytes in big endian
+// order, kinds and argument counts take one byte. Kinds are the
+// types.BasicKind of the operands, KIND_REF compares structs and functions.
+const (
+	OP_CONST         Opcode = iota // u16 constant
+	OP_POP                         //
+	OP_LOAD_LOCAL                  // u16 slot
+	OP_STORE_LOCAL                 // u16 slot
+	OP_LOAD_GLOBAL                 // u16 slot
+	OP_STORE_GLOBAL                // u16 slot
+	OP_FUNCTION                    // u16 function
+	OP_ADD                         // kind
+	OP_SUB                         // kind
+	OP_MUL                         // kind
+	OP_DIV                         // kind
+	OP_POW                         // kind
+	OP_NEG                         // kind
+	OP_NOT                         // kind
+	OP_AND                         // kind
+	OP_OR                          // kind
+	OP_XOR                         // kind
+	OP_SHL                         // kind
+	OP_SHR                         // kind
+	OP_ASHR                        // kind
+	OP_CSHL                        // kind
+	OP_CSHR                        // kind
+	OP_EQ                          // kind
+	OP_NE                          // kind
+	OP_LT                          // kind
+	OP_LE                          // kind
+	OP_GT                          // kind
+	OP_GE                          // kind
+	OP_INT_TO_FLOAT                // kind
+	OP_FORMAT                      // kind
+	OP_INDEX                       // kind of the index
+	OP_SET_INDEX                   // kind of the index
+	OP_STRUCT                      // u16 struct
+	OP_GET_FIELD                   // u16 field
+	OP_INSERT                      // u16 field
+	OP_IS_STRUCT                   // u16 struct
+	OP_JUMP                        // u16 offset
+	OP_JUMP_IF_FALSE               // u16 offset
+	OP_CALL                        // argc
+	OP_CALL_DIRECT                 // u16 function, argc
+	OP_CALL_METHOD                 // u16 constant, argc
+	OP_RETURN                      //
+	OP_UNREACHABLE                 //
+)
+
+const KIND_REF = 255
+
+type operand int
+
+const (
+	NONE operand = iota
+	U16
+	KIND
+	ARGC
+	U16_ARGC
+)
+
+type opcodeInfo struct {
+	name    string
+	operand operand
+}
+
+var opcodes = map[Opcode]opcodeInfo{
+	OP_CONST:         {"CONST", U16},
+	OP_POP:           {"POP", NONE},
+	OP_LOAD_LOCAL:    {"LOAD_LOCAL", U16},
+	OP_STORE_LOCAL:   {"STORE_LOCAL", U16},
+	OP_LOAD_GLOBAL:   {"LOAD_GLOBAL", U16},
+	OP_STORE_GLOBAL:  {"STORE_GLOBAL", U16},
+	OP_FUNCTION:      {"FUNCTION", U16},
+	OP_ADD:           {"ADD", KIND},
+	OP_SUB:           {"SUB", KIND},
+	OP_MUL:           {"MUL", KIND},
+	OP_DIV:           {"DIV", KIND},
+	OP_POW:           {"POW", KIND},
+	OP_NEG:           {"NEG", KIND},
+	OP_NOT:           {"NOT", KIND},
+	OP_AND:           {"AND", KIND},
+	OP_OR:            {"OR", KIND},
+	OP_XOR:           {"XOR", KIND},
+	OP_SHL:           {"SHL", KIND},
+	OP_SHR:           {"SHR", KIND},
+	OP_ASHR:          {"ASHR", KIND},
+	OP_CSHL:          {"CSHL", KIND},
+	OP_CSHR:          {"CSHR", KIND},
+	OP_EQ:            {"EQ", KIND},
+	OP_NE:            {"NE", KIND},
+	OP_LT:            {"LT", KIND},
+	OP_LE:            {"LE", KIND},
+	OP_GT:            {"GT", KIND},
+	OP_GE:            {"GE", KIND},
+	OP_INT_TO_FLOAT:  {"INT_TO_FLOAT", KIND},
+	OP_FORMAT:        {"FORMAT", KIND},
+	OP_INDEX:         {"INDEX", KIND},
+	OP_SET_INDEX:     {"SET_INDEX", KIND},
+	OP_STRUCT:        {"STRUCT", U16},
+	OP_GET_FIELD:     {"GET_FIELD", U16},
+	OP_INSERT:        {"INSERT", U16},
+	OP_IS_STRUCT:     {"IS_STRUCT", U16},
+	OP_JUMP:          {"JUMP", U16},
+	OP_JUMP_IF_FALSE: {"JUMP_IF_FALSE", U16},
+	OP_CALL:          {"CALL", ARGC},
+	OP_CALL_DIRECT:   {"CALL_DIRECT", U16_ARGC},
+	OP_CALL_METHOD:   {"CALL_METHOD", U16_ARGC},
+	OP_RETURN:        {"RETURN", NONE},
+	OP_UNREACHABLE:   {"UNREACHABLE", NONE},
+}
+
+func (op Opcode) String() string {
+	return opcodes[op].name
+}
+
+// Width returns the number of bytes an instruction takes.
+func (op Opcode) Width() int {
+	switch opcodes[op].operand {
+	case U16:
+		return 3
+	case KIND, ARGC:
+		return 2
+	case U16_ARGC:
+		return 4
+	}
+
+	return 1
+}
+
+// Value is a value on the stack of the VM. Integers are stored as their two's
+// complement bits, sign extended for signed types, bools as 0 or 1. Ref
+// holds a string for bin and sym, a *Struct or a *Function.
+type Value struct {
+	Int   uint64
+	Float float64
+	Ref   any
+}
+
+type Struct struct {
+	Type   *types.Struct
+	Fields []Value
+}
+
+// Function is a compiled function. Its parameters, self first, are the
+// first of its local slots.
+type Function struct {
+	Name      string
+	Arity     int
+	Locals    int
+	Result    types.Type
+	Code      []byte
+	Constants []Value
+	// ConstantTypes holds the type of every constant for the disassembler
+	ConstantTypes []types.Type
+	// Positions maps the offsets of instructions that can fail to the source
+	// position they were compiled from.
+	Positions map[int]util.Position
+}
+
+// Program is the compiled form of a set of programs. Init sets the global
+// bindings, Main is the index of the main function or -1.
+type Program struct {
+	Functions []*Function
+	Structs   []*types.Struct
+	// Methods maps the method names of every struct to their function, it
+	// is used to call methods on trait values.
+	Methods []map[string]int
+	Globals int
+	Init    int
+	Main    int
+}
+
+func (program *Program) String() string {
+	var sb strings.Builder
+
+	for idx, function := range program.Functions {
+		if idx > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(function.Disassemble(program))
+	}
+
+	return sb.String()
+}
+
+// Disassemble returns a listing of the instructions of a function, one per
+// line with its offset.
+func (function *Function) Disassemble(program *Program) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "== %s (arity %d, locals %d) ==\n", function.Name, function.Arity, function.Locals)
+
+	for offset := 0; offset < len(function.Code); {
+		var line strings.Builder
+		op := Opcode(function.Code[offset])
+		fmt.Fprintf(&line, "%04d  %-20s", offset, op)
+
+		switch opcodes[op].operand {
+		case U16:
+			arg := readU16(function.Code, offset+1)
+			fmt.Fprintf(&line, " %d", arg)
+
+			switch op {
+			case OP_CONST:
+				fmt.Fprintf(&line, " (%s)", Format(function.Constants[arg], function.ConstantTypes[arg]))
+			case OP_FUNCTION:
+				fmt.Fprintf(&line, " (%s)", program.Functions[arg].Name)
+			case OP_STRUCT, OP_IS_STRUCT:
+				fmt.Fprintf(&line, " (%s)", program.Structs[arg])
+			}
+		case KIND:
+			fmt.Fprintf(&line, " %s", kindName(function.Code[offset+1]))
+		case ARGC:
+			fmt.Fprintf(&line, " %d", function.Code[offset+1])
+		case U16_ARGC:
+			arg := readU16(function.Code, offset+1)
+			if op == OP_CALL_DIRECT {
+				fmt.Fprintf(&line, " %s", program.Functions[arg].Name)
+			} else {
+				fmt.Fprintf(&line, " %s", function.Constants[arg].Ref)
+			}
+			fmt.Fprintf(&line, " %d", function.Code[offset+3])
+		}
+
+		sb.WriteString(strings.TrimRight(line.String(), " ") + "\n")
+		offset += op.Width()
+	}
+
+	return sb.String()
+}
+
+func readU16(code []byte, offset int) int {
+	return int(code[offset])<<8 | int(code[offset+1])
+}
+
+var (
+	kindNames = map[byte]string{}
+	basics    = map[byte]*types.Basic{}
+)
+
+func init() {
+	for _, basic := range []*types.Basic{
+		types.Bool, types.U8, types.U16, types.U32, types.U64, types.I8, types.I16, types.I32, types.I64,
+		types.F32, types.F64, types.Num, types.Sym, types.Bin, types.Nil,
+	} {
+		kindNames[byte(basic.Kind)] = basic.Name
+		basics[byte(basic.Kind)] = basic
+	}
+	kindNames[KIND_REF] = "ref"
+}
+
+func kindName(kind byte) string {
+	if name, ok := kindNames[kind]; ok {
+		return name
+	}
+
+	return strconv.Itoa(int(kind))
+}
+
+// Format returns the value as it is written in source, given its type.
+func Format(value Value, t types.Type) string {
+	switch t := t.(type) {
+	case *types.Basic:
+		switch {
+		case t == types.Bool:
+			return strconv.FormatBool(value.Int != 0)
+		case t.IsSigned():
+			return strconv.FormatInt(int64(value.Int), 10)
+		case t.IsUnsigned():
+			return strconv.FormatUint(value.Int, 10)
+		case t.IsFloat():
+			return strconv.FormatFloat(value.Float, 'g', -1, 64)
+		case t == types.Bin:
+			return strconv.Quote(value.Ref.(string))
+		case t == types.Sym:
+			return "'" + value.Ref.(string)
+		}
+	case *types.Struct, *types.Trait:
+		structure := value.Ref.(*Struct)
+
+		var fields []string
+		for idx, field := range structure.Type.Fields {
+			fields = append(fields, field.Name+": "+Format(structure.Fields[idx], field.Type))
+		}
+
+		if len(fields) == 0 {
+			return structure.Type.Name + " {}"
+		}
+		return structure.Type.Name + " { " + strings.Join(fields, ", ") + " }"
+	case *types.Function:
+		return "fn " + value.Ref.(*Function).Name
+	}
+
+	return "nil"
+}
@@ -0,0 +1,421 @@
+package bytecode
+
+import (
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/consteval"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/ir"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/types"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
+)
+
+var instructions = map[ir.Op]Opcode{
+	ir.OP_NEG:  OP_NEG,
+	ir.OP_NOT:  OP_NOT,
+	ir.OP_ADD:  OP_ADD,
+	ir.OP_SUB:  OP_SUB,
+	ir.OP_MUL:  OP_MUL,
+	ir.OP_DIV:  OP_DIV,
+	ir.OP_POW:  OP_POW,
+	ir.OP_AND:  OP_AND,
+	ir.OP_OR:   OP_OR,
+	ir.OP_XOR:  OP_XOR,
+	ir.OP_SHL:  OP_SHL,
+	ir.OP_SHR:  OP_SHR,
+	ir.OP_ASHR: OP_ASHR,
+	ir.OP_CSHL: OP_CSHL,
+	ir.OP_CSHR: OP_CSHR,
+	ir.OP_EQ:   OP_EQ,
+	ir.OP_NE:   OP_NE,
+	ir.OP_LT:   OP_LT,
+	ir.OP_LE:   OP_LE,
+	ir.OP_GT:   OP_GT,
+	ir.OP_GE:   OP_GE,
+}
+
+type compiler struct {
+	program   *Program
+	functions map[*ir.Function]int
+	structs   map[*types.Struct]int
+	globals   map[*ir.Global]int
+
+	// The function that is compiled, the local slots of its values and the
+	// values that are computed where they are used
+	function *Function
+	locals   map[*ir.Value]int
+	uses     map[*ir.Value]int
+	deferred map[*ir.Value]bool
+
+	// The offsets the blocks start at and the jumps to them
+	blocks map[*ir.Block]int
+	jumps  map[int]*ir.Block
+
+	errors []diagnostics.Diagnostic
+}
+
+// Compile lowers the IR of a program into bytecode. Every function, method
+// and the initialiser of the global bindings become a function of the
+// compiled program. Values are kept in local slots, unless they are only
+// used by the next instruction that needs them.
+func Compile(program *ir.Program) (*Program, []diagnostics.Diagnostic) {
+	c := compiler{
+		program:   &Program{Main: -1, Structs: program.Structs, Globals: len(program.Globals)},
+		functions: map[*ir.Function]int{},
+		structs:   map[*types.Struct]int{},
+		globals:   map[*ir.Global]int{},
+	}
+
+	for idx, global := range program.Globals {
+		c.globals[global] = idx
+	}
+	for idx, structure := range program.Structs {
+		c.structs[structure] = idx
+	}
+
+	var functions []*ir.Function
+	for _, function := range program.Functions {
+		if function.External {
+			continue
+		}
+
+		c.functions[function] = len(c.program.Functions)
+		c.program.Functions = append(c.program.Functions, &Function{
+			Name:      function.Name,
+			Arity:     len(function.Params),
+			Result:    function.Result,
+			Positions: map[int]util.Position{},
+		})
+		functions = append(functions, function)
+	}
+
+	c.program.Init = c.functions[program.Init]
+	if program.Main != nil {
+		c.program.Main = c.functions[program.Main]
+	}
+
+	for _, structure := range program.Structs {
+		methods := map[string]int{}
+		for name, function := range program.Methods[structure] {
+			methods[name] = c.functions[function]
+		}
+		c.program.Methods = append(c.program.Methods, methods)
+	}
+
+	for _, function := range functions {
+		c.compileFunction(function, c.program.Functions[c.functions[function]])
+	}
+
+	return c.program, c.errors
+}
+
+func (c *compiler) errorf(pos util.Position, code string, format string, a ...any) {
+	c.errors = append(c.errors, diagnostics.Errorf(pos, code, format, a...))
+}
+
+// compileFunction compiles the blocks of a function in reverse postorder,
+// so that most jumps go to the next block and can be left out.
+func (c *compiler) compileFunction(decl *ir.Function, function *Function) {
+	c.function = function
+	c.locals = map[*ir.Value]int{}
+	c.uses = decl.Uses()
+	c.deferred = decl.Deferred(nil)
+	c.blocks = map[*ir.Block]int{}
+	c.jumps = map[int]*ir.Block{}
+
+	for _, param := range decl.Params {
+		c.locals[param] = c.newSlot()
+	}
+
+	order := decl.Order()
+	for idx, block := range order {
+		c.blocks[block] = len(function.Code)
+
+		var next *ir.Block
+		if idx+1 < len(order) {
+			next = order[idx+1]
+		}
+
+		for _, instr := range block.Instrs {
+			if !c.deferred[instr] && instr.Op != ir.OP_PHI {
+				c.compileInstr(instr, block, next)
+			}
+		}
+	}
+
+	for offset, block := range c.jumps {
+		c.patchTo(offset, c.blocks[block])
+	}
+}
+
+/* Helper methods */
+
+func (c *compiler) newSlot() int {
+	slot := c.function.Locals
+	c.function.Locals++
+	return slot
+}
+
+func (c *compiler) emit(op Opcode, operands ...int) int {
+	offset := len(c.function.Code)
+	c.function.Code = append(c.function.Code, byte(op))
+
+	switch opcodes[op].operand {
+	case U16:
+		c.function.Code = append(c.function.Code, byte(operands[0]>>8), byte(operands[0]))
+	case KIND, ARGC:
+		c.function.Code = append(c.function.Code, byte(operands[0]))
+	case U16_ARGC:
+		c.function.Code = append(c.function.Code, byte(operands[0]>>8), byte(operands[0]), byte(operands[1]))
+	}
+
+	return offset
+}
+
+// emitAt emits an instruction that can fail at runtime and remembers where
+// it comes from.
+func (c *compiler) emitAt(pos util.Position, op Opcode, operands ...int) {
+	c.function.Positions[c.emit(op, operands...)] = pos
+}
+
+func (c *compiler) addConstant(value Value, t types.Type) int {
+	if len(c.function.Constants) > 0xFFFF {
+		c.errorf(util.Position{}, diagnostics.TOO_LARGE, "Function %s has too many constants", c.function.Name)
+		return 0
+	}
+
+	c.function.Constants = append(c.function.Constants, value)
+	c.function.ConstantTypes = append(c.function.ConstantTypes, t)
+	return len(c.function.Constants) - 1
+}
+
+func (c *compiler) emitConstant(value Value, t types.Type) {
+	c.emit(OP_CONST, c.addConstant(value, t))
+}
+
+// emitJump emits a jump whose target is set by patch.
+func (c *compiler) emitJump(op Opcode) int {
+	return c.emit(op, 0) + 1
+}
+
+func (c *compiler) patch(offset int) {
+	c.patchTo(offset, len(c.function.Code))
+}
+
+func (c *compiler) patchTo(offset int, target int) {
+	if target > 0xFFFF {
+		c.errorf(util.Position{}, diagnostics.TOO_LARGE, "Function %s is too large", c.function.Name)
+		return
+	}
+
+	c.function.Code[offset] = byte(target >> 8)
+	c.function.Code[offset+1] = byte(target)
+}
+
+func kind(t types.Type) int {
+	if basic, ok := t.(*types.Basic); ok {
+		return int(basic.Kind)
+	}
+
+	return KIND_REF
+}
+
+func value(constant consteval.Value) Value {
+	switch {
+	case constant.Type.IsSigned():
+		return Value{Int: uint64(constant.Int.Int64())}
+	case constant.Type.IsInteger():
+		return Value{Int: constant.Int.Uint64()}
+	case constant.Type.IsFloat():
+		return Value{Float: constant.Float}
+	case constant.Type == types.Bool && constant.Bool:
+		return Value{Int: 1}
+	case constant.Type == types.Bin:
+		return Value{Ref: constant.Bytes}
+	case constant.Type == types.Sym:
+		return Value{Ref: constant.Sym}
+	}
+
+	return Value{}
+}
+
+/* Instructions */
+
+// slot returns the local slot of a value, values get their slot when they
+// are first stored or loaded.
+func (c *compiler) slot(instr *ir.Value) int {
+	slot, ok := c.locals[instr]
+	if !ok {
+		slot = c.newSlot()
+		c.locals[instr] = slot
+	}
+
+	return slot
+}
+
+// compileInstr computes an instruction and keeps its value in its local
+// slot if it is used.
+func (c *compiler) compileInstr(instr *ir.Value, block *ir.Block, next *ir.Block) {
+	if instr.IsTerminator() {
+		c.compileTerminator(instr, block, next)
+		return
+	}
+
+	if c.uses[instr] == 0 && instr.Effect() == ir.PURE {
+		return
+	}
+
+	c.compute(instr)
+	switch {
+	case instr.Op == ir.OP_STORE:
+	case c.uses[instr] > 0:
+		c.emit(OP_STORE_LOCAL, c.slot(instr))
+	default:
+		// Every call pushes a value, which is a placeholder for functions
+		// without a result
+		c.emit(OP_POP)
+	}
+}
+
+// push pushes an operand of an instruction.
+func (c *compiler) push(operand *ir.Value) {
+	switch {
+	case operand.Op == ir.OP_CONST:
+		c.emitConstant(value(operand.Const), operand.Type)
+	case operand.Op == ir.OP_FUNCTION:
+		c.emitFunction(operand.Function, operand.Pos)
+	case c.deferred[operand]:
+		c.compute(operand)
+	default:
+		c.emit(OP_LOAD_LOCAL, c.slot(operand))
+	}
+}
+
+func (c *compiler) emitFunction(function *ir.Function, pos util.Position) {
+	idx, ok := c.functions[function]
+	if !ok {
+		c.errorf(pos, diagnostics.UNSUPPORTED, "External function %s cannot be compiled to bytecode", function.Decl.Identifer.Name)
+		return
+	}
+
+	c.emit(OP_FUNCTION, idx)
+}
+
+// compute pushes the value of an instruction.
+func (c *compiler) compute(instr *ir.Value) {
+	for _, arg := range instr.Args {
+		c.push(arg)
+	}
+
+	switch instr.Op {
+	case ir.OP_NOT:
+		c.emit(OP_NOT, kind(instr.Args[0].Type))
+	case ir.OP_CONVERT:
+		source, ok := instr.Args[0].Type.(*types.Basic)
+		target, isBasic := instr.Type.(*types.Basic)
+		// Integers already share their representation and trait values
+		// are the structs they hold
+		if ok && isBasic && source.IsInteger() && target.IsFloat() {
+			c.emit(OP_INT_TO_FLOAT, kind(source))
+		}
+	case ir.OP_FORMAT:
+		c.emit(OP_FORMAT, kind(instr.Args[0].Type))
+	case ir.OP_STRUCT:
+		c.emit(OP_STRUCT, c.structs[instr.Type.(*types.Struct)])
+	case ir.OP_FIELD:
+		c.emit(OP_GET_FIELD, instr.Index)
+	case ir.OP_INSERT:
+		c.emit(OP_INSERT, instr.Index)
+	case ir.OP_INDEX, ir.OP_SET_INDEX:
+		op := OP_INDEX
+		if instr.Op == ir.OP_SET_INDEX {
+			op = OP_SET_INDEX
+		}
+		c.emitAt(instr.Pos, op, kind(instr.Args[1].Type))
+	case ir.OP_IS:
+		c.emit(OP_IS_STRUCT, c.structs[instr.Struct])
+	case ir.OP_LOAD:
+		c.emit(OP_LOAD_GLOBAL, c.globals[instr.Global])
+	case ir.OP_STORE:
+		c.emit(OP_STORE_GLOBAL, c.globals[instr.Global])
+	case ir.OP_CALL:
+		idx, ok := c.functions[instr.Function]
+		if !ok {
+			c.errorf(instr.Pos, diagnostics.UNSUPPORTED, "External function %s cannot be compiled to bytecode", instr.Function.Decl.Identifer.Name)
+			return
+		}
+		c.emitAt(instr.Pos, OP_CALL_DIRECT, idx, len(instr.Args))
+	case ir.OP_CALL_VALUE:
+		c.emitAt(instr.Pos, OP_CALL, len(instr.Args)-1)
+	case ir.OP_CALL_METHOD:
+		c.emitAt(instr.Pos, OP_CALL_METHOD, c.addConstant(Value{Ref: instr.Name}, types.Sym), len(instr.Args))
+	default:
+		c.emitAt(instr.Pos, instructions[instr.Op], kind(instr.Args[0].Type))
+	}
+}
+
+// copies stores the values a block passes to the phis of a successor in
+// their local slots.
+func (c *compiler) copies(from *ir.Block, to *ir.Block) bool {
+	copied := false
+	for _, instr := range to.Instrs {
+		if instr.Op != ir.OP_PHI {
+			break
+		}
+
+		for idx, block := range instr.Blocks {
+			if block == from {
+				c.push(instr.Args[idx])
+				c.emit(OP_STORE_LOCAL, c.slot(instr))
+				copied = true
+			}
+		}
+	}
+
+	return copied
+}
+
+// jump jumps to a block, unless it comes next.
+func (c *compiler) jump(to *ir.Block, next *ir.Block) {
+	if to != next {
+		c.jumps[c.emitJump(OP_JUMP)] = to
+	}
+}
+
+func (c *compiler) compileTerminator(instr *ir.Value, block *ir.Block, next *ir.Block) {
+	switch instr.Op {
+	case ir.OP_JUMP:
+		c.copies(block, instr.Blocks[0])
+		c.jump(instr.Blocks[0], next)
+	case ir.OP_BRANCH:
+		then, otherwise := instr.Blocks[0], instr.Blocks[1]
+		c.push(instr.Args[0])
+		fail := c.emitJump(OP_JUMP_IF_FALSE)
+
+		if !hasPhis(otherwise) {
+			c.jumps[fail] = otherwise
+			c.copies(block, then)
+			c.jump(then, next)
+			return
+		}
+
+		// The values for the phis of the else branch are stored on the
+		// way there
+		c.copies(block, then)
+		c.jump(then, nil)
+		c.patch(fail)
+		c.copies(block, otherwise)
+		c.jump(otherwise, next)
+	case ir.OP_RETURN:
+		if len(instr.Args) > 0 {
+			c.push(instr.Args[0])
+		} else {
+			c.emitConstant(Value{}, types.Void)
+		}
+		c.emit(OP_RETURN)
+	case ir.OP_UNREACHABLE:
+		c.emitAt(instr.Pos, OP_UNREACHABLE)
+	}
+}
+
+func hasPhis(block *ir.Block) bool {
+	return len(block.Instrs) > 0 && block.Instrs[0].Op == ir.OP_PHI
+}
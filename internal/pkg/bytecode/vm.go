@@ -0,0 +1,247 @@
+package bytecode
+
+import (
+	"math/big"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/types"
+)
+
+const maxFrames = 10000
+
+type frame struct {
+	function *Function
+	ip       int
+	// base is the first local slot, the stack is cut back to top on return
+	base int
+	top  int
+}
+
+// fail reports an error of the instruction at the given offset.
+func (f *frame) fail(offset int, err error) error {
+	return diagnostics.Errorf(f.function.Positions[offset], diagnostics.CodeOf(err), "%s", err)
+}
+
+// VM runs a compiled program on a single stack that holds the local slots
+// and the temporary values of all active calls.
+type VM struct {
+	program *Program
+	stack   []Value
+	frames  []frame
+	globals []Value
+	structs map[*types.Struct]int
+}
+
+func New(program *Program) *VM {
+	vm := &VM{
+		program: program,
+		globals: make([]Value, program.Globals),
+		structs: map[*types.Struct]int{},
+	}
+
+	for idx, structure := range program.Structs {
+		vm.structs[structure] = idx
+	}
+
+	return vm
+}
+
+// Run sets the global bindings and runs main if there is one. The result of
+// main is returned.
+func (vm *VM) Run() (Value, error) {
+	if _, err := vm.Call(vm.program.Init); err != nil {
+		return Value{}, err
+	}
+
+	if vm.program.Main < 0 {
+		return Value{}, nil
+	}
+
+	return vm.Call(vm.program.Main)
+}
+
+// Call calls a function of the program with the given arguments.
+func (vm *VM) Call(idx int, arguments ...Value) (Value, error) {
+	depth, top := len(vm.frames), len(vm.stack)
+	vm.stack = append(vm.stack, arguments...)
+
+	err := vm.call(vm.program.Functions[idx], len(arguments), top)
+	if err == nil {
+		var result Value
+		if result, err = vm.execute(depth); err == nil {
+			return result, nil
+		}
+	}
+
+	vm.frames, vm.stack = vm.frames[:depth], vm.stack[:top]
+	return Value{}, err
+}
+
+func (vm *VM) push(value Value) {
+	vm.stack = append(vm.stack, value)
+}
+
+func (vm *VM) pop() Value {
+	value := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return value
+}
+
+// call starts a function whose arguments are on top of the stack.
+func (vm *VM) call(function *Function, argc int, top int) error {
+	if len(vm.frames) >= maxFrames {
+		return diagnostics.Failf(diagnostics.STACK_OVERFLOW, "Stack overflow, too many nested calls")
+	}
+
+	base := len(vm.stack) - argc
+	vm.stack = append(vm.stack, make([]Value, function.Locals-argc)...)
+	vm.frames = append(vm.frames, frame{function: function, base: base, top: top})
+
+	return nil
+}
+
+// index reads an index of the given kind and checks it against the length
+// of a byte string.
+func index(value Value, kind byte, bytes string) (int, error) {
+	var position *big.Int
+	if basics[kind].IsSigned() {
+		position = big.NewInt(int64(value.Int))
+	} else {
+		position = new(big.Int).SetUint64(value.Int)
+	}
+
+	if position.Sign() < 0 || !position.IsInt64() || position.Int64() >= int64(len(bytes)) {
+		return 0, diagnostics.Failf(diagnostics.INDEX_OUT_OF_RANGE, "Index %s is out of range for length %d", position, len(bytes))
+	}
+
+	return int(position.Int64()), nil
+}
+
+// execute runs instructions until the call at the given depth returns.
+func (vm *VM) execute(depth int) (Value, error) {
+	f := &vm.frames[len(vm.frames)-1]
+
+	for {
+		code := f.function.Code
+		offset := f.ip
+		op := Opcode(code[offset])
+		f.ip += op.Width()
+
+		switch op {
+		case OP_CONST:
+			vm.push(f.function.Constants[readU16(code, offset+1)])
+		case OP_POP:
+			vm.pop()
+		case OP_LOAD_LOCAL:
+			vm.push(vm.stack[f.base+readU16(code, offset+1)])
+		case OP_STORE_LOCAL:
+			vm.stack[f.base+readU16(code, offset+1)] = vm.pop()
+		case OP_LOAD_GLOBAL:
+			vm.push(vm.globals[readU16(code, offset+1)])
+		case OP_STORE_GLOBAL:
+			vm.globals[readU16(code, offset+1)] = vm.pop()
+		case OP_FUNCTION:
+			vm.push(Value{Ref: vm.program.Functions[readU16(code, offset+1)]})
+		case OP_NEG, OP_NOT:
+			result, err := unary(op, code[offset+1], vm.pop())
+			if err != nil {
+				return Value{}, f.fail(offset, err)
+			}
+			vm.push(result)
+		case OP_ADD, OP_SUB, OP_MUL, OP_DIV, OP_POW, OP_AND, OP_OR, OP_XOR,
+			OP_SHL, OP_SHR, OP_ASHR, OP_CSHL, OP_CSHR, OP_EQ, OP_NE, OP_LT, OP_LE, OP_GT, OP_GE:
+			right := vm.pop()
+			result, err := binary(op, code[offset+1], vm.pop(), right)
+			if err != nil {
+				return Value{}, f.fail(offset, err)
+			}
+			vm.push(result)
+		case OP_INT_TO_FLOAT:
+			top := &vm.stack[len(vm.stack)-1]
+			if basics[code[offset+1]].IsSigned() {
+				*top = Value{Float: float64(int64(top.Int))}
+			} else {
+				*top = Value{Float: float64(top.Int)}
+			}
+		case OP_FORMAT:
+			top := &vm.stack[len(vm.stack)-1]
+			*top = format(*top, code[offset+1])
+		case OP_INDEX:
+			position := vm.pop()
+			bytes := vm.pop().Ref.(string)
+			idx, err := index(position, code[offset+1], bytes)
+			if err != nil {
+				return Value{}, f.fail(offset, err)
+			}
+			vm.push(Value{Int: uint64(bytes[idx])})
+		case OP_SET_INDEX:
+			value := vm.pop()
+			position := vm.pop()
+			bytes := vm.pop().Ref.(string)
+			idx, err := index(position, code[offset+1], bytes)
+			if err != nil {
+				return Value{}, f.fail(offset, err)
+			}
+			vm.push(Value{Ref: bytes[:idx] + string([]byte{byte(value.Int)}) + bytes[idx+1:]})
+		case OP_STRUCT:
+			structure := vm.program.Structs[readU16(code, offset+1)]
+			fields := make([]Value, len(structure.Fields))
+			copy(fields, vm.stack[len(vm.stack)-len(fields):])
+			vm.stack = vm.stack[:len(vm.stack)-len(fields)]
+			vm.push(Value{Ref: &Struct{Type: structure, Fields: fields}})
+		case OP_GET_FIELD:
+			vm.push(vm.pop().Ref.(*Struct).Fields[readU16(code, offset+1)])
+		case OP_INSERT:
+			value := vm.pop()
+			structure := vm.pop().Ref.(*Struct)
+			fields := append([]Value(nil), structure.Fields...)
+			fields[readU16(code, offset+1)] = value
+			vm.push(Value{Ref: &Struct{Type: structure.Type, Fields: fields}})
+		case OP_IS_STRUCT:
+			structure, ok := vm.pop().Ref.(*Struct)
+			vm.push(boolean(ok && structure.Type == vm.program.Structs[readU16(code, offset+1)]))
+		case OP_JUMP:
+			f.ip = readU16(code, offset+1)
+		case OP_JUMP_IF_FALSE:
+			if vm.pop().Int == 0 {
+				f.ip = readU16(code, offset+1)
+			}
+		case OP_CALL, OP_CALL_DIRECT, OP_CALL_METHOD:
+			var function *Function
+			argc := int(code[offset+op.Width()-1])
+			top := len(vm.stack) - argc
+
+			switch op {
+			case OP_CALL:
+				top--
+				function = vm.stack[top].Ref.(*Function)
+			case OP_CALL_DIRECT:
+				function = vm.program.Functions[readU16(code, offset+1)]
+			default:
+				name := f.function.Constants[readU16(code, offset+1)].Ref.(string)
+				receiver := vm.stack[top].Ref.(*Struct)
+				function = vm.program.Functions[vm.program.Methods[vm.structs[receiver.Type]][name]]
+			}
+
+			if err := vm.call(function, argc, top); err != nil {
+				return Value{}, f.fail(offset, err)
+			}
+			f = &vm.frames[len(vm.frames)-1]
+		case OP_RETURN:
+			result := vm.pop()
+			vm.stack = vm.stack[:f.top]
+			vm.frames = vm.frames[:len(vm.frames)-1]
+
+			if len(vm.frames) == depth {
+				return result, nil
+			}
+
+			vm.push(result)
+			f = &vm.frames[len(vm.frames)-1]
+		case OP_UNREACHABLE:
+			return Value{}, f.fail(offset, diagnostics.Failf(diagnostics.INVALID_PROGRAM, "Reached the end of %s without a value", f.function.Name))
+		default:
+			return Value{}, f.fail(offset, diagnostics.Failf(diagnostics.INVALID_PROGRAM, "Unknown opcode %d", op))
+		}
+	}
+}
@@ -0,0 +1,533 @@
+package cgen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/consteval"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/ir"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/types"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
+)
+
+type generator struct {
+	program *ir.Program
+
+	// C names of functions, globals and types
+	names   map[any]string
+	taken   map[string]bool
+	fnTypes map[string]string
+
+	typedefs    strings.Builder
+	definitions strings.Builder
+	prototypes  strings.Builder
+	helpers     strings.Builder
+	code        strings.Builder
+
+	// The function that is generated: the names of its values, the values
+	// that are computed where they are used and the labels of its blocks
+	body     *strings.Builder
+	values   map[*ir.Value]string
+	counts   map[string]int
+	uses     map[*ir.Value]int
+	deferred map[*ir.Value]bool
+	labels   map[*ir.Block]string
+	targeted map[*ir.Block]bool
+
+	errors []diagnostics.Diagnostic
+}
+
+// Generate translates the IR of a program into a single C99 file. Structs
+// become C structs, trait values a pointer to a struct with a table of its
+// methods. If there is a main function, the file has a C main that runs it
+// and exits with its result if that is an integer.
+func Generate(program *ir.Program) (string, []diagnostics.Diagnostic) {
+	g := generator{
+		program: program,
+		names:   map[any]string{},
+		taken:   map[string]bool{},
+		fnTypes: map[string]string{},
+	}
+
+	g.declare()
+	g.declareTypes()
+	g.declareHelpers()
+
+	for _, function := range program.Functions {
+		if !function.External {
+			g.generateFunction(function)
+		}
+	}
+	g.generateMain()
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "#define Q_MAX_SHIFT %d\n\n", consteval.MAX_UNTYPED_BITS)
+	sb.WriteString(runtime)
+	for _, section := range []*strings.Builder{&g.typedefs, &g.definitions, &g.prototypes, &g.helpers, &g.code} {
+		if section.Len() > 0 {
+			sb.WriteString("\n")
+			sb.WriteString(strings.TrimRight(section.String(), "\n") + "\n")
+		}
+	}
+
+	return sb.String(), g.errors
+}
+
+func (g *generator) errorf(pos util.Position, code string, format string, a ...any) {
+	g.errors = append(g.errors, diagnostics.Errorf(pos, code, format, a...))
+}
+
+// unique returns a C name that is not used yet.
+func (g *generator) unique(name string) string {
+	candidate := name
+	for idx := 2; g.taken[candidate]; idx++ {
+		candidate = name + "_" + strconv.Itoa(idx)
+	}
+
+	g.taken[candidate] = true
+	return candidate
+}
+
+// cName turns a qualified name of the IR into a C identifier.
+var cName = strings.NewReplacer("::", "_", ".", "_").Replace
+
+// declare names the types, globals and functions of the program.
+func (g *generator) declare() {
+	// External functions keep their name to link against C code
+	for _, function := range g.program.Functions {
+		if function.External {
+			g.names[function] = function.Decl.Identifer.Name
+			g.taken[function.Decl.Identifer.Name] = true
+		}
+	}
+
+	for _, structure := range g.program.Structs {
+		g.names[structure] = g.unique("qs_" + cName(structure.Name))
+	}
+	for _, trait := range g.program.Traits {
+		g.names[trait] = g.unique("qt_" + cName(trait.Name))
+	}
+	for _, global := range g.program.Globals {
+		g.names[global] = g.unique("g_" + cName(global.Name))
+	}
+
+	for _, function := range g.program.Functions {
+		switch {
+		case function.External:
+		case function == g.program.Init:
+			g.names[function] = g.unique("q_init")
+		default:
+			g.names[function] = g.unique("qf_" + cName(function.Name))
+		}
+	}
+}
+
+// base returns a C type name without its prefix, to name the helpers of
+// the type.
+func (g *generator) base(t types.Type) string {
+	return g.names[t][3:]
+}
+
+/* Types */
+
+var basicTypes = map[types.BasicKind]string{
+	types.KIND_BOOL: "bool",
+	types.KIND_U8:   "uint8_t",
+	types.KIND_U16:  "uint16_t",
+	types.KIND_U32:  "uint32_t",
+	types.KIND_U64:  "uint64_t",
+	types.KIND_I8:   "int8_t",
+	types.KIND_I16:  "int16_t",
+	types.KIND_I32:  "int32_t",
+	types.KIND_I64:  "int64_t",
+	types.KIND_F32:  "float",
+	types.KIND_F64:  "double",
+	types.KIND_NUM:  "double",
+	types.KIND_SYM:  "const char *",
+	types.KIND_BIN:  "q_bin",
+	types.KIND_VOID: "void",
+}
+
+func (g *generator) ctype(t types.Type) string {
+	switch t := t.(type) {
+	case *types.Basic:
+		if name, ok := basicTypes[t.Kind]; ok {
+			return name
+		}
+		return "int"
+	case *types.Function:
+		return g.fnType(t)
+	case nil:
+		return "void"
+	}
+
+	return g.names[t]
+}
+
+// declaration declares a variable of a type.
+func (g *generator) declaration(t types.Type, name string) string {
+	ctype := g.ctype(t)
+	if strings.HasSuffix(ctype, "*") {
+		return ctype + name
+	}
+
+	return ctype + " " + name
+}
+
+// fnType returns the name of the function pointer type of a signature.
+func (g *generator) fnType(t *types.Function) string {
+	key := t.String()
+	if name, ok := g.fnTypes[key]; ok {
+		return name
+	}
+
+	var parameters []string
+	for _, parameter := range t.Parameters {
+		parameters = append(parameters, g.ctype(parameter))
+	}
+
+	name := g.unique("qfn_" + strconv.Itoa(len(g.fnTypes)+1))
+	g.fnTypes[key] = name
+	fmt.Fprintf(&g.typedefs, "typedef %s (*%s)(%s);\n", g.ctype(t.Result), name, parameterList(parameters))
+
+	return name
+}
+
+func parameterList(parameters []string) string {
+	if len(parameters) == 0 {
+		return "void"
+	}
+
+	return strings.Join(parameters, ", ")
+}
+
+// declareTypes defines the structs, ordered so that every struct comes after
+// the structs it contains, and the values and method tables of traits.
+func (g *generator) declareTypes() {
+	for _, structure := range g.program.Structs {
+		fmt.Fprintf(&g.typedefs, "typedef struct %s %s;\n", g.names[structure], g.names[structure])
+	}
+
+	for _, trait := range g.program.Traits {
+		fmt.Fprintf(&g.typedefs, "typedef struct %s %s;\n", g.names[trait], g.names[trait])
+	}
+
+	for _, trait := range g.program.Traits {
+		fmt.Fprintf(&g.definitions, "struct %s {\n\tvoid *self;\n\tconst struct %s_vtable *vtable;\n};\n\n", g.names[trait], g.names[trait])
+	}
+
+	state := map[*types.Struct]int{}
+	var define func(structure *types.Struct)
+	define = func(structure *types.Struct) {
+		switch state[structure] {
+		case 1:
+			g.errorf(structure.Decl.Identifer.Pos, diagnostics.RECURSIVE_STRUCT, "Struct %s contains itself", structure.Name)
+			return
+		case 2:
+			return
+		}
+
+		state[structure] = 1
+		for _, field := range structure.Fields {
+			if nested, ok := field.Type.(*types.Struct); ok {
+				define(nested)
+			}
+		}
+		state[structure] = 2
+
+		fmt.Fprintf(&g.definitions, "struct %s {\n", g.names[structure])
+		for _, field := range structure.Fields {
+			fmt.Fprintf(&g.definitions, "\t%s;\n", g.declaration(field.Type, "f_"+field.Name))
+		}
+		if len(structure.Fields) == 0 {
+			// C does not allow empty structs
+			g.definitions.WriteString("\tchar empty;\n")
+		}
+		g.definitions.WriteString("};\n\n")
+	}
+
+	for _, structure := range g.program.Structs {
+		define(structure)
+	}
+
+	for _, trait := range g.program.Traits {
+		fmt.Fprintf(&g.definitions, "struct %s_vtable {\n\tbool (*equal)(void *, void *);\n", g.names[trait])
+		for _, method := range trait.Methods {
+			if method.Self {
+				fmt.Fprintf(&g.definitions, "\t%s (*m_%s)(%s);\n", g.ctype(method.Signature.Result), method.Name, strings.Join(g.methodParameters(method, false), ", "))
+			}
+		}
+		g.definitions.WriteString("};\n\n")
+	}
+}
+
+// methodParameters returns the parameters of a method in a method table,
+// whose self is an untyped pointer.
+func (g *generator) methodParameters(method *types.Method, named bool) []string {
+	parameters := []string{"void *self"}
+	if !named {
+		parameters[0] = "void *"
+	}
+
+	for idx, parameter := range method.Signature.Parameters {
+		if named {
+			parameters = append(parameters, g.declaration(parameter, "a"+strconv.Itoa(idx)))
+		} else {
+			parameters = append(parameters, g.ctype(parameter))
+		}
+	}
+
+	return parameters
+}
+
+func arguments(method *types.Method, self string) string {
+	args := []string{self}
+	for idx := range method.Signature.Parameters {
+		args = append(args, "a"+strconv.Itoa(idx))
+	}
+
+	return strings.Join(args, ", ")
+}
+
+// declareHelpers writes the prototypes of all functions and the helpers of
+// structs and traits: comparisons, boxing into trait values, method tables
+// and the dispatch of trait methods.
+func (g *generator) declareHelpers() {
+	for _, function := range g.program.Functions {
+		switch {
+		case function.External:
+			fmt.Fprintf(&g.prototypes, "extern %s;\n", g.signature(function, false))
+		case function != g.program.Init:
+			fmt.Fprintf(&g.prototypes, "%s;\n", g.signature(function, false))
+		}
+	}
+
+	for _, structure := range g.program.Structs {
+		fmt.Fprintf(&g.prototypes, "static inline bool qeq_%s(%s a, %s b);\n", g.base(structure), g.names[structure], g.names[structure])
+	}
+
+	for _, trait := range g.program.Traits {
+		name := g.names[trait]
+		fmt.Fprintf(&g.helpers, "static inline bool qeq_%s(%s a, %s b) {\n\treturn a.vtable == b.vtable && a.vtable->equal(a.self, b.self);\n}\n\n", g.base(trait), name, name)
+
+		for _, method := range trait.Methods {
+			if !method.Self {
+				continue
+			}
+
+			fmt.Fprintf(&g.helpers, "static inline %s qd_%s_%s(%s) {\n\t", g.ctype(method.Signature.Result), g.base(trait), method.Name, strings.Join(append([]string{name + " value"}, g.methodParameters(method, true)[1:]...), ", "))
+			if method.Signature.Result != types.Void {
+				g.helpers.WriteString("return ")
+			}
+			fmt.Fprintf(&g.helpers, "value.vtable->m_%s(%s);\n}\n\n", method.Name, arguments(method, "value.self"))
+		}
+	}
+
+	for _, structure := range g.program.Structs {
+		name, base := g.names[structure], g.base(structure)
+
+		var fields []string
+		for _, field := range structure.Fields {
+			fields = append(fields, g.compare("==", "a.f_"+field.Name, "b.f_"+field.Name, field.Type))
+		}
+		if len(fields) == 0 {
+			fields = append(fields, "true")
+		}
+		fmt.Fprintf(&g.helpers, "static inline bool qeq_%s(%s a, %s b) {\n\treturn %s;\n}\n\n", base, name, name, strings.Join(fields, " && "))
+
+		if len(structure.Traits) == 0 {
+			continue
+		}
+		fmt.Fprintf(&g.helpers, "static bool qw_%s_equal(void *a, void *b) {\n\treturn qeq_%s(*(%s *)a, *(%s *)b);\n}\n\n", base, base, name, name)
+
+		for _, trait := range structure.Traits {
+			fmt.Fprintf(&g.prototypes, "static inline %s qb_%s_%s(%s value);\n", g.names[trait], base, g.base(trait), name)
+		}
+
+		for _, trait := range structure.Traits {
+			pair := base + "_" + g.base(trait)
+			entries := []string{"qw_" + base + "_equal"}
+
+			for _, method := range trait.Methods {
+				if !method.Self {
+					continue
+				}
+
+				// Default methods take their receiver as a trait value
+				implementation := g.program.Methods[structure][method.Name]
+				self := "*(" + name + " *)self"
+				if owner, ok := implementation.Params[0].Type.(*types.Trait); ok {
+					self = "qb_" + base + "_" + g.base(owner) + "(" + self + ")"
+				}
+
+				fmt.Fprintf(&g.helpers, "static %s qw_%s_%s(%s) {\n\t", g.ctype(method.Signature.Result), pair, method.Name, strings.Join(g.methodParameters(method, true), ", "))
+				if method.Signature.Result != types.Void {
+					g.helpers.WriteString("return ")
+				}
+				fmt.Fprintf(&g.helpers, "%s(%s);\n}\n\n", g.names[implementation], arguments(method, self))
+				entries = append(entries, "qw_"+pair+"_"+method.Name)
+			}
+
+			fmt.Fprintf(&g.helpers, "static const struct %s_vtable qv_%s = {%s};\n\n", g.names[trait], pair, strings.Join(entries, ", "))
+			fmt.Fprintf(&g.helpers, "static inline %s qb_%s(%s value) {\n\t%s *self = q_alloc(sizeof value);\n\t*self = value;\n\treturn (%s){self, &qv_%s};\n}\n\n", g.names[trait], pair, name, name, g.names[trait], pair)
+		}
+	}
+
+	for _, global := range g.program.Globals {
+		fmt.Fprintf(&g.prototypes, "static %s;\n", g.declaration(global.Type, g.names[global]))
+	}
+}
+
+// signature returns the C declaration of a function. Parameters are named
+// after their values if named is set.
+func (g *generator) signature(function *ir.Function, named bool) string {
+	if function == g.program.Init {
+		return "static void " + g.names[function] + "(void)"
+	}
+
+	var parameters []string
+	for idx, param := range function.Params {
+		name := "p" + strconv.Itoa(idx)
+		switch {
+		case function.Receiver && idx == 0:
+			name = "self"
+		case named:
+			name = g.values[param]
+		}
+		parameters = append(parameters, g.declaration(param.Type, name))
+	}
+
+	return g.declaration(function.Result, g.names[function]) + "(" + parameterList(parameters) + ")"
+}
+
+// generateMain writes the C main function, which sets the global bindings
+// and runs main.
+func (g *generator) generateMain() {
+	main := g.program.Main
+	if main == nil {
+		return
+	}
+
+	if basic, ok := main.Result.(*types.Basic); ok && basic.IsInteger() {
+		fmt.Fprintf(&g.code, "\nint main(void) {\n\t%s();\n\treturn (int)%s();\n}\n", g.names[g.program.Init], g.names[main])
+	} else {
+		fmt.Fprintf(&g.code, "\nint main(void) {\n\t%s();\n\t%s();\n\treturn 0;\n}\n", g.names[g.program.Init], g.names[main])
+	}
+}
+
+func pushes(t types.Type) bool {
+	return t != nil && t != types.Void
+}
+
+/* Values */
+
+// cString quotes bytes as a C string literal, escaping everything that is
+// not printable ASCII.
+func cString(value string) string {
+	var sb strings.Builder
+
+	sb.WriteByte('"')
+	for idx := 0; idx < len(value); idx++ {
+		c := value[idx]
+		switch {
+		case c == '"' || c == '\\' || c == '?':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c >= 0x20 && c < 0x7F:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "\\%03o", c)
+		}
+	}
+	sb.WriteByte('"')
+
+	return sb.String()
+}
+
+func position(pos util.Position) string {
+	return cString(pos.String())
+}
+
+// literal writes a constant as a C expression of the type of its value.
+func (g *generator) literal(constant consteval.Value, t types.Type) string {
+	basic, ok := t.(*types.Basic)
+	if !ok {
+		return "0"
+	}
+
+	if constant.Type.IsUntyped() && basic.IsNumeric() && !basic.IsUntyped() {
+		if converted, err := consteval.Convert(constant, basic); err == nil {
+			constant = converted
+		}
+	}
+
+	switch {
+	case basic.IsSigned() && basic.Bits == 64:
+		if constant.Int.Cmp(basic.MinInt()) == 0 {
+			return "INT64_MIN"
+		}
+		return "INT64_C(" + constant.Int.String() + ")"
+	case basic.IsUnsigned() && basic.Bits == 64:
+		return "UINT64_C(" + constant.Int.String() + ")"
+	case basic.IsInteger():
+		return "((" + basicTypes[basic.Kind] + ")" + constant.Int.String() + ")"
+	case basic.IsFloat():
+		float := strconv.FormatFloat(constant.Float, 'g', 17, 64)
+		if !strings.ContainsAny(float, ".e") {
+			float += ".0"
+		}
+		return "((" + basicTypes[basic.Kind] + ")" + float + ")"
+	case basic == types.Bool:
+		return strconv.FormatBool(constant.Bool)
+	case basic == types.Bin:
+		return fmt.Sprintf("((q_bin){(const uint8_t *)%s, %d})", cString(constant.Bytes), len(constant.Bytes))
+	case basic == types.Sym:
+		return cString(constant.Sym)
+	}
+
+	return "0"
+}
+
+// convert writes the conversion of a value to another type. Structs become
+// trait values and trait values are unpacked into the struct they hold.
+func (g *generator) convert(value string, from types.Type, to types.Type) string {
+	switch to := to.(type) {
+	case *types.Trait:
+		if structure, ok := from.(*types.Struct); ok {
+			return "qb_" + g.base(structure) + "_" + g.base(to) + "(" + value + ")"
+		}
+	case *types.Struct:
+		if _, ok := from.(*types.Trait); ok {
+			return "(*(" + g.names[to] + " *)" + value + ".self)"
+		}
+	case *types.Basic:
+		if _, ok := from.(*types.Basic); ok && g.ctype(from) != g.ctype(to) {
+			return "((" + g.ctype(to) + ")" + value + ")"
+		}
+	}
+
+	return value
+}
+
+// compare writes a comparison of two values of the same type.
+func (g *generator) compare(operator string, left string, right string, t types.Type) string {
+	switch t := t.(type) {
+	case *types.Struct, *types.Trait:
+		equal := "qeq_" + g.base(t) + "(" + left + ", " + right + ")"
+		if operator == "!=" {
+			return "!" + equal
+		}
+		return equal
+	case *types.Basic:
+		switch t.Kind {
+		case types.KIND_BIN:
+			return "(q_bin_cmp(" + left + ", " + right + ") " + operator + " 0)"
+		case types.KIND_SYM:
+			return "(strcmp(" + left + ", " + right + ") " + operator + " 0)"
+		}
+	}
+
+	return "(" + left + " " + operator + " " + right + ")"
+}
@@ -0,0 +1,144 @@
+package cgen_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/cgen"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/testutil"
+)
+
+type testStruct struct {
+	input string
+	want  string
+}
+
+// generate generates the code of the input after running the optimisations
+// of the given level on its IR.
+func generate(t *testing.T, input string, level int) string {
+	built := testutil.Build(t, level, input)
+
+	code, generateErrors := cgen.Generate(built)
+	if len(generateErrors) > 0 {
+		t.Fatalf("unexpected generator errors: %s", generateErrors)
+	}
+
+	return code
+}
+
+// run compiles the generated code with cc and returns the exit code of the
+// program or the error it stopped with.
+func run(t *testing.T, input string, level int) string {
+	compiler, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("cc is not installed")
+	}
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "main.c")
+	if err := os.WriteFile(source, []byte(generate(t, input, level)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	binary := filepath.Join(dir, "main")
+	if output, err := exec.Command(compiler, "-std=c99", "-pedantic-errors", "-Werror=type-limits", "-o", binary, source, "-lm").CombinedOutput(); err != nil {
+		t.Fatalf("cc failed: %s\n%s", err, output)
+	}
+
+	var stderr strings.Builder
+	command := exec.Command(binary)
+	command.Stderr = &stderr
+
+	var exit *exec.ExitError
+	if err := command.Run(); errors.As(err, &exit) && stderr.Len() > 0 {
+		return strings.TrimSpace(stderr.String())
+	} else if err != nil && exit == nil {
+		t.Fatal(err)
+	}
+
+	return strconv.Itoa(command.ProcessState.ExitCode())
+}
+
+func testHelper(t *testing.T, tests []testStruct) {
+	for _, test := range tests {
+		for _, level := range []int{0, 2} {
+			t.Run(fmt.Sprintf("O%d/%s", level, test.input), func(t *testing.T) {
+				if actual := run(t, test.input, level); actual != test.want {
+					t.Errorf("expected %q but got %q", test.want, actual)
+				}
+			})
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	code := generate(t, "struct P { x: i32, y: i32 }\nfn add(a: u8, b: u8) -> u8 { a + b }", 0)
+
+	for _, want := range []string{
+		"#include <stdint.h>",
+		"struct qs_P {\n\tint32_t f_x;\n\tint32_t f_y;\n};",
+		"uint8_t qf_add(uint8_t l_a, uint8_t l_b) {\n\treturn q_add_u8(\"main.ql:2:32\", l_a, l_b);\n}",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in the generated code", want)
+		}
+	}
+
+	if strings.Contains(code, "int main(void)") {
+		t.Errorf("expected no C main without a main function")
+	}
+}
+
+func TestExpressions(t *testing.T) {
+	testHelper(t, []testStruct{
+		{"fn main() -> i64 { 1 + 2 * 3 }", "7"},
+		{"fn main() -> u8 {\n\tlet a: u8 = 200\n\ta + 55\n}", "255"},
+		{"fn main() -> i64 {\n\tlet a: i8 = -5\n\tlet b: i64 = 3\n\ta * b + 20\n}", "5"},
+		{"fn main() -> i32 {\n\tlet a: num = 7.5\n\tif a / 2 > 3.7 { 1 } else { 0 }\n}", "1"},
+		{"fn main() -> u8 {\n\tlet a: u8 = 1\n\ta cshr 1\n}", "128"},
+		{"fn main() -> i8 {\n\tlet a: i8 = -128\n\t(a ashr 2) + 40\n}", "8"},
+		{"fn main() -> u8 {\n\tlet a: u8 = 200\n\t(a ashr 8) + (a shl 0) - (a shr 3)\n}", "175"},
+		{"fn main() -> u8 {\n\tlet a: u8 = 5\n\tnot a\n}", "250"},
+		{"fn main() -> u8 { \"abc\"[1] }", "98"},
+		{"fn main() -> u8 {\n\tlet! s = \"abc\"\n\ts[0] = 65\n\ts[0]\n}", "65"},
+		{"fn main() -> i32 { if \"ab\" + \"c\" == \"abc\" { 1 } else { 0 } }", "1"},
+		{"const N: u8 = 7\nlet g = N * 2\nfn main() -> u8 { g + N }", "21"},
+		{"fn f(a: i8, b: u64, c: bool, d: sym) -> bin { \"{a} {b} {c} {d} {1}\" }\nfn main() -> i32 { if f(-5, 18446744073709551615, true, 'ok) == \"-5 18446744073709551615 true ok 1\" { 1 } else { 0 } }", "1"},
+		{"fn f(a: f64, b: f64, c: f32, d: f64, e: f64) -> bin { \"{a} {b} {c} {d} {e} {2.5}\" }\nfn main() -> i32 { if f(0.1, 1e6, 1.1, 0.00001, -123456) == \"0.1 1e+06 1.1 1e-05 -123456 2.5\" { 1 } else { 0 } }", "1"},
+
+		// Runtime errors
+		{"fn main() -> u8 {\n\tlet a: u8 = 200\n\ta + 56\n}", "main.ql:3:4: Overflow, the result does not fit into u8"},
+		{"fn main() -> u8 {\n\tlet a: u8 = 128\n\ta shl 1\n}", "main.ql:3:4: Overflow, the result does not fit into u8"},
+		{"fn main() -> i64 {\n\tlet a = 0\n\t1 / a\n}", "main.ql:3:4: Division by zero"},
+		{"fn main() -> u8 {\n\tlet i: i8 = -1\n\t\"abc\"[i]\n}", "main.ql:3:7: Index -1 is out of range for length 3"},
+	})
+}
+
+func TestControlFlow(t *testing.T) {
+	testHelper(t, []testStruct{
+		{"fn main() -> i64 {\n\tlet a = 5\n\tcond {\n\t\ta < 3 -> 1\n\t\ta < 10 -> 2\n\t\telse -> 3\n\t}\n}", "2"},
+		{"fn main() -> i64 {\n\tlet a = -7\n\tcase a {\n\t\t0 -> 1\n\t\t-7 -> 2\n\t\tn -> 3\n\t}\n}", "2"},
+		{"fn f(n: i64) -> i64 {\n\tif n < 2 {\n\t\treturn n\n\t}\n\tf(n - 1) + f(n - 2)\n}\nfn main() -> i64 { f(12) }", "144"},
+		{"fn inc(n: i64) -> i64 { n + 1 }\nfn main() -> i64 {\n\tlet g = inc\n\tg(g(1))\n}", "3"},
+	})
+}
+
+func TestStructs(t *testing.T) {
+	shapes := "struct P { x: i32, y: i32 }\ntrait Shape {\n\tfn area(self) -> i32\n\tfn name(self) -> bin { \"shape\" }\n}\nimpl Shape for P {\n\tfn area(self) -> i32 { self.x * self.y }\n}\n"
+
+	testHelper(t, []testStruct{
+		{shapes + "fn main() -> i32 {\n\tlet! p = P { x: 2, y: 3 }\n\tp.x = 5\n\tp.area()\n}", "15"},
+		{shapes + "fn main() -> i32 {\n\tlet! p = P { x: 2, y: 3 }\n\tlet q = p\n\tp.x = 5\n\tq.x\n}", "2"},
+		{shapes + "fn f(s: Shape) -> i32 { s.area() }\nfn main() -> i32 { f(P { x: 4, y: 4 }) }", "16"},
+		{shapes + "fn f(s: Shape) -> bin { s.name() }\nfn main() -> u8 { f(P { x: 1, y: 1 })[0] }", "115"},
+		{shapes + "fn main() -> i32 {\n\tlet p = P { x: 1, y: 2 }\n\tlet q = P { x: 1, y: 2 }\n\tif p == q { 1 } else { 0 }\n}", "1"},
+		{shapes + "fn main() -> i32 {\n\tlet s: Shape = P { x: 1, y: 2 }\n\tcase s {\n\t\tP { x: 0 } -> 0\n\t\tP { x, y } -> x + y\n\t\t_ -> 9\n\t}\n}", "3"},
+		{shapes + "impl P {\n\tfn origin() -> P { P { x: 7, y: 0 } }\n}\nfn main() -> i32 { P.origin().x }", "7"},
+	})
+}
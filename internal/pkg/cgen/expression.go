@@ -0,0 +1,187 @@
+package cgen
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/ir"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/types"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
+)
+
+var operators = map[ir.Op]string{
+	ir.OP_ADD:  "add",
+	ir.OP_SUB:  "sub",
+	ir.OP_MUL:  "mul",
+	ir.OP_DIV:  "div",
+	ir.OP_POW:  "pow",
+	ir.OP_SHL:  "shl",
+	ir.OP_SHR:  "shr",
+	ir.OP_ASHR: "ashr",
+	ir.OP_CSHL: "cshl",
+	ir.OP_CSHR: "cshr",
+	ir.OP_AND:  "&",
+	ir.OP_OR:   "|",
+	ir.OP_XOR:  "^",
+	ir.OP_EQ:   "==",
+	ir.OP_NE:   "!=",
+	ir.OP_LT:   "<",
+	ir.OP_LE:   "<=",
+	ir.OP_GT:   ">",
+	ir.OP_GE:   ">=",
+}
+
+var simple = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// operand returns a value as a C expression: constants and functions are
+// written as they are, deferred instructions are computed in place and all
+// other values are read from their variable.
+func (g *generator) operand(value *ir.Value) string {
+	switch {
+	case value.Op == ir.OP_CONST:
+		return g.literal(value.Const, value.Type)
+	case value.Op == ir.OP_FUNCTION:
+		return g.names[value.Function]
+	case g.deferred[value]:
+		return g.expression(value)
+	}
+
+	return g.values[value]
+}
+
+// expression writes an instruction as a C expression.
+func (g *generator) expression(instr *ir.Value) string {
+	var args []string
+	for _, arg := range instr.Args {
+		args = append(args, g.operand(arg))
+	}
+
+	switch instr.Op {
+	case ir.OP_NEG:
+		return "q_neg_" + instr.Type.(*types.Basic).Name + "(" + position(instr.Pos) + ", " + args[0] + ")"
+	case ir.OP_NOT:
+		if instr.Type == types.Bool {
+			return "!" + args[0]
+		}
+		return "((" + g.ctype(instr.Type) + ")~" + args[0] + ")"
+	case ir.OP_EQ, ir.OP_NE, ir.OP_LT, ir.OP_LE, ir.OP_GT, ir.OP_GE:
+		return g.compare(operators[instr.Op], args[0], args[1], instr.Args[0].Type)
+	case ir.OP_CONVERT:
+		return g.convert(args[0], instr.Args[0].Type, instr.Type)
+	case ir.OP_FORMAT:
+		return "q_format_" + formatter(instr.Args[0].Type.(*types.Basic)) + "(" + args[0] + ")"
+	case ir.OP_STRUCT:
+		structure := instr.Type.(*types.Struct)
+
+		var fields []string
+		for idx, field := range structure.Fields {
+			fields = append(fields, ".f_"+field.Name+" = "+args[idx])
+		}
+		if len(fields) == 0 {
+			fields = append(fields, "0")
+		}
+		return "((" + g.names[structure] + "){" + strings.Join(fields, ", ") + "})"
+	case ir.OP_FIELD:
+		return args[0] + ".f_" + instr.Args[0].Type.(*types.Struct).Fields[instr.Index].Name
+	case ir.OP_INDEX:
+		return args[0] + ".data[" + index(instr, args[0], args[1]) + "]"
+	case ir.OP_SET_INDEX:
+		return "q_bin_set(" + args[0] + ", " + index(instr, args[0], args[1]) + ", " + args[2] + ")"
+	case ir.OP_IS:
+		return "(" + args[0] + ".vtable == &qv_" + g.base(instr.Struct) + "_" + g.base(instr.Args[0].Type) + ")"
+	case ir.OP_LOAD:
+		return g.names[instr.Global]
+	case ir.OP_STORE:
+		return g.names[instr.Global] + " = " + args[0]
+	case ir.OP_CALL:
+		return g.names[instr.Function] + "(" + strings.Join(args, ", ") + ")"
+	case ir.OP_CALL_VALUE:
+		return args[0] + "(" + strings.Join(args[1:], ", ") + ")"
+	case ir.OP_CALL_METHOD:
+		return "qd_" + g.base(instr.Args[0].Type) + "_" + instr.Name + "(" + strings.Join(args, ", ") + ")"
+	}
+
+	return g.arithmetic(instr, args[0], args[1])
+}
+
+// arithmetic writes an arithmetic or bitwise operation. Those that can fail
+// call a checked helper of the runtime.
+func (g *generator) arithmetic(instr *ir.Value, left string, right string) string {
+	basic := instr.Args[0].Type.(*types.Basic)
+	operator := operators[instr.Op]
+
+	switch {
+	case basic == types.Bin:
+		return "q_bin_concat(" + left + ", " + right + ")"
+	case instr.Op >= ir.OP_SHL && instr.Op <= ir.OP_CSHR:
+		// Shifts take an i64 amount
+		if instr.Args[1].Type != types.I64 {
+			right = "(int64_t)" + right
+		}
+		return checked(operator, basic, instr.Pos, left, right)
+	case len(operator) == 1:
+		return "((" + g.ctype(basic) + ")(" + left + " " + operator + " " + right + "))"
+	}
+
+	return checked(operator, basic, instr.Pos, left, right)
+}
+
+// formatter returns the type whose helper formats a value for an
+// interpolation, integers are formatted as 64 bit integers.
+func formatter(basic *types.Basic) string {
+	switch {
+	case basic.IsSigned():
+		return "i64"
+	case basic.IsUnsigned():
+		return "u64"
+	case basic == types.F32:
+		return "f32"
+	case basic.IsFloat():
+		return "f64"
+	}
+
+	return basic.Name
+}
+
+// checked calls a helper of the runtime that stops the program if the
+// operation fails.
+func checked(operator string, basic *types.Basic, pos util.Position, left string, right string) string {
+	return "q_" + operator + "_" + basic.Name + "(" + position(pos) + ", " + left + ", " + right + ")"
+}
+
+// index returns the checked position of an index into a byte string.
+func index(instr *ir.Value, bytes string, index string) string {
+	if instr.Args[1].Type.(*types.Basic).IsSigned() {
+		return "q_index_i(" + position(instr.Pos) + ", " + index + ", " + bytes + ".len)"
+	}
+
+	return "q_index_u(" + position(instr.Pos) + ", " + index + ", " + bytes + ".len)"
+}
+
+// unwrap removes the parentheses around a condition, skipping those in
+// string literals.
+func unwrap(condition string) string {
+	if !strings.HasPrefix(condition, "(") || !strings.HasSuffix(condition, ")") {
+		return condition
+	}
+
+	depth, quoted := 0, false
+	for idx := 0; idx < len(condition); idx++ {
+		switch c := condition[idx]; {
+		case quoted && c == '\\':
+			idx++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 && idx < len(condition)-1 {
+				return condition
+			}
+		}
+	}
+
+	return condition[1 : len(condition)-1]
+}
@@ -0,0 +1,312 @@
+package cgen
+
+// runtime is put in front of every generated file. Arithmetic that can fail
+// goes through checked helpers which stop the program with the position of
+// the operator, like the errors of the interpreter. Q_MAX_SHIFT is defined by
+// the generator in front of it.
+const runtime = `#include <math.h>
+#include <stdarg.h>
+#include <stdbool.h>
+#include <stddef.h>
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+#if defined(__GNUC__)
+#define Q_NORETURN __attribute__((noreturn))
+#else
+#define Q_NORETURN
+#endif
+
+typedef struct {
+	const uint8_t *data;
+	size_t len;
+} q_bin;
+
+static Q_NORETURN void q_panic(const char *pos, const char *msg) {
+	fprintf(stderr, "%s: %s\n", pos, msg);
+	exit(1);
+}
+
+static Q_NORETURN void q_panicf(const char *pos, const char *format, ...) {
+	char msg[128];
+	va_list args;
+	va_start(args, format);
+	vsnprintf(msg, sizeof msg, format, args);
+	va_end(args);
+	q_panic(pos, msg);
+}
+
+static Q_NORETURN void q_overflow(const char *pos, const char *type) {
+	q_panicf(pos, "Overflow, the result does not fit into %s", type);
+}
+
+static inline void q_check_shift(const char *pos, int64_t n) {
+	if (n < 0 || n > Q_MAX_SHIFT) q_panicf(pos, "Invalid shift amount %lld", (long long)n);
+}
+
+static inline void *q_alloc(size_t size) {
+	void *memory = malloc(size > 0 ? size : 1);
+	if (memory == NULL) {
+		q_panic("runtime", "Out of memory");
+	}
+	return memory;
+}
+
+/* Integers */
+
+#define Q_SIGNED(N, T, MIN, MAX) \
+	static inline T q_add_##N(const char *pos, T a, T b) { \
+		if ((b > 0 && a > MAX - b) || (b < 0 && a < MIN - b)) q_overflow(pos, #N); \
+		return (T)(a + b); \
+	} \
+	static inline T q_sub_##N(const char *pos, T a, T b) { \
+		if ((b < 0 && a > MAX + b) || (b > 0 && a < MIN + b)) q_overflow(pos, #N); \
+		return (T)(a - b); \
+	} \
+	static inline T q_mul_##N(const char *pos, T a, T b) { \
+		if (a > 0 ? (b > 0 ? a > MAX / b : b < MIN / a) : (b > 0 ? a < MIN / b : a != 0 && b < MAX / a)) q_overflow(pos, #N); \
+		return (T)(a * b); \
+	} \
+	static inline T q_div_##N(const char *pos, T a, T b) { \
+		if (b == 0) q_panic(pos, "Division by zero"); \
+		if (a == MIN && b == -1) q_overflow(pos, #N); \
+		return (T)(a / b); \
+	} \
+	static inline T q_neg_##N(const char *pos, T a) { \
+		if (a == MIN) q_overflow(pos, #N); \
+		return (T)-a; \
+	}
+
+#define Q_UNSIGNED(N, T, MAX) \
+	static inline T q_add_##N(const char *pos, T a, T b) { \
+		if (a > MAX - b) q_overflow(pos, #N); \
+		return (T)(a + b); \
+	} \
+	static inline T q_sub_##N(const char *pos, T a, T b) { \
+		if (a < b) q_overflow(pos, #N); \
+		return (T)(a - b); \
+	} \
+	static inline T q_mul_##N(const char *pos, T a, T b) { \
+		if (a != 0 && b > MAX / a) q_overflow(pos, #N); \
+		return (T)(a * b); \
+	} \
+	static inline T q_div_##N(const char *pos, T a, T b) { \
+		if (b == 0) q_panic(pos, "Division by zero"); \
+		return (T)(a / b); \
+	} \
+	static inline T q_neg_##N(const char *pos, T a) { \
+		if (a != 0) q_overflow(pos, #N); \
+		return 0; \
+	}
+
+/* Shifts keep the type of their left operand and take any amount up to
+   Q_MAX_SHIFT, shifting out set bits with shl is an overflow. shr, cshl and
+   cshr work on the bits alone, shl and ashr depend on the sign. */
+#define Q_SHIFTS(N, T, U, BITS) \
+	static inline T q_shr_##N(const char *pos, T a, int64_t n) { \
+		q_check_shift(pos, n); \
+		return n >= BITS ? 0 : (T)((U)a >> n); \
+	} \
+	static inline T q_cshl_##N(const char *pos, T a, int64_t n) { \
+		q_check_shift(pos, n); \
+		n %= BITS; \
+		return n == 0 ? a : (T)(U)(((U)a << n) | ((U)a >> (BITS - n))); \
+	} \
+	static inline T q_cshr_##N(const char *pos, T a, int64_t n) { \
+		return q_cshl_##N(pos, a, n < 0 || n > Q_MAX_SHIFT ? n : (BITS - n % BITS) % BITS); \
+	}
+
+#define Q_SIGNED_SHIFTS(N, T, U, BITS, MAX) \
+	Q_SHIFTS(N, T, U, BITS) \
+	static inline T q_shl_##N(const char *pos, T a, int64_t n) { \
+		q_check_shift(pos, n); \
+		if (a == 0) return 0; \
+		if (n >= BITS || (a < 0 ? ~a : a) > (T)(MAX >> n)) q_overflow(pos, #N); \
+		return (T)((U)a << n); \
+	} \
+	static inline T q_ashr_##N(const char *pos, T a, int64_t n) { \
+		q_check_shift(pos, n); \
+		if (n >= BITS) n = BITS - 1; \
+		return a < 0 ? (T)~(~a >> n) : (T)(a >> n); \
+	}
+
+/* Without a sign bit ashr is the same as shr. */
+#define Q_UNSIGNED_SHIFTS(N, T, BITS, MAX) \
+	Q_SHIFTS(N, T, T, BITS) \
+	static inline T q_shl_##N(const char *pos, T a, int64_t n) { \
+		q_check_shift(pos, n); \
+		if (a == 0) return 0; \
+		if (n >= BITS || a > (T)(MAX >> n)) q_overflow(pos, #N); \
+		return (T)(a << n); \
+	} \
+	static inline T q_ashr_##N(const char *pos, T a, int64_t n) { \
+		return q_shr_##N(pos, a, n); \
+	}
+
+/* Integer powers square the base only while bits of the exponent are left,
+   so an overflow of the square is an overflow of the result. NEGATIVE tells
+   if the exponent is negative, it is false for the unsigned types. */
+#define Q_POW(N, T, NEGATIVE) \
+	static inline T q_pow_##N(const char *pos, T a, T b) { \
+		T result = 1; \
+		if (NEGATIVE) q_panicf(pos, "Negative exponent %lld for an integer power", (long long)b); \
+		while (b != 0) { \
+			if (b & 1) result = q_mul_##N(pos, result, a); \
+			b /= 2; \
+			if (b != 0) a = q_mul_##N(pos, a, a); \
+		} \
+		return result; \
+	}
+
+Q_SIGNED(i8, int8_t, INT8_MIN, INT8_MAX)
+Q_SIGNED(i16, int16_t, INT16_MIN, INT16_MAX)
+Q_SIGNED(i32, int32_t, INT32_MIN, INT32_MAX)
+Q_SIGNED(i64, int64_t, INT64_MIN, INT64_MAX)
+Q_UNSIGNED(u8, uint8_t, UINT8_MAX)
+Q_UNSIGNED(u16, uint16_t, UINT16_MAX)
+Q_UNSIGNED(u32, uint32_t, UINT32_MAX)
+Q_UNSIGNED(u64, uint64_t, UINT64_MAX)
+
+Q_SIGNED_SHIFTS(i8, int8_t, uint8_t, 8, INT8_MAX)
+Q_SIGNED_SHIFTS(i16, int16_t, uint16_t, 16, INT16_MAX)
+Q_SIGNED_SHIFTS(i32, int32_t, uint32_t, 32, INT32_MAX)
+Q_SIGNED_SHIFTS(i64, int64_t, uint64_t, 64, INT64_MAX)
+Q_UNSIGNED_SHIFTS(u8, uint8_t, 8, UINT8_MAX)
+Q_UNSIGNED_SHIFTS(u16, uint16_t, 16, UINT16_MAX)
+Q_UNSIGNED_SHIFTS(u32, uint32_t, 32, UINT32_MAX)
+Q_UNSIGNED_SHIFTS(u64, uint64_t, 64, UINT64_MAX)
+
+Q_POW(i8, int8_t, b < 0)
+Q_POW(i16, int16_t, b < 0)
+Q_POW(i32, int32_t, b < 0)
+Q_POW(i64, int64_t, b < 0)
+Q_POW(u8, uint8_t, false)
+Q_POW(u16, uint16_t, false)
+Q_POW(u32, uint32_t, false)
+Q_POW(u64, uint64_t, false)
+
+/* Floats */
+
+#define Q_FLOAT(N, T) \
+	static inline T q_check_##N(const char *pos, T a) { \
+		if (isinf(a)) q_overflow(pos, #N); \
+		return a; \
+	} \
+	static inline T q_add_##N(const char *pos, T a, T b) { return q_check_##N(pos, (T)(a + b)); } \
+	static inline T q_sub_##N(const char *pos, T a, T b) { return q_check_##N(pos, (T)(a - b)); } \
+	static inline T q_mul_##N(const char *pos, T a, T b) { return q_check_##N(pos, (T)(a * b)); } \
+	static inline T q_div_##N(const char *pos, T a, T b) { \
+		if (b == 0) q_panic(pos, "Division by zero"); \
+		return q_check_##N(pos, (T)(a / b)); \
+	} \
+	static inline T q_pow_##N(const char *pos, T a, T b) { return q_check_##N(pos, (T)pow(a, b)); } \
+	static inline T q_neg_##N(const char *pos, T a) { \
+		(void)pos; \
+		return -a; \
+	}
+
+Q_FLOAT(f32, float)
+Q_FLOAT(f64, double)
+Q_FLOAT(num, double)
+
+/* Byte strings are values, changing a byte creates a new string. */
+
+static inline q_bin q_bin_concat(q_bin a, q_bin b) {
+	uint8_t *data = q_alloc(a.len + b.len);
+	if (a.len > 0) memcpy(data, a.data, a.len);
+	if (b.len > 0) memcpy(data + a.len, b.data, b.len);
+	return (q_bin){data, a.len + b.len};
+}
+
+static inline int q_bin_cmp(q_bin a, q_bin b) {
+	size_t len = a.len < b.len ? a.len : b.len;
+	int order = len > 0 ? memcmp(a.data, b.data, len) : 0;
+	if (order != 0) return order;
+	return a.len < b.len ? -1 : a.len > b.len;
+}
+
+static inline size_t q_index_i(const char *pos, int64_t index, size_t len) {
+	if (index < 0 || (uint64_t)index >= len) {
+		q_panicf(pos, "Index %lld is out of range for length %lu", (long long)index, (unsigned long)len);
+	}
+	return (size_t)index;
+}
+
+static inline size_t q_index_u(const char *pos, uint64_t index, size_t len) {
+	if (index >= len) {
+		q_panicf(pos, "Index %llu is out of range for length %lu", (unsigned long long)index, (unsigned long)len);
+	}
+	return (size_t)index;
+}
+
+static inline q_bin q_bin_set(q_bin a, size_t index, uint8_t value) {
+	uint8_t *data = q_alloc(a.len);
+	memcpy(data, a.data, a.len);
+	data[index] = value;
+	return (q_bin){data, a.len};
+}
+
+/* Interpolated values are formatted like the interpreter formats them,
+   floats with the fewest digits that read back as the same value. */
+
+static inline q_bin q_text(const char *text) {
+	size_t len = strlen(text);
+	uint8_t *data = q_alloc(len);
+	if (len > 0) memcpy(data, text, len);
+	return (q_bin){data, len};
+}
+
+static inline q_bin q_format_i64(int64_t value) {
+	char text[24];
+	snprintf(text, sizeof text, "%lld", (long long)value);
+	return q_text(text);
+}
+
+static inline q_bin q_format_u64(uint64_t value) {
+	char text[24];
+	snprintf(text, sizeof text, "%llu", (unsigned long long)value);
+	return q_text(text);
+}
+
+static inline q_bin q_format_bool(bool value) {
+	return q_text(value ? "true" : "false");
+}
+
+static inline q_bin q_format_sym(const char *value) {
+	return q_text(value);
+}
+
+static q_bin q_format_float(double value, bool single) {
+	char text[40];
+	int digits, exponent;
+
+	if (isnan(value)) return q_text("NaN");
+	if (isinf(value)) return q_text(value > 0 ? "+Inf" : "-Inf");
+
+	for (digits = 1; digits < 17; digits++) {
+		snprintf(text, sizeof text, "%.*e", digits - 1, value);
+		if (single ? strtof(text, NULL) == (float)value : strtod(text, NULL) == value) break;
+	}
+
+	/* Exponents below -4 and from 6 on are written as in 1e+06 */
+	exponent = atoi(strchr(text, 'e') + 1);
+	if (exponent >= -4 && exponent < 6) {
+		snprintf(text, sizeof text, "%.*f", digits - 1 > exponent ? digits - 1 - exponent : 0, value);
+	}
+	return q_text(text);
+}
+
+static inline q_bin q_format_f32(float value) {
+	return q_format_float(value, true);
+}
+
+static inline q_bin q_format_f64(double value) {
+	return q_format_float(value, false);
+}
+
+static inline Q_NORETURN void q_unreachable(const char *pos) {
+	q_panic(pos, "Reached a branch that cannot be reached");
+}
+`
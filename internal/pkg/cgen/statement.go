@@ -0,0 +1,252 @@
+package cgen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/ir"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/types"
+)
+
+/* Functions */
+
+func (g *generator) line(format string, a ...any) {
+	g.body.WriteString("\t")
+	fmt.Fprintf(g.body, format, a...)
+	g.body.WriteString("\n")
+}
+
+// local names a value of the current function.
+func (g *generator) local(value *ir.Value, name string) string {
+	g.counts[name]++
+	if g.counts[name] > 1 {
+		name += "_" + strconv.Itoa(g.counts[name])
+	}
+
+	g.values[value] = "l_" + name
+	return g.values[value]
+}
+
+// generateFunction writes the blocks of a function in reverse postorder,
+// they continue in each other with gotos unless the next block is the one
+// that follows. Values that are used more than once or in other blocks
+// are kept in variables, the phis are declared up front.
+func (g *generator) generateFunction(function *ir.Function) {
+	g.body = &strings.Builder{}
+	g.values = map[*ir.Value]string{}
+	g.counts = map[string]int{}
+	g.uses = function.Uses()
+	g.labels = map[*ir.Block]string{}
+	g.targeted = map[*ir.Block]bool{}
+
+	for idx, param := range function.Params {
+		if function.Receiver && idx == 0 {
+			g.values[param] = "self"
+		} else {
+			g.local(param, param.Name)
+		}
+	}
+
+	impure := impurity()
+	g.deferred = function.Deferred(func(value *ir.Value, user *ir.Value) bool {
+		return g.deferrable(value, user, impure)
+	})
+
+	order := function.Order()
+	for idx, block := range order {
+		g.labels[block] = "b" + strconv.Itoa(idx)
+		for _, instr := range block.Instrs {
+			if instr.Op == ir.OP_PHI {
+				g.line("%s;", g.declaration(instr.Type, g.local(instr, "t")))
+			}
+		}
+	}
+
+	for idx, block := range order {
+		if g.targeted[block] {
+			g.body.WriteString(g.labels[block] + ":;\n")
+		}
+
+		var next *ir.Block
+		if idx+1 < len(order) {
+			next = order[idx+1]
+		}
+
+		for _, instr := range block.Instrs {
+			switch {
+			case instr.Op == ir.OP_PHI || g.deferred[instr]:
+			case instr.IsTerminator():
+				g.terminator(instr, block, next)
+			default:
+				g.statement(instr)
+			}
+		}
+	}
+
+	fmt.Fprintf(&g.code, "%s {\n%s}\n\n", g.signature(function, true), g.body)
+}
+
+// impurity returns a function that reports whether computing a value has
+// side effects, may stop the program or reads a global, which depends on
+// the side effects of calls.
+func impurity() func(value *ir.Value) bool {
+	known := map[*ir.Value]bool{}
+
+	var impure func(value *ir.Value) bool
+	impure = func(value *ir.Value) bool {
+		if result, ok := known[value]; ok {
+			return result
+		}
+
+		result := false
+		switch value.Op {
+		case ir.OP_CONST, ir.OP_PARAM, ir.OP_FUNCTION, ir.OP_PHI:
+		default:
+			result = value.Effect() != ir.PURE || value.Op == ir.OP_LOAD
+			for _, arg := range value.Args {
+				result = result || impure(arg)
+			}
+		}
+
+		known[value] = result
+		return result
+	}
+
+	return impure
+}
+
+// deferrable reports whether a value can be computed as an operand of its
+// user. C leaves the order operands are computed in open, so an operand
+// that is not pure must be the only one. Inserts need a variable to assign
+// the field of and byte strings are read twice by index operations.
+func (g *generator) deferrable(value *ir.Value, user *ir.Value, impure func(value *ir.Value) bool) bool {
+	switch {
+	case value.Op == ir.OP_INSERT:
+		return false
+	case (user.Op == ir.OP_INDEX || user.Op == ir.OP_SET_INDEX) && user.Args[0] == value:
+		return false
+	case !impure(value):
+		return true
+	case user.Op == ir.OP_SET_INDEX && user.Args[2] == value:
+		// The index is checked while the new byte is computed
+		return false
+	}
+
+	for _, arg := range user.Args {
+		if arg != value && impure(arg) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// statement writes an instruction that is not computed where it is used.
+func (g *generator) statement(instr *ir.Value) {
+	used := g.uses[instr] > 0
+
+	switch {
+	case instr.Op == ir.OP_INSERT:
+		if !used {
+			return
+		}
+		name := g.local(instr, "t")
+		g.line("%s = %s;", g.declaration(instr.Type, name), g.operand(instr.Args[0]))
+		g.line("%s.f_%s = %s;", name, instr.Type.(*types.Struct).Fields[instr.Index].Name, g.operand(instr.Args[1]))
+	case used:
+		value := g.expression(instr)
+		g.line("%s = %s;", g.declaration(instr.Type, g.local(instr, "t")), value)
+	case !pushes(instr.Type):
+		g.line("%s;", g.expression(instr))
+	case instr.Effect() != ir.PURE:
+		g.line("(void)%s;", g.expression(instr))
+	}
+}
+
+// copies writes the assignments of the values a block passes to the phis
+// of a successor.
+func (g *generator) copies(from *ir.Block, to *ir.Block) []string {
+	var lines []string
+	for _, instr := range to.Instrs {
+		if instr.Op != ir.OP_PHI {
+			break
+		}
+
+		for idx, block := range instr.Blocks {
+			if block == from {
+				lines = append(lines, g.values[instr]+" = "+g.operand(instr.Args[idx])+";")
+			}
+		}
+	}
+
+	return lines
+}
+
+func (g *generator) jump(to *ir.Block) string {
+	g.targeted[to] = true
+	return "goto " + g.labels[to] + ";"
+}
+
+// edge writes the code of a branch that continues in a block, nested into
+// an if.
+func (g *generator) edge(condition string, lines []string) {
+	if len(lines) == 1 {
+		g.line("if (%s) %s", condition, lines[0])
+		return
+	}
+
+	g.line("if (%s) {", condition)
+	for _, line := range lines {
+		g.line("\t%s", line)
+	}
+	g.line("}")
+}
+
+func (g *generator) terminator(instr *ir.Value, block *ir.Block, next *ir.Block) {
+	switch instr.Op {
+	case ir.OP_JUMP:
+		for _, line := range g.copies(block, instr.Blocks[0]) {
+			g.line("%s", line)
+		}
+		if instr.Blocks[0] != next {
+			g.line("%s", g.jump(instr.Blocks[0]))
+		}
+	case ir.OP_BRANCH:
+		then, otherwise := instr.Blocks[0], instr.Blocks[1]
+		condition := unwrap(g.operand(instr.Args[0]))
+		thenCopies, elseCopies := g.copies(block, then), g.copies(block, otherwise)
+
+		// The branch to the next block is the one that is left out
+		if then == next && len(thenCopies) == 0 {
+			g.edge(negate(condition), append(elseCopies, g.jump(otherwise)))
+			return
+		}
+
+		g.edge(condition, append(thenCopies, g.jump(then)))
+		for _, line := range elseCopies {
+			g.line("%s", line)
+		}
+		if otherwise != next {
+			g.line("%s", g.jump(otherwise))
+		}
+	case ir.OP_RETURN:
+		switch {
+		case len(instr.Args) > 0:
+			g.line("return %s;", unwrap(g.operand(instr.Args[0])))
+		case next != nil:
+			g.line("return;")
+		}
+	case ir.OP_UNREACHABLE:
+		g.line("q_unreachable(%s);", position(instr.Pos))
+	}
+}
+
+// negate negates a condition.
+func negate(condition string) string {
+	if simple.MatchString(condition) {
+		return "!" + condition
+	}
+
+	return "!(" + condition + ")"
+}
@@ -0,0 +1,479 @@
+package checker
+
+import (
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/consteval"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/parser"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/resolver"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/types"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
+)
+
+// Info holds the result of type checking a set of programs.
+type Info struct {
+	// Types maps every checked expression to its type. Untyped numbers get
+	// the type they are converted to.
+	Types map[parser.Expression]types.Type
+	// Defs maps declarations, bindings, parameters, binding patterns and
+	// type annotations to their type.
+	Defs map[any]types.Type
+	// Uses maps identifiers and paths to the node they refer to.
+	Uses map[parser.Expression]any
+	// Methods maps the field accesses that select a method to the method.
+	Methods map[*parser.FieldAccess]*types.Method
+}
+
+type object struct {
+	node   any
+	typ    types.Type
+	isType bool
+}
+
+type environment struct {
+	parent  *environment
+	objects map[string]*object
+}
+
+func newEnvironment(parent *environment) *environment {
+	return &environment{parent: parent, objects: map[string]*object{}}
+}
+
+func (env *environment) lookup(name string) *object {
+	for ; env != nil; env = env.parent {
+		if obj, ok := env.objects[name]; ok {
+			return obj
+		}
+	}
+
+	return nil
+}
+
+type checker struct {
+	resolution *resolver.Resolution
+	constants  map[*parser.Constant]consteval.Value
+	info       *Info
+
+	// The namespace of the scope that is checked, the result type of the
+	// function and the type of self in the method that is checked
+	namespace string
+	result    types.Type
+	self      types.Type
+
+	// Expressions that got an untyped type, in the order they were checked
+	untyped []parser.Expression
+
+	errors []diagnostics.Diagnostic
+}
+
+// Run checks the types of all given programs. Declarations are visible in
+// their whole namespace, across files, so all of them are declared before
+// any function body is checked. The folded constants decide the types of
+// constants without a declared type.
+func Run(programs []parser.Program, resolution *resolver.Resolution, constants map[*parser.Constant]consteval.Value) (*Info, []diagnostics.Diagnostic) {
+	c := checker{
+		resolution: resolution,
+		constants:  constants,
+		info: &Info{
+			Types:   map[parser.Expression]types.Type{},
+			Defs:    map[any]types.Type{},
+			Uses:    map[parser.Expression]any{},
+			Methods: map[*parser.FieldAccess]*types.Method{},
+		},
+	}
+
+	namespaces := map[string]*environment{}
+	envs := map[*parser.Scope]*environment{}
+
+	for _, program := range programs {
+		for _, scope := range program.Scopes {
+			name := namespaceOf(scope)
+
+			namespace, ok := namespaces[name]
+			if !ok {
+				namespace = newEnvironment(nil)
+				namespaces[name] = namespace
+			}
+
+			// Every scope shares the declarations of its namespace and
+			// falls back to its own imports
+			envs[scope] = &environment{parent: newEnvironment(nil), objects: namespace.objects}
+			c.declareTypes(scope, envs[scope], name)
+		}
+	}
+
+	for _, program := range programs {
+		for _, scope := range program.Scopes {
+			c.declareImports(scope, envs[scope].parent)
+		}
+	}
+
+	for _, program := range programs {
+		for _, scope := range program.Scopes {
+			c.completeTypes(scope, envs[scope])
+		}
+	}
+
+	for _, program := range programs {
+		for _, scope := range program.Scopes {
+			c.namespace = namespaceOf(scope)
+			for _, statement := range scope.Statements {
+				c.checkStatement(statement, envs[scope])
+			}
+		}
+	}
+
+	for _, program := range programs {
+		for _, scope := range program.Scopes {
+			c.namespace = namespaceOf(scope)
+			c.checkBodies(scope, envs[scope])
+		}
+	}
+
+	// Untyped numbers that were not converted by their context take their
+	// default type, outer expressions first so they convert their operands
+	for idx := len(c.untyped) - 1; idx >= 0; idx-- {
+		expression := c.untyped[idx]
+		c.convertUntyped(expression, types.Default(c.info.Types[expression]))
+	}
+
+	return c.info, c.errors
+}
+
+// errorf reports an error, the returned pointer is only valid until the
+// next error is reported.
+func (c *checker) errorf(pos util.Position, code string, format string, a ...any) *diagnostics.Diagnostic {
+	c.errors = append(c.errors, diagnostics.Errorf(pos, code, format, a...))
+	return &c.errors[len(c.errors)-1]
+}
+
+// fail reports an error of evaluating or converting a constant at pos.
+func (c *checker) fail(pos util.Position, err error) *diagnostics.Diagnostic {
+	return c.errorf(pos, diagnostics.CodeOf(err), "%s", err)
+}
+
+func namespaceOf(scope *parser.Scope) string {
+	if scope.Namespace == nil {
+		return ""
+	}
+
+	return scope.Namespace.Path.String()
+}
+
+func qualify(namespace string, name string) string {
+	if namespace == "" {
+		return name
+	}
+
+	return namespace + "::" + name
+}
+
+/* Declarations */
+
+// declareTypes creates the types of the structs and traits of a scope, so
+// that the declarations of all scopes can refer to them.
+func (c *checker) declareTypes(scope *parser.Scope, env *environment, namespace string) {
+	for _, structure := range scope.Structs {
+		t := &types.Struct{Name: qualify(namespace, structure.Identifer.Name), Namespace: namespace, Decl: structure}
+		c.info.Defs[structure] = t
+		c.declare(env, structure.Identifer, &object{structure, t, true})
+	}
+
+	for _, trait := range scope.Traits {
+		t := &types.Trait{Name: qualify(namespace, trait.Identifer.Name), Decl: trait}
+		c.info.Defs[trait] = t
+		c.declare(env, trait.Identifer, &object{trait, t, true})
+	}
+}
+
+// declare adds a declaration to the objects of its namespace. The namespace
+// may be spread over several files, a name that is already declared in any of
+// them keeps its first declaration.
+func (c *checker) declare(env *environment, identifier *parser.Identifer, obj *object) {
+	if other, ok := env.objects[identifier.Name]; ok {
+		first := declarationName(other.node).Pos
+		c.errorf(identifier.Pos, diagnostics.DUPLICATE_DECLARATION, "%s is already declared at %s", identifier.Name, first).
+			At(first, "first declared here")
+		return
+	}
+
+	env.objects[identifier.Name] = obj
+}
+
+func declarationName(node any) *parser.Identifer {
+	switch node := node.(type) {
+	case *parser.Struct:
+		return node.Identifer
+	case *parser.Trait:
+		return node.Identifer
+	case *parser.Constant:
+		return node.Identifer
+	case *parser.Function:
+		return node.Identifer
+	}
+
+	return &parser.Identifer{}
+}
+
+func (c *checker) declareImports(scope *parser.Scope, env *environment) {
+	if c.resolution == nil {
+		return
+	}
+
+	for alias, symbol := range c.resolution.Imports[scope] {
+		if symbol.Kind == resolver.NAMESPACE {
+			continue
+		}
+
+		env.objects[alias] = &object{
+			node:   symbol.Node,
+			isType: symbol.Kind == resolver.STRUCT || symbol.Kind == resolver.TRAIT,
+		}
+	}
+}
+
+// completeTypes resolves the fields of structs, the signatures of functions
+// and methods, the types of constants and the impl blocks of a scope.
+func (c *checker) completeTypes(scope *parser.Scope, env *environment) {
+	for _, structure := range scope.Structs {
+		t := c.info.Defs[structure].(*types.Struct)
+
+		declared := map[string]util.Position{}
+		for _, field := range structure.Fields {
+			if first, ok := declared[field.Identifer.Name]; ok {
+				c.errorf(field.Identifer.Pos, diagnostics.DUPLICATE_DECLARATION, "Struct %s already has a field %s", t, field.Identifer.Name).
+					At(first, "first declared here")
+				continue
+			}
+			declared[field.Identifer.Name] = field.Identifer.Pos
+
+			t.Fields = append(t.Fields, &types.Field{Name: field.Identifer.Name, Decl: field, Type: c.resolveType(field.Type, env)})
+		}
+	}
+
+	for _, trait := range scope.Traits {
+		t := c.info.Defs[trait].(*types.Trait)
+
+		for _, method := range trait.Methods {
+			if first := t.Method(method.Identifer.Name); first != nil {
+				c.errorf(method.Identifer.Pos, diagnostics.DUPLICATE_DECLARATION, "Trait %s already has a method %s", t, method.Identifer.Name).
+					At(first.Decl.Identifer.Pos, "first declared here")
+				continue
+			}
+
+			t.Methods = append(t.Methods, c.method(method, env))
+		}
+	}
+
+	for _, constant := range scope.Constants {
+		c.info.Defs[constant] = c.constantType(constant, env)
+		c.declare(env, constant.Identifer, &object{node: constant})
+	}
+
+	for _, function := range scope.Functions {
+		c.info.Defs[function] = c.signature(function, env)
+		c.declare(env, function.Identifer, &object{node: function})
+	}
+
+	for _, impl := range scope.Impls {
+		c.completeImpl(impl, env)
+	}
+}
+
+func (c *checker) constantType(constant *parser.Constant, env *environment) types.Type {
+	if value, ok := c.constants[constant]; ok {
+		return value.Type
+	}
+
+	if constant.Type != nil {
+		return c.resolveType(constant.Type, env)
+	}
+
+	return types.Invalid
+}
+
+func (c *checker) signature(function *parser.Function, env *environment) *types.Function {
+	signature := &types.Function{Result: types.Void}
+
+	for _, parameter := range function.Parameters {
+		if parameter.Type == nil {
+			continue
+		}
+
+		t := c.resolveType(parameter.Type, env)
+		c.info.Defs[parameter] = t
+		signature.Parameters = append(signature.Parameters, t)
+	}
+
+	if function.ReturnType != nil {
+		signature.Result = c.resolveType(function.ReturnType, env)
+	}
+
+	c.info.Defs[function] = signature
+	return signature
+}
+
+func (c *checker) method(function *parser.Function, env *environment) *types.Method {
+	return &types.Method{
+		Name:      function.Identifer.Name,
+		Decl:      function,
+		Signature: c.signature(function, env),
+		Self:      len(function.Parameters) > 0 && function.Parameters[0].Type == nil,
+	}
+}
+
+func (c *checker) completeImpl(impl *parser.Impl, env *environment) {
+	structure, ok := c.resolveType(impl.Type, env).(*types.Struct)
+	if !ok {
+		c.errorf(impl.Type.Identifer.Pos, diagnostics.INVALID_TYPE, "Only structs can have impl blocks")
+		return
+	}
+
+	var trait *types.Trait
+	if impl.Trait != nil {
+		t := c.resolveType(impl.Trait, env)
+		if trait, ok = t.(*types.Trait); !ok {
+			if t != types.Invalid {
+				c.errorf(impl.Trait.Identifer.Pos, diagnostics.INVALID_TYPE, "%s is not a trait", t)
+			}
+			return
+		}
+
+		if structure.Implements(trait) {
+			c.errorf(impl.Trait.Identifer.Pos, diagnostics.DUPLICATE_DECLARATION, "%s already implements %s", structure, trait)
+			return
+		}
+	}
+
+	for _, function := range impl.Methods {
+		method := c.method(function, env)
+
+		var first *types.Method
+		for _, other := range structure.Methods {
+			if other.Name == method.Name {
+				first = other
+			}
+		}
+
+		if first != nil {
+			c.errorf(function.Identifer.Pos, diagnostics.DUPLICATE_DECLARATION, "Struct %s already has a method %s", structure, method.Name).
+				At(first.Decl.Identifer.Pos, "first declared here")
+			continue
+		}
+
+		structure.Methods = append(structure.Methods, method)
+
+		if trait == nil {
+			continue
+		}
+
+		required := trait.Method(method.Name)
+		if required == nil {
+			c.errorf(function.Identifer.Pos, diagnostics.UNKNOWN_METHOD, "%s is not a method of trait %s", method.Name, trait).
+				At(trait.Decl.Identifer.Pos, "trait declared here").
+				Helpf("Move %s into a separate impl block of %s", method.Name, structure)
+			continue
+		}
+
+		if method.Self != required.Self || !identical(method.Signature, required.Signature) {
+			c.errorf(function.Identifer.Pos, diagnostics.METHOD_MISMATCH, "Method %s of %s does not match trait %s, expected %s but found %s", method.Name, structure, trait, required.Signature, method.Signature).
+				At(required.Decl.Identifer.Pos, "declared by the trait here")
+		}
+	}
+
+	if trait == nil {
+		return
+	}
+
+	for _, required := range trait.Methods {
+		if required.Decl.Body != nil {
+			continue
+		}
+
+		found := false
+		for _, function := range impl.Methods {
+			found = found || function.Identifer.Name == required.Name
+		}
+
+		if !found {
+			c.errorf(impl.Type.Identifer.Pos, diagnostics.MISSING_METHOD, "%s does not implement %s of trait %s", structure, required.Name, trait).
+				At(required.Decl.Identifer.Pos, "required by the trait").
+				Helpf("Add %s to the impl block or give it a default body in the trait", required.Name)
+		}
+	}
+
+	structure.Traits = append(structure.Traits, trait)
+}
+
+func identical(a *types.Function, b *types.Function) bool {
+	if len(a.Parameters) != len(b.Parameters) || a.Result != b.Result {
+		return false
+	}
+
+	for idx := range a.Parameters {
+		if a.Parameters[idx] != b.Parameters[idx] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// resolveType returns the type a type annotation names and records it.
+func (c *checker) resolveType(t *parser.Type, env *environment) types.Type {
+	resolved := c.lookupType(t, env)
+	c.info.Defs[t] = resolved
+	return resolved
+}
+
+// Primitive types cannot be shadowed, qualified types were already looked up
+// by the resolver.
+func (c *checker) lookupType(t *parser.Type, env *environment) types.Type {
+	if t.Namespace != nil {
+		if c.resolution == nil {
+			return types.Invalid
+		}
+
+		symbol, ok := c.resolution.Paths[t]
+		if !ok {
+			return types.Invalid
+		}
+
+		if named, ok := c.info.Defs[symbol.Node]; ok && (symbol.Kind == resolver.STRUCT || symbol.Kind == resolver.TRAIT) {
+			return named
+		}
+
+		c.errorf(t.Identifer.Pos, diagnostics.INVALID_TYPE, "%s is not a type", symbol.Name)
+		return types.Invalid
+	}
+
+	if basic := types.LookupBasic(t.Identifer.Name); basic != nil {
+		return basic
+	}
+
+	obj := env.lookup(t.Identifer.Name)
+	if obj == nil {
+		c.errorf(t.Identifer.Pos, diagnostics.UNKNOWN_NAME, "Unknown type %s", t.Identifer.Name)
+		return types.Invalid
+	}
+
+	if !obj.isType {
+		c.errorf(t.Identifer.Pos, diagnostics.INVALID_TYPE, "%s is not a type", t.Identifer.Name)
+		return types.Invalid
+	}
+
+	return c.typeOf(obj)
+}
+
+// typeOf returns the type of an object. Imported objects only know their
+// node, their type is looked up once it is needed.
+func (c *checker) typeOf(obj *object) types.Type {
+	if obj.typ != nil {
+		return obj.typ
+	}
+
+	if t, ok := c.info.Defs[obj.node]; ok {
+		return t
+	}
+
+	return types.Invalid
+}
@@ -0,0 +1,275 @@
+package checker_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/checker"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/lexer"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/parser"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/testutil"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/types"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
+)
+
+type testStruct struct {
+	input  string
+	errors []string
+}
+
+func check(t *testing.T, files ...string) ([]parser.Program, *checker.Info, []string) {
+	programs := testutil.Parse(t, files...)
+	front := testutil.Evaluate(t, programs)
+	info, checkErrors := checker.Run(programs, front.Resolution, front.Constants)
+
+	var msgs []string
+	for _, err := range checkErrors {
+		msgs = append(msgs, err.Msg)
+	}
+
+	return programs, info, msgs
+}
+
+func testHelper(t *testing.T, tests []testStruct) {
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			_, _, msgs := check(t, test.input)
+
+			if !reflect.DeepEqual(msgs, test.errors) {
+				t.Errorf("\n%s\n%q\n%s\n%q", "---- EXPECTED ----", test.errors, "---- ACTUAL ----", msgs)
+			}
+		})
+	}
+}
+
+func TestBindings(t *testing.T) {
+	testHelper(t, []testStruct{
+		{"fn a() {\n\tlet b: u8 = 255\n\tlet c: u16 = b\n}", nil},
+		{"fn a() {\n\tlet b: i8 = -1\n\tlet c: i64 = b\n}", nil},
+		{"fn a() {\n\tlet b: u32 = 1\n\tlet c: i64 = b\n}", nil},
+		{"fn a() {\n\tlet b: f32 = 1.5\n\tlet c: f64 = b\n\tlet d: num = c\n}", nil},
+		{"fn a() {\n\tlet b: i32 = 1\n\tlet c: num = b\n}", nil},
+		{"fn a() {\n\tlet b = \"x\"\n\tlet c: bin = b\n}", nil},
+		{"fn a() {\n\tlet b: bin = b\"\\xff\"\n\tlet c: sym = 'ok\n}", nil},
+		{"fn a() {\n\tlet b = 255u8\n\tlet c: u16 = b\n\tlet d: i8 = -128i8\n}", nil},
+		{"fn a(b: i8) -> bool {\n\tcase b {\n\t\t-128i8 -> true\n\t\t_ -> false\n\t}\n}", nil},
+
+		// Mismatches
+		{"fn a() {\n\tlet b: u8 = 256\n}", []string{"Overflow, 256 does not fit into u8"}},
+		{"fn a() {\n\tlet b: u8 = 200 + 100\n}", []string{"Overflow, 300 does not fit into u8"}},
+		{"fn a() {\n\tlet b: u8 = 0x1FF\n}", []string{"Overflow, 511 does not fit into u8"}},
+		{"fn a() {\n\tlet b = 256u8\n}", []string{"Overflow, 256 does not fit into u8"}},
+		{"fn a() {\n\tlet b = -129i8\n}", []string{"Overflow, -129 does not fit into i8"}},
+		{"fn a() {\n\tlet b: i8 = 1u8\n}", []string{"Expected i8 but found u8"}},
+		{"fn a() {\n\tlet b: i32 = 1.5f32\n}", []string{"Expected i32 but found f32"}},
+		{"fn a() {\n\tlet b: i32 = 1.5\n}", []string{"Expected i32 but found untyped float"}},
+		{"fn a() {\n\tlet b: u16 = 1\n\tlet c: u8 = b\n}", []string{"Expected u8 but found u16"}},
+		{"fn a() {\n\tlet b: i8 = 1\n\tlet c: u64 = b\n}", []string{"Expected u64 but found i8"}},
+		{"fn a() {\n\tlet b: u8 = 1\n\tlet c: i8 = b\n}", []string{"Expected i8 but found u8"}},
+		{"fn a() {\n\tlet b: i32 = 1\n\tlet c: f64 = b\n}", []string{"Expected f64 but found i32"}},
+		{"fn a() {\n\tlet b: i64 = 1\n\tlet c: num = b\n}", []string{"Expected num but found i64"}},
+		{"fn a() {\n\tlet b: bool = 1\n}", []string{"Expected bool but found untyped int"}},
+		{"fn a() {\n\tlet b: bin = true\n}", []string{"Expected bin but found bool"}},
+		{"fn a() {\n\tlet b: bin = 'ok\n}", []string{"Expected bin but found sym"}},
+		{"fn a() {\n\tlet b: sym = b\"ok\"\n}", []string{"Expected sym but found bin"}},
+		{"fn a() {\n\tlet b = nil\n}", []string{"Cannot infer the type of b from nil"}},
+		{"fn a() {\n\tlet b: P = 1\n}", []string{"Unknown type P"}},
+		{"fn a() {\n\tlet b = c\n}", []string{"Unknown identifier c"}},
+		{"fn a() {\n\tlet! b: u8 = 1\n\tb = true\n}", []string{"Expected u8 but found bool"}},
+		{"fn a() {\n\tlet! b: u8 = 1\n\tb += 2\n\tb *= b\n}", nil},
+		{"fn a() {\n\tlet! b: u8 = 1\n\tb += 256\n}", []string{"Overflow, 256 does not fit into u8"}},
+		{"fn a() {\n\tlet! b: u8 = 1\n\tb -= true\n}", []string{"Mismatched types u8 and bool"}},
+		{"fn a() {\n\tlet! b: bin = \"a\"\n\tb += \"b\"\n\tb /= \"c\"\n}", []string{"Slash sign cannot be applied to bin"}},
+	})
+}
+
+func TestOperators(t *testing.T) {
+	testHelper(t, []testStruct{
+		{"fn a(b: u8) -> u8 {\n\treturn b + 1\n}", nil},
+		{"fn a(b: u8, c: u16) -> u16 {\n\treturn b * c\n}", nil},
+		{"fn a(b: i32) -> bool {\n\treturn b < 10 and not (b == 3)\n}", nil},
+		{"fn a(b: u32) -> u32 {\n\treturn b shl 2 xor b\n}", nil},
+		{"fn a(b: bin, c: bin) -> bin {\n\treturn b + c\n}", nil},
+		{"fn a(b: bin, c: bin) -> bin {\n\treturn \"<{b}, {c}>\"\n}", nil},
+		{"fn a(b: i32, c: f32, d: bool, e: sym) -> bin {\n\treturn \"{b} {c} {d} {e} {1} {2.5}\"\n}", nil},
+		{"fn a(b: f32) -> f32 {\n\treturn -b / 2\n}", nil},
+		{"fn a(b: sym) -> bool {\n\treturn b == 'ok\n}", nil},
+
+		{"fn a(b: u8) -> u8 {\n\treturn b + 256\n}", []string{"Overflow, 256 does not fit into u8"}},
+		{"fn a(b: i8, c: u8) {\n\tb + c\n}", []string{"Mismatched types i8 and u8"}},
+		{"fn a(b: i32, c: f64) {\n\tb * c\n}", []string{"Mismatched types i32 and f64"}},
+		{"fn a(b: bool) {\n\tb + 1\n}", []string{"Mismatched types bool and untyped int"}},
+		{"fn a(b: bool) {\n\tb + b\n}", []string{"Plus sign cannot be applied to bool"}},
+		{"fn a(b: bin) {\n\tb * b\n}", []string{"Star sign cannot be applied to bin"}},
+		{"fn a(b: sym) {\n\tb + 'ok\n}", []string{"Plus sign cannot be applied to sym"}},
+		{"fn a(b: sym) {\n\tb == b\"ok\"\n}", []string{"Mismatched types sym and bin"}},
+		{"fn a(b: f64) {\n\tb shl 1\n}", []string{"Keyword 'shl' cannot be applied to f64"}},
+		{"fn a(b: u8) {\n\t-b\n}", []string{"Minus sign cannot be applied to u8"}},
+		{"fn a(b: bool) {\n\tb < b\n}", []string{"Less than cannot be applied to bool"}},
+		{"fn a(b: bin) {\n\tb[true]\n}", []string{"Expected an integer but found bool"}},
+		{"fn a(b: i32) {\n\tb[0]\n}", []string{"Cannot index i32"}},
+		{"struct P {}\nfn a(b: P) -> bin {\n\treturn \"b = {b}\"\n}", []string{"P cannot be interpolated"}},
+		{"fn a() -> bin {\n\treturn \"{nil}\"\n}", []string{"nil cannot be interpolated"}},
+		{"fn a(b: i32) {\n\tif b {\n\t\tb\n\t}\n}", []string{"Expected bool but found i32"}},
+	})
+}
+
+func TestFunctions(t *testing.T) {
+	testHelper(t, []testStruct{
+		{"fn a(b: i32) -> i32 {\n\treturn b\n}\nfn c() -> i32 {\n\treturn a(1)\n}", nil},
+		{"fn a(b: i32) -> i32 {\n\tb * 2\n}", nil},
+		{"fn a(b: bool) -> u8 {\n\tif b {\n\t\treturn 1\n\t} else {\n\t\t2\n\t}\n}", nil},
+		{"fn a(b: i32) -> bin {\n\tcase b {\n\t\t0 -> \"zero\"\n\t\t_ -> \"other\"\n\t}\n}", nil},
+		{"fn a() {\n\treturn\n}", nil},
+
+		{"fn a() -> i32 {\n\treturn true\n}", []string{"Expected i32 but found bool"}},
+		{"fn a() -> i32 {\n\treturn\n}", []string{"Expected a return value of type i32"}},
+		{"fn a() {\n\treturn 1\n}", []string{"Cannot return a value from a function without a return type"}},
+		{"fn a() -> i32 {\n\tlet b = 1\n}", []string{"Function a has to return i32"}},
+		{"fn a(b: bool) -> i32 {\n\tif b {\n\t\t1\n\t}\n}", []string{"Function a has to return i32"}},
+		{"fn a(b: i32) {}\nfn c() {\n\ta(true)\n}", []string{"Expected i32 but found bool"}},
+		{"fn a(b: i32) {}\nfn c() {\n\ta()\n}", []string{"Expected 1 arguments but found 0"}},
+		{"fn a() {}\nfn c() {\n\tlet d = a()\n}", []string{"Expected a value but found void"}},
+		{"fn a() {\n\tlet b = 1\n\tb()\n}", []string{"Cannot call i64"}},
+	})
+}
+
+func TestStructsAndTraits(t *testing.T) {
+	shapes := "struct P { x: i32, y: i32 }\ntrait Shape {\n\tfn area(self) -> i32\n\tfn name(self) -> bin { \"shape\" }\n}\n"
+
+	testHelper(t, []testStruct{
+		{shapes + "fn a() -> i32 {\n\tlet p = P { x: 1, y: 2 }\n\tp.x + p.y\n}", nil},
+		{shapes + "impl P {\n\tfn new() -> P { P { x: 0, y: 0 } }\n\tfn sum(self) -> i32 { self.x + self.y }\n}\nfn a() -> i32 {\n\tP.new().sum()\n}", nil},
+		{shapes + "impl Shape for P {\n\tfn area(self) -> i32 { self.x * self.y }\n}\nfn a(s: Shape) -> i32 {\n\ts.area()\n}\nfn b() -> i32 {\n\ta(P { x: 1, y: 2 })\n}", nil},
+		{shapes + "impl Shape for P {\n\tfn area(self) -> i32 { self.x * self.y }\n}\nfn a(p: P) -> bin {\n\tp.name()\n}", nil},
+
+		{shapes + "fn a() {\n\tP { x: 1, y: true }\n}", []string{"Expected i32 but found bool"}},
+		{shapes + "fn a() {\n\tP { x: 1 }\n}", []string{"Missing field y in literal of P"}},
+		{shapes + "fn a() {\n\tP { x: 1, y: 2, z: 3 }\n}", []string{"P has no field z"}},
+		{shapes + "fn a(p: P) {\n\tp.z\n}", []string{"P has no field z"}},
+		{shapes + "fn a(p: P) {\n\tp.area()\n}", []string{"P has no field area"}},
+		{shapes + "fn a(s: Shape) {\n\ta(P { x: 1, y: 2 })\n}", []string{"Expected Shape but found P"}},
+		{shapes + "impl Shape for P {}", []string{"P does not implement area of trait Shape"}},
+		{shapes + "impl Shape for P {\n\tfn area(self) -> bool { true }\n}", []string{"Method area of P does not match trait Shape, expected fn() -> i32 but found fn() -> bool"}},
+		{shapes + "impl Shape for P {\n\tfn area(self) -> i32 { 1 }\n\tfn size(self) {}\n}", []string{"size is not a method of trait Shape"}},
+		{shapes + "impl i32 {}", []string{"Only structs can have impl blocks"}},
+		{shapes + "impl P {\n\tfn sum(self) -> i32 { self.x }\n}\nfn a() {\n\tP.sum()\n}", []string{"Method sum of P has to be called on a value"}},
+		{shapes + "fn a() {\n\tP\n}", []string{"P is a type and not a value"}},
+	})
+}
+
+func TestFieldVisibility(t *testing.T) {
+	lib := "namespace lib\npub struct Box {\n\tpub size: i32\n\tsecret: i32\n}\npub fn new() -> Box {\n\tBox { size: 1, secret: 2 }\n}\nfn peek(b: Box) -> i32 {\n\tb.secret\n}"
+
+	tests := []testStruct{
+		{"namespace app\nfn a(b: lib::Box) -> i32 {\n\tb.size\n}", nil},
+		{"namespace lib\nfn a(b: Box) -> i32 {\n\tcase b {\n\t\tBox { secret: s } -> s\n\t}\n}", nil},
+
+		{"namespace app\nfn a(b: lib::Box) -> i32 {\n\tb.secret\n}", []string{"Field secret of lib::Box is private to namespace lib"}},
+		{"namespace app\nfn a(b: lib::Box) {\n\tlet! c = b\n\tc.secret = 1\n}", []string{"Field secret of lib::Box is private to namespace lib"}},
+		{"namespace app\nfn a() {\n\tlib::Box { size: 1, secret: 2 }\n}", []string{"Field secret of lib::Box is private to namespace lib"}},
+		{"namespace app\nfn a() {\n\tlib::Box { size: 1 }\n}", []string{"lib::Box cannot be created outside of namespace lib, its field secret is private"}},
+		{"namespace app\nfn a(b: lib::Box) -> i32 {\n\tcase b {\n\t\tlib::Box { secret: s } -> s\n\t}\n}", []string{"Field secret of lib::Box is private to namespace lib"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			_, _, msgs := check(t, lib, test.input)
+
+			if !reflect.DeepEqual(msgs, test.errors) {
+				t.Errorf("\n%s\n%q\n%s\n%q", "---- EXPECTED ----", test.errors, "---- ACTUAL ----", msgs)
+			}
+		})
+	}
+}
+
+func TestRedeclarations(t *testing.T) {
+	testHelper(t, []testStruct{
+		{"fn f() {}\nfn f() {}", []string{"f is already declared at main.ql:1:4"}},
+		{"const a = 1\nconst a = 2", []string{"a is already declared at main.ql:1:7"}},
+		{"struct P { x: i32 }\nstruct P { y: i32 }", []string{"P is already declared at main.ql:1:8"}},
+		{"trait T {}\ntrait T {}", []string{"T is already declared at main.ql:1:7"}},
+		{"struct A { x: i32 }\nfn A() {}", []string{"A is already declared at main.ql:1:8"}},
+		{"namespace a\nfn f() {}\nnamespace b\nfn f() {}", nil},
+	})
+
+	_, _, msgs := check(t, "namespace a\npub fn f() {}", "namespace a\nfn g() {}\nfn f() {}")
+	if want := []string{"f is already declared at main.ql:2:8"}; !reflect.DeepEqual(msgs, want) {
+		t.Errorf("\n%s\n%q\n%s\n%q", "---- EXPECTED ----", want, "---- ACTUAL ----", msgs)
+	}
+}
+
+func TestCases(t *testing.T) {
+	testHelper(t, []testStruct{
+		{"fn a(b: bool) -> i32 {\n\tcase b {\n\t\ttrue -> 1\n\t\tfalse -> 0\n\t}\n}", nil},
+		{"struct P { x: i32 }\nfn a(b: P) -> i32 {\n\tcase b {\n\t\tP { x } -> x\n\t}\n}", nil},
+		{"fn a(b: i8) -> i8 {\n\tcase b {\n\t\t-128 -> 0\n\t\tn -> n\n\t}\n}", nil},
+
+		{"fn a(b: bool) {\n\tcase b {\n\t\ttrue -> 1\n\t}\n}", []string{"Case is not exhaustive, missing false"}},
+		{"fn a(b: i32) {\n\tcase b {\n\t\tfalse -> 1\n\t\t_ -> 0\n\t}\n}", []string{"Pattern of type bool cannot match i32"}},
+		{"fn a(b: u8) {\n\tcase b {\n\t\t256 -> 1\n\t\t_ -> 0\n\t}\n}", []string{"Overflow, 256 does not fit into u8"}},
+		{"struct P { x: i32 }\nstruct Q { x: i32 }\nfn a(b: P) {\n\tcase b {\n\t\tQ { x } -> x\n\t}\n}", []string{"Pattern of type Q cannot match P"}},
+		{"struct P { x: i32 }\nfn a(b: P) {\n\tcase b {\n\t\tP { x: true } -> 1\n\t\t_ -> 0\n\t}\n}", []string{"Pattern of type bool cannot match i32"}},
+		{"fn a(b: i64) {\n\tcase b {\n\t\tnil -> 1\n\t\t_ -> 0\n\t}\n}", []string{"Pattern of type nil cannot match i64"}},
+		{"struct P { x: i32 }\nfn a(b: P) {\n\tcase b {\n\t\tP { x: nil } -> 1\n\t\t_ -> 0\n\t}\n}", []string{"Pattern of type nil cannot match i32"}},
+		{"fn a() -> i32 {\n\tcase nil {\n\t\tnil -> 1\n\t}\n}", nil},
+		{"fn a() -> i32 {\n\tcase nil {\n\t\t_ -> 1\n\t}\n}", nil},
+		{"fn a() -> i32 {\n\tcase nil {\n\t\tfalse -> 1\n\t}\n}", []string{"Pattern of type bool cannot match nil", "Case is not exhaustive, missing nil"}},
+
+		// Without a typed subject the patterns decide
+		{"fn a(b: i32) {\n\tcase c {\n\t\ttrue -> 1\n\t}\n}", []string{"Unknown identifier c", "Case is not exhaustive, missing false"}},
+	})
+}
+
+func TestInfo(t *testing.T) {
+	programs, info, errors := check(t,
+		"namespace a\npub const x: u16 = 1\npub fn f(b: u8) -> u16 { b + x }",
+		"namespace b\nimport a::f\nfn g() -> u16 { f(2) + a::x }",
+	)
+
+	if errors != nil {
+		t.Fatalf("unexpected errors: %q", errors)
+	}
+
+	g := programs[1].Scopes[1].Functions[0]
+	sum := g.Body.Statements[0].(*parser.ExpressionStatement).Expression.(*parser.Binary)
+	call := sum.Left.(*parser.Call)
+
+	if info.Types[sum] != types.U16 || info.Types[call.Arguments[0]] != types.U8 {
+		t.Errorf("expected u16 and u8 but got %s and %s", info.Types[sum], info.Types[call.Arguments[0]])
+	}
+
+	if info.Uses[call.Callee] != programs[0].Scopes[1].Functions[0] {
+		t.Errorf("expected the call to use a::f")
+	}
+
+	if info.Defs[g].String() != "fn() -> u16" {
+		t.Errorf("expected fn() -> u16 but got %s", info.Defs[g])
+	}
+}
+
+func TestDiagnostic(t *testing.T) {
+	input := "struct P { x: i32 }\ntrait Shape {\n\tfn area(self) -> i32\n}\nimpl Shape for P {}"
+	program, _ := parser.Run(lexer.Run(util.NewFileSet().AddFile("main.ql", input)))
+
+	_, errors := checker.Run([]parser.Program{program}, nil, nil)
+	if len(errors) != 1 {
+		t.Fatalf("expected one error but got %s", errors)
+	}
+
+	want := "error[E0510]: P does not implement area of trait Shape\n" +
+		" --> main.ql:5:16\n" +
+		"  |\n" +
+		"3 |     fn area(self) -> i32\n" +
+		"  |        ---- required by the trait\n" +
+		" ...\n" +
+		"5 | impl Shape for P {}\n" +
+		"  |                ^ missing method\n" +
+		"  |\n" +
+		"  = help: Add area to the impl block or give it a default body in the trait\n"
+
+	if got := diagnostics.Render(errors[0], diagnostics.Plain); got != want {
+		t.Errorf("\n%s\n%s\n%s\n%s", "---- EXPECTED ----", want, "---- ACTUAL ----", got)
+	}
+}
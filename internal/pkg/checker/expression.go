@@ -0,0 +1,515 @@
+package checker
+
+import (
+	"fmt"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/consteval"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/lexer"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/parser"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/resolver"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/types"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
+)
+
+var shifts = map[lexer.TokenType]bool{
+	lexer.KEYWORD_SHL:  true,
+	lexer.KEYWORD_SHR:  true,
+	lexer.KEYWORD_ASHR: true,
+	lexer.KEYWORD_CSHL: true,
+	lexer.KEYWORD_CSHR: true,
+}
+
+var comparisons = map[lexer.TokenType]bool{
+	lexer.EQUALS:                 true,
+	lexer.NOT_EQUALS:             true,
+	lexer.LESS_THAN:              true,
+	lexer.LESS_THAN_OR_EQUALS:    true,
+	lexer.GREATER_THAN:           true,
+	lexer.GREATER_THAN_OR_EQUALS: true,
+}
+
+var literalTypes = map[lexer.TokenType]types.Type{
+	lexer.KEYWORD_TRUE:        types.Bool,
+	lexer.KEYWORD_FALSE:       types.Bool,
+	lexer.KEYWORD_NIL:         types.Nil,
+	lexer.STRING_LITERAL:      types.Bin,
+	lexer.BYTE_STRING_LITERAL: types.Bin,
+	lexer.SYM_LITERAL:         types.Sym,
+}
+
+// checkExpression returns the type of an expression and records it. It is
+// nil for if, cond and case expressions whose branches all return.
+func (c *checker) checkExpression(expression parser.Expression, env *environment) types.Type {
+	t := c.expressionType(expression, env)
+	c.info.Types[expression] = t
+
+	if basic, ok := t.(*types.Basic); ok && basic.IsUntyped() {
+		c.untyped = append(c.untyped, expression)
+	}
+
+	return t
+}
+
+func (c *checker) expressionType(expression parser.Expression, env *environment) types.Type {
+	switch expression := expression.(type) {
+	case *parser.Literal:
+		return c.checkLiteral(expression)
+	case *parser.Grouping:
+		return c.checkExpression(expression.Expression, env)
+	case *parser.Interpolation:
+		return c.checkInterpolation(expression, env)
+	case *parser.IdentifierExpression:
+		return c.checkIdentifier(expression, env)
+	case *parser.PathExpression:
+		return c.checkPath(expression)
+	case *parser.SelfExpression:
+		if c.self == nil {
+			c.errorf(expression.Pos, diagnostics.UNKNOWN_NAME, "self can only be used in methods")
+			return types.Invalid
+		}
+		return c.self
+	case *parser.StructLiteral:
+		return c.checkStructLiteral(expression, env)
+	case *parser.FieldAccess:
+		return c.checkFieldAccess(expression, env, false)
+	case *parser.Call:
+		return c.checkCall(expression, env)
+	case *parser.Index:
+		target := c.checkExpression(expression.Target, env)
+		c.checkInteger(expression.Index, env)
+
+		if target != types.Bin && target != types.Invalid {
+			c.errorf(expression.Pos, diagnostics.INVALID_OPERAND, "Cannot index %s", target)
+			return types.Invalid
+		}
+		return types.U8
+	case *parser.Unary:
+		return c.checkUnary(expression, env)
+	case *parser.Binary:
+		return c.checkBinary(expression, env)
+	case *parser.If:
+		c.checkCondition(expression.Condition, env)
+
+		value := c.checkScope(expression.Then, env)
+		if expression.Else == nil {
+			return types.Void
+		}
+		return c.join(value, c.checkScope(expression.Else, env), false)
+	case *parser.Cond:
+		var value types.Type
+		for idx, branch := range expression.Branches {
+			c.checkCondition(branch.Condition, env)
+			value = c.join(value, c.checkScope(branch.Body, env), idx == 0)
+		}
+
+		if expression.Else == nil {
+			return types.Void
+		}
+		return c.join(value, c.checkScope(expression.Else, env), false)
+	case *parser.Case:
+		return c.checkCase(expression, env)
+	}
+
+	return types.Invalid
+}
+
+func (c *checker) checkIdentifier(expression *parser.IdentifierExpression, env *environment) types.Type {
+	obj := env.lookup(expression.Identifer.Name)
+	if obj == nil {
+		c.errorf(expression.Identifer.Pos, diagnostics.UNKNOWN_NAME, "Unknown identifier %s", expression.Identifer.Name)
+		return types.Invalid
+	}
+
+	c.info.Uses[expression] = obj.node
+
+	if obj.isType {
+		c.errorf(expression.Identifer.Pos, diagnostics.NOT_A_VALUE, "%s is a type and not a value", expression.Identifer.Name).
+			At(declarationName(obj.node).Pos, "declared here")
+		return types.Invalid
+	}
+
+	return c.typeOf(obj)
+}
+
+func (c *checker) checkPath(expression *parser.PathExpression) types.Type {
+	if c.resolution == nil {
+		return types.Invalid
+	}
+
+	// The resolver already reported paths it could not resolve
+	symbol, ok := c.resolution.Paths[expression]
+	if !ok {
+		return types.Invalid
+	}
+
+	c.info.Uses[expression] = symbol.Node
+
+	if symbol.Kind != resolver.CONSTANT && symbol.Kind != resolver.FUNCTION {
+		c.errorf(expression.Identifer.Pos, diagnostics.NOT_A_VALUE, "%s is a type and not a value", symbol.Name).At(symbol.Pos, "declared here")
+		return types.Invalid
+	}
+
+	if t, ok := c.info.Defs[symbol.Node]; ok {
+		return t
+	}
+
+	return types.Invalid
+}
+
+func (c *checker) checkCondition(expression parser.Expression, env *environment) {
+	if t := c.checkExpression(expression, env); t != types.Bool && t != types.Invalid {
+		c.errorf(parser.ExpressionPos(expression), diagnostics.TYPE_MISMATCH, "Expected bool but found %s", t).Labelf("has type %s", t)
+	}
+}
+
+func (c *checker) checkInteger(expression parser.Expression, env *environment) types.Type {
+	t := c.checkExpression(expression, env)
+	if basic, ok := t.(*types.Basic); !ok || !basic.IsInteger() && basic != types.Invalid {
+		c.errorf(parser.ExpressionPos(expression), diagnostics.TYPE_MISMATCH, "Expected an integer but found %s", t).Labelf("has type %s", t)
+		return types.Invalid
+	}
+
+	c.convertUntyped(expression, types.Default(t))
+	return types.Default(t)
+}
+
+// checkLiteral returns the type of a literal. Numbers are untyped unless
+// they have a suffix, whose type they have to fit into.
+func (c *checker) checkLiteral(literal *parser.Literal) types.Type {
+	if t, ok := literalTypes[literal.Kind]; ok {
+		return t
+	}
+
+	value, err := consteval.Literal(literal)
+	if err != nil {
+		c.fail(literal.Pos, err)
+		return types.Invalid
+	}
+
+	return value.Type
+}
+
+func (c *checker) checkStructLiteral(expression *parser.StructLiteral, env *environment) types.Type {
+	t := c.resolveType(expression.Type, env)
+	structure, ok := t.(*types.Struct)
+	if !ok {
+		if t != types.Invalid {
+			c.errorf(expression.Type.Identifer.Pos, diagnostics.INVALID_TYPE, "%s is not a struct", t)
+		}
+
+		for _, field := range expression.Fields {
+			c.checkExpression(field.Expression, env)
+		}
+		return types.Invalid
+	}
+
+	given := map[string]util.Position{}
+	for _, field := range expression.Fields {
+		value := c.checkExpression(field.Expression, env)
+
+		f := structure.Field(field.Identifer.Name)
+		first, duplicate := given[field.Identifer.Name]
+		switch {
+		case f == nil:
+			c.errorf(field.Identifer.Pos, diagnostics.UNKNOWN_FIELD, "%s has no field %s", structure, field.Identifer.Name)
+		case duplicate:
+			c.errorf(field.Identifer.Pos, diagnostics.DUPLICATE_DECLARATION, "Field %s is given more than once", f.Name).
+				Labelf("given again").
+				At(first, "first given here")
+			continue
+		default:
+			c.checkVisibility(structure, f, field.Identifer.Pos)
+			c.assign(field.Expression, value, f.Type)
+		}
+
+		given[field.Identifer.Name] = field.Identifer.Pos
+	}
+
+	for _, field := range structure.Fields {
+		if _, ok := given[field.Name]; ok {
+			continue
+		}
+
+		if !c.visible(structure, field) {
+			c.errorf(expression.Type.Identifer.Pos, diagnostics.PRIVATE_NAME, "%s cannot be created outside of namespace %s, its field %s is private", structure, structure.Namespace, field.Name).
+				At(field.Decl.Identifer.Pos, "declared here")
+			continue
+		}
+
+		c.errorf(expression.Type.Identifer.Pos, diagnostics.MISSING_FIELD, "Missing field %s in literal of %s", field.Name, structure).
+			Helpf("Give %s a value, like %s { %s: ... }", field.Name, structure, field.Name)
+	}
+
+	return structure
+}
+
+// visible tells if a field can be used in the namespace that is checked,
+// private fields can only be used in the namespace of their struct.
+func (c *checker) visible(structure *types.Struct, field *types.Field) bool {
+	return *field.Decl.Visibility != parser.PRIVATE || structure.Namespace == c.namespace
+}
+
+// checkVisibility reports a field that is used at pos but is private to
+// another namespace.
+func (c *checker) checkVisibility(structure *types.Struct, field *types.Field, pos util.Position) {
+	if c.visible(structure, field) {
+		return
+	}
+
+	c.errorf(pos, diagnostics.PRIVATE_NAME, "Field %s of %s is private to namespace %s", field.Name, structure, structure.Namespace).
+		At(field.Decl.Identifer.Pos, "declared here").
+		Helpf("Declare %s with pub to use it outside of namespace %s", field.Name, structure.Namespace)
+}
+
+// checkFieldAccess returns the type of a field or, if it is the callee of a
+// call, of a method. Methods without self are called on the type.
+func (c *checker) checkFieldAccess(expression *parser.FieldAccess, env *environment, callee bool) types.Type {
+	name := expression.Identifer.Name
+
+	if structure := c.staticTarget(expression.Target, env); structure != nil {
+		method := structure.Method(name)
+		switch {
+		case method == nil:
+			c.errorf(expression.Identifer.Pos, diagnostics.UNKNOWN_METHOD, "%s has no method %s", structure, name)
+			return types.Invalid
+		case method.Self:
+			c.errorf(expression.Identifer.Pos, diagnostics.INVALID_METHOD_CALL, "Method %s of %s has to be called on a value", name, structure)
+			return types.Invalid
+		case !callee:
+			c.errorf(expression.Identifer.Pos, diagnostics.INVALID_METHOD_CALL, "Method %s of %s has to be called", name, structure)
+			return types.Invalid
+		}
+
+		c.info.Methods[expression] = method
+		return method.Signature
+	}
+
+	target := c.checkExpression(expression.Target, env)
+
+	var method *types.Method
+	switch target := target.(type) {
+	case *types.Struct:
+		if field := target.Field(name); field != nil {
+			c.checkVisibility(target, field, expression.Identifer.Pos)
+			return field.Type
+		}
+		method = target.Method(name)
+	case *types.Trait:
+		method = target.Method(name)
+	}
+
+	if target == types.Invalid {
+		return types.Invalid
+	}
+
+	switch {
+	case method == nil:
+		c.errorf(expression.Identifer.Pos, diagnostics.UNKNOWN_FIELD, "%s has no field %s", target, name)
+		return types.Invalid
+	case !method.Self:
+		c.errorf(expression.Identifer.Pos, diagnostics.INVALID_METHOD_CALL, "Method %s of %s has to be called on the type", name, target)
+		return types.Invalid
+	case !callee:
+		c.errorf(expression.Identifer.Pos, diagnostics.INVALID_METHOD_CALL, "Method %s of %s has to be called", name, target)
+		return types.Invalid
+	}
+
+	c.info.Methods[expression] = method
+	return method.Signature
+}
+
+// staticTarget returns the struct an expression names, if it names a type
+// instead of a value.
+func (c *checker) staticTarget(expression parser.Expression, env *environment) *types.Struct {
+	var t types.Type
+
+	switch expression := expression.(type) {
+	case *parser.IdentifierExpression:
+		obj := env.lookup(expression.Identifer.Name)
+		if obj == nil || !obj.isType {
+			return nil
+		}
+		c.info.Uses[expression] = obj.node
+		t = c.typeOf(obj)
+	case *parser.PathExpression:
+		if c.resolution == nil {
+			return nil
+		}
+		symbol, ok := c.resolution.Paths[expression]
+		if !ok || symbol.Kind != resolver.STRUCT {
+			return nil
+		}
+		c.info.Uses[expression] = symbol.Node
+		t = c.info.Defs[symbol.Node]
+	}
+
+	structure, _ := t.(*types.Struct)
+	return structure
+}
+
+func (c *checker) checkCall(expression *parser.Call, env *environment) types.Type {
+	var callee types.Type
+	if access, ok := expression.Callee.(*parser.FieldAccess); ok {
+		callee = c.checkFieldAccess(access, env, true)
+		c.info.Types[access] = callee
+	} else {
+		callee = c.checkExpression(expression.Callee, env)
+	}
+
+	signature, ok := callee.(*types.Function)
+	if !ok {
+		if callee != types.Invalid {
+			c.errorf(expression.Pos, diagnostics.INVALID_CALL, "Cannot call %s", callee)
+		}
+
+		for _, argument := range expression.Arguments {
+			c.checkExpression(argument, env)
+		}
+		return types.Invalid
+	}
+
+	if len(expression.Arguments) != len(signature.Parameters) {
+		c.errorf(expression.Pos, diagnostics.INVALID_CALL, "Expected %d arguments but found %d", len(signature.Parameters), len(expression.Arguments)).
+			Labelf("expects %d arguments", len(signature.Parameters))
+	}
+
+	for idx, argument := range expression.Arguments {
+		value := c.checkExpression(argument, env)
+		if idx < len(signature.Parameters) {
+			c.assign(argument, value, signature.Parameters[idx])
+		}
+	}
+
+	return signature.Result
+}
+
+func (c *checker) checkUnary(expression *parser.Unary, env *environment) types.Type {
+	// A negated literal with a suffix is checked as a whole, so that -128i8
+	// fits into i8 even though 128i8 does not
+	if literal := consteval.NegatedLiteral(expression); literal != nil {
+		value, err := consteval.NegativeLiteral(literal)
+		if err != nil {
+			c.fail(expression.Pos, err)
+			return types.Invalid
+		}
+
+		c.info.Types[literal] = value.Type
+		return value.Type
+	}
+
+	t := c.checkExpression(expression.Operand, env)
+	basic, ok := t.(*types.Basic)
+
+	switch {
+	case t == types.Invalid:
+		return t
+	case !ok:
+	case expression.Operator == lexer.MINUS_SIGN && basic.IsNumeric() && !basic.IsUnsigned():
+		return t
+	case expression.Operator == lexer.KEYWORD_NOT && (basic == types.Bool || basic.IsInteger()):
+		return t
+	}
+
+	c.errorf(expression.Pos, diagnostics.INVALID_OPERAND, "%s cannot be applied to %s", expression.Operator, t)
+	return types.Invalid
+}
+
+// checkInterpolation checks that only byte strings, bool, numbers and
+// symbols are interpolated. Untyped numbers are formatted as their default
+// type.
+func (c *checker) checkInterpolation(expression *parser.Interpolation, env *environment) types.Type {
+	for _, embedded := range expression.Expressions {
+		t := c.checkExpression(embedded, env)
+		if basic, ok := t.(*types.Basic); ok && basic.IsUntyped() {
+			c.convertUntyped(embedded, types.Default(t))
+			continue
+		}
+
+		if basic, ok := t.(*types.Basic); t != nil && (!ok || !basic.IsNumeric() && basic != types.Bin && basic != types.Bool && basic != types.Sym && basic != types.Invalid) {
+			c.errorf(parser.ExpressionPos(embedded), diagnostics.INVALID_OPERAND, "%s cannot be interpolated", t).
+				Labelf("has type %s", t).
+				Notef("Only byte strings, bool, numbers and symbols can be interpolated")
+		}
+	}
+
+	return types.Bin
+}
+
+func (c *checker) checkBinary(expression *parser.Binary, env *environment) types.Type {
+	left := c.checkExpression(expression.Left, env)
+
+	if shifts[expression.Operator] {
+		right := c.checkInteger(expression.Right, env)
+		if basic, ok := left.(*types.Basic); !ok || !basic.IsInteger() && basic != types.Invalid {
+			c.errorf(expression.Pos, diagnostics.INVALID_OPERAND, "%s cannot be applied to %s", expression.Operator, left)
+			return types.Invalid
+		}
+
+		if left == types.Invalid || right == types.Invalid {
+			return types.Invalid
+		}
+		return left
+	}
+
+	right := c.checkExpression(expression.Right, env)
+	if left == types.Invalid || right == types.Invalid {
+		return types.Invalid
+	}
+
+	if left == nil || right == nil || left == types.Void || right == types.Void {
+		c.errorf(expression.Pos, diagnostics.INVALID_OPERAND, "%s cannot be applied to void", expression.Operator)
+		return types.Invalid
+	}
+
+	// The right side of ?? is only used if the left side is nil
+	if expression.Operator == lexer.IF_NIL {
+		if left == types.Nil {
+			return right
+		}
+
+		c.assign(expression.Right, right, left)
+		return left
+	}
+
+	t := types.Unify(left, right)
+	if t == nil {
+		c.errorf(expression.Pos, diagnostics.TYPE_MISMATCH, "Mismatched types %s and %s", left, right).
+			Labelf("operands of different types").
+			At(parser.ExpressionPos(expression.Left), fmt.Sprintf("has type %s", left)).
+			At(parser.ExpressionPos(expression.Right), fmt.Sprintf("has type %s", right))
+		return types.Invalid
+	}
+
+	if comparisons[expression.Operator] {
+		t = types.Default(t)
+	}
+
+	c.convertUntyped(expression.Left, t)
+	c.convertUntyped(expression.Right, t)
+
+	basic, _ := t.(*types.Basic)
+
+	switch {
+	case expression.Operator == lexer.EQUALS || expression.Operator == lexer.NOT_EQUALS:
+		return types.Bool
+	case comparisons[expression.Operator] && basic != nil && (basic.IsNumeric() || basic == types.Bin):
+		return types.Bool
+	case basic == nil:
+	case expression.Operator == lexer.LOGICAL_AND || expression.Operator == lexer.LOGICAL_OR:
+		if basic == types.Bool {
+			return t
+		}
+	case expression.Operator == lexer.KEYWORD_AND || expression.Operator == lexer.KEYWORD_OR || expression.Operator == lexer.KEYWORD_XOR:
+		if basic == types.Bool || basic.IsInteger() {
+			return t
+		}
+	case expression.Operator == lexer.PLUS_SIGN && basic == types.Bin:
+		return t
+	case basic.IsNumeric():
+		return t
+	}
+
+	c.errorf(expression.Pos, diagnostics.INVALID_OPERAND, "%s cannot be applied to %s", expression.Operator, t)
+	return types.Invalid
+}
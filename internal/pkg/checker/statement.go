@@ -0,0 +1,327 @@
+package checker
+
+import (
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/analyzer"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/consteval"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/lexer"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/parser"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/types"
+)
+
+// checkBodies checks the bodies of all functions and methods of a scope.
+func (c *checker) checkBodies(scope *parser.Scope, env *environment) {
+	for _, function := range scope.Functions {
+		c.checkFunction(function, env, nil)
+	}
+
+	for _, trait := range scope.Traits {
+		t := c.info.Defs[trait].(*types.Trait)
+		for _, method := range trait.Methods {
+			c.checkFunction(method, env, t)
+		}
+	}
+
+	for _, impl := range scope.Impls {
+		self := c.resolveType(impl.Type, env)
+		for _, method := range impl.Methods {
+			c.checkFunction(method, env, self)
+		}
+	}
+}
+
+func (c *checker) checkFunction(function *parser.Function, parent *environment, self types.Type) {
+	if function.Body == nil {
+		return
+	}
+
+	signature, ok := c.info.Defs[function].(*types.Function)
+	if !ok {
+		return
+	}
+
+	result, outerSelf := c.result, c.self
+	c.result, c.self = signature.Result, self
+	defer func() { c.result, c.self = result, outerSelf }()
+
+	env := newEnvironment(parent)
+	for _, parameter := range function.Parameters {
+		if parameter.Type != nil {
+			env.objects[parameter.Identifer.Name] = &object{node: parameter, typ: c.info.Defs[parameter]}
+		}
+	}
+
+	// The last expression of the body is its value, so a function can end
+	// with it instead of a return
+	value := c.checkScope(function.Body, env)
+	if value == nil || signature.Result == types.Void {
+		return
+	}
+
+	if value == types.Void {
+		c.errorf(function.Identifer.Pos, diagnostics.INVALID_RETURN, "Function %s has to return %s", function.Identifer.Name, signature.Result).
+			Helpf("End the body with a value of type %s or return one", signature.Result)
+		return
+	}
+
+	last := function.Body.Statements[len(function.Body.Statements)-1].(*parser.ExpressionStatement)
+	c.assign(last.Expression, value, signature.Result)
+}
+
+// checkScope checks the declarations and statements of a nested scope and
+// returns the type of its value: the type of its last statement if that is
+// an expression, void if it is not and nil if the scope always returns.
+func (c *checker) checkScope(scope *parser.Scope, parent *environment) types.Type {
+	env := newEnvironment(parent)
+
+	c.declareTypes(scope, env, "")
+	c.completeTypes(scope, env)
+	c.checkBodies(scope, env)
+
+	var value types.Type = types.Void
+	for _, statement := range scope.Statements {
+		value = c.checkStatement(statement, env)
+	}
+
+	return value
+}
+
+func (c *checker) checkStatement(statement parser.Statement, env *environment) types.Type {
+	switch statement := statement.(type) {
+	case *parser.ExpressionStatement:
+		return c.checkExpression(statement.Expression, env)
+	case *parser.Return:
+		c.checkReturn(statement, env)
+		return nil
+	case *parser.Binding:
+		c.checkBinding(statement, env)
+	case *parser.Assignment:
+		target := c.checkExpression(statement.Target, env)
+		value := c.checkExpression(statement.Expression, env)
+		c.assign(statement.Expression, value, target)
+	}
+
+	return types.Void
+}
+
+func (c *checker) checkReturn(statement *parser.Return, env *environment) {
+	if statement.Expression == nil {
+		if c.result != nil && c.result != types.Void {
+			c.errorf(statement.Pos, diagnostics.INVALID_RETURN, "Expected a return value of type %s", c.result).Labelf("returns no value")
+		}
+		return
+	}
+
+	value := c.checkExpression(statement.Expression, env)
+	if c.result == nil || c.result == types.Void {
+		c.errorf(statement.Pos, diagnostics.INVALID_RETURN, "Cannot return a value from a function without a return type").
+			Helpf("Declare the return type after the parameters, like -> %s", types.Default(value))
+		return
+	}
+
+	c.assign(statement.Expression, value, c.result)
+}
+
+func (c *checker) checkBinding(binding *parser.Binding, env *environment) {
+	value := c.checkExpression(binding.Expression, env)
+
+	var t types.Type
+	switch {
+	case binding.Type != nil:
+		t = c.resolveType(binding.Type, env)
+		c.assign(binding.Expression, value, t)
+	case value == nil || value == types.Void:
+		c.errorf(parser.ExpressionPos(binding.Expression), diagnostics.TYPE_MISMATCH, "Expected a value but found void")
+		t = types.Invalid
+	case value == types.Nil:
+		c.errorf(binding.Identifer.Pos, diagnostics.UNINFERRED_TYPE, "Cannot infer the type of %s from nil", binding.Identifer.Name).
+			Helpf("Give %s a type, like let %s: T = nil", binding.Identifer.Name, binding.Identifer.Name)
+		t = types.Invalid
+	default:
+		t = types.Default(value)
+		c.convertUntyped(binding.Expression, t)
+	}
+
+	c.info.Defs[binding] = t
+	env.objects[binding.Identifer.Name] = &object{node: binding, typ: t}
+}
+
+// assign reports an error if an expression of type value cannot be used
+// where target is expected. Untyped numbers take the target type if their
+// value fits into it.
+func (c *checker) assign(expression parser.Expression, value types.Type, target types.Type) {
+	if value == nil || value == types.Void {
+		c.errorf(parser.ExpressionPos(expression), diagnostics.TYPE_MISMATCH, "Expected %s but found void", target).Labelf("has no value")
+		return
+	}
+
+	if !types.AssignableTo(value, target) {
+		c.errorf(parser.ExpressionPos(expression), diagnostics.TYPE_MISMATCH, "Expected %s but found %s", target, value).Labelf("has type %s", value)
+		return
+	}
+
+	c.convertUntyped(expression, target)
+}
+
+// convertUntyped gives an untyped expression and its untyped operands the
+// given type and checks that its value fits into it.
+func (c *checker) convertUntyped(expression parser.Expression, target types.Type) {
+	value, ok := c.info.Types[expression].(*types.Basic)
+	if !ok || !value.IsUntyped() || target == value {
+		return
+	}
+
+	basic, ok := target.(*types.Basic)
+	if !ok || basic == types.Invalid || basic.IsUntyped() {
+		return
+	}
+
+	if folded, ok := consteval.Fold(expression); ok {
+		if _, err := consteval.Convert(folded, basic); err != nil {
+			c.fail(parser.ExpressionPos(expression), err)
+		}
+	}
+
+	c.setUntyped(expression, basic)
+}
+
+func (c *checker) setUntyped(expression parser.Expression, target types.Type) {
+	value, ok := c.info.Types[expression].(*types.Basic)
+	if !ok || !value.IsUntyped() {
+		return
+	}
+
+	c.info.Types[expression] = target
+
+	switch expression := expression.(type) {
+	case *parser.Grouping:
+		c.setUntyped(expression.Expression, target)
+	case *parser.Unary:
+		c.setUntyped(expression.Operand, target)
+	case *parser.Binary:
+		c.setUntyped(expression.Left, target)
+		if !shifts[expression.Operator] {
+			c.setUntyped(expression.Right, target)
+		}
+	case *parser.If:
+		c.setUntypedScope(expression.Then, target)
+		c.setUntypedScope(expression.Else, target)
+	case *parser.Cond:
+		for _, branch := range expression.Branches {
+			c.setUntypedScope(branch.Body, target)
+		}
+		c.setUntypedScope(expression.Else, target)
+	case *parser.Case:
+		for _, branch := range expression.Branches {
+			c.setUntypedScope(branch.Body, target)
+		}
+	}
+}
+
+func (c *checker) setUntypedScope(scope *parser.Scope, target types.Type) {
+	if scope == nil || len(scope.Statements) == 0 {
+		return
+	}
+
+	if last, ok := scope.Statements[len(scope.Statements)-1].(*parser.ExpressionStatement); ok {
+		c.setUntyped(last.Expression, target)
+	}
+}
+
+/* Patterns */
+
+func (c *checker) checkCase(expression *parser.Case, env *environment) types.Type {
+	subject := c.checkExpression(expression.Subject, env)
+	subject = types.Default(subject)
+	c.convertUntyped(expression.Subject, subject)
+
+	var value types.Type
+	for idx, branch := range expression.Branches {
+		branchEnv := newEnvironment(env)
+		c.checkPattern(branch.Pattern, subject, branchEnv)
+		value = c.join(value, c.checkScope(branch.Body, branchEnv), idx == 0)
+	}
+
+	// Without type information the patterns are the best guess there is
+	exhaustiveness := analyzer.InferSubject(expression)
+	if subject != types.Invalid {
+		_, isStruct := subject.(*types.Struct)
+		exhaustiveness = analyzer.Subject{
+			Bool:   subject == types.Bool,
+			Nil:    subject == types.Nil,
+			Struct: isStruct,
+		}
+	}
+
+	c.errors = append(c.errors, analyzer.CheckCase(expression, exhaustiveness)...)
+
+	return value
+}
+
+func (c *checker) checkPattern(pattern parser.Pattern, subject types.Type, env *environment) {
+	switch pattern := pattern.(type) {
+	case *parser.LiteralPattern:
+		var expression parser.Expression = pattern.Literal
+		if pattern.Negative {
+			expression = &parser.Unary{Operator: lexer.MINUS_SIGN, Operand: pattern.Literal, Pos: pattern.Literal.Pos}
+		}
+		t := c.checkExpression(expression, env)
+
+		if !types.AssignableTo(t, subject) {
+			c.errorf(pattern.Literal.Pos, diagnostics.TYPE_MISMATCH, "Pattern of type %s cannot match %s", t, subject)
+			return
+		}
+
+		c.convertUntyped(expression, subject)
+	case *parser.NilPattern:
+		// Only nil itself can be nil, no other type has nil as a value
+		if !types.AssignableTo(types.Nil, subject) {
+			c.errorf(pattern.Pos, diagnostics.TYPE_MISMATCH, "Pattern of type %s cannot match %s", types.Nil, subject)
+		}
+	case *parser.BindingPattern:
+		c.info.Defs[pattern] = subject
+		env.objects[pattern.Identifer.Name] = &object{node: pattern, typ: subject}
+	case *parser.StructPattern:
+		t := c.resolveType(pattern.Type, env)
+		structure, ok := t.(*types.Struct)
+		if !ok {
+			if t != types.Invalid {
+				c.errorf(pattern.Type.Identifer.Pos, diagnostics.INVALID_TYPE, "%s is not a struct", t)
+			}
+			return
+		}
+
+		if subject != types.Invalid && !types.AssignableTo(structure, subject) {
+			c.errorf(pattern.Type.Identifer.Pos, diagnostics.TYPE_MISMATCH, "Pattern of type %s cannot match %s", structure, subject)
+		}
+
+		for _, field := range pattern.Fields {
+			f := structure.Field(field.Identifer.Name)
+			if f == nil {
+				c.errorf(field.Identifer.Pos, diagnostics.UNKNOWN_FIELD, "%s has no field %s", structure, field.Identifer.Name)
+				continue
+			}
+
+			c.checkVisibility(structure, f, field.Identifer.Pos)
+			c.checkPattern(field.Pattern, f.Type, env)
+		}
+	}
+}
+
+// join combines the values of the branches of if, cond and case. Branches
+// that always return do not count, branches of different types make the
+// whole expression void.
+func (c *checker) join(value types.Type, branch types.Type, first bool) types.Type {
+	switch {
+	case first || value == nil:
+		return branch
+	case branch == nil:
+		return value
+	}
+
+	if t := types.Unify(value, branch); t != nil {
+		return t
+	}
+
+	return types.Void
+}
@@ -6,10 +6,13 @@ import (
 	"strings"
 
 	"github.com/fatih/color"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
 )
 
 type Cli struct {
-	sc bufio.Scanner
+	sc      bufio.Scanner
+	noColor bool
 }
 
 func New(sc bufio.Scanner) *Cli {
@@ -41,22 +44,67 @@ func (cli *Cli) Read() string {
 	return strings.TrimSpace(input.String())
 }
 
+// DisableColor makes the cli write without colors, which it otherwise only
+// does when the output is not a terminal.
+func (cli *Cli) DisableColor() {
+	cli.noColor = true
+}
+
+func (cli *Cli) color(attributes ...color.Attribute) *color.Color {
+	c := color.New(attributes...)
+	if cli.noColor {
+		c.DisableColor()
+	}
+
+	return c
+}
+
 func (cli *Cli) Write(text string, a ...any) {
 	fmt.Printf(text+"\n", a...)
 }
 
 func (cli *Cli) WriteSuccess(text string, a ...any) {
-	color.New(color.FgHiGreen).PrintfFunc()(text+"\n", a...)
+	cli.color(color.FgHiGreen).PrintfFunc()(text+"\n", a...)
 }
 
 func (cli *Cli) WriteDebug(text string, a ...any) {
-	color.New(color.FgCyan).PrintfFunc()(text+"\n", a...)
+	cli.color(color.FgCyan).PrintfFunc()(text+"\n", a...)
 }
 
 func (cli *Cli) WriteWarning(text string, a ...any) {
-	color.New(color.FgYellow).PrintfFunc()(text+"\n", a...)
+	cli.color(color.FgYellow).PrintfFunc()(text+"\n", a...)
 }
 
 func (cli *Cli) WriteError(text string, a ...any) {
-	color.New(color.FgRed).PrintfFunc()(text+"\n", a...)
+	cli.color(color.FgRed).PrintfFunc()(text+"\n", a...)
+}
+
+var diagnosticColors = map[diagnostics.Style][]color.Attribute{
+	diagnostics.ERROR_STYLE:   {color.FgHiRed, color.Bold},
+	diagnostics.WARNING_STYLE: {color.FgYellow, color.Bold},
+	diagnostics.NOTE_STYLE:    {color.FgHiGreen, color.Bold},
+	diagnostics.HELP_STYLE:    {color.FgCyan, color.Bold},
+	diagnostics.GUTTER_STYLE:  {color.FgHiBlue, color.Bold},
+	diagnostics.MESSAGE_STYLE: {color.Bold},
+}
+
+// WriteDiagnostic writes a diagnostic with its source excerpt, followed by an
+// empty line that separates it from the next one.
+func (cli *Cli) WriteDiagnostic(diagnostic diagnostics.Diagnostic) {
+	fmt.Println(diagnostics.Render(diagnostic, func(style diagnostics.Style, text string) string {
+		return cli.color(diagnosticColors[style]...).Sprint(text)
+	}))
+}
+
+// Report writes diagnostics and errors that know their diagnostic as
+// diagnostics and any other error as it is.
+func (cli *Cli) Report(err error) {
+	switch err := err.(type) {
+	case diagnostics.Diagnostic:
+		cli.WriteDiagnostic(err)
+	case interface{ Diagnostic() diagnostics.Diagnostic }:
+		cli.WriteDiagnostic(err.Diagnostic())
+	default:
+		cli.WriteError("%s", err)
+	}
 }
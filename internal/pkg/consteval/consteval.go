@@ -0,0 +1,565 @@
+package consteval
+
+import (
+	"math"
+	"math/big"
+	"strings"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/lexer"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/parser"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/resolver"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/types"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
+)
+
+// MAX_UNTYPED_BITS is the biggest untyped integer a constant may hold, in
+// bits. It keeps a careless power from exhausting the memory. It is also the
+// biggest amount of a shift, every backend checks shifts against it.
+const MAX_UNTYPED_BITS = 4096
+
+type environment struct {
+	parent    *environment
+	constants map[string]*parser.Constant
+	imports   map[string]*resolver.Symbol
+}
+
+func (env *environment) lookup(name string) *parser.Constant {
+	for ; env != nil; env = env.parent {
+		if constant, ok := env.constants[name]; ok {
+			return constant
+		}
+
+		if symbol, ok := env.imports[name]; ok {
+			if constant, ok := symbol.Node.(*parser.Constant); ok {
+				return constant
+			}
+			return nil
+		}
+	}
+
+	return nil
+}
+
+type evaluator struct {
+	resolution *resolver.Resolution
+	envs       map[*parser.Constant]*environment
+	values     map[*parser.Constant]Value
+	failed     map[*parser.Constant]bool
+	stack      []*parser.Constant
+	errors     []diagnostics.Diagnostic
+}
+
+// Run folds the initialisers of all constants of the given programs, in
+// every scope and across files. Constants may refer to each other in any
+// order as long as they do not depend on themselves.
+func Run(programs []parser.Program, resolution *resolver.Resolution) (map[*parser.Constant]Value, []diagnostics.Diagnostic) {
+	e := evaluator{
+		resolution: resolution,
+		envs:       map[*parser.Constant]*environment{},
+		values:     map[*parser.Constant]Value{},
+		failed:     map[*parser.Constant]bool{},
+	}
+
+	namespaces := map[string]map[string]*parser.Constant{}
+	for _, program := range programs {
+		for _, scope := range program.Scopes {
+			name := namespaceName(scope)
+			if namespaces[name] == nil {
+				namespaces[name] = map[string]*parser.Constant{}
+			}
+
+			for _, constant := range scope.Constants {
+				namespaces[name][constant.Identifer.Name] = constant
+			}
+		}
+	}
+
+	var constants []*parser.Constant
+	for _, program := range programs {
+		for _, scope := range program.Scopes {
+			env := &environment{constants: namespaces[namespaceName(scope)]}
+			if resolution != nil {
+				env.imports = resolution.Imports[scope]
+			}
+
+			constants = append(constants, e.collect(scope, env, true)...)
+		}
+	}
+
+	for _, constant := range constants {
+		e.evaluate(constant)
+	}
+
+	return e.values, e.errors
+}
+
+// Fold evaluates an expression that is built from literals and operators
+// only. It reports false if the expression refers to anything else or
+// cannot be evaluated.
+func Fold(expression parser.Expression) (Value, bool) {
+	e := evaluator{}
+	value, ok := e.evaluateExpression(expression, nil)
+	return value, ok && len(e.errors) == 0
+}
+
+func namespaceName(scope *parser.Scope) string {
+	if scope.Namespace == nil {
+		return ""
+	}
+
+	return scope.Namespace.Path.String()
+}
+
+// collect remembers the environment every constant is evaluated in. Nested
+// scopes see their own constants first and then the ones of their parents.
+func (e *evaluator) collect(scope *parser.Scope, env *environment, topLevel bool) []*parser.Constant {
+	if !topLevel {
+		env = &environment{parent: env, constants: map[string]*parser.Constant{}}
+		for _, constant := range scope.Constants {
+			env.constants[constant.Identifer.Name] = constant
+		}
+	}
+
+	constants := scope.Constants
+	for _, constant := range scope.Constants {
+		e.envs[constant] = env
+	}
+
+	parser.Inspect(scope, func(node any) bool {
+		if nested, ok := node.(*parser.Scope); ok && nested != scope {
+			constants = append(constants, e.collect(nested, env, false)...)
+			return false
+		}
+
+		return true
+	})
+
+	return constants
+}
+
+func (e *evaluator) errorf(pos util.Position, code string, format string, a ...any) {
+	e.errors = append(e.errors, diagnostics.Errorf(pos, code, format, a...))
+}
+
+// fail reports an error of evaluating an operator or a conversion at pos.
+func (e *evaluator) fail(pos util.Position, err error) {
+	e.errors = append(e.errors, diagnostics.Errorf(pos, diagnostics.CodeOf(err), "%s", err))
+}
+
+func (e *evaluator) evaluate(constant *parser.Constant) (Value, bool) {
+	if value, ok := e.values[constant]; ok {
+		return value, true
+	}
+
+	if e.failed[constant] {
+		return Value{}, false
+	}
+
+	for idx, visiting := range e.stack {
+		if visiting != constant {
+			continue
+		}
+
+		var names []string
+		for _, cycle := range e.stack[idx:] {
+			names = append(names, cycle.Identifer.Name)
+		}
+		names = append(names, constant.Identifer.Name)
+
+		e.errorf(constant.Identifer.Pos, diagnostics.CYCLIC_CONSTANT, "Constant %s depends on itself: %s", constant.Identifer.Name, strings.Join(names, " -> "))
+		e.failed[constant] = true
+		return Value{}, false
+	}
+
+	e.stack = append(e.stack, constant)
+	value, ok := e.evaluateExpression(constant.Expression.Expression, e.envs[constant])
+	e.stack = e.stack[:len(e.stack)-1]
+
+	if ok && constant.Type != nil {
+		value, ok = e.convertTo(value, constant.Type, parser.ExpressionPos(constant.Expression.Expression))
+	}
+
+	if !ok {
+		e.failed[constant] = true
+		return Value{}, false
+	}
+
+	e.values[constant] = value
+	return value, true
+}
+
+func (e *evaluator) convertTo(value Value, target *parser.Type, pos util.Position) (Value, bool) {
+	basic := types.LookupBasic(target.Identifer.Name)
+	if target.Namespace != nil || basic == nil {
+		e.errorf(target.Identifer.Pos, diagnostics.INVALID_TYPE, "Constants can only have a primitive type")
+		return Value{}, false
+	}
+
+	converted, err := Convert(value, basic)
+	if err != nil {
+		e.fail(pos, err)
+		return Value{}, false
+	}
+
+	return converted, true
+}
+
+func (e *evaluator) evaluateExpression(expression parser.Expression, env *environment) (Value, bool) {
+	switch expression := expression.(type) {
+	case *parser.Literal:
+		value, err := Literal(expression)
+		if err != nil {
+			e.fail(expression.Pos, err)
+			return Value{}, false
+		}
+		return value, true
+	case *parser.Grouping:
+		return e.evaluateExpression(expression.Expression, env)
+	case *parser.Interpolation:
+		return e.evaluateInterpolation(expression, env)
+	case *parser.IdentifierExpression:
+		constant := env.lookup(expression.Identifer.Name)
+		if constant == nil {
+			e.errorf(expression.Identifer.Pos, diagnostics.UNKNOWN_NAME, "Unknown constant %s", expression.Identifer.Name)
+			return Value{}, false
+		}
+		return e.evaluate(constant)
+	case *parser.PathExpression:
+		if e.resolution == nil {
+			e.errorf(expression.Identifer.Pos, diagnostics.UNKNOWN_NAME, "Unknown constant %s::%s", expression.Namespace, expression.Identifer.Name)
+			return Value{}, false
+		}
+
+		// The resolver reports the paths it cannot resolve and the analyzer
+		// the ones that name something else than a constant
+		var constant *parser.Constant
+		if symbol, ok := e.resolution.Paths[expression]; ok {
+			constant, _ = symbol.Node.(*parser.Constant)
+		}
+
+		if constant == nil {
+			return Value{}, false
+		}
+		return e.evaluate(constant)
+	case *parser.Unary:
+		if literal := NegatedLiteral(expression); literal != nil {
+			value, err := NegativeLiteral(literal)
+			if err != nil {
+				e.fail(expression.Pos, err)
+				return Value{}, false
+			}
+			return value, true
+		}
+
+		operand, ok := e.evaluateExpression(expression.Operand, env)
+		if !ok {
+			return Value{}, false
+		}
+
+		value, err := Unary(expression.Operator, operand)
+		if err != nil {
+			e.fail(expression.Pos, err)
+			return Value{}, false
+		}
+		return value, true
+	case *parser.Binary:
+		return e.evaluateBinary(expression, env)
+	}
+
+	e.errorf(parser.ExpressionPos(expression), diagnostics.NOT_CONSTANT, "Expression cannot be evaluated at compile time")
+	return Value{}, false
+}
+
+// evaluateInterpolation concatenates the parts of an interpolation with the
+// text of its expressions.
+func (e *evaluator) evaluateInterpolation(expression *parser.Interpolation, env *environment) (Value, bool) {
+	var sb strings.Builder
+	for idx, part := range expression.Parts {
+		sb.WriteString(part.Value)
+		if idx == len(expression.Expressions) {
+			break
+		}
+
+		value, ok := e.evaluateExpression(expression.Expressions[idx], env)
+		if !ok {
+			return Value{}, false
+		}
+		sb.WriteString(Format(value))
+	}
+
+	return Value{Type: types.Bin, Bytes: sb.String()}, true
+}
+
+func (e *evaluator) evaluateBinary(expression *parser.Binary, env *environment) (Value, bool) {
+	left, ok := e.evaluateExpression(expression.Left, env)
+	if !ok {
+		return Value{}, false
+	}
+
+	// The right side of ??, && and || is only evaluated if it is needed
+	switch {
+	case expression.Operator == lexer.IF_NIL && left.Type != types.Nil:
+		return left, true
+	case expression.Operator == lexer.LOGICAL_AND && left.Type == types.Bool && !left.Bool:
+		return left, true
+	case expression.Operator == lexer.LOGICAL_OR && left.Type == types.Bool && left.Bool:
+		return left, true
+	}
+
+	right, ok := e.evaluateExpression(expression.Right, env)
+	if !ok {
+		return Value{}, false
+	}
+
+	if expression.Operator == lexer.IF_NIL {
+		return right, true
+	}
+
+	value, err := Binary(expression.Operator, left, right)
+	if err != nil {
+		e.fail(expression.Pos, err)
+		return Value{}, false
+	}
+
+	return value, true
+}
+
+/* Operations */
+
+// Unary applies a prefix operator to a value.
+func Unary(operator lexer.TokenType, operand Value) (Value, error) {
+	switch {
+	case operator == lexer.MINUS_SIGN && operand.Type.IsInteger():
+		return checkInt(intValue(operand.Type, new(big.Int).Neg(operand.Int)))
+	case operator == lexer.MINUS_SIGN && operand.Type.IsFloat():
+		return floatValue(operand.Type, -operand.Float), nil
+	case operator == lexer.KEYWORD_NOT && operand.Type == types.Bool:
+		return boolValue(!operand.Bool), nil
+	case operator == lexer.KEYWORD_NOT && operand.Type.IsUnsigned():
+		return intValue(operand.Type, new(big.Int).Xor(operand.Int, operand.Type.MaxInt())), nil
+	case operator == lexer.KEYWORD_NOT && operand.Type.IsInteger():
+		return intValue(operand.Type, new(big.Int).Not(operand.Int)), nil
+	}
+
+	return Value{}, diagnostics.Failf(diagnostics.INVALID_OPERAND, "%s cannot be applied to %s", operator, operand.Type)
+}
+
+// unify brings two operands to a common type. Untyped operands take the type
+// of the other operand, two untyped numbers become a float if one of them is.
+func unify(left Value, right Value) (Value, Value, error) {
+	switch {
+	case left.Type == right.Type:
+		return left, right, nil
+	case left.Type.IsUntyped() && right.Type.IsUntyped():
+		left, _ = Convert(left, types.UntypedFloat)
+		right, _ = Convert(right, types.UntypedFloat)
+		return left, right, nil
+	case left.Type.IsUntyped():
+		left, err := Convert(left, right.Type)
+		return left, right, err
+	case right.Type.IsUntyped():
+		right, err := Convert(right, left.Type)
+		return left, right, err
+	}
+
+	return Value{}, Value{}, diagnostics.Failf(diagnostics.TYPE_MISMATCH, "Mismatched types %s and %s", left.Type, right.Type)
+}
+
+var shifts = map[lexer.TokenType]bool{
+	lexer.KEYWORD_SHL:  true,
+	lexer.KEYWORD_SHR:  true,
+	lexer.KEYWORD_ASHR: true,
+	lexer.KEYWORD_CSHL: true,
+	lexer.KEYWORD_CSHR: true,
+}
+
+// Binary applies an operator to two values, except for ??, && and || whose
+// right side may not be evaluated. Untyped operands take the type of the
+// other operand, results that do not fit into their type are errors.
+func Binary(operator lexer.TokenType, left Value, right Value) (Value, error) {
+	if shifts[operator] {
+		return shift(operator, left, right)
+	}
+
+	left, right, err := unify(left, right)
+	if err != nil {
+		return Value{}, err
+	}
+
+	switch operator {
+	case lexer.EQUALS, lexer.NOT_EQUALS, lexer.LESS_THAN, lexer.LESS_THAN_OR_EQUALS, lexer.GREATER_THAN, lexer.GREATER_THAN_OR_EQUALS:
+		return compare(operator, left, right)
+	}
+
+	switch {
+	case left.Type.IsInteger():
+		return intBinary(operator, left, right)
+	case left.Type.IsFloat():
+		return floatBinary(operator, left, right)
+	case left.Type == types.Bool:
+		switch operator {
+		case lexer.KEYWORD_AND, lexer.LOGICAL_AND:
+			return boolValue(left.Bool && right.Bool), nil
+		case lexer.KEYWORD_OR, lexer.LOGICAL_OR:
+			return boolValue(left.Bool || right.Bool), nil
+		case lexer.KEYWORD_XOR:
+			return boolValue(left.Bool != right.Bool), nil
+		}
+	case left.Type == types.Bin && operator == lexer.PLUS_SIGN:
+		return Value{Type: types.Bin, Bytes: left.Bytes + right.Bytes}, nil
+	}
+
+	return Value{}, diagnostics.Failf(diagnostics.INVALID_OPERAND, "%s cannot be applied to %s", operator, left.Type)
+}
+
+func compare(operator lexer.TokenType, left Value, right Value) (Value, error) {
+	var order int
+
+	switch {
+	case left.Type.IsInteger():
+		order = left.Int.Cmp(right.Int)
+	case left.Type.IsFloat():
+		order = big.NewFloat(left.Float).Cmp(big.NewFloat(right.Float))
+	case left.Type == types.Bin:
+		order = strings.Compare(left.Bytes, right.Bytes)
+	case operator != lexer.EQUALS && operator != lexer.NOT_EQUALS:
+		return Value{}, diagnostics.Failf(diagnostics.INVALID_OPERAND, "%s cannot be applied to %s", operator, left.Type)
+	case left.Type == types.Bool && left.Bool != right.Bool,
+		left.Type == types.Sym && left.Sym != right.Sym:
+		order = 1
+	}
+
+	switch operator {
+	case lexer.EQUALS:
+		return boolValue(order == 0), nil
+	case lexer.NOT_EQUALS:
+		return boolValue(order != 0), nil
+	case lexer.LESS_THAN:
+		return boolValue(order < 0), nil
+	case lexer.LESS_THAN_OR_EQUALS:
+		return boolValue(order <= 0), nil
+	case lexer.GREATER_THAN:
+		return boolValue(order > 0), nil
+	default:
+		return boolValue(order >= 0), nil
+	}
+}
+
+func intBinary(operator lexer.TokenType, left Value, right Value) (Value, error) {
+	result := new(big.Int)
+
+	switch operator {
+	case lexer.PLUS_SIGN:
+		result.Add(left.Int, right.Int)
+	case lexer.MINUS_SIGN:
+		result.Sub(left.Int, right.Int)
+	case lexer.STAR_SIGN:
+		result.Mul(left.Int, right.Int)
+	case lexer.SLASH_SIGN:
+		if right.Int.Sign() == 0 {
+			return Value{}, diagnostics.Failf(diagnostics.DIVISION_BY_ZERO, "Division by zero")
+		}
+		result.Quo(left.Int, right.Int)
+	case lexer.CIRCUMFLEX:
+		if right.Int.Sign() < 0 {
+			return Value{}, diagnostics.Failf(diagnostics.NEGATIVE_EXPONENT, "Negative exponent %s for an integer power", right.Int)
+		}
+
+		limit := MAX_UNTYPED_BITS
+		if !left.Type.IsUntyped() {
+			limit = left.Type.Bits
+		}
+
+		if left.Int.CmpAbs(big.NewInt(1)) > 0 && int64(left.Int.BitLen()-1)*right.Int.Int64() > int64(limit) || !right.Int.IsInt64() {
+			return Value{}, diagnostics.Failf(diagnostics.OVERFLOW, "Overflow, %s ^ %s is too large", left.Int, right.Int)
+		}
+		result.Exp(left.Int, right.Int, nil)
+	case lexer.KEYWORD_AND:
+		result.And(left.Int, right.Int)
+	case lexer.KEYWORD_OR:
+		result.Or(left.Int, right.Int)
+	case lexer.KEYWORD_XOR:
+		result.Xor(left.Int, right.Int)
+	default:
+		return Value{}, diagnostics.Failf(diagnostics.INVALID_OPERAND, "%s cannot be applied to %s", operator, left.Type)
+	}
+
+	if left.Type.IsUntyped() && result.BitLen() > MAX_UNTYPED_BITS {
+		return Value{}, diagnostics.Failf(diagnostics.OVERFLOW, "Overflow, the result has more than %d bits", MAX_UNTYPED_BITS)
+	}
+
+	return checkInt(intValue(left.Type, result))
+}
+
+func floatBinary(operator lexer.TokenType, left Value, right Value) (Value, error) {
+	var result float64
+
+	switch operator {
+	case lexer.PLUS_SIGN:
+		result = left.Float + right.Float
+	case lexer.MINUS_SIGN:
+		result = left.Float - right.Float
+	case lexer.STAR_SIGN:
+		result = left.Float * right.Float
+	case lexer.SLASH_SIGN:
+		if right.Float == 0 {
+			return Value{}, diagnostics.Failf(diagnostics.DIVISION_BY_ZERO, "Division by zero")
+		}
+		result = left.Float / right.Float
+	case lexer.CIRCUMFLEX:
+		result = math.Pow(left.Float, right.Float)
+	default:
+		return Value{}, diagnostics.Failf(diagnostics.INVALID_OPERAND, "%s cannot be applied to %s", operator, left.Type)
+	}
+
+	return checkFloat(floatValue(left.Type, result))
+}
+
+// Shifts keep the type of their left operand, the amount can be any integer.
+// shr shifts in zeros, ashr copies the sign bit, cshl and cshr rotate and so
+// need a sized integer type.
+func shift(operator lexer.TokenType, left Value, right Value) (Value, error) {
+	if !left.Type.IsInteger() || !right.Type.IsInteger() {
+		return Value{}, diagnostics.Failf(diagnostics.INVALID_OPERAND, "%s cannot be applied to %s and %s", operator, left.Type, right.Type)
+	}
+
+	if right.Int.Sign() < 0 || !right.Int.IsInt64() || right.Int.Int64() > MAX_UNTYPED_BITS {
+		return Value{}, diagnostics.Failf(diagnostics.INVALID_SHIFT, "Invalid shift amount %s", right.Int)
+	}
+
+	amount := uint(right.Int.Int64())
+	typed := !left.Type.IsUntyped()
+
+	switch operator {
+	case lexer.KEYWORD_SHL:
+		return checkInt(intValue(left.Type, new(big.Int).Lsh(left.Int, amount)))
+	case lexer.KEYWORD_SHR:
+		if typed {
+			return patternValue(left.Type, new(big.Int).Rsh(bits(left), amount)), nil
+		}
+		if left.Int.Sign() < 0 {
+			return Value{}, diagnostics.Failf(diagnostics.INVALID_OPERAND, "shr of a negative untyped constant needs a sized integer type")
+		}
+		return intValue(left.Type, new(big.Int).Rsh(left.Int, amount)), nil
+	case lexer.KEYWORD_ASHR:
+		return intValue(left.Type, new(big.Int).Rsh(left.Int, amount)), nil
+	}
+
+	if !typed {
+		return Value{}, diagnostics.Failf(diagnostics.INVALID_OPERAND, "%s needs a sized integer type", operator)
+	}
+
+	width := uint(left.Type.Bits)
+	amount %= width
+	if operator == lexer.KEYWORD_CSHR {
+		amount = (width - amount) % width
+	}
+
+	pattern := bits(left)
+	rotated := new(big.Int).Lsh(pattern, amount)
+	rotated.Or(rotated, new(big.Int).Rsh(pattern, width-amount))
+	rotated.And(rotated, new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), width), big.NewInt(1)))
+
+	return patternValue(left.Type, rotated), nil
+}
@@ -0,0 +1,188 @@
+package consteval_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/consteval"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/parser"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/resolver"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/testutil"
+)
+
+type testStruct struct {
+	input  string
+	values []string
+	errors []string
+}
+
+func evaluate(t *testing.T, files ...string) ([]parser.Program, map[*parser.Constant]consteval.Value, []string) {
+	programs := testutil.Parse(t, files...)
+	front := testutil.Resolve(t, programs)
+	values, evalErrors := consteval.Run(programs, front.Resolution)
+
+	var msgs []string
+	for _, err := range evalErrors {
+		msgs = append(msgs, err.Msg)
+	}
+
+	return programs, values, msgs
+}
+
+// Values are listed as "name = value type" in declaration order of the
+// top-level constants that could be evaluated.
+func testHelper(t *testing.T, tests []testStruct) {
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			programs, values, errors := evaluate(t, test.input)
+
+			var actual []string
+			for _, constant := range programs[0].Scopes[0].Constants {
+				if value, ok := values[constant]; ok {
+					actual = append(actual, fmt.Sprintf("%s = %s %s", constant.Identifer.Name, value, value.Type))
+				}
+			}
+
+			if !reflect.DeepEqual(actual, test.values) || !reflect.DeepEqual(errors, test.errors) {
+				t.Errorf("\n%s\n%q\n%q\n%s\n%q\n%q", "---- EXPECTED ----", test.values, test.errors, "---- ACTUAL ----", actual, errors)
+			}
+		})
+	}
+}
+
+func TestIntegers(t *testing.T) {
+	testHelper(t, []testStruct{
+		{"const a = 1 + 2 * 3", []string{"a = 7 untyped int"}, nil},
+		{"const a = (1 + 2) * 3", []string{"a = 9 untyped int"}, nil},
+		{"const a = 7 / 2", []string{"a = 3 untyped int"}, nil},
+		{"const a = -7 / 2", []string{"a = -3 untyped int"}, nil},
+		{"const a = 2 ^ 3 ^ 2", []string{"a = 512 untyped int"}, nil},
+		{"const a = 0xFF and 0x0F", []string{"a = 15 untyped int"}, nil},
+		{"const a = 0b1010 xor 0b0110", []string{"a = 12 untyped int"}, nil},
+		{"const a: u8 = 255", []string{"a = 255 u8"}, nil},
+		{"const a: i8 = -128", []string{"a = -128 i8"}, nil},
+		{"const a: u64 = 18446744073709551615", []string{"a = 18446744073709551615 u64"}, nil},
+		{"const a: i32 = 3.0", []string{"a = 3 i32"}, nil},
+		{"const a: u8 = 200\nconst b = a + 55", []string{"a = 200 u8", "b = 255 u8"}, nil},
+		{"const a: u8 = 0x0F\nconst b = not a", []string{"a = 15 u8", "b = 240 u8"}, nil},
+		{"const a = 255u8", []string{"a = 255 u8"}, nil},
+		{"const a = 0x_FFu8 - 0b1_0000u8", []string{"a = 239 u8"}, nil},
+		{"const a = 1_000_000i64", []string{"a = 1000000 i64"}, nil},
+		{"const a = -128i8", []string{"a = -128 i8"}, nil},
+
+		// Overflow
+		{"const a: u8 = 256", nil, []string{"Overflow, 256 does not fit into u8"}},
+		{"const a: u8 = -1", nil, []string{"Overflow, -1 does not fit into u8"}},
+		{"const a: i8 = 128", nil, []string{"Overflow, 128 does not fit into i8"}},
+		{"const a: i64 = 0x8000000000000000", nil, []string{"Overflow, 9223372036854775808 does not fit into i64"}},
+		{"const a: u8 = 0x1FF", nil, []string{"Overflow, 511 does not fit into u8"}},
+		{"const a = 256u8", nil, []string{"Overflow, 256 does not fit into u8"}},
+		{"const a = 128i8", nil, []string{"Overflow, 128 does not fit into i8"}},
+		{"const a = -129i8", nil, []string{"Overflow, -129 does not fit into i8"}},
+		{"const a: u8 = 200\nconst b = a + 56", []string{"a = 200 u8"}, []string{"Overflow, 256 does not fit into u8"}},
+		{"const a: i16 = 2 ^ 15", nil, []string{"Overflow, 32768 does not fit into i16"}},
+		{"const a: i32 = 1.5", nil, []string{"Constant 1.5 is not an integer"}},
+
+		// Errors
+		{"const a = 1 / 0", nil, []string{"Division by zero"}},
+		{"const a = 2 ^ -1", nil, []string{"Negative exponent -1 for an integer power"}},
+		{"const a: u8 = 1\nconst b: i8 = 1\nconst c = a + b", []string{"a = 1 u8", "b = 1 i8"}, []string{"Mismatched types u8 and i8"}},
+	})
+}
+
+func TestShifts(t *testing.T) {
+	testHelper(t, []testStruct{
+		{"const a = 1 shl 10", []string{"a = 1024 untyped int"}, nil},
+		{"const a: i8 = -16\nconst b = a shr 2", []string{"a = -16 i8", "b = 60 i8"}, nil},
+		{"const a: i8 = -16\nconst b = a ashr 2", []string{"a = -16 i8", "b = -4 i8"}, nil},
+		{"const a: u8 = 0b10000001\nconst b = a cshl 1", []string{"a = 129 u8", "b = 3 u8"}, nil},
+		{"const a: u8 = 0b10000001\nconst b = a cshr 1", []string{"a = 129 u8", "b = 192 u8"}, nil},
+		{"const a: u8 = 1\nconst b = a cshl 9", []string{"a = 1 u8", "b = 2 u8"}, nil},
+		{"const a: u8 = 128\nconst b = a shl 1", []string{"a = 128 u8"}, []string{"Overflow, 256 does not fit into u8"}},
+		{"const a = 1 cshl 1", nil, []string{"Keyword 'cshl' needs a sized integer type"}},
+		{"const a = 1 shl -1", nil, []string{"Invalid shift amount -1"}},
+	})
+}
+
+func TestOtherTypes(t *testing.T) {
+	testHelper(t, []testStruct{
+		{"const a = 1.5 * 2", []string{"a = 3 untyped float"}, nil},
+		{"const a: f64 = 1 / 4.0", []string{"a = 0.25 f64"}, nil},
+		{"const a: f32 = 0.1", []string{"a = 0.10000000149011612 f32"}, nil},
+		{"const a: f32 = 1e39", nil, []string{"Overflow, the result does not fit into f32"}},
+		{"const a = 0.1f32", []string{"a = 0.10000000149011612 f32"}, nil},
+		{"const a = 2f64 / 4", []string{"a = 0.5 f64"}, nil},
+		{"const a = 1e39f32", nil, []string{"Overflow, 1e39f32 does not fit into f32"}},
+		{"const a = 1e999", nil, []string{"Overflow, 1e999 does not fit into untyped float"}},
+		{"const a: num = 2", []string{"a = 2 num"}, nil},
+		{"const a = 1.0 / 0", nil, []string{"Division by zero"}},
+		{"const a = 1 < 2 and not false", []string{"a = true bool"}, nil},
+		{"const a = true xor true", []string{"a = false bool"}, nil},
+		{"const a: bool = 1 == 1.0", []string{"a = true bool"}, nil},
+		{"const a = \"ab\" + \"c\"", []string{"a = \"abc\" bin"}, nil},
+		{"const a = \"a\\tb\"", []string{"a = \"a\\tb\" bin"}, nil},
+		{"const a: i8 = -5\nconst b = \"{a} {1e6} {true} {'ok}\"", []string{"a = -5 i8", "b = \"-5 1e+06 true ok\" bin"}, nil},
+		{"const a = \"ab\" < \"b\"", []string{"a = true bool"}, nil},
+		{"const a = nil ?? 2", []string{"a = 2 untyped int"}, nil},
+		{"const a = 1 ?? 1 / 0", []string{"a = 1 untyped int"}, nil},
+		{"const a: bool = 1", nil, []string{"Cannot use untyped int as bool"}},
+		{"const a = true + 1", nil, []string{"Cannot use untyped int as bool"}},
+		{"const a = -true", nil, []string{"Minus sign cannot be applied to bool"}},
+	})
+}
+
+func TestReferences(t *testing.T) {
+	testHelper(t, []testStruct{
+		{"const a = b * 2\nconst b = 3", []string{"a = 6 untyped int", "b = 3 untyped int"}, nil},
+		{"const a = c\nconst b: u16 = 300\nconst c = b", []string{"a = 300 u16", "b = 300 u16", "c = 300 u16"}, nil},
+		{"const a = b\nconst b = a", nil, []string{"Constant a depends on itself: a -> b -> a"}},
+		{"const a = a + 1", nil, []string{"Constant a depends on itself: a -> a"}},
+		{"const a = b\nconst b = c\nconst c = b", nil, []string{"Constant b depends on itself: b -> c -> b"}},
+		{"const a = b", nil, []string{"Unknown constant b"}},
+	})
+}
+
+func TestAcrossFiles(t *testing.T) {
+	programs, values, errors := evaluate(t,
+		"namespace a\npub const x: u8 = b::y + 1",
+		"namespace b\npub const y = 41\nconst z = a::x * 2",
+	)
+
+	if errors != nil {
+		t.Fatalf("unexpected errors: %q", errors)
+	}
+
+	x := programs[0].Scopes[1].Constants[0]
+	z := programs[1].Scopes[1].Constants[1]
+	if values[x].String() != "42" || values[z].String() != "84" || values[z].Type != values[x].Type {
+		t.Errorf("expected 42 and 84 but got %s and %s", values[x], values[z])
+	}
+
+	_, _, errors = evaluate(t,
+		"namespace a\npub const x = b::y",
+		"namespace b\npub const y = a::x",
+	)
+
+	if !reflect.DeepEqual(errors, []string{"Constant x depends on itself: x -> y -> x"}) {
+		t.Errorf("expected a cyclic dependency but got %q", errors)
+	}
+}
+
+// Paths that do not name a constant are reported by the resolver and the
+// analyzer, not again while folding.
+func TestUnresolvedPaths(t *testing.T) {
+	for _, input := range []string{
+		"namespace a\nconst x = b::k",
+		"namespace a\npub fn f() {}\nnamespace b\nconst x = a::f",
+	} {
+		t.Run(input, func(t *testing.T) {
+			programs := testutil.Parse(t, input)
+
+			resolution, _ := resolver.Run(programs)
+			if _, errors := consteval.Run(programs, resolution); len(errors) > 0 {
+				t.Errorf("unexpected errors: %s", errors)
+			}
+		})
+	}
+}
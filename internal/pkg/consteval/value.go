@@ -0,0 +1,220 @@
+package consteval
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/lexer"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/parser"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/types"
+)
+
+// Value is the result of evaluating a constant. Which field holds the value
+// depends on the type: integers use Int, floats use Float, bool uses Bool,
+// bin uses Bytes and sym uses Sym.
+type Value struct {
+	Type  *types.Basic
+	Int   *big.Int
+	Float float64
+	Bool  bool
+	Bytes string
+	Sym   string
+}
+
+func (value Value) String() string {
+	switch {
+	case value.Type.IsInteger():
+		return value.Int.String()
+	case value.Type.IsFloat():
+		return strconv.FormatFloat(value.Float, 'g', -1, 64)
+	}
+
+	switch value.Type.Kind {
+	case types.KIND_BOOL:
+		return strconv.FormatBool(value.Bool)
+	case types.KIND_BIN:
+		return strconv.Quote(value.Bytes)
+	case types.KIND_SYM:
+		return "'" + value.Sym
+	}
+
+	return "nil"
+}
+
+// Format returns the text a value is interpolated as. Byte strings are
+// inserted as they are and symbols by their name, f32s show the fewest
+// digits that read back as the same f32.
+func Format(value Value) string {
+	switch {
+	case value.Type.Kind == types.KIND_F32:
+		return strconv.FormatFloat(value.Float, 'g', -1, 32)
+	case value.Type.Kind == types.KIND_BIN:
+		return value.Bytes
+	case value.Type.Kind == types.KIND_SYM:
+		return value.Sym
+	}
+
+	return value.String()
+}
+
+func intValue(basic *types.Basic, value *big.Int) Value {
+	return Value{Type: basic, Int: value}
+}
+
+func floatValue(basic *types.Basic, value float64) Value {
+	if basic.Kind == types.KIND_F32 {
+		value = float64(float32(value))
+	}
+
+	return Value{Type: basic, Float: value}
+}
+
+func boolValue(value bool) Value {
+	return Value{Type: types.Bool, Bool: value}
+}
+
+// Literal returns the value of a literal. Numbers are untyped unless they
+// have a suffix, in which case they have to fit into its type.
+func Literal(literal *parser.Literal) (Value, error) {
+	switch literal.Kind {
+	case lexer.KEYWORD_TRUE:
+		return boolValue(true), nil
+	case lexer.KEYWORD_FALSE:
+		return boolValue(false), nil
+	case lexer.KEYWORD_NIL:
+		return Value{Type: types.Nil}, nil
+	case lexer.STRING_LITERAL, lexer.BYTE_STRING_LITERAL:
+		return Value{Type: types.Bin, Bytes: literal.Value}, nil
+	case lexer.SYM_LITERAL:
+		return Value{Type: types.Sym, Sym: literal.Value}, nil
+	case lexer.BIN_NUM_LITERAL, lexer.OCT_NUM_LITERAL, lexer.DEC_NUM_LITERAL, lexer.HEX_NUM_LITERAL, lexer.NORMAL_NUM_LITERAL:
+		value, err := number(literal)
+		if err != nil || value.Int == nil {
+			return value, err
+		}
+		return checkInt(value)
+	}
+
+	return Value{}, diagnostics.Failf(diagnostics.NOT_CONSTANT, "%s cannot be evaluated at compile time", literal.Kind)
+}
+
+// NegatedLiteral returns the number literal with a suffix that a unary minus
+// is applied to, or nil if it is applied to something else.
+func NegatedLiteral(expression *parser.Unary) *parser.Literal {
+	literal, ok := expression.Operand.(*parser.Literal)
+	if !ok || literal.Suffix == "" || expression.Operator != lexer.MINUS_SIGN {
+		return nil
+	}
+
+	return literal
+}
+
+// NegativeLiteral returns the value of a negated number literal, which only
+// has to fit into its suffix after the negation, like -128i8.
+func NegativeLiteral(literal *parser.Literal) (Value, error) {
+	value, err := number(literal)
+	if err != nil {
+		return Value{}, err
+	}
+
+	return Unary(lexer.MINUS_SIGN, value)
+}
+
+// number returns the value of a number literal without checking that it fits
+// into its suffix.
+func number(literal *parser.Literal) (Value, error) {
+	switch {
+	case literal.Int != nil:
+		target := types.UntypedInt
+		if literal.Suffix != "" {
+			target = types.LookupBasic(literal.Suffix)
+		}
+		return intValue(target, new(big.Int).Set(literal.Int)), nil
+	case literal.Float != nil:
+		target := types.UntypedFloat
+		if literal.Suffix != "" {
+			target = types.LookupBasic(literal.Suffix)
+		}
+
+		// Rounding from the parsed value keeps f32 literals from being
+		// rounded twice
+		value, _ := literal.Float.Float64()
+		if target.Kind == types.KIND_F32 {
+			value32, _ := literal.Float.Float32()
+			value = float64(value32)
+		}
+
+		if math.IsInf(value, 0) {
+			return Value{}, diagnostics.Failf(diagnostics.OVERFLOW, "Overflow, %s does not fit into %s", literal.Value, target)
+		}
+		return floatValue(target, value), nil
+	}
+
+	return Value{}, diagnostics.Failf(diagnostics.INVALID_NUMBER, "Invalid number literal %s", literal.Value)
+}
+
+// Convert converts a value to the given type. Untyped numbers take any
+// numeric type they can be represented by, typed values keep their type.
+func Convert(value Value, target *types.Basic) (Value, error) {
+	if value.Type == target {
+		return value, nil
+	}
+
+	if !value.Type.IsUntyped() || !target.IsNumeric() {
+		return Value{}, diagnostics.Failf(diagnostics.TYPE_MISMATCH, "Cannot use %s as %s", value.Type, target)
+	}
+
+	switch {
+	case target.IsInteger() && value.Type.Kind == types.KIND_UNTYPED_INT:
+		if !target.Fits(value.Int) {
+			return Value{}, diagnostics.Failf(diagnostics.OVERFLOW, "Overflow, %s does not fit into %s", value.Int, target)
+		}
+		return intValue(target, value.Int), nil
+	case target.IsInteger():
+		if value.Float != math.Trunc(value.Float) || math.IsInf(value.Float, 0) {
+			return Value{}, diagnostics.Failf(diagnostics.TYPE_MISMATCH, "Constant %s is not an integer", value)
+		}
+
+		integer, _ := big.NewFloat(value.Float).Int(nil)
+		return Convert(intValue(types.UntypedInt, integer), target)
+	case value.Type.Kind == types.KIND_UNTYPED_INT:
+		float, _ := new(big.Float).SetInt(value.Int).Float64()
+		return checkFloat(floatValue(target, float))
+	default:
+		return checkFloat(floatValue(target, value.Float))
+	}
+}
+
+func checkFloat(value Value) (Value, error) {
+	if math.IsInf(value.Float, 0) {
+		return Value{}, diagnostics.Failf(diagnostics.OVERFLOW, "Overflow, the result does not fit into %s", value.Type)
+	}
+
+	return value, nil
+}
+
+func checkInt(value Value) (Value, error) {
+	if !value.Type.IsUntyped() && !value.Type.Fits(value.Int) {
+		return Value{}, diagnostics.Failf(diagnostics.OVERFLOW, "Overflow, %s does not fit into %s", value.Int, value.Type)
+	}
+
+	return value, nil
+}
+
+// bits returns the two's complement bit pattern of a sized integer as an
+// unsigned number and patternValue converts it back.
+func bits(value Value) *big.Int {
+	mask := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(value.Type.Bits)), big.NewInt(1))
+	return new(big.Int).And(value.Int, mask)
+}
+
+func patternValue(basic *types.Basic, bits *big.Int) Value {
+	value := new(big.Int).Set(bits)
+	if basic.IsSigned() && value.Bit(basic.Bits-1) == 1 {
+		value.Sub(value, new(big.Int).Lsh(big.NewInt(1), uint(basic.Bits)))
+	}
+
+	return intValue(basic, value)
+}
@@ -0,0 +1,154 @@
+package diagnostics
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Codes tell which kind of error a diagnostic reports, the hundreds group
+// them by the part of the compiler that usually finds them. A kind keeps its
+// code no matter which pass reports it, an overflow is E0401 whether the
+// constant evaluator or the interpreter runs into it.
+const (
+	// Errors of the lexer, they are reported by the parser as it is the one
+	// that stumbles over them
+	UNKNOWN_CHARACTER  = "E0001"
+	UNCLOSED_COMMENT   = "E0002"
+	INVALID_STRING     = "E0003"
+	INVALID_SYM        = "E0004"
+	INVALID_IDENTIFIER = "E0005"
+	INVALID_NUMBER     = "E0006"
+
+	SYNTAX_ERROR = "E0100"
+
+	// Names and paths
+	UNKNOWN_NAME     = "E0200"
+	AMBIGUOUS_NAME   = "E0201"
+	PRIVATE_NAME     = "E0202"
+	NOT_A_NAMESPACE  = "E0203"
+	DUPLICATE_IMPORT = "E0204"
+
+	// Checks that need no types
+	IMMUTABLE_ASSIGNMENT = "E0300"
+	INVALID_ASSIGNMENT   = "E0301"
+	NOT_CONSTANT         = "E0302"
+	UNREACHABLE_BRANCH   = "E0303"
+	NON_EXHAUSTIVE_CASE  = "E0304"
+
+	// Arithmetic, at compile time and at run time
+	CYCLIC_CONSTANT   = "E0400"
+	OVERFLOW          = "E0401"
+	DIVISION_BY_ZERO  = "E0402"
+	INVALID_SHIFT     = "E0403"
+	NEGATIVE_EXPONENT = "E0404"
+
+	// Types
+	DUPLICATE_DECLARATION = "E0500"
+	TYPE_MISMATCH         = "E0501"
+	INVALID_TYPE          = "E0502"
+	NOT_A_VALUE           = "E0503"
+	INVALID_OPERAND       = "E0504"
+	UNKNOWN_FIELD         = "E0505"
+	MISSING_FIELD         = "E0506"
+	UNKNOWN_METHOD        = "E0507"
+	INVALID_METHOD_CALL   = "E0508"
+	INVALID_CALL          = "E0509"
+	MISSING_METHOD        = "E0510"
+	METHOD_MISMATCH       = "E0511"
+	INVALID_RETURN        = "E0512"
+	UNINFERRED_TYPE       = "E0513"
+
+	// Errors of running a program
+	INDEX_OUT_OF_RANGE = "E0600"
+	STACK_OVERFLOW     = "E0601"
+	UNINITIALISED      = "E0602"
+	INVALID_PROGRAM    = "E0603"
+
+	// Programs a backend cannot generate
+	UNSUPPORTED      = "E0700"
+	TOO_LARGE        = "E0701"
+	CAPTURED_BINDING = "E0702"
+	RECURSIVE_STRUCT = "E0703"
+)
+
+// labels are the messages of the primary labels of each kind, passes that
+// know more about an error replace them.
+var labels = map[string]string{
+	UNKNOWN_CHARACTER:  "unknown character",
+	UNCLOSED_COMMENT:   "comment is never closed",
+	INVALID_STRING:     "invalid string",
+	INVALID_SYM:        "invalid sym",
+	INVALID_IDENTIFIER: "invalid identifier",
+	INVALID_NUMBER:     "invalid number",
+
+	SYNTAX_ERROR: "unexpected here",
+
+	UNKNOWN_NAME:     "not found",
+	AMBIGUOUS_NAME:   "ambiguous",
+	PRIVATE_NAME:     "private",
+	NOT_A_NAMESPACE:  "not a namespace",
+	DUPLICATE_IMPORT: "imported again",
+
+	IMMUTABLE_ASSIGNMENT: "cannot be assigned",
+	INVALID_ASSIGNMENT:   "cannot be assigned",
+	NOT_CONSTANT:         "not known at compile time",
+	UNREACHABLE_BRANCH:   "never matches",
+	NON_EXHAUSTIVE_CASE:  "not every value is matched",
+
+	CYCLIC_CONSTANT:   "depends on itself",
+	OVERFLOW:          "overflows",
+	DIVISION_BY_ZERO:  "divides by zero",
+	INVALID_SHIFT:     "invalid shift amount",
+	NEGATIVE_EXPONENT: "negative exponent",
+
+	DUPLICATE_DECLARATION: "declared again",
+	TYPE_MISMATCH:         "wrong type",
+	INVALID_TYPE:          "wrong kind of type",
+	NOT_A_VALUE:           "not a value",
+	INVALID_OPERAND:       "invalid operand",
+	UNKNOWN_FIELD:         "unknown field",
+	MISSING_FIELD:         "missing field",
+	UNKNOWN_METHOD:        "unknown method",
+	INVALID_METHOD_CALL:   "invalid method call",
+	INVALID_CALL:          "invalid call",
+	MISSING_METHOD:        "missing method",
+	METHOD_MISMATCH:       "does not match the trait",
+	INVALID_RETURN:        "invalid return",
+	UNINFERRED_TYPE:       "type cannot be inferred",
+
+	INDEX_OUT_OF_RANGE: "out of range",
+	STACK_OVERFLOW:     "too many nested calls",
+	UNINITIALISED:      "not initialised yet",
+	INVALID_PROGRAM:    "cannot be run",
+
+	UNSUPPORTED:      "not supported",
+	TOO_LARGE:        "too large",
+	CAPTURED_BINDING: "used by a nested function",
+	RECURSIVE_STRUCT: "contains itself",
+}
+
+// Failure is an error that knows its kind but not where it happened, like
+// the errors of evaluating an operator. The pass that runs into it reports
+// it at the position of its expression with the same code.
+type Failure struct {
+	Code string
+	Msg  string
+}
+
+func (failure Failure) Error() string {
+	return failure.Msg
+}
+
+func Failf(code string, format string, a ...any) error {
+	return Failure{code, fmt.Sprintf(format, a...)}
+}
+
+// CodeOf returns the code of a failure, other errors have none.
+func CodeOf(err error) string {
+	var failure Failure
+	if errors.As(err, &failure) {
+		return failure.Code
+	}
+
+	return ""
+}
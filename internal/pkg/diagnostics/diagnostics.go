@@ -0,0 +1,269 @@
+package diagnostics
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
+)
+
+type Severity int
+
+const (
+	ERROR Severity = iota
+	WARNING
+	NOTE
+)
+
+func (severity Severity) String() string {
+	switch severity {
+	case WARNING:
+		return "warning"
+	case NOTE:
+		return "note"
+	}
+
+	return "error"
+}
+
+// Label marks a span of the source, Msg may be empty.
+type Label struct {
+	Span util.Span
+	Msg  string
+}
+
+// Diagnostic is a message about the source. Primary is the span it is about,
+// Labels point to other spans that explain it, like earlier declarations.
+type Diagnostic struct {
+	Severity Severity
+	Code     string
+	Msg      string
+	Primary  Label
+	Labels   []Label
+	Notes    []string
+	Help     []string
+}
+
+// New returns a diagnostic about a span, its primary label tells what kind
+// of error the code stands for.
+func New(severity Severity, code string, span util.Span, msg string) Diagnostic {
+	return Diagnostic{
+		Severity: severity,
+		Code:     code,
+		Msg:      msg,
+		Primary:  Label{Span: span, Msg: labels[code]},
+	}
+}
+
+func (diagnostic Diagnostic) Error() string {
+	if diagnostic.Primary.Span.Start.File == nil {
+		return diagnostic.Msg
+	}
+
+	return fmt.Sprintf("%s: %s", diagnostic.Primary.Span, diagnostic.Msg)
+}
+
+// Errorf returns an error about the token at pos, the passes report their
+// errors with it.
+func Errorf(pos util.Position, code string, format string, a ...any) Diagnostic {
+	return New(ERROR, code, pos.Span(), fmt.Sprintf(format, a...))
+}
+
+// Labelf replaces the message of the primary label, for errors that know
+// more about their span than their code tells.
+func (diagnostic *Diagnostic) Labelf(format string, a ...any) *Diagnostic {
+	diagnostic.Primary.Msg = fmt.Sprintf(format, a...)
+	return diagnostic
+}
+
+// At adds a label to the token at pos.
+func (diagnostic *Diagnostic) At(pos util.Position, msg string) *Diagnostic {
+	diagnostic.Labels = append(diagnostic.Labels, Label{Span: pos.Span(), Msg: msg})
+	return diagnostic
+}
+
+func (diagnostic *Diagnostic) Notef(format string, a ...any) *Diagnostic {
+	diagnostic.Notes = append(diagnostic.Notes, fmt.Sprintf(format, a...))
+	return diagnostic
+}
+
+func (diagnostic *Diagnostic) Helpf(format string, a ...any) *Diagnostic {
+	diagnostic.Help = append(diagnostic.Help, fmt.Sprintf(format, a...))
+	return diagnostic
+}
+
+/* Rendering */
+
+// Style is the part of a rendered diagnostic a text belongs to.
+type Style int
+
+const (
+	ERROR_STYLE Style = iota
+	WARNING_STYLE
+	NOTE_STYLE
+	HELP_STYLE
+	GUTTER_STYLE
+	MESSAGE_STYLE
+)
+
+// Painter colors a text of the given style, the cli does so with escape
+// sequences of the terminal.
+type Painter func(style Style, text string) string
+
+// Plain leaves every text as it is.
+func Plain(style Style, text string) string {
+	return text
+}
+
+var severityStyles = map[Severity]Style{
+	ERROR:   ERROR_STYLE,
+	WARNING: WARNING_STYLE,
+	NOTE:    NOTE_STYLE,
+}
+
+// tabWidth is the number of spaces tabs of the source are shown with, so that
+// underlines line up no matter how wide the terminal shows tabs.
+const tabWidth = 4
+
+// Render writes a diagnostic like rustc does: the message is followed by the
+// source lines of its labels with the primary span underlined with ^ and the
+// other spans with -, then come the notes and help.
+//
+//	error[E0501]: Expected u8 but found bin
+//	 --> main.ql:2:14
+//	  |
+//	2 |     let a: u8 = "x"
+//	  |                 ^^^ has type bin
+func Render(diagnostic Diagnostic, paint Painter) string {
+	style := severityStyles[diagnostic.Severity]
+
+	var sb strings.Builder
+
+	header := diagnostic.Severity.String()
+	if diagnostic.Code != "" {
+		header += "[" + diagnostic.Code + "]"
+	}
+	sb.WriteString(paint(style, header) + paint(MESSAGE_STYLE, ": "+diagnostic.Msg) + "\n")
+
+	var labels []Label
+	for _, label := range append([]Label{diagnostic.Primary}, diagnostic.Labels...) {
+		if label.Span.Start.File != nil {
+			labels = append(labels, label)
+		}
+	}
+
+	width := 0
+	for _, label := range labels {
+		width = max(width, len(fmt.Sprint(label.Span.Start.Row+1)))
+	}
+	gutter := strings.Repeat(" ", width)
+
+	// The labels are shown grouped by their file, the file of the primary
+	// label comes first
+	var files []*util.File
+	for _, label := range labels {
+		if !slices.Contains(files, label.Span.Start.File) {
+			files = append(files, label.Span.Start.File)
+		}
+	}
+
+	for idx, file := range files {
+		var fileLabels []Label
+		for _, label := range labels {
+			if label.Span.Start.File == file {
+				fileLabels = append(fileLabels, label)
+			}
+		}
+
+		arrow := "-->"
+		if idx > 0 {
+			arrow = ":::"
+		}
+		sb.WriteString(gutter + paint(GUTTER_STYLE, arrow) + " " + fileLabels[0].Span.String() + "\n")
+		sb.WriteString(gutter + " " + paint(GUTTER_STYLE, "|") + "\n")
+
+		var rows []int
+		for _, label := range fileLabels {
+			if !slices.Contains(rows, label.Span.Start.Row) {
+				rows = append(rows, label.Span.Start.Row)
+			}
+		}
+		slices.Sort(rows)
+
+		for rowIdx, row := range rows {
+			if rowIdx > 0 && row > rows[rowIdx-1]+1 {
+				sb.WriteString(gutter + paint(GUTTER_STYLE, "...") + "\n")
+			}
+
+			line := []rune(file.Line(row))
+			number := fmt.Sprintf("%*d |", width, row+1)
+			sb.WriteString(paint(GUTTER_STYLE, number) + " " + expandTabs(line) + "\n")
+
+			// The labels of a row are underlined from left to right
+			var rowLabels []int
+			for labelIdx, label := range fileLabels {
+				if label.Span.Start.Row == row {
+					rowLabels = append(rowLabels, labelIdx)
+				}
+			}
+			slices.SortStableFunc(rowLabels, func(a int, b int) int {
+				return fileLabels[a].Span.Start.Col - fileLabels[b].Span.Start.Col
+			})
+
+			for _, labelIdx := range rowLabels {
+				label := fileLabels[labelIdx]
+
+				start := min(label.Span.Start.Col, len(line))
+				end := len(line)
+				if label.Span.End.Row == row {
+					end = min(label.Span.End.Col, len(line))
+				}
+
+				mark, markStyle := "-", GUTTER_STYLE
+				if labelIdx == 0 && file == diagnostic.Primary.Span.Start.File {
+					mark, markStyle = "^", style
+				}
+
+				underline := strings.Repeat(mark, max(1, displayWidth(line[start:end])))
+				if label.Msg != "" {
+					underline += " " + label.Msg
+				}
+
+				indent := strings.Repeat(" ", displayWidth(line[:start]))
+				sb.WriteString(gutter + " " + paint(GUTTER_STYLE, "|") + " " + indent + paint(markStyle, underline) + "\n")
+			}
+		}
+	}
+
+	if len(files) > 0 && len(diagnostic.Notes)+len(diagnostic.Help) > 0 {
+		sb.WriteString(gutter + " " + paint(GUTTER_STYLE, "|") + "\n")
+	}
+
+	for _, note := range diagnostic.Notes {
+		sb.WriteString(gutter + " " + paint(GUTTER_STYLE, "=") + " " + paint(MESSAGE_STYLE, "note") + ": " + note + "\n")
+	}
+
+	for _, help := range diagnostic.Help {
+		sb.WriteString(gutter + " " + paint(GUTTER_STYLE, "=") + " " + paint(HELP_STYLE, "help") + ": " + help + "\n")
+	}
+
+	return sb.String()
+}
+
+func displayWidth(runes []rune) int {
+	width := 0
+	for _, ch := range runes {
+		if ch == '\t' {
+			width += tabWidth
+		} else {
+			width++
+		}
+	}
+
+	return width
+}
+
+func expandTabs(runes []rune) string {
+	return strings.ReplaceAll(string(runes), "\t", strings.Repeat(" ", tabWidth))
+}
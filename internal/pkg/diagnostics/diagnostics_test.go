@@ -0,0 +1,151 @@
+package diagnostics_test
+
+import (
+	"testing"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/lexer"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
+)
+
+// spanOf returns the span of the first token of a file with the given literal.
+func spanOf(t *testing.T, file *util.File, literal string) util.Span {
+	for _, token := range lexer.Run(file) {
+		if token.Literal == literal {
+			return token.Pos.Span()
+		}
+	}
+
+	t.Fatalf("%s has no token %q", file.Name, literal)
+	return util.Span{}
+}
+
+func TestRender(t *testing.T) {
+	set := util.NewFileSet()
+	main := set.AddFile("main.ql", "fn main() -> u8 {\n\tlet a: u8 = \"x\"\n\n\n\n\n\n\n\n\ta + größe\n}")
+	other := set.AddFile("other.ql", "fn größe() -> u8 { 1 }\r\nconst s = \"a\r\nb\"")
+	sum := set.AddFile("sum.ql", "a + größe")
+
+	tests := []struct {
+		name       string
+		diagnostic diagnostics.Diagnostic
+		want       string
+	}{
+		{
+			"primary",
+			diagnostics.New(diagnostics.ERROR, diagnostics.TYPE_MISMATCH, spanOf(t, main, `"x"`), "Expected u8 but found bin"),
+			"error[E0501]: Expected u8 but found bin\n" +
+				" --> main.ql:2:14\n" +
+				"  |\n" +
+				"2 |     let a: u8 = \"x\"\n" +
+				"  |                 ^^^ wrong type\n",
+		},
+		{
+			"labels",
+			diagnostics.Diagnostic{
+				Severity: diagnostics.WARNING,
+				Msg:      "Unused variable a",
+				Primary:  diagnostics.Label{Span: spanOf(t, main, "a"), Msg: "never used"},
+				Labels: []diagnostics.Label{
+					{Span: spanOf(t, main, "größe"), Msg: "used here"},
+					{Span: spanOf(t, main, "main")},
+					{Span: spanOf(t, other, "größe"), Msg: "declared here"},
+				},
+				Notes: []string{"Variables are immutable"},
+				Help:  []string{"Remove it", "Rename it to _"},
+			},
+			"warning: Unused variable a\n" +
+				"  --> main.ql:2:6\n" +
+				"   |\n" +
+				" 1 | fn main() -> u8 {\n" +
+				"   |    ----\n" +
+				" 2 |     let a: u8 = \"x\"\n" +
+				"   |         ^ never used\n" +
+				"  ...\n" +
+				"10 |     a + größe\n" +
+				"   |         ----- used here\n" +
+				"  ::: other.ql:1:4\n" +
+				"   |\n" +
+				" 1 | fn größe() -> u8 { 1 }\n" +
+				"   |    ----- declared here\n" +
+				"   |\n" +
+				"   = note: Variables are immutable\n" +
+				"   = help: Remove it\n" +
+				"   = help: Rename it to _\n",
+		},
+		{
+			"same row",
+			diagnostics.Diagnostic{
+				Severity: diagnostics.ERROR,
+				Code:     diagnostics.TYPE_MISMATCH,
+				Msg:      "Mismatched types u8 and fn",
+				Primary:  diagnostics.Label{Span: spanOf(t, sum, "+"), Msg: "operands of different types"},
+				Labels: []diagnostics.Label{
+					{Span: spanOf(t, sum, "größe"), Msg: "has type fn"},
+					{Span: spanOf(t, sum, "a"), Msg: "has type u8"},
+				},
+			},
+			"error[E0501]: Mismatched types u8 and fn\n" +
+				" --> sum.ql:1:3\n" +
+				"  |\n" +
+				"1 | a + größe\n" +
+				"  | - has type u8\n" +
+				"  |   ^ operands of different types\n" +
+				"  |     ----- has type fn\n",
+		},
+		{
+			"multiple lines",
+			diagnostics.New(diagnostics.NOTE, "", spanOf(t, other, "\"a\r\nb\""), "Strings can span lines"),
+			"note: Strings can span lines\n" +
+				" --> other.ql:2:11\n" +
+				"  |\n" +
+				"2 | const s = \"a\n" +
+				"  |           ^^\n",
+		},
+		{
+			"end of file",
+			diagnostics.New(diagnostics.ERROR, diagnostics.SYNTAX_ERROR, util.Position{File: main, Row: 10, Col: 1}.Span(), "Expected a declaration"),
+			"error[E0100]: Expected a declaration\n" +
+				"  --> main.ql:11:2\n" +
+				"   |\n" +
+				"11 | }\n" +
+				"   |  ^ unexpected here\n",
+		},
+		{
+			"without file",
+			diagnostics.Diagnostic{Severity: diagnostics.ERROR, Code: diagnostics.TOO_LARGE, Msg: "Function f is too large", Notes: []string{"Split it up"}},
+			"error[E0701]: Function f is too large\n" +
+				" = note: Split it up\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := diagnostics.Render(test.diagnostic, diagnostics.Plain); got != test.want {
+				t.Errorf("\n%s\n%s\n%s\n%s", "---- EXPECTED ----", test.want, "---- ACTUAL ----", got)
+			}
+		})
+	}
+}
+
+func TestPainter(t *testing.T) {
+	file := util.NewFileSet().AddFile("main.ql", "1 + a")
+	diagnostic := diagnostics.New(diagnostics.ERROR, diagnostics.UNKNOWN_NAME, spanOf(t, file, "a"), "Unknown a")
+
+	paint := func(style diagnostics.Style, text string) string {
+		if style == diagnostics.ERROR_STYLE {
+			return "<" + text + ">"
+		}
+		return text
+	}
+
+	want := "<error[E0200]>: Unknown a\n" +
+		" --> main.ql:1:5\n" +
+		"  |\n" +
+		"1 | 1 + a\n" +
+		"  |     <^ not found>\n"
+
+	if got := diagnostics.Render(diagnostic, paint); got != want {
+		t.Errorf("\n%s\n%s\n%s\n%s", "---- EXPECTED ----", want, "---- ACTUAL ----", got)
+	}
+}
@@ -0,0 +1,369 @@
+package interpreter
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/consteval"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/lexer"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/parser"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/types"
+)
+
+func (in *Interpreter) eval(expression parser.Expression, env *environment) (Value, error) {
+	switch expression := expression.(type) {
+	case *parser.Literal:
+		value, err := consteval.Literal(expression)
+		if err != nil {
+			return nil, diagnostics.Errorf(expression.Pos, diagnostics.CodeOf(err), "%s", err)
+		}
+		return coerce(value, in.info.Types[expression]), nil
+	case *parser.Grouping:
+		return in.eval(expression.Expression, env)
+	case *parser.Interpolation:
+		return in.interpolate(expression, env)
+	case *parser.IdentifierExpression:
+		return in.load(expression, expression.Identifer.Name, env)
+	case *parser.PathExpression:
+		return in.load(expression, expression.Identifer.Name, env)
+	case *parser.SelfExpression:
+		value, _ := env.lookup("self")
+		return value, nil
+	case *parser.StructLiteral:
+		structure := &Struct{Type: in.info.Defs[expression.Type].(*types.Struct), Fields: map[string]Value{}}
+		for _, field := range expression.Fields {
+			value, err := in.eval(field.Expression, env)
+			if err != nil {
+				return nil, err
+			}
+			structure.Fields[field.Identifer.Name] = copyValue(coerce(value, structure.Type.Field(field.Identifer.Name).Type))
+		}
+		return structure, nil
+	case *parser.FieldAccess:
+		target, err := in.eval(expression.Target, env)
+		if err != nil {
+			return nil, err
+		}
+		return target.(*Struct).Fields[expression.Identifer.Name], nil
+	case *parser.Call:
+		return in.evalCall(expression, env)
+	case *parser.Index:
+		target, err := in.eval(expression.Target, env)
+		if err != nil {
+			return nil, err
+		}
+
+		idx, err := in.index(expression, target, env)
+		if err != nil {
+			return nil, err
+		}
+		return consteval.Value{Type: types.U8, Int: big.NewInt(int64(target.(consteval.Value).Bytes[idx]))}, nil
+	case *parser.Unary:
+		if literal := consteval.NegatedLiteral(expression); literal != nil {
+			value, err := consteval.NegativeLiteral(literal)
+			if err != nil {
+				return nil, diagnostics.Errorf(expression.Pos, diagnostics.CodeOf(err), "%s", err)
+			}
+			return value, nil
+		}
+
+		operand, err := in.eval(expression.Operand, env)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := consteval.Unary(expression.Operator, operand.(consteval.Value))
+		if err != nil {
+			return nil, diagnostics.Errorf(expression.Pos, diagnostics.CodeOf(err), "%s", err)
+		}
+		return value, nil
+	case *parser.Binary:
+		return in.evalBinary(expression, env)
+	case *parser.If:
+		condition, err := in.eval(expression.Condition, env)
+		if err != nil {
+			return nil, err
+		}
+
+		if condition.(consteval.Value).Bool {
+			return in.branch(expression.Then, expression, env)
+		} else if expression.Else != nil {
+			return in.branch(expression.Else, expression, env)
+		}
+		return nil, nil
+	case *parser.Cond:
+		for _, branch := range expression.Branches {
+			condition, err := in.eval(branch.Condition, env)
+			if err != nil {
+				return nil, err
+			}
+
+			if condition.(consteval.Value).Bool {
+				return in.branch(branch.Body, expression, env)
+			}
+		}
+
+		if expression.Else != nil {
+			return in.branch(expression.Else, expression, env)
+		}
+		return nil, nil
+	case *parser.Case:
+		return in.evalCase(expression, env)
+	}
+
+	return nil, diagnostics.Errorf(parser.ExpressionPos(expression), diagnostics.INVALID_PROGRAM, "Expression cannot be evaluated")
+}
+
+// interpolate concatenates the parts of an interpolation with the text of
+// its expressions.
+func (in *Interpreter) interpolate(expression *parser.Interpolation, env *environment) (Value, error) {
+	var sb strings.Builder
+	for idx, part := range expression.Parts {
+		sb.WriteString(part.Value)
+		if idx == len(expression.Expressions) {
+			break
+		}
+
+		embedded := expression.Expressions[idx]
+		value, err := in.eval(embedded, env)
+		if err != nil {
+			return nil, err
+		}
+		sb.WriteString(consteval.Format(coerce(value, in.info.Types[embedded]).(consteval.Value)))
+	}
+
+	return consteval.Value{Type: types.Bin, Bytes: sb.String()}, nil
+}
+
+// branch runs the body of a branch and converts its value to the type of
+// the whole expression.
+func (in *Interpreter) branch(scope *parser.Scope, expression parser.Expression, env *environment) (Value, error) {
+	value, err := in.execScope(scope, env)
+	if err != nil || value == nil {
+		return value, err
+	}
+
+	if in.info.Types[expression] == types.Void {
+		return nil, nil
+	}
+
+	return coerce(value, in.info.Types[expression]), nil
+}
+
+// load reads a constant, function or binding. Untyped constants take the
+// type the type checker gave their use.
+func (in *Interpreter) load(expression parser.Expression, name string, env *environment) (Value, error) {
+	switch node := in.info.Uses[expression].(type) {
+	case *parser.Constant:
+		return coerce(in.constants[node], in.info.Types[expression]), nil
+	case *parser.Function:
+		return &Function{Decl: node, Env: in.functions[node]}, nil
+	}
+
+	value, ok := env.lookup(name)
+	if !ok {
+		return nil, diagnostics.Errorf(parser.ExpressionPos(expression), diagnostics.UNINITIALISED, "%s is not initialised yet", name)
+	}
+
+	return value, nil
+}
+
+func (in *Interpreter) index(expression *parser.Index, target Value, env *environment) (int, error) {
+	idx, err := in.eval(expression.Index, env)
+	if err != nil {
+		return 0, err
+	}
+
+	bytes := target.(consteval.Value).Bytes
+	position := idx.(consteval.Value).Int
+	if position.Sign() < 0 || !position.IsInt64() || position.Int64() >= int64(len(bytes)) {
+		return 0, diagnostics.Errorf(expression.Pos, diagnostics.INDEX_OUT_OF_RANGE, "Index %s is out of range for length %d", position, len(bytes))
+	}
+
+	return int(position.Int64()), nil
+}
+
+func (in *Interpreter) evalCall(expression *parser.Call, env *environment) (Value, error) {
+	var function *Function
+	var self Value
+
+	if access, ok := expression.Callee.(*parser.FieldAccess); ok && in.info.Methods[access] != nil {
+		method := in.info.Methods[access]
+		decl := method.Decl
+
+		// Methods called on a value are looked up on the struct it holds,
+		// which may differ from the type of the expression for traits
+		if method.Self {
+			var err error
+			if self, err = in.eval(access.Target, env); err != nil {
+				return nil, err
+			}
+			decl = self.(*Struct).Type.Method(method.Name).Decl
+		}
+
+		function = &Function{Decl: decl, Env: in.functions[decl]}
+	} else {
+		callee, err := in.eval(expression.Callee, env)
+		if err != nil {
+			return nil, err
+		}
+		function = callee.(*Function)
+	}
+
+	var arguments []Value
+	for _, argument := range expression.Arguments {
+		value, err := in.eval(argument, env)
+		if err != nil {
+			return nil, err
+		}
+		arguments = append(arguments, value)
+	}
+
+	return in.call(function, self, arguments, expression.Pos)
+}
+
+func (in *Interpreter) evalBinary(expression *parser.Binary, env *environment) (Value, error) {
+	left, err := in.eval(expression.Left, env)
+	if err != nil {
+		return nil, err
+	}
+
+	// The right side of ??, && and || is only evaluated if it is needed
+	switch expression.Operator {
+	case lexer.IF_NIL:
+		if primitive, ok := left.(consteval.Value); !ok || primitive.Type != types.Nil {
+			return left, nil
+		}
+		return in.eval(expression.Right, env)
+	case lexer.LOGICAL_AND, lexer.LOGICAL_OR:
+		if left.(consteval.Value).Bool == (expression.Operator == lexer.LOGICAL_OR) {
+			return left, nil
+		}
+		return in.eval(expression.Right, env)
+	}
+
+	right, err := in.eval(expression.Right, env)
+	if err != nil {
+		return nil, err
+	}
+
+	// Both operands are converted to the common type the type checker
+	// chose, shifts keep the type of their left operand
+	if t := types.Unify(in.info.Types[expression.Left], in.info.Types[expression.Right]); t != nil {
+		if !shifts[expression.Operator] {
+			left, right = coerce(left, t), coerce(right, t)
+		}
+	}
+
+	if expression.Operator == lexer.EQUALS || expression.Operator == lexer.NOT_EQUALS {
+		if _, ok := left.(consteval.Value); !ok {
+			return consteval.Value{Type: types.Bool, Bool: equal(left, right) == (expression.Operator == lexer.EQUALS)}, nil
+		}
+	}
+
+	value, err := consteval.Binary(expression.Operator, left.(consteval.Value), right.(consteval.Value))
+	if err != nil {
+		return nil, diagnostics.Errorf(expression.Pos, diagnostics.CodeOf(err), "%s", err)
+	}
+
+	return value, nil
+}
+
+var shifts = map[lexer.TokenType]bool{
+	lexer.KEYWORD_SHL:  true,
+	lexer.KEYWORD_SHR:  true,
+	lexer.KEYWORD_ASHR: true,
+	lexer.KEYWORD_CSHL: true,
+	lexer.KEYWORD_CSHR: true,
+}
+
+// equal compares values that are not primitives, structs are equal if all
+// their fields are.
+func equal(left Value, right Value) bool {
+	switch left := left.(type) {
+	case *Struct:
+		other, ok := right.(*Struct)
+		if !ok || left.Type != other.Type {
+			return false
+		}
+
+		for name, field := range left.Fields {
+			if !equal(field, other.Fields[name]) {
+				return false
+			}
+		}
+		return true
+	case *Function:
+		other, ok := right.(*Function)
+		return ok && left.Decl == other.Decl
+	case consteval.Value:
+		value, err := consteval.Binary(lexer.EQUALS, left, right.(consteval.Value))
+		return err == nil && value.Bool
+	}
+
+	return false
+}
+
+/* Patterns */
+
+func (in *Interpreter) evalCase(expression *parser.Case, env *environment) (Value, error) {
+	subject, err := in.eval(expression.Subject, env)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, branch := range expression.Branches {
+		branchEnv := newEnvironment(env)
+
+		matched, err := in.match(branch.Pattern, subject, in.info.Types[expression.Subject], branchEnv)
+		if err != nil {
+			return nil, err
+		}
+
+		if matched {
+			return in.branch(branch.Body, expression, branchEnv)
+		}
+	}
+
+	return nil, nil
+}
+
+func (in *Interpreter) match(pattern parser.Pattern, subject Value, t types.Type, env *environment) (bool, error) {
+	switch pattern := pattern.(type) {
+	case *parser.LiteralPattern:
+		var expression parser.Expression = pattern.Literal
+		if pattern.Negative {
+			expression = &parser.Unary{Operator: lexer.MINUS_SIGN, Operand: pattern.Literal, Pos: pattern.Literal.Pos}
+		}
+
+		value, err := in.eval(expression, env)
+		if err != nil {
+			return false, err
+		}
+		return equal(subject, coerce(value, t)), nil
+	case *parser.NilPattern:
+		primitive, ok := subject.(consteval.Value)
+		return ok && primitive.Type == types.Nil, nil
+	case *parser.BindingPattern:
+		env.values[pattern.Identifer.Name] = copyValue(subject)
+		return true, nil
+	case *parser.MutedPattern:
+		return true, nil
+	case *parser.StructPattern:
+		structure, ok := subject.(*Struct)
+		if !ok || structure.Type != in.info.Defs[pattern.Type] {
+			return false, nil
+		}
+
+		for _, field := range pattern.Fields {
+			matched, err := in.match(field.Pattern, structure.Fields[field.Identifer.Name], structure.Type.Field(field.Identifer.Name).Type, env)
+			if err != nil || !matched {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+
+	return false, nil
+}
@@ -0,0 +1,341 @@
+package interpreter
+
+import (
+	"strings"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/checker"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/consteval"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/parser"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/types"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
+)
+
+// The deepest the interpreter nests function calls before it gives up.
+const maxCallDepth = 10000
+
+// Value is a runtime value. Primitive values are consteval.Values, the same
+// representation the constant evaluator folds constants into.
+type Value interface {
+	String() string
+}
+
+type Struct struct {
+	Type   *types.Struct
+	Fields map[string]Value
+}
+
+// Function is a function or method together with the environment it was
+// declared in.
+type Function struct {
+	Decl *parser.Function
+	Env  *environment
+}
+
+func (structure *Struct) String() string {
+	var fields []string
+	for _, field := range structure.Type.Fields {
+		fields = append(fields, field.Name+": "+structure.Fields[field.Name].String())
+	}
+
+	if len(fields) == 0 {
+		return structure.Type.Name + " {}"
+	}
+
+	return structure.Type.Name + " { " + strings.Join(fields, ", ") + " }"
+}
+
+func (function *Function) String() string {
+	return "fn " + function.Decl.Identifer.Name
+}
+
+type environment struct {
+	parent *environment
+	values map[string]Value
+}
+
+func newEnvironment(parent *environment) *environment {
+	return &environment{parent: parent, values: map[string]Value{}}
+}
+
+func (env *environment) lookup(name string) (Value, bool) {
+	for ; env != nil; env = env.parent {
+		if value, ok := env.values[name]; ok {
+			return value, true
+		}
+	}
+
+	return nil, false
+}
+
+func (env *environment) set(name string, value Value) {
+	for scope := env; scope != nil; scope = scope.parent {
+		if _, ok := scope.values[name]; ok {
+			scope.values[name] = value
+			return
+		}
+	}
+
+	env.values[name] = value
+}
+
+// returnSignal unwinds the statements of a function up to its call.
+type returnSignal struct {
+	value Value
+}
+
+func (*returnSignal) Error() string {
+	return "return outside of a function"
+}
+
+// Interpreter executes programs statement by statement. Its environment
+// outlives a single run, so every run sees the bindings and declarations of
+// the runs before.
+type Interpreter struct {
+	info       *checker.Info
+	constants  map[*parser.Constant]consteval.Value
+	namespaces map[string]*environment
+	functions  map[*parser.Function]*environment
+	depth      int
+}
+
+func New() *Interpreter {
+	return &Interpreter{
+		namespaces: map[string]*environment{},
+		functions:  map[*parser.Function]*environment{},
+	}
+}
+
+// Run executes the statements of a checked program and returns the value of
+// the last one with its type. The value is nil if the last statement has no
+// value. The type information has to cover the programs of earlier runs.
+func (in *Interpreter) Run(program parser.Program, info *checker.Info, constants map[*parser.Constant]consteval.Value) (Value, types.Type, error) {
+	in.info = info
+	in.constants = constants
+
+	var value Value
+	var t types.Type = types.Void
+
+	for _, scope := range program.Scopes {
+		name := ""
+		if scope.Namespace != nil {
+			name = scope.Namespace.Path.String()
+		}
+
+		env, ok := in.namespaces[name]
+		if !ok {
+			env = newEnvironment(nil)
+			in.namespaces[name] = env
+		}
+
+		in.declare(scope, env)
+
+		for _, statement := range scope.Statements {
+			var err error
+			if value, err = in.exec(statement, env); err != nil {
+				if _, ok := err.(*returnSignal); ok {
+					return nil, types.Void, diagnostics.Errorf(statement.(*parser.Return).Pos, diagnostics.INVALID_PROGRAM, "Cannot return outside of a function")
+				}
+				return nil, types.Void, err
+			}
+
+			t = types.Void
+			if expression, ok := statement.(*parser.ExpressionStatement); ok && value != nil {
+				t = info.Types[expression.Expression]
+			}
+		}
+	}
+
+	if t == types.Void {
+		return nil, t, nil
+	}
+
+	return value, t, nil
+}
+
+func (in *Interpreter) declare(scope *parser.Scope, env *environment) {
+	for _, function := range scope.Functions {
+		in.functions[function] = env
+	}
+
+	for _, trait := range scope.Traits {
+		for _, method := range trait.Methods {
+			in.functions[method] = env
+		}
+	}
+
+	for _, impl := range scope.Impls {
+		for _, method := range impl.Methods {
+			in.functions[method] = env
+		}
+	}
+}
+
+func (in *Interpreter) exec(statement parser.Statement, env *environment) (Value, error) {
+	switch statement := statement.(type) {
+	case *parser.ExpressionStatement:
+		return in.eval(statement.Expression, env)
+	case *parser.Return:
+		var value Value
+		if statement.Expression != nil {
+			var err error
+			if value, err = in.eval(statement.Expression, env); err != nil {
+				return nil, err
+			}
+		}
+		return nil, &returnSignal{value}
+	case *parser.Binding:
+		value, err := in.eval(statement.Expression, env)
+		if err != nil {
+			return nil, err
+		}
+		env.values[statement.Identifer.Name] = copyValue(coerce(value, in.info.Defs[statement]))
+	case *parser.Assignment:
+		value, err := in.eval(statement.Expression, env)
+		if err != nil {
+			return nil, err
+		}
+		return nil, in.assign(statement.Target, copyValue(coerce(value, in.info.Types[statement.Target])), env)
+	}
+
+	return nil, nil
+}
+
+// execScope runs the statements of a scope and returns the value of the
+// last one.
+func (in *Interpreter) execScope(scope *parser.Scope, parent *environment) (Value, error) {
+	env := newEnvironment(parent)
+	in.declare(scope, env)
+
+	var value Value
+	for _, statement := range scope.Statements {
+		var err error
+		if value, err = in.exec(statement, env); err != nil {
+			return nil, err
+		}
+	}
+
+	return value, nil
+}
+
+func (in *Interpreter) assign(target parser.Expression, value Value, env *environment) error {
+	switch target := target.(type) {
+	case *parser.IdentifierExpression:
+		env.set(target.Identifer.Name, value)
+	case *parser.FieldAccess:
+		structure, err := in.eval(target.Target, env)
+		if err != nil {
+			return err
+		}
+		structure.(*Struct).Fields[target.Identifer.Name] = value
+	case *parser.Index:
+		// Byte strings are values, so the whole string is replaced
+		container, err := in.eval(target.Target, env)
+		if err != nil {
+			return err
+		}
+
+		idx, err := in.index(target, container, env)
+		if err != nil {
+			return err
+		}
+
+		bytes := []byte(container.(consteval.Value).Bytes)
+		bytes[idx] = byte(value.(consteval.Value).Int.Uint64())
+
+		return in.assign(target.Target, consteval.Value{Type: types.Bin, Bytes: string(bytes)}, env)
+	}
+
+	return nil
+}
+
+// call runs a function with the given receiver and arguments. The receiver
+// is nil for functions and methods without self.
+func (in *Interpreter) call(function *Function, self Value, arguments []Value, pos util.Position) (Value, error) {
+	if function.Decl.Body == nil {
+		return nil, diagnostics.Errorf(pos, diagnostics.UNSUPPORTED, "External function %s cannot be called by the interpreter", function.Decl.Identifer.Name)
+	}
+
+	if in.depth >= maxCallDepth {
+		return nil, diagnostics.Errorf(pos, diagnostics.STACK_OVERFLOW, "Stack overflow, too many nested calls")
+	}
+
+	in.depth++
+	defer func() { in.depth-- }()
+
+	env := newEnvironment(function.Env)
+	if self != nil {
+		env.values["self"] = self
+	}
+
+	idx := 0
+	for _, parameter := range function.Decl.Parameters {
+		if parameter.Type == nil {
+			continue
+		}
+
+		env.values[parameter.Identifer.Name] = copyValue(coerce(arguments[idx], in.info.Defs[parameter]))
+		idx++
+	}
+
+	value, err := in.execScope(function.Decl.Body, env)
+	if ret, ok := err.(*returnSignal); ok {
+		value, err = ret.value, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	signature := in.info.Defs[function.Decl].(*types.Function)
+	if signature.Result == types.Void {
+		return nil, nil
+	}
+
+	return coerce(value, signature.Result), nil
+}
+
+// coerce applies the implicit conversions of the type checker to a value
+// that is used as the given type.
+func coerce(value Value, target types.Type) Value {
+	primitive, ok := value.(consteval.Value)
+	basic, isBasic := target.(*types.Basic)
+	if !ok || !isBasic || primitive.Type == basic || !basic.IsNumeric() || basic.IsUntyped() {
+		return value
+	}
+
+	if primitive.Type.IsUntyped() {
+		if converted, err := consteval.Convert(primitive, basic); err == nil {
+			return converted
+		}
+		return value
+	}
+
+	switch {
+	case primitive.Type.IsInteger() && basic.IsInteger():
+		return consteval.Value{Type: basic, Int: primitive.Int}
+	case primitive.Type.IsInteger():
+		float, _ := primitive.Int.Float64()
+		return consteval.Value{Type: basic, Float: float}
+	case primitive.Type.IsFloat() && basic.IsFloat():
+		return consteval.Value{Type: basic, Float: primitive.Float}
+	}
+
+	return value
+}
+
+// copyValue copies structs, which are values and must not be shared between
+// bindings.
+func copyValue(value Value) Value {
+	structure, ok := value.(*Struct)
+	if !ok {
+		return value
+	}
+
+	fields := map[string]Value{}
+	for name, field := range structure.Fields {
+		fields[name] = copyValue(field)
+	}
+
+	return &Struct{Type: structure.Type, Fields: fields}
+}
@@ -0,0 +1,116 @@
+package interpreter_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/interpreter"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/lexer"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/parser"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/testutil"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
+)
+
+type testStruct struct {
+	input string
+	want  string
+}
+
+// run evaluates the inputs one after another in the same interpreter, like
+// the lines of a REPL session, and returns the result of the last one as
+// "value: type" or the runtime error.
+func run(t *testing.T, inputs ...string) string {
+	var programs []parser.Program
+	in := interpreter.New()
+	result := ""
+
+	for _, input := range inputs {
+		program, errors := parser.RunInteractive(lexer.Run(util.NewFileSet().AddFile("", input)))
+		if len(errors) > 0 {
+			t.Fatalf("unexpected parser errors: %s", errors)
+		}
+		programs = append(programs, program)
+
+		front := testutil.Check(t, programs)
+
+		value, typ, err := in.Run(program, front.Info, front.Constants)
+		switch {
+		case err != nil:
+			result = err.(diagnostics.Diagnostic).Msg
+		case value == nil:
+			result = ""
+		default:
+			result = fmt.Sprintf("%s: %s", value, typ)
+		}
+	}
+
+	return result
+}
+
+func testHelper(t *testing.T, tests []testStruct) {
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			if actual := run(t, test.input); actual != test.want {
+				t.Errorf("expected %q but got %q", test.want, actual)
+			}
+		})
+	}
+}
+
+func TestExpressions(t *testing.T) {
+	testHelper(t, []testStruct{
+		{"1 + 2 * 3", "7: i64"},
+		{"let a: u8 = 200\na + 55", "255: u8"},
+		{"let a: u8 = 200\nlet b: u16 = 1000\na + b", "1200: u16"},
+		{"7.0 / 2", "3.5: num"},
+		{"let a: f32 = 0.5\na * 3", "1.5: f32"},
+		{"1 < 2 and not false", "true: bool"},
+		{"\"ab\" + \"c\"", "\"abc\": bin"},
+		{"\"abc\"[1]", "98: u8"},
+		{"let a = \"b\"\n\"a{a + a}\\u{63}\"", "\"abbc\": bin"},
+		{"let a: i8 = -5\nlet b: f32 = 1.1\n\"{a} {b} {true} {'ok} {1e6}\"", "\"-5 1.1 true ok 1e+06\": bin"},
+		{"-128i8 + 1", "-127: i8"},
+		{"0x_FFu8 - 1_0", "245: u8"},
+		{"let a: u8 = 1\na cshr 1", "128: u8"},
+		{"nil ?? 3", "3: i64"},
+		{"let a = 1", ""},
+
+		// Runtime errors
+		{"let a: u8 = 200\na + 56", "Overflow, 256 does not fit into u8"},
+		{"let a = 0\n1 / a", "Division by zero"},
+		{"\"abc\"[3]", "Index 3 is out of range for length 3"},
+	})
+}
+
+func TestControlFlow(t *testing.T) {
+	testHelper(t, []testStruct{
+		{"if 1 < 2 { \"yes\" } else { \"no\" }", "\"yes\": bin"},
+		{"let a = 5\ncond {\n\ta < 3 -> 1\n\ta < 10 -> 2\n\telse -> 3\n}", "2: i64"},
+		{"let a = 7\ncase a {\n\t0 -> \"zero\"\n\tn -> \"other\"\n}", "\"other\": bin"},
+		{"let! a = 1\nif a == 1 {\n\ta = 2\n}\na", "2: i64"},
+		{"fn f(n: i64) -> i64 {\n\tif n < 2 {\n\t\treturn n\n\t}\n\tf(n - 1) + f(n - 2)\n}\nf(15)", "610: i64"},
+		{"fn f(n: i64) -> i64 { f(n + 1) }\nf(0)", "Stack overflow, too many nested calls"},
+	})
+}
+
+func TestStructs(t *testing.T) {
+	shapes := "struct P { x: i32, y: i32 }\ntrait Shape {\n\tfn area(self) -> i32\n\tfn name(self) -> bin { \"shape\" }\n}\nimpl Shape for P {\n\tfn area(self) -> i32 { self.x * self.y }\n}\n"
+
+	testHelper(t, []testStruct{
+		{shapes + "P { x: 2, y: 3 }", "P { x: 2, y: 3 }: P"},
+		{shapes + "let! p = P { x: 2, y: 3 }\np.x = 5\np.area()", "15: i32"},
+		{shapes + "let! p = P { x: 2, y: 3 }\nlet q = p\np.x = 5\nq.x", "2: i32"},
+		{shapes + "fn f(s: Shape) -> i32 { s.area() }\nf(P { x: 4, y: 4 })", "16: i32"},
+		{shapes + "P { x: 1, y: 1 }.name()", "\"shape\": bin"},
+		{shapes + "P { x: 1, y: 2 } == P { x: 1, y: 2 }", "true: bool"},
+		{shapes + "let p = P { x: 1, y: 2 }\ncase p {\n\tP { x: 0 } -> 0\n\tP { x, y } -> x + y\n}", "3: i32"},
+		{shapes + "impl P {\n\tfn origin() -> P { P { x: 0, y: 0 } }\n}\nP.origin()", "P { x: 0, y: 0 }: P"},
+	})
+}
+
+func TestSession(t *testing.T) {
+	if actual := run(t, "let! a = 1", "fn double(n: i64) -> i64 { n * 2 }", "a = double(a + 1)", "double(a)"); actual != "8: i64" {
+		t.Errorf("expected \"8: i64\" but got %q", actual)
+	}
+}
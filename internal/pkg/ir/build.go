@@ -0,0 +1,342 @@
+package ir
+
+import (
+	"strconv"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/checker"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/consteval"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/parser"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/types"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
+)
+
+// The key of the self parameter in the values of a method.
+type selfKey struct{}
+
+type builder struct {
+	info      *checker.Info
+	constants map[*parser.Constant]consteval.Value
+	program   *Program
+
+	functions map[*parser.Function]*Function
+	globals   map[*parser.Binding]*Global
+	taken     map[string]bool
+
+	// The function that is built: its current block and the values of its
+	// bindings in the order they were declared
+	function   *Function
+	block      *Block
+	terminated bool
+	values     map[any]*Value
+	order      []any
+
+	errors []diagnostics.Diagnostic
+}
+
+// Build translates type checked programs into SSA form. Bindings become
+// values, with phis where branches join, and structs and byte strings are
+// values that are replaced as a whole when a field or byte is assigned.
+// Methods of traits are looked up at runtime by their name.
+func Build(programs []parser.Program, info *checker.Info, constants map[*parser.Constant]consteval.Value) (*Program, []diagnostics.Diagnostic) {
+	b := builder{
+		info:      info,
+		constants: constants,
+		program:   &Program{Methods: map[*types.Struct]map[string]*Function{}},
+		functions: map[*parser.Function]*Function{},
+		globals:   map[*parser.Binding]*Global{},
+		taken:     map[string]bool{},
+	}
+
+	for _, program := range programs {
+		for _, top := range program.Scopes {
+			namespace := ""
+			if top.Namespace != nil {
+				namespace = top.Namespace.Path.String() + "::"
+			}
+
+			for _, statement := range top.Statements {
+				if binding, ok := statement.(*parser.Binding); ok {
+					b.globals[binding] = &Global{b.unique(namespace + binding.Identifer.Name), info.Defs[binding]}
+					b.program.Globals = append(b.program.Globals, b.globals[binding])
+				}
+			}
+
+			parser.Inspect(top, func(node any) bool {
+				scope, ok := node.(*parser.Scope)
+				if !ok {
+					return true
+				}
+
+				if scope == top {
+					b.declare(scope, namespace, true)
+				} else {
+					b.declare(scope, "", false)
+				}
+
+				if scope == top && namespace == "" {
+					for _, function := range scope.Functions {
+						if function.Identifer.Name == "main" && len(function.Parameters) == 0 && function.Body != nil {
+							b.program.Main = b.functions[function]
+						}
+					}
+				}
+
+				return true
+			})
+		}
+	}
+
+	for _, structure := range b.program.Structs {
+		methods := map[string]*Function{}
+		for _, trait := range structure.Traits {
+			for _, method := range trait.Methods {
+				if method.Decl.Body != nil {
+					methods[method.Name] = b.functions[method.Decl]
+				}
+			}
+		}
+		for _, method := range structure.Methods {
+			methods[method.Name] = b.functions[method.Decl]
+		}
+		b.program.Methods[structure] = methods
+	}
+
+	for _, function := range b.program.Functions {
+		if !function.External {
+			b.buildFunction(function)
+		}
+	}
+	b.buildInit(programs)
+
+	return b.program, b.errors
+}
+
+func (b *builder) errorf(pos util.Position, code string, format string, a ...any) {
+	b.errors = append(b.errors, diagnostics.Errorf(pos, code, format, a...))
+}
+
+// unique returns a name of a function or global that is not used yet.
+func (b *builder) unique(name string) string {
+	candidate := name
+	for idx := 2; b.taken[candidate]; idx++ {
+		candidate = name + "." + strconv.Itoa(idx)
+	}
+
+	b.taken[candidate] = true
+	return candidate
+}
+
+// declare adds the types of a scope and a function for every function and
+// method of it.
+func (b *builder) declare(scope *parser.Scope, prefix string, top bool) {
+	for _, structure := range scope.Structs {
+		b.program.Structs = append(b.program.Structs, b.info.Defs[structure].(*types.Struct))
+	}
+	for _, trait := range scope.Traits {
+		b.program.Traits = append(b.program.Traits, b.info.Defs[trait].(*types.Trait))
+	}
+
+	for _, function := range scope.Functions {
+		b.add(function, prefix+function.Identifer.Name, nil)
+		if visibility := function.Visibility; top && visibility != nil && *visibility == parser.PUBLIC {
+			b.functions[function].Public = true
+		}
+	}
+
+	for _, trait := range scope.Traits {
+		for _, method := range trait.Methods {
+			if method.Body != nil {
+				b.add(method, prefix+trait.Identifer.Name+"."+method.Identifer.Name, b.info.Defs[trait])
+			}
+		}
+	}
+
+	for _, impl := range scope.Impls {
+		for _, method := range impl.Methods {
+			b.add(method, prefix+impl.Type.Identifer.Name+"."+method.Identifer.Name, b.info.Defs[impl.Type])
+		}
+	}
+}
+
+func (b *builder) add(decl *parser.Function, name string, self types.Type) {
+	signature := b.info.Defs[decl].(*types.Function)
+	function := &Function{Name: b.unique(name), Decl: decl, Result: signature.Result, External: decl.Body == nil}
+
+	idx := 0
+	for _, parameter := range decl.Parameters {
+		if parameter.Type == nil {
+			function.Receiver = true
+			function.Params = append(function.Params, &Value{Op: OP_PARAM, Type: self, Name: "self", Index: len(function.Params), Pos: parameter.Identifer.Pos})
+			continue
+		}
+
+		function.Params = append(function.Params, &Value{Op: OP_PARAM, Type: signature.Parameters[idx], Name: parameter.Identifer.Name, Index: len(function.Params), Pos: parameter.Identifer.Pos})
+		idx++
+	}
+
+	b.functions[decl] = function
+	b.program.Functions = append(b.program.Functions, function)
+}
+
+/* Functions */
+
+func (b *builder) enter(function *Function) {
+	b.function = function
+	b.values = map[any]*Value{}
+	b.order = nil
+	b.start(&Block{})
+}
+
+func (b *builder) buildFunction(function *Function) {
+	b.enter(function)
+
+	for idx, parameter := range function.Decl.Parameters {
+		if parameter.Type == nil {
+			b.bind(selfKey{}, function.Params[idx])
+		} else {
+			b.bind(parameter, function.Params[idx])
+		}
+	}
+
+	value, t := b.scope(function.Decl.Body, pushes(function.Result))
+	b.ret(value, t, function.Decl.Identifer.Pos)
+	function.cleanup()
+}
+
+// buildInit builds the initialisers of the global bindings into a function
+// that runs before main.
+func (b *builder) buildInit(programs []parser.Program) {
+	b.program.Init = &Function{Name: b.unique("<init>"), Result: types.Void}
+	b.program.Functions = append(b.program.Functions, b.program.Init)
+	b.enter(b.program.Init)
+
+	for _, program := range programs {
+		for _, scope := range program.Scopes {
+			for _, statement := range scope.Statements {
+				b.statement(statement)
+			}
+		}
+	}
+
+	b.ret(nil, types.Void, util.Position{})
+	b.program.Init.cleanup()
+}
+
+// ret returns the value of a body from the current function.
+func (b *builder) ret(value *Value, t types.Type, pos util.Position) {
+	switch {
+	case b.terminated:
+	case !pushes(b.function.Result):
+		b.stop(OP_RETURN, pos, nil)
+	case pushes(t):
+		b.stop(OP_RETURN, pos, nil, b.coerce(value, t, b.function.Result))
+	default:
+		b.stop(OP_UNREACHABLE, pos, nil)
+	}
+}
+
+func pushes(t types.Type) bool {
+	return t != nil && t != types.Void
+}
+
+/* Instructions */
+
+// start continues in a new block.
+func (b *builder) start(block *Block) {
+	b.function.Blocks = append(b.function.Blocks, block)
+	b.block = block
+	b.terminated = false
+}
+
+func (b *builder) emit(op Op, t types.Type, pos util.Position, args ...*Value) *Value {
+	instr := &Value{Op: op, Type: t, Args: args, Pos: pos}
+	b.block.Instrs = append(b.block.Instrs, instr)
+	return instr
+}
+
+// end ends the current block with a terminator.
+func (b *builder) end(op Op, pos util.Position, targets []*Block, args ...*Value) {
+	b.emit(op, types.Void, pos, args...).Blocks = targets
+}
+
+// stop ends the current block with a terminator. The code that follows is
+// unreachable and goes into a block of its own, which is removed once the
+// function is built.
+func (b *builder) stop(op Op, pos util.Position, targets []*Block, args ...*Value) {
+	b.end(op, pos, targets, args...)
+	b.start(&Block{})
+	b.terminated = true
+}
+
+func (b *builder) constant(value consteval.Value, t types.Type) *Value {
+	return &Value{Op: OP_CONST, Type: t, Const: value}
+}
+
+// bind sets the value of a binding.
+func (b *builder) bind(node any, value *Value) {
+	if _, ok := b.values[node]; !ok {
+		b.order = append(b.order, node)
+	}
+
+	b.values[node] = value
+}
+
+func (b *builder) snapshot() map[any]*Value {
+	return copyOf(b.values)
+}
+
+func copyOf(values map[any]*Value) map[any]*Value {
+	copied := map[any]*Value{}
+	for node, value := range values {
+		copied[node] = value
+	}
+
+	return copied
+}
+
+/* Values */
+
+// literal returns a constant of the type the type checker gave it.
+func (b *builder) literal(constant consteval.Value, t types.Type, pos util.Position) *Value {
+	if basic, ok := t.(*types.Basic); ok && constant.Type.IsUntyped() && basic.IsNumeric() && !basic.IsUntyped() {
+		converted, err := consteval.Convert(constant, basic)
+		if err != nil {
+			b.errorf(pos, diagnostics.CodeOf(err), "%s", err)
+		} else {
+			constant = converted
+		}
+	}
+
+	return b.constant(constant, t)
+}
+
+// coerce converts a value between the types of an implicit conversion.
+// Constants are converted right away, constants in branches can keep their
+// default type while the branches join in a smaller one.
+func (b *builder) coerce(value *Value, from types.Type, to types.Type) *Value {
+	if value == nil || from == to || !pushes(to) {
+		return value
+	}
+
+	switch target := to.(type) {
+	case *types.Trait:
+		if _, ok := from.(*types.Struct); ok {
+			return b.emit(OP_CONVERT, target, value.Pos, value)
+		}
+	case *types.Basic:
+		source, ok := from.(*types.Basic)
+		if !ok || !source.IsNumeric() || !target.IsNumeric() {
+			return value
+		}
+
+		if value.isConst() {
+			if converted, ok := convertConst(value.Const, target); ok {
+				return b.constant(converted, target)
+			}
+		}
+		return b.emit(OP_CONVERT, target, value.Pos, value)
+	}
+
+	return value
+}
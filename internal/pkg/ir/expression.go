@@ -0,0 +1,610 @@
+package ir
+
+import (
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/consteval"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/lexer"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/parser"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/types"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
+)
+
+var operators = map[lexer.TokenType]Op{
+	lexer.PLUS_SIGN:              OP_ADD,
+	lexer.MINUS_SIGN:             OP_SUB,
+	lexer.STAR_SIGN:              OP_MUL,
+	lexer.SLASH_SIGN:             OP_DIV,
+	lexer.CIRCUMFLEX:             OP_POW,
+	lexer.KEYWORD_SHL:            OP_SHL,
+	lexer.KEYWORD_SHR:            OP_SHR,
+	lexer.KEYWORD_ASHR:           OP_ASHR,
+	lexer.KEYWORD_CSHL:           OP_CSHL,
+	lexer.KEYWORD_CSHR:           OP_CSHR,
+	lexer.KEYWORD_AND:            OP_AND,
+	lexer.KEYWORD_OR:             OP_OR,
+	lexer.KEYWORD_XOR:            OP_XOR,
+	lexer.EQUALS:                 OP_EQ,
+	lexer.NOT_EQUALS:             OP_NE,
+	lexer.LESS_THAN:              OP_LT,
+	lexer.LESS_THAN_OR_EQUALS:    OP_LE,
+	lexer.GREATER_THAN:           OP_GT,
+	lexer.GREATER_THAN_OR_EQUALS: OP_GE,
+}
+
+func isShift(op Op) bool {
+	return op >= OP_SHL && op <= OP_CSHR
+}
+
+/* Statements */
+
+// scope builds the statements of a scope. If want is set, the value of the
+// last statement is returned, with its type: void if the scope has no value
+// and nil if it always returns.
+func (b *builder) scope(scope *parser.Scope, want bool) (*Value, types.Type) {
+	var t types.Type = types.Void
+
+	for idx, statement := range scope.Statements {
+		if last, ok := statement.(*parser.ExpressionStatement); ok && want && idx == len(scope.Statements)-1 {
+			return b.expression(last.Expression), b.info.Types[last.Expression]
+		}
+
+		t = b.statement(statement)
+	}
+
+	return nil, t
+}
+
+// branch builds the body of a branch and converts its value to the type of
+// the whole expression.
+func (b *builder) branch(scope *parser.Scope, t types.Type) *Value {
+	value, valueType := b.scope(scope, pushes(t))
+	if pushes(t) && pushes(valueType) {
+		return b.coerce(value, valueType, t)
+	}
+
+	return nil
+}
+
+func (b *builder) statement(statement parser.Statement) types.Type {
+	switch statement := statement.(type) {
+	case *parser.ExpressionStatement:
+		b.expression(statement.Expression)
+		return b.info.Types[statement.Expression]
+	case *parser.Return:
+		if statement.Expression == nil || !pushes(b.function.Result) {
+			if statement.Expression != nil {
+				b.expression(statement.Expression)
+			}
+			b.stop(OP_RETURN, statement.Pos, nil)
+			return nil
+		}
+
+		value := b.expression(statement.Expression)
+		b.stop(OP_RETURN, statement.Pos, nil, b.coerce(value, b.info.Types[statement.Expression], b.function.Result))
+		return nil
+	case *parser.Binding:
+		t := b.info.Defs[statement]
+		value := b.coerce(b.expression(statement.Expression), b.info.Types[statement.Expression], t)
+
+		if global, ok := b.globals[statement]; ok {
+			b.emit(OP_STORE, types.Void, statement.Identifer.Pos, value).Global = global
+		} else {
+			b.bind(statement, value)
+		}
+	case *parser.Assignment:
+		value := b.coerce(b.expression(statement.Expression), b.info.Types[statement.Expression], b.info.Types[statement.Target])
+		b.assign(statement.Target, value)
+	}
+
+	return types.Void
+}
+
+// assign stores a value into a target. Structs and byte strings are values,
+// so storing into a field or byte stores a new value into the target of the
+// field or byte.
+func (b *builder) assign(target parser.Expression, value *Value) {
+	switch target := target.(type) {
+	case *parser.IdentifierExpression:
+		node := b.info.Uses[target]
+		if _, ok := b.values[node]; ok {
+			b.values[node] = value
+		} else if binding, ok := node.(*parser.Binding); ok && b.globals[binding] != nil {
+			b.emit(OP_STORE, types.Void, target.Identifer.Pos, value).Global = b.globals[binding]
+		} else {
+			b.errorf(target.Identifer.Pos, diagnostics.CAPTURED_BINDING, "Nested functions cannot use %s of the enclosing function", target.Identifer.Name)
+		}
+	case *parser.SelfExpression:
+		b.values[selfKey{}] = value
+	case *parser.FieldAccess:
+		structure := b.expression(target.Target)
+		insert := b.emit(OP_INSERT, b.info.Types[target.Target], target.Pos, structure, value)
+		insert.Index = fieldIndex(b.info.Types[target.Target].(*types.Struct), target.Identifer.Name)
+		b.assign(target.Target, insert)
+	case *parser.Grouping:
+		b.assign(target.Expression, value)
+	case *parser.Index:
+		bytes := b.expression(target.Target)
+		index := b.expression(target.Index)
+		b.assign(target.Target, b.emit(OP_SET_INDEX, b.info.Types[target.Target], target.Pos, bytes, index, value))
+	}
+}
+
+// fieldIndex returns the position of a field in its struct.
+func fieldIndex(structure *types.Struct, name string) int {
+	for idx, field := range structure.Fields {
+		if field.Name == name {
+			return idx
+		}
+	}
+
+	return 0
+}
+
+/* Expressions */
+
+// expression builds the instructions of an expression and returns its
+// value, which is nil if it has none.
+func (b *builder) expression(expression parser.Expression) *Value {
+	t := b.info.Types[expression]
+
+	// Operators on constants are folded, their operands may not fit into the
+	// type of the result on their own like 128i8 in -128i8
+	switch expression.(type) {
+	case *parser.Unary, *parser.Binary:
+		if constant, ok := consteval.Fold(expression); ok {
+			return b.literal(constant, t, parser.ExpressionPos(expression))
+		}
+	}
+
+	switch expression := expression.(type) {
+	case *parser.Literal:
+		constant, err := consteval.Literal(expression)
+		if err != nil {
+			b.errorf(expression.Pos, diagnostics.CodeOf(err), "%s", err)
+			return b.constant(consteval.Value{Type: types.Nil}, t)
+		}
+		return b.literal(constant, t, expression.Pos)
+	case *parser.Grouping:
+		return b.expression(expression.Expression)
+	case *parser.Interpolation:
+		return b.interpolation(expression)
+	case *parser.IdentifierExpression:
+		return b.load(expression, expression.Identifer)
+	case *parser.PathExpression:
+		return b.load(expression, expression.Identifer)
+	case *parser.SelfExpression:
+		return b.values[selfKey{}]
+	case *parser.StructLiteral:
+		structure := b.info.Defs[expression.Type].(*types.Struct)
+
+		var fields []*Value
+		for _, field := range structure.Fields {
+			for _, literal := range expression.Fields {
+				if literal.Identifer.Name == field.Name {
+					fields = append(fields, b.coerce(b.expression(literal.Expression), b.info.Types[literal.Expression], field.Type))
+				}
+			}
+		}
+		return b.emit(OP_STRUCT, structure, expression.Type.Identifer.Pos, fields...)
+	case *parser.FieldAccess:
+		structure := b.expression(expression.Target)
+		field := b.emit(OP_FIELD, t, expression.Pos, structure)
+		field.Index = fieldIndex(b.info.Types[expression.Target].(*types.Struct), expression.Identifer.Name)
+		return field
+	case *parser.Call:
+		return b.call(expression)
+	case *parser.Index:
+		bytes := b.expression(expression.Target)
+		return b.emit(OP_INDEX, t, expression.Pos, bytes, b.expression(expression.Index))
+	case *parser.Unary:
+		operand := b.expression(expression.Operand)
+		if expression.Operator == lexer.MINUS_SIGN {
+			return b.emit(OP_NEG, t, expression.Pos, operand)
+		}
+		return b.emit(OP_NOT, t, expression.Pos, operand)
+	case *parser.Binary:
+		return b.binary(expression)
+	case *parser.If:
+		return b.ifExpression(expression)
+	case *parser.Cond:
+		return b.condExpression(expression)
+	case *parser.Case:
+		return b.caseExpression(expression)
+	}
+
+	return nil
+}
+
+// load returns a constant, a function or the value of a binding. Untyped
+// constants take the type the type checker gave their use.
+func (b *builder) load(expression parser.Expression, identifer *parser.Identifer) *Value {
+	node := b.info.Uses[expression]
+
+	switch node := node.(type) {
+	case *parser.Constant:
+		return b.literal(b.constants[node], b.info.Types[expression], identifer.Pos)
+	case *parser.Function:
+		return &Value{Op: OP_FUNCTION, Type: b.info.Types[expression], Function: b.functions[node], Pos: identifer.Pos}
+	}
+
+	if value, ok := b.values[node]; ok {
+		return value
+	}
+	if binding, ok := node.(*parser.Binding); ok && b.globals[binding] != nil {
+		load := b.emit(OP_LOAD, b.globals[binding].Type, identifer.Pos)
+		load.Global = b.globals[binding]
+		return load
+	}
+
+	b.errorf(identifer.Pos, diagnostics.CAPTURED_BINDING, "Nested functions cannot use %s of the enclosing function", identifer.Name)
+	return nil
+}
+
+// call calls functions and the methods of structs directly, methods of
+// traits are looked up at runtime.
+func (b *builder) call(expression *parser.Call) *Value {
+	t := b.info.Types[expression]
+	if !pushes(t) {
+		t = types.Void
+	}
+
+	arguments := func(parameters []types.Type, args ...*Value) []*Value {
+		for idx, argument := range expression.Arguments {
+			args = append(args, b.coerce(b.expression(argument), b.info.Types[argument], parameters[idx]))
+		}
+		return args
+	}
+
+	if access, ok := expression.Callee.(*parser.FieldAccess); ok && b.info.Methods[access] != nil {
+		method := b.info.Methods[access]
+
+		if _, ok := b.info.Types[access.Target].(*types.Trait); ok && method.Self {
+			receiver := b.expression(access.Target)
+			call := b.emit(OP_CALL_METHOD, t, expression.Pos, arguments(method.Signature.Parameters, receiver)...)
+			call.Name = method.Name
+			return call
+		}
+
+		function := b.functions[method.Decl]
+		var args []*Value
+		if method.Self {
+			// Default methods of traits take their receiver as a trait value
+			args = append(args, b.coerce(b.expression(access.Target), b.info.Types[access.Target], function.Params[0].Type))
+		}
+
+		call := b.emit(OP_CALL, t, expression.Pos, arguments(method.Signature.Parameters, args...)...)
+		call.Function = function
+		return call
+	}
+
+	signature := b.info.Types[expression.Callee].(*types.Function)
+
+	if decl, ok := b.info.Uses[expression.Callee].(*parser.Function); ok {
+		call := b.emit(OP_CALL, t, expression.Pos, arguments(signature.Parameters)...)
+		call.Function = b.functions[decl]
+		return call
+	}
+
+	callee := b.expression(expression.Callee)
+	return b.emit(OP_CALL_VALUE, t, expression.Pos, arguments(signature.Parameters, callee)...)
+}
+
+func (b *builder) binary(expression *parser.Binary) *Value {
+	left, right := b.info.Types[expression.Left], b.info.Types[expression.Right]
+
+	// The right side of ?? is only used if the left one is nil, which is
+	// known statically as there are no nilable types
+	switch expression.Operator {
+	case lexer.IF_NIL:
+		if left == types.Nil {
+			return b.expression(expression.Right)
+		}
+		return b.expression(expression.Left)
+	case lexer.LOGICAL_AND, lexer.LOGICAL_OR:
+		return b.logical(expression)
+	}
+
+	op := operators[expression.Operator]
+
+	// Shifts keep the type of their left operand and take an i64 amount,
+	// all other operators convert both operands to their common type
+	t := left
+	if !isShift(op) {
+		if unified := types.Unify(left, right); unified != nil {
+			t = unified
+		}
+	}
+
+	l := b.coerce(b.expression(expression.Left), left, t)
+	r := b.expression(expression.Right)
+	if isShift(op) {
+		if amount, ok := right.(*types.Basic); ok && amount.Bits < 64 {
+			r = b.coerce(r, right, types.I64)
+		}
+	} else {
+		r = b.coerce(r, right, t)
+	}
+
+	return b.emit(op, b.info.Types[expression], expression.Pos, l, r)
+}
+
+// interpolation concatenates the parts of an interpolation with the text of
+// its expressions.
+func (b *builder) interpolation(expression *parser.Interpolation) *Value {
+	var value *Value
+	join := func(right *Value, pos util.Position) {
+		if value == nil {
+			value = right
+		} else {
+			value = b.emit(OP_ADD, types.Bin, pos, value, right)
+		}
+	}
+
+	for idx, part := range expression.Parts {
+		if part.Value != "" || len(expression.Parts) == 1 {
+			join(b.constant(consteval.Value{Type: types.Bin, Bytes: part.Value}, types.Bin), part.Pos)
+		}
+		if idx < len(expression.Expressions) {
+			embedded := expression.Expressions[idx]
+			text := b.expression(embedded)
+			if t := b.info.Types[embedded]; t != types.Bin {
+				text = b.emit(OP_FORMAT, types.Bin, parser.ExpressionPos(embedded), text)
+			}
+			join(text, parser.ExpressionPos(embedded))
+		}
+	}
+
+	return value
+}
+
+/* Branches */
+
+// arm is a branch that continues in the block where the branches join, with
+// its last block, value and the values of the bindings at its end.
+type arm struct {
+	block  *Block
+	value  *Value
+	values map[any]*Value
+}
+
+// leave ends a branch by jumping to the block where the branches join. A
+// branch that returned does not join.
+func (b *builder) leave(arms *[]arm, join *Block, value *Value) {
+	if b.terminated {
+		return
+	}
+
+	*arms = append(*arms, arm{b.block, value, b.snapshot()})
+	b.end(OP_JUMP, util.Position{}, []*Block{join})
+}
+
+// join continues in the block where branches join. Bindings of the
+// enclosing scopes that have different values in the branches, and the
+// value of the whole expression, become phis at the position of the
+// expression.
+func (b *builder) join(arms []arm, block *Block, t types.Type, before map[any]*Value, pos util.Position) *Value {
+	b.start(block)
+	b.values = before
+
+	if len(arms) == 0 {
+		b.terminated = true
+		return nil
+	}
+
+	for _, node := range b.order {
+		if _, ok := before[node]; ok {
+			b.values[node] = b.phi(arms, pos, func(arm arm) *Value { return arm.values[node] })
+		}
+	}
+
+	if pushes(t) {
+		return b.phi(arms, pos, func(arm arm) *Value { return arm.value })
+	}
+
+	return nil
+}
+
+// phi returns the value of the arms, or a phi if they differ.
+func (b *builder) phi(arms []arm, pos util.Position, value func(arm arm) *Value) *Value {
+	first := value(arms[0])
+
+	same := true
+	var args []*Value
+	var blocks []*Block
+	for _, arm := range arms {
+		same = same && value(arm) == first
+		args = append(args, value(arm))
+		blocks = append(blocks, arm.block)
+	}
+
+	if same {
+		return first
+	}
+
+	phi := b.emit(OP_PHI, first.Type, pos, args...)
+	phi.Blocks = blocks
+	return phi
+}
+
+func (b *builder) ifExpression(expression *parser.If) *Value {
+	t := b.info.Types[expression]
+	condition := b.expression(expression.Condition)
+	then, end := &Block{}, &Block{}
+	before := b.snapshot()
+	var arms []arm
+
+	// Without an else the condition continues at the end right away
+	otherwise := end
+	if expression.Else != nil {
+		otherwise = &Block{}
+	} else {
+		arms = append(arms, arm{b.block, nil, before})
+	}
+	b.end(OP_BRANCH, expression.Pos, []*Block{then, otherwise}, condition)
+
+	b.start(then)
+	b.leave(&arms, end, b.branch(expression.Then, t))
+
+	if expression.Else != nil {
+		b.values = copyOf(before)
+		b.start(otherwise)
+		b.leave(&arms, end, b.branch(expression.Else, t))
+	}
+
+	return b.join(arms, end, t, copyOf(before), expression.Pos)
+}
+
+// logical builds && and ||, whose right side is only evaluated if needed.
+func (b *builder) logical(expression *parser.Binary) *Value {
+	left := b.expression(expression.Left)
+	right, end := &Block{}, &Block{}
+	before := b.snapshot()
+	arms := []arm{{b.block, left, before}}
+
+	if expression.Operator == lexer.LOGICAL_AND {
+		b.end(OP_BRANCH, expression.Pos, []*Block{right, end}, left)
+	} else {
+		b.end(OP_BRANCH, expression.Pos, []*Block{end, right}, left)
+	}
+
+	b.start(right)
+	b.leave(&arms, end, b.expression(expression.Right))
+
+	return b.join(arms, end, types.Bool, copyOf(before), expression.Pos)
+}
+
+func (b *builder) condExpression(expression *parser.Cond) *Value {
+	t := b.info.Types[expression]
+	end := &Block{}
+	before := b.snapshot()
+	var arms []arm
+
+	for _, branch := range expression.Branches {
+		condition := b.expression(branch.Condition)
+		then, next := &Block{}, &Block{}
+		state := b.snapshot()
+
+		b.end(OP_BRANCH, expression.Pos, []*Block{then, next}, condition)
+		b.start(then)
+		b.leave(&arms, end, b.branch(branch.Body, t))
+
+		b.values = state
+		b.start(next)
+	}
+
+	switch {
+	case expression.Else != nil:
+		b.leave(&arms, end, b.branch(expression.Else, t))
+	case pushes(t):
+		b.stop(OP_UNREACHABLE, expression.Pos, nil)
+	default:
+		b.leave(&arms, end, nil)
+	}
+
+	return b.join(arms, end, t, before, expression.Pos)
+}
+
+/* Patterns */
+
+type binding struct {
+	node  any
+	value *Value
+}
+
+// caseExpression tests the branches in order. A branch whose pattern always
+// matches ends the tests.
+func (b *builder) caseExpression(expression *parser.Case) *Value {
+	t := b.info.Types[expression]
+	subject := b.expression(expression.Subject)
+	subjectType := b.info.Types[expression.Subject]
+	end := &Block{}
+	before := b.snapshot()
+	var arms []arm
+
+	matched := false
+	for _, branch := range expression.Branches {
+		next := &Block{}
+		state := b.snapshot()
+
+		var bindings []binding
+		tested := b.pattern(branch.Pattern, subject, subjectType, next, &bindings)
+		for _, binding := range bindings {
+			b.bind(binding.node, binding.value)
+		}
+		b.leave(&arms, end, b.branch(branch.Body, t))
+
+		if !tested {
+			matched = true
+			break
+		}
+
+		b.values = state
+		b.start(next)
+	}
+
+	if !matched {
+		if pushes(t) {
+			b.stop(OP_UNREACHABLE, expression.Pos, nil)
+		} else {
+			b.leave(&arms, end, nil)
+		}
+	}
+
+	return b.join(arms, end, t, before, expression.Pos)
+}
+
+// pattern tests a pattern against a value and collects the bindings it
+// makes. Every test that fails continues in the fail block. It reports
+// whether the pattern can fail at all.
+func (b *builder) pattern(pattern parser.Pattern, value *Value, t types.Type, fail *Block, bindings *[]binding) bool {
+	switch pattern := pattern.(type) {
+	case *parser.LiteralPattern:
+		var expression parser.Expression = pattern.Literal
+		if pattern.Negative {
+			expression = &parser.Unary{Operator: lexer.MINUS_SIGN, Operand: pattern.Literal, Pos: pattern.Literal.Pos}
+		}
+
+		constant, ok := consteval.Fold(expression)
+		if !ok {
+			b.errorf(pattern.Literal.Pos, diagnostics.UNSUPPORTED, "Pattern %s cannot be compiled", pattern.Literal.Value)
+			return false
+		}
+		b.test(b.emit(OP_EQ, types.Bool, pattern.Literal.Pos, value, b.literal(constant, t, pattern.Literal.Pos)), fail)
+		return true
+	case *parser.NilPattern:
+		b.stop(OP_JUMP, pattern.Pos, []*Block{fail})
+		return true
+	case *parser.BindingPattern:
+		*bindings = append(*bindings, binding{pattern, value})
+		return false
+	case *parser.StructPattern:
+		structure := b.info.Defs[pattern.Type].(*types.Struct)
+		tested := false
+
+		if _, ok := t.(*types.Trait); ok {
+			is := b.emit(OP_IS, types.Bool, pattern.Type.Identifer.Pos, value)
+			is.Struct = structure
+			b.test(is, fail)
+			value = b.emit(OP_CONVERT, structure, pattern.Type.Identifer.Pos, value)
+			tested = true
+		}
+
+		for _, field := range pattern.Fields {
+			idx := fieldIndex(structure, field.Identifer.Name)
+			element := b.emit(OP_FIELD, structure.Fields[idx].Type, field.Identifer.Pos, value)
+			element.Index = idx
+			tested = b.pattern(field.Pattern, element, structure.Fields[idx].Type, fail, bindings) || tested
+		}
+		return tested
+	}
+
+	return false
+}
+
+// test continues in a new block if a condition holds and in the fail block
+// otherwise.
+func (b *builder) test(condition *Value, fail *Block) {
+	match := &Block{}
+	b.end(OP_BRANCH, condition.Pos, []*Block{match, fail}, condition)
+	b.start(match)
+}
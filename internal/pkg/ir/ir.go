@@ -0,0 +1,403 @@
+package ir
+
+import (
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/consteval"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/lexer"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/parser"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/types"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
+)
+
+// Program is the IR of a set of programs. Init runs the initialisers of the
+// global bindings before Main, which is nil if there is no main function.
+type Program struct {
+	Globals   []*Global
+	Functions []*Function
+	Init      *Function
+	Main      *Function
+	// Structs and Traits are the declared types in the order they are
+	// declared
+	Structs []*types.Struct
+	Traits  []*types.Trait
+	// Methods maps every struct to its methods by name, including the
+	// default methods of the traits it implements
+	Methods map[*types.Struct]map[string]*Function
+}
+
+type Global struct {
+	Name string
+	Type types.Type
+}
+
+// Function is a function or method. External functions have no blocks, the
+// first block of all others is their entry. Public functions are declared
+// pub in a top scope, backends export them. Methods that take self have a
+// receiver, self is their first parameter.
+type Function struct {
+	Name     string
+	Decl     *parser.Function
+	Params   []*Value
+	Result   types.Type
+	Blocks   []*Block
+	External bool
+	Public   bool
+	Receiver bool
+}
+
+// Block is a sequence of instructions that ends with exactly one
+// terminator.
+type Block struct {
+	Instrs []*Value
+}
+
+type Op int
+
+const (
+	// Values that are not part of a block
+	OP_CONST Op = iota
+	OP_PARAM
+	OP_FUNCTION
+
+	// Instructions
+	OP_NEG
+	OP_NOT
+	OP_ADD
+	OP_SUB
+	OP_MUL
+	OP_DIV
+	OP_POW
+	OP_SHL
+	OP_SHR
+	OP_ASHR
+	OP_CSHL
+	OP_CSHR
+	OP_AND
+	OP_OR
+	OP_XOR
+	OP_EQ
+	OP_NE
+	OP_LT
+	OP_LE
+	OP_GT
+	OP_GE
+	OP_CONVERT
+	OP_FORMAT
+	OP_STRUCT
+	OP_FIELD
+	OP_INSERT
+	OP_INDEX
+	OP_SET_INDEX
+	OP_IS
+	OP_LOAD
+	OP_STORE
+	OP_CALL
+	OP_CALL_VALUE
+	OP_CALL_METHOD
+	OP_PHI
+
+	// Terminators
+	OP_JUMP
+	OP_BRANCH
+	OP_RETURN
+	OP_UNREACHABLE
+)
+
+// Side effects of instructions. Instructions that may stop the program can
+// only be removed if their result is known, calls and stores are always
+// kept.
+const (
+	PURE = iota
+	TRAPS
+	EFFECTS
+)
+
+var ops = [...]struct {
+	name     string
+	operator lexer.TokenType
+	effect   int
+}{
+	OP_CONST:       {"const", "", PURE},
+	OP_PARAM:       {"param", "", PURE},
+	OP_FUNCTION:    {"function", "", PURE},
+	OP_NEG:         {"neg", lexer.MINUS_SIGN, TRAPS},
+	OP_NOT:         {"not", lexer.KEYWORD_NOT, PURE},
+	OP_ADD:         {"add", lexer.PLUS_SIGN, TRAPS},
+	OP_SUB:         {"sub", lexer.MINUS_SIGN, TRAPS},
+	OP_MUL:         {"mul", lexer.STAR_SIGN, TRAPS},
+	OP_DIV:         {"div", lexer.SLASH_SIGN, TRAPS},
+	OP_POW:         {"pow", lexer.CIRCUMFLEX, TRAPS},
+	OP_SHL:         {"shl", lexer.KEYWORD_SHL, TRAPS},
+	OP_SHR:         {"shr", lexer.KEYWORD_SHR, TRAPS},
+	OP_ASHR:        {"ashr", lexer.KEYWORD_ASHR, TRAPS},
+	OP_CSHL:        {"cshl", lexer.KEYWORD_CSHL, TRAPS},
+	OP_CSHR:        {"cshr", lexer.KEYWORD_CSHR, TRAPS},
+	OP_AND:         {"and", lexer.KEYWORD_AND, PURE},
+	OP_OR:          {"or", lexer.KEYWORD_OR, PURE},
+	OP_XOR:         {"xor", lexer.KEYWORD_XOR, PURE},
+	OP_EQ:          {"eq", lexer.EQUALS, PURE},
+	OP_NE:          {"ne", lexer.NOT_EQUALS, PURE},
+	OP_LT:          {"lt", lexer.LESS_THAN, PURE},
+	OP_LE:          {"le", lexer.LESS_THAN_OR_EQUALS, PURE},
+	OP_GT:          {"gt", lexer.GREATER_THAN, PURE},
+	OP_GE:          {"ge", lexer.GREATER_THAN_OR_EQUALS, PURE},
+	OP_CONVERT:     {"convert", "", PURE},
+	OP_FORMAT:      {"format", "", PURE},
+	OP_STRUCT:      {"struct", "", PURE},
+	OP_FIELD:       {"field", "", PURE},
+	OP_INSERT:      {"insert", "", PURE},
+	OP_INDEX:       {"index", "", TRAPS},
+	OP_SET_INDEX:   {"set_index", "", TRAPS},
+	OP_IS:          {"is", "", PURE},
+	OP_LOAD:        {"load", "", PURE},
+	OP_STORE:       {"store", "", EFFECTS},
+	OP_CALL:        {"call", "", EFFECTS},
+	OP_CALL_VALUE:  {"call", "", EFFECTS},
+	OP_CALL_METHOD: {"call", "", EFFECTS},
+	OP_PHI:         {"phi", "", PURE},
+	OP_JUMP:        {"jump", "", EFFECTS},
+	OP_BRANCH:      {"branch", "", EFFECTS},
+	OP_RETURN:      {"ret", "", EFFECTS},
+	OP_UNREACHABLE: {"unreachable", "", EFFECTS},
+}
+
+func (op Op) String() string {
+	return ops[op].name
+}
+
+// Operator returns the operator an arithmetic, bitwise or comparison
+// instruction applies, it is empty for all other instructions.
+func (op Op) Operator() lexer.TokenType {
+	return ops[op].operator
+}
+
+// Value is a constant, a parameter, a function or an instruction and the
+// value it computes. Each instruction is assigned exactly once and its
+// operands are the values in Args. Instructions without a result have the
+// type void.
+type Value struct {
+	Op   Op
+	Type types.Type
+	Args []*Value
+	// Const is the value of a constant, Name the name of a parameter or the
+	// method that is called
+	Const consteval.Value
+	Name  string
+	// Index is the field of a struct that is read or replaced
+	Index    int
+	Function *Function
+	Global   *Global
+	// Struct is the type a trait value is tested for
+	Struct *types.Struct
+	// Blocks are the targets of a terminator, or the predecessors the
+	// arguments of a phi come from
+	Blocks []*Block
+	Pos    util.Position
+}
+
+// IsTerminator reports whether an instruction ends a block.
+func (value *Value) IsTerminator() bool {
+	return value.Op >= OP_JUMP
+}
+
+// Effect tells whether an instruction is pure, may stop the program or has
+// other side effects.
+func (value *Value) Effect() int {
+	return ops[value.Op].effect
+}
+
+func (value *Value) isConst() bool {
+	return value != nil && value.Op == OP_CONST
+}
+
+/* Blocks */
+
+// Terminator returns the last instruction of a block.
+func (block *Block) Terminator() *Value {
+	if len(block.Instrs) == 0 {
+		return nil
+	}
+
+	return block.Instrs[len(block.Instrs)-1]
+}
+
+// Successors returns the blocks a block continues in.
+func (block *Block) Successors() []*Block {
+	if terminator := block.Terminator(); terminator != nil {
+		return terminator.Blocks
+	}
+
+	return nil
+}
+
+// Predecessors maps every block of a function to the blocks that continue
+// in it.
+func (function *Function) Predecessors() map[*Block][]*Block {
+	predecessors := map[*Block][]*Block{}
+	for _, block := range function.Blocks {
+		for _, successor := range block.Successors() {
+			if !contains(predecessors[successor], block) {
+				predecessors[successor] = append(predecessors[successor], block)
+			}
+		}
+	}
+
+	return predecessors
+}
+
+func contains(blocks []*Block, block *Block) bool {
+	for _, candidate := range blocks {
+		if candidate == block {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Order returns the reachable blocks of a function in reverse postorder,
+// where every block comes before its successors unless they loop back.
+func (function *Function) Order() []*Block {
+	if len(function.Blocks) == 0 {
+		return nil
+	}
+
+	visited := map[*Block]bool{}
+	var postorder []*Block
+	var visit func(block *Block)
+	visit = func(block *Block) {
+		visited[block] = true
+		for _, successor := range block.Successors() {
+			if !visited[successor] {
+				visit(successor)
+			}
+		}
+		postorder = append(postorder, block)
+	}
+	visit(function.Blocks[0])
+
+	for i, j := 0, len(postorder)-1; i < j; i, j = i+1, j-1 {
+		postorder[i], postorder[j] = postorder[j], postorder[i]
+	}
+
+	return postorder
+}
+
+/* Rewriting */
+
+// replace substitutes the values in a function by their replacements and
+// removes the replaced instructions.
+func (function *Function) replace(replacements map[*Value]*Value) {
+	if len(replacements) == 0 {
+		return
+	}
+
+	resolve := func(value *Value) *Value {
+		for {
+			replacement, ok := replacements[value]
+			if !ok {
+				return value
+			}
+			value = replacement
+		}
+	}
+
+	for _, block := range function.Blocks {
+		instrs := block.Instrs[:0]
+		for _, instr := range block.Instrs {
+			if _, ok := replacements[instr]; ok {
+				continue
+			}
+
+			for idx, arg := range instr.Args {
+				instr.Args[idx] = resolve(arg)
+			}
+			instrs = append(instrs, instr)
+		}
+		block.Instrs = instrs
+	}
+}
+
+// removeEdge removes the arguments a block passes to the phis of one of its
+// successors.
+func removeEdge(from *Block, to *Block) {
+	for _, instr := range to.Instrs {
+		if instr.Op != OP_PHI {
+			break
+		}
+
+		for idx := 0; idx < len(instr.Blocks); idx++ {
+			if instr.Blocks[idx] == from {
+				instr.Args = append(instr.Args[:idx:idx], instr.Args[idx+1:]...)
+				instr.Blocks = append(instr.Blocks[:idx:idx], instr.Blocks[idx+1:]...)
+				idx--
+			}
+		}
+	}
+}
+
+// cleanup removes the blocks that cannot be reached and the phis that only
+// have a single value.
+func (function *Function) cleanup() bool {
+	changed := false
+	reachable := map[*Block]bool{}
+	for _, block := range function.Order() {
+		reachable[block] = true
+	}
+
+	blocks := function.Blocks[:0]
+	for _, block := range function.Blocks {
+		if reachable[block] {
+			blocks = append(blocks, block)
+			continue
+		}
+
+		for _, successor := range block.Successors() {
+			removeEdge(block, successor)
+		}
+		changed = true
+	}
+	function.Blocks = blocks
+
+	for {
+		replacements := map[*Value]*Value{}
+		for _, block := range function.Blocks {
+			for _, instr := range block.Instrs {
+				if instr.Op != OP_PHI {
+					continue
+				}
+
+				if value := trivialPhi(instr); value != nil {
+					replacements[instr] = value
+				}
+			}
+		}
+
+		if len(replacements) == 0 {
+			return changed
+		}
+		function.replace(replacements)
+		changed = true
+	}
+}
+
+// trivialPhi returns the only value a phi can have, apart from itself, or
+// nil if it has several.
+func trivialPhi(phi *Value) *Value {
+	var only *Value
+	for _, arg := range phi.Args {
+		switch {
+		case arg == phi || arg == only:
+		case only == nil:
+			only = arg
+		case arg.isConst() && only.isConst() && sameConst(arg, only):
+		default:
+			return nil
+		}
+	}
+
+	return only
+}
+
+func sameConst(a *Value, b *Value) bool {
+	return a.Type == b.Type && a.Const.Type == b.Const.Type && a.Const.String() == b.Const.String()
+}
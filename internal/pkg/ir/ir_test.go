@@ -0,0 +1,178 @@
+package ir_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/ir"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/testutil"
+)
+
+type testStruct struct {
+	input string
+	want  string
+}
+
+func build(t *testing.T, input string) *ir.Program {
+	return testutil.Build(t, 0, input)
+}
+
+// testHelper builds every input, runs a pass over it and expects the text of
+// the program to contain the wanted function.
+func testHelper(t *testing.T, tests []testStruct, pass func(program *ir.Program)) {
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			program := build(t, test.input)
+			if err := ir.Verify(program); err != nil {
+				t.Fatalf("invalid program after building: %s\n%s", err, program)
+			}
+
+			pass(program)
+			if err := ir.Verify(program); err != nil {
+				t.Fatalf("invalid program after the pass: %s\n%s", err, program)
+			}
+
+			if actual := program.String(); !strings.Contains(actual, test.want) {
+				t.Errorf("expected\n%s\nin\n%s", test.want, actual)
+			}
+		})
+	}
+}
+
+func TestBuild(t *testing.T) {
+	shapes := "struct C {\n\tr: i64\n}\nstruct S {\n\ts: i64\n}\ntrait Shape {\n\tfn area(self) -> i64\n}\n" +
+		"impl Shape for C {\n\tfn area(self) -> i64 { self.r * self.r * 3 }\n}\n" +
+		"impl Shape for S {\n\tfn area(self) -> i64 { self.s * self.s }\n}\n"
+
+	testHelper(t, []testStruct{
+		{"fn add(a: i8, b: i8) -> i8 {\n\ta + b\n}",
+			"fn @add(%0 a: i8, %1 b: i8) -> i8 {\nb0:\n\t%2: i8 = add %0, %1\n\tret %2\n}\n"},
+		{"fn f(a: i64) -> i64 {\n\tlet! b = 1\n\tif a > 2 {\n\t\tb = a\n\t}\n\tb * 2\n}",
+			"fn @f(%0 a: i64) -> i64 {\nb0:\n\t%1: bool = gt %0, 2\n\tbranch %1, b1, b2\nb1:\n\tjump b2\nb2:\n\t%2: i64 = phi [1, b0], [%0, b1]\n\t%3: i64 = mul %2, 2\n\tret %3\n}\n"},
+		{"fn f(a: i32) -> i32 {\n\tcond {\n\t\ta < 0 -> 0\n\t\ta < 10 -> a\n\t\telse -> 10\n\t}\n}",
+			"fn @f(%0 a: i32) -> i32 {\nb0:\n\t%1: bool = lt %0, 0\n\tbranch %1, b1, b2\nb1:\n\tjump b5\nb2:\n\t%2: bool = lt %0, 10\n\tbranch %2, b3, b4\nb3:\n\tjump b5\nb4:\n\tjump b5\nb5:\n\t%3: i32 = phi [0, b1], [%0, b3], [10, b4]\n\tret %3\n}\n"},
+		{"fn f(a: bool, b: bool) -> bool {\n\ta && b\n}",
+			"fn @f(%0 a: bool, %1 b: bool) -> bool {\nb0:\n\tbranch %0, b1, b2\nb1:\n\tjump b2\nb2:\n\t%2: bool = phi [%0, b0], [%1, b1]\n\tret %2\n}\n"},
+		{"fn f(a: i64) -> i64 {\n\tif a < 0 {\n\t\treturn 0\n\t}\n\ta\n}",
+			"fn @f(%0 a: i64) -> i64 {\nb0:\n\t%1: bool = lt %0, 0\n\tbranch %1, b1, b2\nb1:\n\tret 0\nb2:\n\tret %0\n}\n"},
+		{"let! count: u32 = 1\nfn f() {\n\tcount = count + 2\n}",
+			"global @count: u32\n\nfn @f() {\nb0:\n\t%0: u32 = load @count\n\t%1: u32 = add %0, 2\n\tstore @count, %1\n\tret\n}\n\nfn @<init>() {\nb0:\n\tstore @count, 1\n\tret\n}\n"},
+		{"struct P {\n\tx: i64\n\ty: f64\n}\nfn f(a: i64) -> P {\n\tlet! p = P { y: 1, x: a }\n\tp.x = 2\n\tp\n}",
+			"fn @f(%0 a: i64) -> P {\nb0:\n\t%1: P = struct %0, 1.0\n\t%2: P = insert %1, x, 2\n\tret %2\n}\n"},
+		{"fn f(a: i16) -> i64 {\n\tlet b: i64 = a\n\tlet c: f32 = 2\n\tb shl 1\n}",
+			"fn @f(%0 a: i16) -> i64 {\nb0:\n\t%1: i64 = convert %0\n\t%2: i64 = shl %1, 1\n\tret %2\n}\n"},
+		{"fn f(a: bin) -> u8 {\n\tlet! b = a\n\tb[1] = 7\n\tb[1]\n}",
+			"fn @f(%0 a: bin) -> u8 {\nb0:\n\t%1: bin = set_index %0, 1, 7\n\t%2: u8 = index %1, 1\n\tret %2\n}\n"},
+		{"fn inc(a: i64) -> i64 { a + 1 }\nfn f() -> i64 {\n\tlet g = inc\n\tg(1) + inc(2)\n}",
+			"fn @f() -> i64 {\nb0:\n\t%0: i64 = call @inc(1)\n\t%1: i64 = call @inc(2)\n\t%2: i64 = add %0, %1\n\tret %2\n}\n"},
+		{"fn f(a: i32, b: bin) -> bin {\n\t\"a{a}{b}.\"\n}",
+			"fn @f(%0 a: i32, %1 b: bin) -> bin {\nb0:\n\t%2: bin = format %0\n\t%3: bin = add \"a\", %2\n\t%4: bin = add %3, %1\n\t%5: bin = add %4, \".\"\n\tret %5\n}\n"},
+		{"ext fn puts(s: bin) -> i32\nfn main() -> i32 {\n\tputs(\"hi\")\n}",
+			"extern fn @puts(s: bin) -> i32\n\nfn @main() -> i32 {\nb0:\n\t%0: i32 = call @puts(\"hi\")\n\tret %0\n}\n"},
+		{shapes + "fn f(s: Shape) -> i64 {\n\ts.area()\n}",
+			"fn @f(%0 s: Shape) -> i64 {\nb0:\n\t%1: i64 = call %0.area()\n\tret %1\n}\n"},
+		{shapes + "fn f(s: Shape) -> i64 {\n\tcase s {\n\t\tC { r: 1 } -> 0\n\t\tC { r } -> r\n\t\t_ -> 2\n\t}\n}",
+			"fn @f(%0 s: Shape) -> i64 {\nb0:\n\t%1: bool = is %0, C\n\tbranch %1, b1, b3\nb1:\n\t%2: C = convert %0\n\t%3: i64 = field %2, r\n\t%4: bool = eq %3, 1\n\tbranch %4, b2, b3\nb2:\n\tjump b6\nb3:\n\t%5: bool = is %0, C\n\tbranch %5, b4, b5\nb4:\n\t%6: C = convert %0\n\t%7: i64 = field %6, r\n\tjump b6\nb5:\n\tjump b6\nb6:\n\t%8: i64 = phi [0, b2], [%7, b4], [2, b5]\n\tret %8\n}\n"},
+		{shapes + "fn f() -> i64 {\n\tlet s: Shape = C { r: 2 }\n\ts.area()\n}",
+			"fn @f() -> i64 {\nb0:\n\t%0: C = struct 2\n\t%1: Shape = convert %0\n\t%2: i64 = call %1.area()\n\tret %2\n}\n"},
+	}, func(program *ir.Program) {})
+}
+
+func TestPropagateConstants(t *testing.T) {
+	testHelper(t, []testStruct{
+		{"fn f() -> i64 {\n\tlet a: i64 = 2\n\tlet b = a * 3\n\tb + a\n}",
+			"fn @f() -> i64 {\nb0:\n\tret 8\n}\n"},
+		{"fn f(a: i64) -> i64 {\n\tlet b: i64 = 4\n\tif b > 3 {\n\t\ta\n\t} else {\n\t\tb\n\t}\n}",
+			"fn @f(%0 a: i64) -> i64 {\nb0:\n\tjump b1\nb1:\n\tjump b2\nb2:\n\tret %0\n}\n"},
+		{"fn f() -> u8 {\n\tlet a: u8 = 200\n\ta + 100\n}",
+			"fn @f() -> u8 {\nb0:\n\t%0: u8 = add 200, 100\n\tret %0\n}\n"},
+		{"fn f() -> i32 {\n\tlet a: i32 = 0\n\t10 / a\n}",
+			"fn @f() -> i32 {\nb0:\n\t%0: i32 = div 10, 0\n\tret %0\n}\n"},
+		{"fn f() -> num {\n\tlet a: i8 = -3\n\tlet b: num = a\n\tb / 2\n}",
+			"fn @f() -> num {\nb0:\n\tret -1.5\n}\n"},
+		{"fn f() -> bin {\n\tlet a: f32 = 1.1\n\t\"{a} {true}\"\n}",
+			"fn @f() -> bin {\nb0:\n\tret \"1.1 true\"\n}\n"},
+		{"fn f() -> u8 {\n\tlet! b = \"abc\"\n\tb[0] = 65\n\tb[0] xor b[2]\n}",
+			"fn @f() -> u8 {\nb0:\n\tret 34\n}\n"},
+		{"struct P {\n\tx: i64\n}\nfn f(a: i64) -> i64 {\n\tlet! p = P { x: a }\n\tp.x = p.x + 1\n\tp.x\n}",
+			"fn @f(%0 a: i64) -> i64 {\nb0:\n\t%1: P = struct %0\n\t%2: i64 = add %0, 1\n\t%3: P = insert %1, x, %2\n\tret %2\n}\n"},
+		{"struct C {\n\tr: i64\n}\ntrait Shape {\n\tfn area(self) -> i64\n}\nimpl Shape for C {\n\tfn area(self) -> i64 { self.r }\n}\n" +
+			"fn f() -> i64 {\n\tlet s: Shape = C { r: 2 }\n\tcase s {\n\t\tC { r } -> s.area() + r\n\t\t_ -> 0\n\t}\n}",
+			"fn @f() -> i64 {\nb0:\n\t%0: C = struct 2\n\t%1: Shape = convert %0\n\tjump b1\nb1:\n\t%2: i64 = call @C.area(%0)\n\t%3: i64 = add %2, 2\n\tjump b2\nb2:\n\tret %3\n}\n"},
+	}, func(program *ir.Program) { ir.PropagateConstants(program) })
+}
+
+func TestEliminateDeadCode(t *testing.T) {
+	testHelper(t, []testStruct{
+		{"fn f(a: i64) -> i64 {\n\tlet b = a == 3\n\tlet c = a and 2\n\ta\n}",
+			"fn @f(%0 a: i64) -> i64 {\nb0:\n\tret %0\n}\n"},
+		{"fn f(a: i64) -> i64 {\n\tlet b = a + 1\n\ta\n}",
+			"fn @f(%0 a: i64) -> i64 {\nb0:\n\t%1: i64 = add %0, 1\n\tret %0\n}\n"},
+		{"fn g() -> i64 { 1 }\nfn f() -> i64 {\n\tlet a = g()\n\t2\n}",
+			"fn @f() -> i64 {\nb0:\n\t%0: i64 = call @g()\n\tret 2\n}\n"},
+		{"fn f(a: bool) -> i64 {\n\tlet! b = 1\n\tif a {\n\t\tb = 2\n\t}\n\t3\n}",
+			"fn @f(%0 a: bool) -> i64 {\nb0:\n\tbranch %0, b1, b2\nb1:\n\tjump b2\nb2:\n\tret 3\n}\n"},
+	}, func(program *ir.Program) { ir.EliminateDeadCode(program) })
+}
+
+func TestEliminateCommonSubexpressions(t *testing.T) {
+	testHelper(t, []testStruct{
+		{"fn f(a: i64, b: i64) -> i64 {\n\t(a + b) * (a + b)\n}",
+			"fn @f(%0 a: i64, %1 b: i64) -> i64 {\nb0:\n\t%2: i64 = add %0, %1\n\t%3: i64 = mul %2, %2\n\tret %3\n}\n"},
+		{"fn f(a: i64, b: i64) -> i64 {\n\tlet c = a * 2\n\tif b > 0 {\n\t\ta * 2\n\t} else {\n\t\tc\n\t}\n}",
+			"fn @f(%0 a: i64, %1 b: i64) -> i64 {\nb0:\n\t%2: i64 = mul %0, 2\n\t%3: bool = gt %1, 0\n\tbranch %3, b1, b2\nb1:\n\tjump b3\nb2:\n\tjump b3\nb3:\n\t%4: i64 = phi [%2, b1], [%2, b2]\n\tret %4\n}\n"},
+		{"fn f(a: bool, b: i64) -> i64 {\n\tif a {\n\t\tb + 1\n\t} else {\n\t\tb + 1\n\t}\n}",
+			"b1:\n\t%2: i64 = add %1, 1\n\tjump b3\nb2:\n\t%3: i64 = add %1, 1\n\tjump b3\n"},
+		{"let! g: i64 = 1\nfn f() -> i64 {\n\tg + g\n}",
+			"fn @f() -> i64 {\nb0:\n\t%0: i64 = load @g\n\t%1: i64 = load @g\n\t%2: i64 = add %0, %1\n\tret %2\n}\n"},
+	}, func(program *ir.Program) { ir.EliminateCommonSubexpressions(program) })
+}
+
+func TestInlineCalls(t *testing.T) {
+	testHelper(t, []testStruct{
+		{"fn add(a: i64, b: i64) -> i64 { a + b }\nfn f(c: i64) -> i64 {\n\tadd(c, 1) * 2\n}",
+			"fn @f(%0 c: i64) -> i64 {\nb0:\n\tjump b1\nb1:\n\t%1: i64 = add %0, 1\n\tjump b2\nb2:\n\t%2: i64 = mul %1, 2\n\tret %2\n}\n"},
+		{"fn abs(a: i64) -> i64 {\n\tif a < 0 {\n\t\treturn -a\n\t}\n\ta\n}\nfn f(c: i64) -> i64 {\n\tabs(c)\n}",
+			"fn @f(%0 c: i64) -> i64 {\nb0:\n\tjump b1\nb1:\n\t%1: bool = lt %0, 0\n\tbranch %1, b2, b3\nb2:\n\t%2: i64 = neg %0\n\tjump b4\nb3:\n\tjump b4\nb4:\n\t%3: i64 = phi [%2, b2], [%0, b3]\n\tret %3\n}\n"},
+		{"fn f(n: i64) -> i64 {\n\tif n < 2 {\n\t\treturn n\n\t}\n\tf(n - 1)\n}\nfn g() -> i64 {\n\tf(3)\n}",
+			"fn @g() -> i64 {\nb0:\n\t%0: i64 = call @f(3)\n\tret %0\n}\n"},
+	}, func(program *ir.Program) { ir.InlineCalls(program) })
+}
+
+func TestOptimize(t *testing.T) {
+	source := "fn sq(a: i64) -> i64 { a * a }\nfn f(b: i64) -> i64 {\n\tlet c: i64 = 3\n\tsq(c) + sq(b) + sq(b)\n}"
+
+	for _, test := range []struct {
+		level int
+		want  string
+	}{
+		{0, "fn @f(%0 b: i64) -> i64 {\nb0:\n\t%1: i64 = call @sq(3)\n\t%2: i64 = call @sq(%0)\n\t%3: i64 = add %1, %2\n\t%4: i64 = call @sq(%0)\n\t%5: i64 = add %3, %4\n\tret %5\n}\n"},
+		{1, "fn @f(%0 b: i64) -> i64 {\nb0:\n\t%1: i64 = call @sq(3)\n\t%2: i64 = call @sq(%0)\n\t%3: i64 = add %1, %2\n\t%4: i64 = call @sq(%0)\n\t%5: i64 = add %3, %4\n\tret %5\n}\n"},
+		{2, "fn @f(%0 b: i64) -> i64 {\nb0:\n\t%1: i64 = mul %0, %0\n\t%2: i64 = add 9, %1\n\t%3: i64 = add %2, %1\n\tret %3\n}\n"},
+	} {
+		program := build(t, source)
+		ir.Optimize(program, test.level)
+		if err := ir.Verify(program); err != nil {
+			t.Fatalf("invalid program at level %d: %s\n%s", test.level, err, program)
+		}
+
+		if actual := program.String(); !strings.Contains(actual, test.want) {
+			t.Errorf("expected\n%s\nat level %d in\n%s", test.want, test.level, actual)
+		}
+	}
+}
+
+func TestReceiver(t *testing.T) {
+	program := build(t, "struct P { x: i64 }\nimpl P {\n\tfn new() -> P { P { x: 0 } }\n\tfn get(self) -> i64 { self.x }\n}\nfn f() -> i64 { P.new().get() }")
+
+	receivers := map[string]bool{}
+	for _, function := range program.Functions {
+		receivers[function.Name] = function.Receiver
+	}
+
+	want := map[string]bool{"P.new": false, "P.get": true, "f": false, "<init>": false}
+	if !reflect.DeepEqual(receivers, want) {
+		t.Errorf("expected the receivers %v but got %v", want, receivers)
+	}
+}
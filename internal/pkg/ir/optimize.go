@@ -0,0 +1,599 @@
+package ir
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/consteval"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/types"
+)
+
+// The largest number of instructions a function may have to be inlined.
+const INLINE_LIMIT = 24
+
+// The number of times the passes are repeated at most.
+const MAX_ROUNDS = 8
+
+// Optimize runs the passes of an optimisation level on a program. Level 1
+// propagates constants and eliminates common subexpressions and dead code,
+// level 2 also inlines small functions. The passes are repeated as long as
+// they find something to improve.
+func Optimize(program *Program, level int) {
+	if level <= 0 {
+		return
+	}
+
+	for round := 0; round < MAX_ROUNDS; round++ {
+		changed := false
+		if level >= 2 {
+			changed = InlineCalls(program) || changed
+		}
+		changed = PropagateConstants(program) || changed
+		changed = EliminateCommonSubexpressions(program) || changed
+		changed = EliminateDeadCode(program) || changed
+
+		if !changed {
+			return
+		}
+	}
+}
+
+func (program *Program) each(pass func(function *Function) bool) bool {
+	changed := false
+	for _, function := range program.Functions {
+		if !function.External {
+			changed = pass(function) || changed
+		}
+	}
+
+	return changed
+}
+
+/* Constant propagation */
+
+// PropagateConstants computes the instructions whose operands are constants
+// at compile time and replaces their uses with the result. Branches on
+// constants become jumps and the blocks they no longer reach are removed.
+// Calls of function values and trait methods whose function is known become
+// direct calls. Instructions that would stop the program are kept.
+func PropagateConstants(program *Program) bool {
+	return program.each(func(function *Function) bool {
+		return propagate(program, function)
+	})
+}
+
+func propagate(program *Program, function *Function) bool {
+	changed := false
+
+	for {
+		replacements := map[*Value]*Value{}
+		folded := false
+
+		for _, block := range function.Blocks {
+			for _, instr := range block.Instrs {
+				if value := fold(instr); value != nil {
+					replacements[instr] = value
+				}
+				folded = resolveCall(program, instr) || folded
+			}
+
+			terminator := block.Terminator()
+			if terminator.Op != OP_BRANCH || !terminator.Args[0].isConst() {
+				continue
+			}
+
+			taken, dropped := terminator.Blocks[0], terminator.Blocks[1]
+			if !terminator.Args[0].Const.Bool {
+				taken, dropped = dropped, taken
+			}
+			if taken != dropped {
+				removeEdge(block, dropped)
+			}
+
+			terminator.Op, terminator.Args, terminator.Blocks = OP_JUMP, nil, []*Block{taken}
+			folded = true
+		}
+
+		if len(replacements) == 0 && !folded {
+			return changed
+		}
+
+		function.replace(replacements)
+		function.cleanup()
+		changed = true
+	}
+}
+
+// fold returns the value an instruction is known to have, or nil.
+func fold(instr *Value) *Value {
+	args := instr.Args
+	constants := len(args) > 0
+	for _, arg := range args {
+		constants = constants && arg.isConst()
+	}
+
+	switch {
+	case instr.Op == OP_PHI:
+		return trivialPhi(instr)
+	case instr.Op == OP_FIELD && args[0] != nil && args[0].Op == OP_STRUCT:
+		return args[0].Args[instr.Index]
+	case instr.Op == OP_FIELD && args[0] != nil && args[0].Op == OP_INSERT && args[0].Index == instr.Index:
+		return args[0].Args[1]
+	case instr.Op == OP_IS && args[0] != nil && args[0].Op == OP_CONVERT:
+		if structure, ok := args[0].Args[0].Type.(*types.Struct); ok {
+			return constBool(structure == instr.Struct)
+		}
+	case instr.Op == OP_CONVERT && args[0] != nil && args[0].Op == OP_CONVERT && args[0].Args[0].Type == instr.Type:
+		return args[0].Args[0]
+	case !constants:
+	case instr.Op == OP_NEG || instr.Op == OP_NOT:
+		if result, err := consteval.Unary(ops[instr.Op].operator, args[0].Const); err == nil {
+			return &Value{Op: OP_CONST, Type: instr.Type, Const: result}
+		}
+	case instr.Op >= OP_ADD && instr.Op <= OP_GE:
+		if result, err := consteval.Binary(ops[instr.Op].operator, args[0].Const, args[1].Const); err == nil {
+			return &Value{Op: OP_CONST, Type: instr.Type, Const: result}
+		}
+	case instr.Op == OP_CONVERT:
+		if target, ok := instr.Type.(*types.Basic); ok {
+			if result, ok := convertConst(args[0].Const, target); ok {
+				return &Value{Op: OP_CONST, Type: instr.Type, Const: result}
+			}
+		}
+	case instr.Op == OP_FORMAT:
+		return &Value{Op: OP_CONST, Type: instr.Type, Const: consteval.Value{Type: types.Bin, Bytes: consteval.Format(args[0].Const)}}
+	case instr.Op == OP_INDEX && args[0].Const.Type == types.Bin && args[1].Const.Type.IsInteger():
+		bytes, index := args[0].Const.Bytes, args[1].Const.Int
+		if index.Sign() >= 0 && index.Cmp(big.NewInt(int64(len(bytes)))) < 0 {
+			return &Value{Op: OP_CONST, Type: instr.Type, Const: consteval.Value{Type: types.U8, Int: big.NewInt(int64(bytes[index.Int64()]))}}
+		}
+	case instr.Op == OP_SET_INDEX && args[0].Const.Type == types.Bin && args[1].Const.Type.IsInteger() && args[2].Const.Type == types.U8:
+		bytes, index := []byte(args[0].Const.Bytes), args[1].Const.Int
+		if index.Sign() >= 0 && index.Cmp(big.NewInt(int64(len(bytes)))) < 0 {
+			bytes[index.Int64()] = byte(args[2].Const.Int.Uint64())
+			return &Value{Op: OP_CONST, Type: instr.Type, Const: consteval.Value{Type: types.Bin, Bytes: string(bytes)}}
+		}
+	}
+
+	return nil
+}
+
+// resolveCall turns a call of a known function value, or of a method of a
+// trait value whose struct is known, into a direct call.
+func resolveCall(program *Program, instr *Value) bool {
+	if len(instr.Args) == 0 || instr.Args[0] == nil {
+		return false
+	}
+	callee := instr.Args[0]
+
+	switch {
+	case instr.Op == OP_CALL_VALUE && callee.Op == OP_FUNCTION:
+		instr.Op, instr.Function, instr.Args = OP_CALL, callee.Function, instr.Args[1:]
+		return true
+	case instr.Op == OP_CALL_METHOD && callee.Op == OP_CONVERT:
+		structure, ok := callee.Args[0].Type.(*types.Struct)
+		if !ok || program.Methods[structure][instr.Name] == nil {
+			return false
+		}
+
+		// Default methods of traits still take a trait value
+		method := program.Methods[structure][instr.Name]
+		if method.Params[0].Type == structure {
+			instr.Args[0] = callee.Args[0]
+		}
+		instr.Op, instr.Function, instr.Name = OP_CALL, method, ""
+		return true
+	}
+
+	return false
+}
+
+func constBool(value bool) *Value {
+	return &Value{Op: OP_CONST, Type: types.Bool, Const: consteval.Value{Type: types.Bool, Bool: value}}
+}
+
+// convertConst converts a number constant to another number type. Integers
+// that do not fit into an integer type are not converted.
+func convertConst(value consteval.Value, target *types.Basic) (consteval.Value, bool) {
+	switch {
+	case value.Type == nil || !value.Type.IsNumeric() || !target.IsNumeric():
+		return consteval.Value{}, false
+	case value.Type.IsUntyped():
+		converted, err := consteval.Convert(value, target)
+		return converted, err == nil
+	case value.Type.IsInteger() && target.IsInteger():
+		return consteval.Value{Type: target, Int: value.Int}, target.Fits(value.Int)
+	case value.Type.IsInteger():
+		float := new(big.Float).SetInt(value.Int)
+		if target.Kind == types.KIND_F32 {
+			converted, _ := float.Float32()
+			return consteval.Value{Type: target, Float: float64(converted)}, true
+		}
+		converted, _ := float.Float64()
+		return consteval.Value{Type: target, Float: converted}, true
+	case target.IsFloat():
+		if target.Kind == types.KIND_F32 {
+			return consteval.Value{Type: target, Float: float64(float32(value.Float))}, true
+		}
+		return consteval.Value{Type: target, Float: value.Float}, true
+	}
+
+	return consteval.Value{}, false
+}
+
+/* Dead code elimination */
+
+// EliminateDeadCode removes the instructions whose results are never used
+// and the blocks that cannot be reached, and merges blocks with the only
+// block that continues in them.
+func EliminateDeadCode(program *Program) bool {
+	return program.each(func(function *Function) bool {
+		live := map[*Value]bool{}
+		var mark func(value *Value)
+		mark = func(value *Value) {
+			if value == nil || live[value] {
+				return
+			}
+
+			live[value] = true
+			for _, arg := range value.Args {
+				mark(arg)
+			}
+		}
+
+		for _, block := range function.Blocks {
+			for _, instr := range block.Instrs {
+				if instr.Effect() != PURE {
+					mark(instr)
+				}
+			}
+		}
+
+		changed := false
+		for _, block := range function.Blocks {
+			instrs := block.Instrs[:0]
+			for _, instr := range block.Instrs {
+				if live[instr] {
+					instrs = append(instrs, instr)
+				} else {
+					changed = true
+				}
+			}
+			block.Instrs = instrs
+		}
+
+		changed = merge(function) || changed
+		return function.cleanup() || changed
+	})
+}
+
+// merge appends blocks to the block before them if that is the only block
+// that continues in them.
+func merge(function *Function) bool {
+	changed := false
+
+	for {
+		predecessors := function.Predecessors()
+		merged := false
+
+		for _, block := range function.Blocks {
+			terminator := block.Terminator()
+			if terminator.Op != OP_JUMP {
+				continue
+			}
+
+			next := terminator.Blocks[0]
+			if next == block || next == function.Blocks[0] || len(predecessors[next]) != 1 {
+				continue
+			}
+
+			replacements := map[*Value]*Value{}
+			instrs := block.Instrs[:len(block.Instrs)-1]
+			for _, instr := range next.Instrs {
+				if instr.Op == OP_PHI {
+					replacements[instr] = instr.Args[0]
+				} else {
+					instrs = append(instrs, instr)
+				}
+			}
+			block.Instrs = instrs
+
+			for _, successor := range block.Successors() {
+				renameEdge(next, block, successor)
+			}
+			function.removeBlock(next)
+			function.replace(replacements)
+			merged = true
+			break
+		}
+
+		if !merged {
+			return changed
+		}
+		changed = true
+	}
+}
+
+// renameEdge makes the phis of a block take the arguments that came from
+// one predecessor from another one instead.
+func renameEdge(from *Block, to *Block, block *Block) {
+	for _, instr := range block.Instrs {
+		if instr.Op != OP_PHI {
+			break
+		}
+
+		for idx := range instr.Blocks {
+			if instr.Blocks[idx] == from {
+				instr.Blocks[idx] = to
+			}
+		}
+	}
+}
+
+func (function *Function) removeBlock(block *Block) {
+	for idx, candidate := range function.Blocks {
+		if candidate == block {
+			function.Blocks = append(function.Blocks[:idx], function.Blocks[idx+1:]...)
+			return
+		}
+	}
+}
+
+/* Common subexpression elimination */
+
+// EliminateCommonSubexpressions replaces instructions by an instruction
+// that computes the same value and runs before them on every path. Loads
+// and calls are never replaced, as globals can change in between.
+func EliminateCommonSubexpressions(program *Program) bool {
+	return program.each(func(function *Function) bool {
+		dominators := function.dominators()
+		children := map[*Block][]*Block{}
+		for _, block := range function.Order()[1:] {
+			children[dominators[block]] = append(children[dominators[block]], block)
+		}
+
+		replacements := map[*Value]*Value{}
+		available := map[string]*Value{}
+
+		var walk func(block *Block)
+		walk = func(block *Block) {
+			var added []string
+			for _, instr := range block.Instrs {
+				if instr.Op < OP_NEG || instr.Op > OP_IS || instr.Op == OP_LOAD {
+					continue
+				}
+
+				key := expressionKey(instr, replacements)
+				if existing, ok := available[key]; ok {
+					replacements[instr] = existing
+					continue
+				}
+
+				available[key] = instr
+				added = append(added, key)
+			}
+
+			for _, child := range children[block] {
+				walk(child)
+			}
+
+			for _, key := range added {
+				delete(available, key)
+			}
+		}
+		walk(function.Blocks[0])
+
+		function.replace(replacements)
+		return len(replacements) > 0
+	})
+}
+
+// expressionKey describes the value an instruction computes, instructions
+// with the same key compute the same value.
+func expressionKey(instr *Value, replacements map[*Value]*Value) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d %p %d %p", instr.Op, instr.Type, instr.Index, instr.Struct)
+
+	for _, arg := range instr.Args {
+		for replacements[arg] != nil {
+			arg = replacements[arg]
+		}
+
+		switch {
+		case arg.isConst():
+			fmt.Fprintf(&sb, " const %p %p %s", arg.Type, arg.Const.Type, arg.Const)
+		case arg != nil && arg.Op == OP_FUNCTION:
+			fmt.Fprintf(&sb, " function %p", arg.Function)
+		default:
+			fmt.Fprintf(&sb, " %p", arg)
+		}
+	}
+
+	return sb.String()
+}
+
+// dominators maps every reachable block to its immediate dominator, the
+// last block that runs before it on every path from the entry. The entry
+// dominates itself.
+func (function *Function) dominators() map[*Block]*Block {
+	order := function.Order()
+	index := map[*Block]int{}
+	for idx, block := range order {
+		index[block] = idx
+	}
+
+	predecessors := function.Predecessors()
+	dominators := map[*Block]*Block{order[0]: order[0]}
+
+	intersect := func(a *Block, b *Block) *Block {
+		for a != b {
+			for index[a] > index[b] {
+				a = dominators[a]
+			}
+			for index[b] > index[a] {
+				b = dominators[b]
+			}
+		}
+		return a
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, block := range order[1:] {
+			var dominator *Block
+			for _, predecessor := range predecessors[block] {
+				if _, ok := dominators[predecessor]; !ok {
+					continue
+				}
+
+				if dominator == nil {
+					dominator = predecessor
+				} else {
+					dominator = intersect(predecessor, dominator)
+				}
+			}
+
+			if dominators[block] != dominator {
+				dominators[block] = dominator
+				changed = true
+			}
+		}
+	}
+
+	return dominators
+}
+
+/* Inlining */
+
+// InlineCalls replaces direct calls of small functions that do not call
+// themselves by a copy of their body.
+func InlineCalls(program *Program) bool {
+	return program.each(func(function *Function) bool {
+		var calls []*Value
+		for _, block := range function.Blocks {
+			for _, instr := range block.Instrs {
+				if instr.Op == OP_CALL && inlinable(function, instr.Function) {
+					calls = append(calls, instr)
+				}
+			}
+		}
+
+		for _, call := range calls {
+			inline(function, call)
+		}
+
+		if len(calls) == 0 {
+			return false
+		}
+
+		function.cleanup()
+		return true
+	})
+}
+
+func inlinable(caller *Function, callee *Function) bool {
+	if callee.External || callee == caller {
+		return false
+	}
+
+	size := 0
+	for _, block := range callee.Blocks {
+		for _, instr := range block.Instrs {
+			if instr.Op == OP_CALL && instr.Function == callee {
+				return false
+			}
+			size++
+		}
+	}
+
+	return size <= INLINE_LIMIT
+}
+
+// inline splits the block of a call in two and puts a copy of the blocks
+// of the called function in between. Its parameters become the arguments
+// and its returns jump to the second half, where a phi joins the results.
+func inline(function *Function, call *Value) {
+	var block *Block
+	var position int
+	for _, candidate := range function.Blocks {
+		for idx, instr := range candidate.Instrs {
+			if instr == call {
+				block, position = candidate, idx
+			}
+		}
+	}
+
+	rest := &Block{Instrs: append([]*Value{}, block.Instrs[position+1:]...)}
+	for _, successor := range rest.Successors() {
+		renameEdge(block, rest, successor)
+	}
+
+	callee := call.Function
+	values := map[*Value]*Value{}
+	for idx, param := range callee.Params {
+		values[param] = call.Args[idx]
+	}
+
+	clones := map[*Block]*Block{}
+	for _, original := range callee.Blocks {
+		clones[original] = &Block{}
+		for _, instr := range original.Instrs {
+			clone := *instr
+			clone.Args = append([]*Value{}, instr.Args...)
+			clone.Blocks = append([]*Block{}, instr.Blocks...)
+			values[instr] = &clone
+			clones[original].Instrs = append(clones[original].Instrs, &clone)
+		}
+	}
+
+	var results []*Value
+	var exits []*Block
+	for _, original := range callee.Blocks {
+		clone := clones[original]
+		for _, instr := range clone.Instrs {
+			for idx, arg := range instr.Args {
+				if value, ok := values[arg]; ok {
+					instr.Args[idx] = value
+				}
+			}
+			for idx, target := range instr.Blocks {
+				instr.Blocks[idx] = clones[target]
+			}
+		}
+
+		terminator := clone.Terminator()
+		if terminator.Op == OP_RETURN {
+			if len(terminator.Args) > 0 {
+				results = append(results, terminator.Args[0])
+			}
+			exits = append(exits, clone)
+			*terminator = Value{Op: OP_JUMP, Type: types.Void, Blocks: []*Block{rest}, Pos: terminator.Pos}
+		}
+	}
+
+	block.Instrs = append(block.Instrs[:position], &Value{Op: OP_JUMP, Type: types.Void, Blocks: []*Block{clones[callee.Blocks[0]]}, Pos: call.Pos})
+
+	var result *Value
+	switch {
+	case len(results) == 1:
+		result = results[0]
+	case len(results) > 1:
+		result = &Value{Op: OP_PHI, Type: callee.Result, Args: results, Blocks: exits, Pos: call.Pos}
+		rest.Instrs = append([]*Value{result}, rest.Instrs...)
+	}
+
+	var blocks []*Block
+	for _, candidate := range function.Blocks {
+		blocks = append(blocks, candidate)
+		if candidate == block {
+			for _, original := range callee.Blocks {
+				blocks = append(blocks, clones[original])
+			}
+			blocks = append(blocks, rest)
+		}
+	}
+	function.Blocks = blocks
+
+	function.replace(map[*Value]*Value{call: result})
+}
@@ -0,0 +1,186 @@
+package ir
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/consteval"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/types"
+)
+
+// String writes the program as text. Globals and functions are prefixed
+// with @, values are numbered with % in the order they are defined and
+// blocks are numbered in their order in the function.
+func (program *Program) String() string {
+	var sb strings.Builder
+
+	for _, global := range program.Globals {
+		fmt.Fprintf(&sb, "global @%s: %s\n", global.Name, global.Type)
+	}
+
+	for _, function := range program.Functions {
+		if sb.Len() > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(function.String())
+	}
+
+	return sb.String()
+}
+
+type printer struct {
+	sb     strings.Builder
+	values map[*Value]int
+	blocks map[*Block]int
+}
+
+func (function *Function) String() string {
+	p := printer{values: map[*Value]int{}, blocks: map[*Block]int{}}
+
+	for _, param := range function.Params {
+		p.values[param] = len(p.values)
+	}
+	for idx, block := range function.Blocks {
+		p.blocks[block] = idx
+		for _, instr := range block.Instrs {
+			if pushes(instr.Type) {
+				p.values[instr] = len(p.values)
+			}
+		}
+	}
+
+	var params []string
+	for _, param := range function.Params {
+		if function.External {
+			params = append(params, fmt.Sprintf("%s: %s", param.Name, param.Type))
+		} else {
+			params = append(params, fmt.Sprintf("%s %s: %s", p.operand(param), param.Name, param.Type))
+		}
+	}
+
+	head := "fn @" + function.Name + "(" + strings.Join(params, ", ") + ")"
+	if pushes(function.Result) {
+		head += " -> " + function.Result.String()
+	}
+
+	if function.External {
+		return "extern " + head + "\n"
+	}
+
+	p.sb.WriteString(head + " {\n")
+	for _, block := range function.Blocks {
+		fmt.Fprintf(&p.sb, "b%d:\n", p.blocks[block])
+		for _, instr := range block.Instrs {
+			p.instr(instr)
+		}
+	}
+	p.sb.WriteString("}\n")
+
+	return p.sb.String()
+}
+
+func (p *printer) instr(instr *Value) {
+	p.sb.WriteString("\t")
+	if pushes(instr.Type) {
+		fmt.Fprintf(&p.sb, "%s: %s = ", p.operand(instr), instr.Type)
+	}
+	p.sb.WriteString(instr.Op.String())
+
+	switch instr.Op {
+	case OP_FIELD:
+		fmt.Fprintf(&p.sb, " %s, %s", p.operand(instr.Args[0]), fieldName(instr.Args[0], instr.Index))
+	case OP_INSERT:
+		fmt.Fprintf(&p.sb, " %s, %s, %s", p.operand(instr.Args[0]), fieldName(instr.Args[0], instr.Index), p.operand(instr.Args[1]))
+	case OP_IS:
+		fmt.Fprintf(&p.sb, " %s, %s", p.operand(instr.Args[0]), instr.Struct)
+	case OP_LOAD:
+		fmt.Fprintf(&p.sb, " @%s", instr.Global.Name)
+	case OP_STORE:
+		fmt.Fprintf(&p.sb, " @%s, %s", instr.Global.Name, p.operand(instr.Args[0]))
+	case OP_CALL:
+		fmt.Fprintf(&p.sb, " @%s(%s)", instr.Function.Name, p.operands(instr.Args))
+	case OP_CALL_VALUE:
+		fmt.Fprintf(&p.sb, " %s(%s)", p.operand(instr.Args[0]), p.operands(instr.Args[1:]))
+	case OP_CALL_METHOD:
+		fmt.Fprintf(&p.sb, " %s.%s(%s)", p.operand(instr.Args[0]), instr.Name, p.operands(instr.Args[1:]))
+	case OP_PHI:
+		var incoming []string
+		for idx, arg := range instr.Args {
+			incoming = append(incoming, fmt.Sprintf("[%s, %s]", p.operand(arg), p.block(instr.Blocks[idx])))
+		}
+		p.sb.WriteString(" " + strings.Join(incoming, ", "))
+	default:
+		operands := []string{}
+		if len(instr.Args) > 0 {
+			operands = append(operands, p.operands(instr.Args))
+		}
+		for _, block := range instr.Blocks {
+			operands = append(operands, p.block(block))
+		}
+		if len(operands) > 0 {
+			p.sb.WriteString(" " + strings.Join(operands, ", "))
+		}
+	}
+
+	p.sb.WriteString("\n")
+}
+
+func (p *printer) operands(values []*Value) string {
+	var operands []string
+	for _, value := range values {
+		operands = append(operands, p.operand(value))
+	}
+
+	return strings.Join(operands, ", ")
+}
+
+func (p *printer) operand(value *Value) string {
+	switch {
+	case value == nil:
+		return "undef"
+	case value.Op == OP_CONST:
+		return constant(value.Const)
+	case value.Op == OP_FUNCTION:
+		return "@" + value.Function.Name
+	}
+
+	if number, ok := p.values[value]; ok {
+		return "%" + strconv.Itoa(number)
+	}
+
+	return "%?"
+}
+
+func (p *printer) block(block *Block) string {
+	if number, ok := p.blocks[block]; ok {
+		return "b" + strconv.Itoa(number)
+	}
+
+	return "b?"
+}
+
+// constant writes a constant, floats always with a point or exponent.
+func constant(value consteval.Value) string {
+	if value.Type.IsFloat() {
+		text := strconv.FormatFloat(value.Float, 'g', -1, 64)
+		if !strings.ContainsAny(text, ".eIN") {
+			text += ".0"
+		}
+		return text
+	}
+
+	return value.String()
+}
+
+func fieldName(value *Value, index int) string {
+	if value == nil {
+		return strconv.Itoa(index)
+	}
+
+	if structure, ok := value.Type.(*types.Struct); ok && index < len(structure.Fields) {
+		return structure.Fields[index].Name
+	}
+
+	return strconv.Itoa(index)
+}
@@ -0,0 +1,114 @@
+package ir
+
+/* Scheduling */
+
+// Uses counts how often every value is an operand of an instruction of a
+// function.
+func (function *Function) Uses() map[*Value]int {
+	uses := map[*Value]int{}
+	for _, block := range function.Blocks {
+		for _, instr := range block.Instrs {
+			for _, arg := range instr.Args {
+				if arg != nil {
+					uses[arg]++
+				}
+			}
+		}
+	}
+
+	return uses
+}
+
+// Deferred returns the instructions a backend can compute right where they
+// are used instead of keeping their value in a variable. Such an
+// instruction is used once, by an instruction of its own block that is not
+// a phi, and moving it there together with the instructions deferred into
+// it does not change the order of instructions whose order matters. The
+// operands of an instruction are computed from the first to the last.
+// allow can rule out instructions a backend cannot defer, it may be nil.
+func (function *Function) Deferred(allow func(value *Value, user *Value) bool) map[*Value]bool {
+	uses := function.Uses()
+	users := map[*Value]*Value{}
+	for _, block := range function.Blocks {
+		for _, instr := range block.Instrs {
+			for _, arg := range instr.Args {
+				users[arg] = instr
+			}
+		}
+	}
+
+	deferred := map[*Value]bool{}
+	for _, block := range function.Blocks {
+		position := map[*Value]int{}
+		for idx, instr := range block.Instrs {
+			position[instr] = idx
+		}
+
+		// Later instructions are deferred first, so that the operands of
+		// an instruction can all be computed in order right before it
+		for idx := len(block.Instrs) - 1; idx >= 0; idx-- {
+			instr := block.Instrs[idx]
+			user := users[instr]
+			if _, ok := position[user]; !ok || uses[instr] != 1 || instr.Op == OP_PHI || user.Op == OP_PHI {
+				continue
+			}
+			if allow != nil && !allow(instr, user) {
+				continue
+			}
+
+			deferred[instr] = true
+			if !keepsOrder(block, position, deferred) {
+				delete(deferred, instr)
+			}
+		}
+	}
+
+	return deferred
+}
+
+// keepsOrder reports whether computing the deferred instructions of a block
+// where they are used keeps the order of the instructions that do not
+// commute.
+func keepsOrder(block *Block, position map[*Value]int, deferred map[*Value]bool) bool {
+	var order []*Value
+	var visit func(instr *Value)
+	visit = func(instr *Value) {
+		for _, arg := range instr.Args {
+			if deferred[arg] {
+				visit(arg)
+			}
+		}
+		order = append(order, instr)
+	}
+	for _, instr := range block.Instrs {
+		if !deferred[instr] {
+			visit(instr)
+		}
+	}
+
+	for i, first := range order {
+		for _, second := range order[i+1:] {
+			if position[first] > position[second] && !commute(first, second) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// commute reports whether two instructions can run in either order. Loads
+// depend on stores and calls, and instructions that may stop the program
+// keep their order so that the same error is reported.
+func commute(a *Value, b *Value) bool {
+	switch {
+	case a.Effect() == EFFECTS:
+		return b.Effect() == PURE && b.Op != OP_LOAD
+	case b.Effect() == EFFECTS:
+		return a.Effect() == PURE && a.Op != OP_LOAD
+	case a.Effect() == TRAPS:
+		return b.Effect() == PURE
+	}
+
+	return true
+}
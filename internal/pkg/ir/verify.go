@@ -0,0 +1,112 @@
+package ir
+
+import (
+	"fmt"
+)
+
+// Verify checks that the functions of a program are in SSA form: every
+// block ends with its only terminator, phis come first and have one
+// argument for every predecessor, and every instruction is defined before
+// its uses on all paths.
+func Verify(program *Program) error {
+	for _, function := range program.Functions {
+		if err := function.verify(); err != nil {
+			return fmt.Errorf("@%s: %s", function.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (function *Function) verify() error {
+	if function.External {
+		return nil
+	}
+	if len(function.Blocks) == 0 {
+		return fmt.Errorf("function has no blocks")
+	}
+
+	defined := map[*Value]*Block{}
+	position := map[*Value]int{}
+	blocks := map[*Block]bool{}
+	for _, block := range function.Blocks {
+		blocks[block] = true
+		for idx, instr := range block.Instrs {
+			defined[instr] = block
+			position[instr] = idx
+		}
+	}
+
+	dominators := function.dominators()
+	dominates := func(a *Block, b *Block) bool {
+		for {
+			if a == b {
+				return true
+			}
+			if dominators[b] == b || dominators[b] == nil {
+				return false
+			}
+			b = dominators[b]
+		}
+	}
+
+	predecessors := function.Predecessors()
+	for number, block := range function.Blocks {
+		if _, ok := dominators[block]; !ok {
+			return fmt.Errorf("b%d cannot be reached", number)
+		}
+
+		for idx, instr := range block.Instrs {
+			last := idx == len(block.Instrs)-1
+			switch {
+			case instr.IsTerminator() != last:
+				return fmt.Errorf("b%d does not end with its only terminator", number)
+			case instr.Op == OP_PHI && idx > 0 && block.Instrs[idx-1].Op != OP_PHI:
+				return fmt.Errorf("phi in b%d after other instructions", number)
+			case instr.Op == OP_PHI && len(instr.Blocks) != len(predecessors[block]):
+				return fmt.Errorf("phi in b%d has %d arguments for %d predecessors", number, len(instr.Blocks), len(predecessors[block]))
+			}
+
+			for _, target := range instr.Blocks {
+				if !blocks[target] {
+					return fmt.Errorf("%s in b%d refers to a block of another function", instr.Op, number)
+				}
+			}
+
+			for argument, arg := range instr.Args {
+				switch {
+				case arg == nil:
+					return fmt.Errorf("%s in b%d has no argument %d", instr.Op, number, argument)
+				case arg.Op == OP_CONST || arg.Op == OP_FUNCTION:
+					continue
+				case arg.Op == OP_PARAM:
+					if arg.Index >= len(function.Params) || function.Params[arg.Index] != arg {
+						return fmt.Errorf("%s in b%d uses a parameter of another function", instr.Op, number)
+					}
+					continue
+				}
+
+				definition, ok := defined[arg]
+				if !ok {
+					return fmt.Errorf("%s in b%d uses %s that is not defined", instr.Op, number, arg.Op)
+				}
+
+				// The arguments of phis only need to be defined at the end of
+				// the block they come from
+				use := block
+				if instr.Op == OP_PHI {
+					use = instr.Blocks[argument]
+					if !contains(predecessors[block], use) {
+						return fmt.Errorf("phi in b%d has an argument from a block that does not continue in it", number)
+					}
+				}
+
+				if definition == use && instr.Op != OP_PHI && position[arg] >= idx || !dominates(definition, use) {
+					return fmt.Errorf("%s in b%d uses %s before it is defined", instr.Op, number, arg.Op)
+				}
+			}
+		}
+	}
+
+	return nil
+}
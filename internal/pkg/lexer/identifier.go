@@ -0,0 +1,159 @@
+package lexer
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+/* Identifier characters */
+
+// Characters of ID_Start that are not in XID_Start, because they change
+// under NFKC in a way that no longer starts an identifier (UAX #31).
+var notXIDStart = map[rune]bool{
+	0x037A: true, 0x0E33: true, 0x0EB3: true, 0x309B: true, 0x309C: true,
+	0xFC5E: true, 0xFC5F: true, 0xFC60: true, 0xFC61: true, 0xFC62: true, 0xFC63: true,
+	0xFDFA: true, 0xFDFB: true, 0xFE70: true, 0xFE72: true, 0xFE74: true, 0xFE76: true,
+	0xFE78: true, 0xFE7A: true, 0xFE7C: true, 0xFE7E: true, 0xFF9E: true, 0xFF9F: true,
+}
+
+// Characters of ID_Continue that are not in XID_Continue.
+var notXIDContinue = map[rune]bool{
+	0x037A: true, 0x309B: true, 0x309C: true,
+	0xFC5E: true, 0xFC5F: true, 0xFC60: true, 0xFC61: true, 0xFC62: true, 0xFC63: true,
+	0xFDFA: true, 0xFDFB: true, 0xFE70: true, 0xFE72: true, 0xFE74: true, 0xFE76: true,
+	0xFE78: true, 0xFE7A: true, 0xFE7C: true, 0xFE7E: true,
+	// The joiners are invisible and only allowed in some contexts, they are
+	// left out completely
+	0x200C: true, 0x200D: true,
+}
+
+// isIdentifierStart reports if an identifier can start with ch, which is an
+// underscore or an XID_Start character.
+func isIdentifierStart(ch rune) bool {
+	if ch < 0x80 {
+		return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+	}
+
+	return isIDStart(ch) && !notXIDStart[ch]
+}
+
+// isIdentifierContinue reports if ch can follow the start of an identifier,
+// which are the XID_Continue characters.
+func isIdentifierContinue(ch rune) bool {
+	if ch < 0x80 {
+		return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9')
+	}
+
+	return (isIDStart(ch) || unicode.In(ch, unicode.Mn, unicode.Mc, unicode.Nd, unicode.Pc, unicode.Other_ID_Continue)) &&
+		!isPattern(ch) && !notXIDContinue[ch]
+}
+
+func isIDStart(ch rune) bool {
+	return (unicode.IsLetter(ch) || unicode.In(ch, unicode.Nl, unicode.Other_ID_Start)) && !isPattern(ch)
+}
+
+func isPattern(ch rune) bool {
+	return unicode.In(ch, unicode.Pattern_Syntax, unicode.Pattern_White_Space)
+}
+
+/* Normalisation */
+
+// normalize returns the NFC form of an identifier, so that identifiers are
+// equal no matter if their characters were written precomposed or not. The
+// tables of norm follow the Unicode version of the unicode package.
+func normalize(text string) string {
+	return norm.NFC.String(text)
+}
+
+/* Scripts and confusables */
+
+// scriptGroups are the scripts that may be mixed in one identifier, as they
+// are written together (UTS #39, highly restrictive).
+var scriptGroups = [][]string{
+	{"Latin", "Han", "Hiragana", "Katakana"},
+	{"Latin", "Han", "Bopomofo"},
+	{"Latin", "Han", "Hangul"},
+}
+
+// mixedScripts returns the scripts of an identifier if they are not written
+// together. Common and inherited characters like digits and combining
+// marks belong to every script.
+func mixedScripts(name string) []string {
+	var scripts []string
+	for _, ch := range name {
+		if ch < 0x80 {
+			if unicode.IsLetter(ch) && !slices.Contains(scripts, "Latin") {
+				scripts = append(scripts, "Latin")
+			}
+			continue
+		}
+
+		for script, table := range unicode.Scripts {
+			if script == "Common" || script == "Inherited" || !unicode.Is(table, ch) {
+				continue
+			}
+			if !slices.Contains(scripts, script) {
+				scripts = append(scripts, script)
+			}
+			break
+		}
+	}
+
+	if len(scripts) <= 1 {
+		return nil
+	}
+
+	for _, group := range scriptGroups {
+		if !slices.ContainsFunc(scripts, func(script string) bool { return !slices.Contains(group, script) }) {
+			return nil
+		}
+	}
+
+	return scripts
+}
+
+// confusables maps characters to the ASCII characters they can hardly be
+// told apart from (UTS #39). Only characters outside of ASCII are mapped, so
+// ASCII identifiers are never confusable with each other.
+var confusables = map[rune]rune{
+	// Latin
+	'ı': 'i', 'ɑ': 'a', 'ɡ': 'g', 'ℓ': 'l',
+
+	// Greek
+	'Α': 'A', 'Β': 'B', 'Ε': 'E', 'Ζ': 'Z', 'Η': 'H', 'Ι': 'l', 'Κ': 'K', 'Μ': 'M',
+	'Ν': 'N', 'Ο': 'O', 'Ρ': 'P', 'Τ': 'T', 'Υ': 'Y', 'Χ': 'X',
+	'α': 'a', 'γ': 'y', 'ι': 'i', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'υ': 'u', 'ϲ': 'c', 'ϳ': 'j',
+
+	// Cyrillic
+	'А': 'A', 'В': 'B', 'Е': 'E', 'Ѕ': 'S', 'І': 'l', 'Ј': 'J', 'К': 'K', 'М': 'M',
+	'Н': 'H', 'О': 'O', 'Р': 'P', 'С': 'C', 'Т': 'T', 'Х': 'X', 'Ү': 'Y', 'Ԛ': 'Q',
+	'Ԝ': 'W', 'Ӏ': 'l',
+	'а': 'a', 'е': 'e', 'һ': 'h', 'і': 'i', 'ј': 'j', 'о': 'o', 'р': 'p', 'с': 'c',
+	'ѕ': 's', 'у': 'y', 'х': 'x', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w', 'ү': 'y', 'ӏ': 'l',
+
+	// Armenian
+	'հ': 'h', 'ո': 'n', 'ս': 'u', 'օ': 'o',
+}
+
+// Skeleton maps every character of an identifier to the character it can be
+// confused with, identifiers with the same skeleton look alike.
+func Skeleton(name string) string {
+	return strings.Map(func(ch rune) rune {
+		switch {
+		case ch >= 'Ａ' && ch <= 'Ｚ':
+			return ch - 'Ａ' + 'A'
+		case ch >= 'ａ' && ch <= 'ｚ':
+			return ch - 'ａ' + 'a'
+		case ch >= '０' && ch <= '９':
+			return ch - '０' + '0'
+		}
+
+		if confusable, ok := confusables[ch]; ok {
+			return confusable
+		}
+		return ch
+	}, name)
+}
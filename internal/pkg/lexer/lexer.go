@@ -1,22 +1,111 @@
 package lexer
 
 import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
 	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
 
 	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
-	"github.com/henryk-kramer/quartz-lang/internal/pkg/util/array"
 )
 
-type lexer struct {
+// Lexer turns source text into tokens. The source is read while it is lexed
+// and only the text of the token the lexer is working on is kept, so sources
+// of any size can be lexed without holding them in memory.
+type Lexer struct {
+	reader *bufio.Reader
+	err    error
+	// runes holds the source from the start of the current token on, base
+	// is the index of its first rune in the source. sizes holds the number of
+	// bytes every rune took up in the source, which differs from its UTF-8
+	// length for invalid bytes
 	runes    []rune
+	sizes    []int
+	base     int
 	tokens   []Token
 	startPos util.Position
 	currPos  util.Position
+	// interpolations holds the depth of braces in every interpolated
+	// expression of the strings the lexer is in
+	interpolations []int
 }
 
-func Run(text string, filename string) []Token {
-	l := newLexer(text, filename)
+// Run lexes the text of a file at once, it returns the tokens Next returns
+// before the EOF token.
+func Run(file *util.File) []Token {
+	l := New(strings.NewReader(file.Text), file)
 
+	var tokens []Token
+	for token := l.Next(); token.Type != EOF; token = l.Next() {
+		tokens = append(tokens, token)
+	}
+
+	return tokens
+}
+
+// New returns a lexer that reads the source from reader, the positions of its
+// tokens refer to file. The text of the file is not needed to lex it.
+func New(reader io.Reader, file *util.File) *Lexer {
+	return &Lexer{
+		reader:   bufio.NewReader(reader),
+		currPos:  util.Position{File: file},
+		startPos: util.Position{File: file},
+	}
+}
+
+// Next returns the next token of the source, or an EOF token once all of it
+// is lexed. Unknown characters that follow each other are returned as a
+// single token.
+func (l *Lexer) Next() Token {
+	if !l.lex() {
+		pos := l.currPos
+		pos.Len = 0
+		pos.Size = 0
+		return Token{Type: EOF, Pos: pos}
+	}
+
+	token := l.pop()
+	for token.Type == UNKNOWN && l.lex() && l.tokens[0].Type == UNKNOWN {
+		next := l.pop()
+		token.Pos.Len++
+		token.Pos.Size += next.Pos.Size
+		token.Literal += next.Literal
+	}
+
+	return token
+}
+
+// Err returns the error that reading the source failed with, if it did not
+// simply end.
+func (l *Lexer) Err() error {
+	if l.err == io.EOF {
+		return nil
+	}
+
+	return l.err
+}
+
+// lex lexes tokens until one is waiting to be returned and reports if there
+// is one.
+func (l *Lexer) lex() bool {
+	for len(l.tokens) == 0 && !l.eof() {
+		l.parseToken()
+	}
+
+	return len(l.tokens) > 0
+}
+
+func (l *Lexer) pop() Token {
+	token := l.tokens[0]
+	l.tokens = l.tokens[1:]
+	return token
+}
+
+func (l *Lexer) parseToken() {
 	matchBinNum := func(ch rune) bool {
 		return ch >= '0' && ch <= '1'
 	}
@@ -29,131 +118,217 @@ func Run(text string, filename string) []Token {
 		return ch >= '0' && ch <= '9'
 	}
 
-	matchHexNum := func(ch rune) bool {
-		return (ch >= '0' && ch <= '9') ||
-			(ch >= 'a' && ch <= 'f') ||
-			(ch >= 'A' && ch <= 'F')
-	}
+	var _ = l.parseChars("\t", TAB) ||
+		l.parseNewline() ||
+		l.parseWhitespace() ||
+		l.parseSingleLineComment() ||
+		l.parseMultiLineComment() ||
+		l.parseStringLiteral() ||
+		l.parseInterpolationEnd() ||
+		l.parseRawStringLiteral() ||
+		l.parseByteStringLiteral() ||
+		l.parseSymLiteral() ||
+		l.parseIdentifierOrKeyword() ||
+		l.parseXaryNumLiteral('b', matchBinNum, BIN_NUM_LITERAL, BIN_NUM_LITERAL_ERROR) ||
+		l.parseXaryNumLiteral('o', matchOctNum, OCT_NUM_LITERAL, OCT_NUM_LITERAL_ERROR) ||
+		l.parseXaryNumLiteral('d', matchDecNum, DEC_NUM_LITERAL, DEC_NUM_LITERAL_ERROR) ||
+		l.parseXaryNumLiteral('x', isHexDigit, HEX_NUM_LITERAL, HEX_NUM_LITERAL_ERROR) ||
+		l.parseNormalNumLiteral() ||
+		l.parseOperator() ||
+		l.parseUnknown()
+}
 
-	for !l.eof() {
-		var _ = l.parseChars("\t", TAB) ||
-			l.parseNewline() ||
-			l.parseWhitespace() ||
-			l.parseSingleLineComment() ||
-			l.parseMultiLineComment() ||
-			l.parseStringLiteral() ||
-			l.parseIdentifierOrKeyword() ||
-			l.parseXaryNumLiteral('b', matchBinNum, BIN_NUM_LITERAL, BIN_NUM_LITERAL_ERROR) ||
-			l.parseXaryNumLiteral('o', matchOctNum, OCT_NUM_LITERAL, OCT_NUM_LITERAL_ERROR) ||
-			l.parseXaryNumLiteral('d', matchDecNum, DEC_NUM_LITERAL, DEC_NUM_LITERAL_ERROR) ||
-			l.parseXaryNumLiteral('x', matchHexNum, HEX_NUM_LITERAL, HEX_NUM_LITERAL_ERROR) ||
-			l.parseNormalNumLiteral() ||
-			l.parseChars("<=", LESS_THAN_OR_EQUALS) ||
-			l.parseChars("<", LESS_THAN) ||
-			l.parseChars(">=", GREATER_THAN_OR_EQUALS) ||
-			l.parseChars(">", GREATER_THAN) ||
-			l.parseChars("==", EQUALS) ||
-			l.parseChars("!=", NOT_EQUALS) ||
-			l.parseChars("->", RETURN_TYPE_INDICATOR) ||
-			l.parseChars("??", IF_NIL) ||
-			l.parseChars("&&", IF_NIL) ||
-			l.parseChars("||", IF_NIL) ||
-			l.parseChars("::", DOUBLE_SEMICOLON) ||
-			l.parseChars("=", BINDING) ||
-			l.parseChars("_", MUTED) ||
-			l.parseChars("'", TICK) ||
-			l.parseChars("^", CIRCUMFLEX) ||
-			l.parseChars("|", PIPE) ||
-			l.parseChars(",", COMMA) ||
-			l.parseChars(":", TYPE_INDICATOR) ||
-			l.parseChars("+", PLUS_SIGN) ||
-			l.parseChars("-", MINUS_SIGN) ||
-			l.parseChars("*", STAR_SIGN) ||
-			l.parseChars("/", SLASH_SIGN) ||
-			l.parseChars("(", OPENED_PARENTHESIS) ||
-			l.parseChars(")", CLOSED_PARENTHESIS) ||
-			l.parseChars("{", OPENED_BRACE) ||
-			l.parseChars("}", CLOSED_BRACE) ||
-			l.parseChars("[", OPENED_BRACKET) ||
-			l.parseChars("]", CLOSED_BRACKET) ||
-			l.parseUnknown()
+// operators maps the characters of every operator to its token type. The
+// longest operator that matches is taken, so "<=" is never lexed as "<"
+// followed by "=" no matter how the table is ordered.
+var operators = map[string]TokenType{
+	"<=": LESS_THAN_OR_EQUALS,
+	"<":  LESS_THAN,
+	">=": GREATER_THAN_OR_EQUALS,
+	">":  GREATER_THAN,
+	"==": EQUALS,
+	"!=": NOT_EQUALS,
+	"->": RETURN_TYPE_INDICATOR,
+	"??": IF_NIL,
+	"&&": LOGICAL_AND,
+	"||": LOGICAL_OR,
+	"::": DOUBLE_SEMICOLON,
+	"=":  BINDING,
+	"+=": PLUS_ASSIGNMENT,
+	"-=": MINUS_ASSIGNMENT,
+	"*=": STAR_ASSIGNMENT,
+	"/=": SLASH_ASSIGNMENT,
+	"_":  MUTED,
+	"^":  CIRCUMFLEX,
+	"|":  PIPE,
+	",":  COMMA,
+	".":  DOT,
+	":":  TYPE_INDICATOR,
+	"+":  PLUS_SIGN,
+	"-":  MINUS_SIGN,
+	"*":  STAR_SIGN,
+	"/":  SLASH_SIGN,
+	"(":  OPENED_PARENTHESIS,
+	")":  CLOSED_PARENTHESIS,
+	"{":  OPENED_BRACE,
+	"}":  CLOSED_BRACE,
+	"[":  OPENED_BRACKET,
+	"]":  CLOSED_BRACKET,
+}
+
+// maxOperatorLen is the number of runes of the longest operator.
+var maxOperatorLen = func() int {
+	length := 0
+	for chars := range operators {
+		length = max(length, len([]rune(chars)))
 	}
 
-	var tokens []Token
-	var lastTokenType TokenType
-	for _, token := range l.tokens {
-		if lastTokenType == UNKNOWN && token.Type == UNKNOWN {
-			lastToken := &tokens[len(tokens)-1]
-			lastToken.Pos.Len++
-			lastToken.Literal += token.Literal
-			continue
+	return length
+}()
+
+// keywords maps the keywords to their token type, let! is lexed separately.
+var keywords = map[string]TokenType{
+	"let":       KEYWORD_LET,
+	"namespace": KEYWORD_NAMESPACE,
+	"import":    KEYWORD_IMPORT,
+	"from":      KEYWORD_FROM,
+	"as":        KEYWORD_AS,
+	"const":     KEYWORD_CONST,
+	"ext":       KEYWORD_EXT,
+	"pub":       KEYWORD_PUB,
+	"fn":        KEYWORD_FN,
+	"struct":    KEYWORD_STRUCT,
+	"trait":     KEYWORD_TRAIT,
+	"impl":      KEYWORD_IMPL,
+	"for":       KEYWORD_FOR,
+	"self":      KEYWORD_SELF,
+	"nil":       KEYWORD_NIL,
+	"if":        KEYWORD_IF,
+	"cond":      KEYWORD_COND,
+	"case":      KEYWORD_CASE,
+	"else":      KEYWORD_ELSE,
+	"return":    KEYWORD_RETURN,
+	"not":       KEYWORD_NOT,
+	"and":       KEYWORD_AND,
+	"or":        KEYWORD_OR,
+	"xor":       KEYWORD_XOR,
+	"shl":       KEYWORD_SHL,
+	"shr":       KEYWORD_SHR,
+	"ashr":      KEYWORD_ASHR,
+	"cshl":      KEYWORD_CSHL,
+	"cshr":      KEYWORD_CSHR,
+	"true":      KEYWORD_TRUE,
+	"false":     KEYWORD_FALSE,
+	"bool":      KEYWORD_BOOL,
+	"u8":        KEYWORD_U8,
+	"u16":       KEYWORD_U16,
+	"u32":       KEYWORD_U32,
+	"u64":       KEYWORD_U64,
+	"i8":        KEYWORD_I8,
+	"i16":       KEYWORD_I16,
+	"i32":       KEYWORD_I32,
+	"i64":       KEYWORD_I64,
+	"f32":       KEYWORD_F32,
+	"f64":       KEYWORD_F64,
+	"num":       KEYWORD_NUM,
+	"sym":       KEYWORD_SYM,
+	"bin":       KEYWORD_BIN,
+}
+
+/* Helper methods */
+
+func isHexDigit(ch rune) bool {
+	return (ch >= '0' && ch <= '9') ||
+		(ch >= 'a' && ch <= 'f') ||
+		(ch >= 'A' && ch <= 'F')
+}
+
+// fill reads the source up to the rune at idx and reports if there is one.
+func (l *Lexer) fill(idx int) bool {
+	for idx-l.base >= len(l.runes) {
+		if l.err != nil {
+			return false
 		}
 
-		lastTokenType = token.Type
-		tokens = append(tokens, token)
+		ch, size, err := l.reader.ReadRune()
+		if err != nil {
+			l.err = err
+			return false
+		}
+
+		l.runes = append(l.runes, ch)
+		l.sizes = append(l.sizes, size)
 	}
 
-	return tokens
+	return true
 }
 
-func newLexer(text string, filename string) lexer {
-	return lexer{
-		runes:    []rune(text),
-		currPos:  util.Position{File: filename},
-		startPos: util.Position{File: filename},
+// runeAt returns the rune at idx, or 0 behind the end of the source.
+func (l *Lexer) runeAt(idx int) rune {
+	if !l.fill(idx) {
+		return 0
 	}
+
+	return l.runes[idx-l.base]
 }
 
-/* Helper methods */
+// text returns the source from start up to end, start must not lie before
+// the start of the current token.
+func (l *Lexer) text(start int, end int) string {
+	l.fill(end - 1)
+	return string(l.runes[start-l.base : end-l.base])
+}
 
-func (l *lexer) eof() bool {
-	return l.currPos.Idx >= len(l.runes)
+// discard forgets the source before the start of the current token, which
+// no token needs anymore.
+func (l *Lexer) discard() {
+	kept := copy(l.runes, l.runes[l.startPos.Idx-l.base:])
+	copy(l.sizes, l.sizes[l.startPos.Idx-l.base:])
+	l.runes = l.runes[:kept]
+	l.sizes = l.sizes[:kept]
+	l.base = l.startPos.Idx
 }
 
-func (l *lexer) peek() rune {
-	if l.eof() {
-		return 0
-	}
+func (l *Lexer) eof() bool {
+	return !l.fill(l.currPos.Idx)
+}
 
-	return l.runes[l.currPos.Idx]
+func (l *Lexer) peek() rune {
+	return l.runeAt(l.currPos.Idx)
 }
 
-func (l *lexer) advance() rune {
+// advance moves behind the next character, a carriage return followed by a
+// line feed is a single line break and returned as '\n' like a lone one.
+func (l *Lexer) advance() rune {
 	if l.eof() {
 		return 0
 	}
 
 	ch := l.peek()
 
+	l.currPos.Offset += l.sizes[l.currPos.Idx-l.base]
 	l.currPos.Idx++
-	l.currPos.Col++
-
-	if ch == '\n' {
-		if l.peek() == '\r' {
-			l.currPos.Idx++
-		}
 
-		l.currPos.Row++
-		l.currPos.Col = 0
-
-		return '\n'
+	if ch == '\r' && l.peek() == '\n' {
+		l.currPos.Offset += l.sizes[l.currPos.Idx-l.base]
+		l.currPos.Idx++
 	}
 
-	if ch == '\r' {
-		if l.peek() == '\n' {
-			l.currPos.Idx++
-		}
-
+	if ch == '\n' || ch == '\r' {
 		l.currPos.Row++
 		l.currPos.Col = 0
+		l.currPos.Col16 = 0
 
 		return '\n'
 	}
 
+	l.currPos.Col++
+	l.currPos.Col16 += utf16.RuneLen(ch)
+
 	return ch
 }
 
-func (l *lexer) advanceWhile(match func(rune) bool) {
+func (l *Lexer) advanceWhile(match func(rune) bool) {
 	for {
 		if l.eof() {
 			return
@@ -169,52 +344,74 @@ func (l *lexer) advanceWhile(match func(rune) bool) {
 	}
 }
 
-func (l *lexer) commit(tokenType TokenType) {
+func (l *Lexer) commit(tokenType TokenType) {
 	pos := l.startPos
 	pos.Len = l.currPos.Idx - l.startPos.Idx
+	pos.Size = l.currPos.Offset - l.startPos.Offset
 
 	token := Token{
 		Type:     tokenType,
 		HasError: false,
-		Literal:  string(l.runes[l.startPos.Idx:l.currPos.Idx]),
+		Literal:  l.text(l.startPos.Idx, l.currPos.Idx),
 		Pos:      pos,
 	}
 
 	l.tokens = append(l.tokens, token)
 
 	l.startPos = l.currPos
+	l.discard()
 }
 
-func (l *lexer) commitErr(tokenType TokenType, errorMsg string) {
+func (l *Lexer) commitErr(tokenType TokenType, errorMsg string) {
 	pos := l.startPos
 	pos.Len = l.currPos.Idx - l.startPos.Idx
+	pos.Size = l.currPos.Offset - l.startPos.Offset
 
 	token := Token{
 		Type:     tokenType,
 		HasError: true,
 		ErrorMsg: errorMsg,
-		Literal:  string(l.runes[l.startPos.Idx:l.currPos.Idx]),
+		Literal:  l.text(l.startPos.Idx, l.currPos.Idx),
 		Pos:      pos,
 	}
 
 	l.tokens = append(l.tokens, token)
 
 	l.startPos = l.currPos
+	l.discard()
+}
+
+// commitName commits an identifier with its normalised name as literal.
+func (l *Lexer) commitName(tokenType TokenType, name string) {
+	l.commit(tokenType)
+	l.tokens[len(l.tokens)-1].Literal = name
 }
 
-func (l *lexer) rollback() {
+// commitDecoded commits a literal with its decoded value, or an error if
+// decoding it failed.
+func (l *Lexer) commitDecoded(tokenType TokenType, errTokenType TokenType, value string, errorMsg string) {
+	if errorMsg != "" {
+		l.commitErr(errTokenType, errorMsg)
+	} else {
+		l.commit(tokenType)
+	}
+
+	l.tokens[len(l.tokens)-1].Value = value
+}
+
+func (l *Lexer) rollback() {
 	l.currPos = l.startPos
 }
 
-func (l *lexer) literal() string {
-	return string(l.runes[l.startPos.Idx:l.currPos.Idx])
+func (l *Lexer) literal() string {
+	return l.text(l.startPos.Idx, l.currPos.Idx)
 }
 
 /* Parse methods*/
 
-func (l *lexer) parseChars(chars string, tokenType TokenType) bool {
+func (l *Lexer) parseChars(chars string, tokenType TokenType) bool {
 	for _, ch := range chars {
-		if array.GetOrDefault(l.runes, l.currPos.Idx, 0) != ch {
+		if l.runeAt(l.currPos.Idx) != ch {
 			l.rollback()
 			return false
 		}
@@ -226,7 +423,37 @@ func (l *lexer) parseChars(chars string, tokenType TokenType) bool {
 	return true
 }
 
-func (l *lexer) parseNewline() bool {
+func (l *Lexer) parseOperator() bool {
+	l.fill(l.currPos.Idx + maxOperatorLen - 1)
+
+	for length := min(maxOperatorLen, l.base+len(l.runes)-l.currPos.Idx); length > 0; length-- {
+		tokenType, ok := operators[l.text(l.currPos.Idx, l.currPos.Idx+length)]
+		if !ok {
+			continue
+		}
+
+		for range length {
+			l.advance()
+		}
+
+		l.commit(tokenType)
+
+		if depth := len(l.interpolations) - 1; depth >= 0 {
+			switch tokenType {
+			case OPENED_BRACE:
+				l.interpolations[depth]++
+			case CLOSED_BRACE:
+				l.interpolations[depth]--
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+func (l *Lexer) parseNewline() bool {
 	ch := l.peek()
 
 	if ch != '\n' && ch != '\r' {
@@ -239,7 +466,7 @@ func (l *lexer) parseNewline() bool {
 	return true
 }
 
-func (l *lexer) parseWhitespace() bool {
+func (l *Lexer) parseWhitespace() bool {
 	ch := l.peek()
 
 	if !unicode.IsSpace(ch) {
@@ -254,7 +481,9 @@ func (l *lexer) parseWhitespace() bool {
 	return true
 }
 
-func (l *lexer) parseSingleLineComment() bool {
+// parseSingleLineComment parses a comment up to the end of the line. Three
+// slashes start a doc comment, four or more a normal comment again.
+func (l *Lexer) parseSingleLineComment() bool {
 	if l.peek() != '/' {
 		return false
 	}
@@ -267,14 +496,25 @@ func (l *lexer) parseSingleLineComment() bool {
 	}
 
 	l.advanceWhile(func(ch rune) bool {
-		return ch != '\n'
+		return ch != '\n' && ch != '\r'
 	})
 
+	text := l.text(l.startPos.Idx, l.currPos.Idx)
+	if strings.HasPrefix(text, "///") && !strings.HasPrefix(text, "////") {
+		line := strings.TrimPrefix(text[3:], " ")
+		l.commitDoc(line)
+		return true
+	}
+
 	l.commit(SINGLE_LINE_COMMENT)
 	return true
 }
 
-func (l *lexer) parseMultiLineComment() bool {
+// parseMultiLineComment parses a comment that can span lines. Comments nest,
+// so code that contains comments can be commented out. A comment opened with
+// two stars is a doc comment, unless it is empty like /**/ or opened with
+// more stars.
+func (l *Lexer) parseMultiLineComment() bool {
 	if l.peek() != '/' {
 		return false
 	}
@@ -286,191 +526,374 @@ func (l *lexer) parseMultiLineComment() bool {
 		return false
 	}
 
-	var prevCh rune
-	l.advanceWhile(func(ch rune) bool {
-		if prevCh == '*' && ch == '/' {
-			return false
+	l.advance()
+
+	next := l.runeAt(l.currPos.Idx + 1)
+	doc := l.peek() == '*' && next != '*' && next != '/'
+
+	depth := 1
+	for depth > 0 && !l.eof() {
+		ch := l.advance()
+
+		switch {
+		case ch == '/' && l.peek() == '*':
+			l.advance()
+			depth++
+		case ch == '*' && l.peek() == '/':
+			l.advance()
+			depth--
 		}
+	}
 
-		prevCh = ch
+	if depth > 0 {
+		l.commitErr(MULTI_LINE_COMMENT_ERROR, "Multi line comment not closed")
 		return true
-	})
+	}
 
-	if l.eof() {
-		l.commitErr(MULTI_LINE_COMMENT_ERROR, "Multi line comment not closed")
+	if doc {
+		text := l.text(l.startPos.Idx+3, l.currPos.Idx-2)
+		l.commitDoc(docText(text))
 		return true
 	}
 
+	l.commit(MULTI_LINE_COMMENT)
+	return true
+}
+
+// docText returns the text of a doc block comment. The stars that lines
+// start with are removed together with the space after them, like in
+//
+//	/**
+//	 * Text
+//	 */
+func docText(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+
+	for idx, line := range lines {
+		trimmed := strings.TrimLeft(line, " \t")
+		if rest, ok := strings.CutPrefix(trimmed, "*"); ok && idx > 0 {
+			line = strings.TrimPrefix(rest, " ")
+		} else if idx == 0 {
+			line = trimmed
+		}
+		lines[idx] = strings.TrimRight(line, " \t")
+	}
+
+	for len(lines) > 0 && lines[0] == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (l *Lexer) parseStringLiteral() bool {
+	if l.peek() != '"' {
+		return false
+	}
+
 	l.advance()
 
-	l.commit(MULTI_LINE_COMMENT)
+	return l.parseStringContent(STRING_LITERAL, INTERPOLATION_START)
+}
+
+// parseInterpolationEnd continues a string after the brace that closes one
+// of its interpolated expressions.
+func (l *Lexer) parseInterpolationEnd() bool {
+	depth := len(l.interpolations) - 1
+	if depth < 0 || l.interpolations[depth] > 0 || l.peek() != '}' {
+		return false
+	}
+
+	l.interpolations = l.interpolations[:depth]
+	l.advance()
+
+	return l.parseStringContent(INTERPOLATION_END, INTERPOLATION_MIDDLE)
+}
+
+// parseStringContent decodes the characters of a string up to its closing
+// quote, which commits the closed token type, or up to a brace that starts
+// an interpolated expression, which commits the interpolated one.
+func (l *Lexer) parseStringContent(closed TokenType, interpolated TokenType) bool {
+	var sb strings.Builder
+	var errorMsg string
+
+	for !l.eof() {
+		ch := l.advance()
+
+		switch ch {
+		case '"':
+			l.commitDecoded(closed, STRING_LITERAL_ERROR, sb.String(), errorMsg)
+			return true
+		case '{':
+			l.interpolations = append(l.interpolations, 0)
+			l.commitDecoded(interpolated, STRING_LITERAL_ERROR, sb.String(), errorMsg)
+			return true
+		case '\\':
+			if msg := l.parseEscape(&sb); errorMsg == "" {
+				errorMsg = msg
+			}
+		default:
+			sb.WriteRune(ch)
+		}
+	}
+
+	l.commitErr(STRING_LITERAL_ERROR, "String literal not closed")
 	return true
 }
 
-func (l *lexer) parseStringLiteral() bool {
+var escapes = map[rune]rune{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'0':  0,
+	'\\': '\\',
+	'"':  '"',
+	'{':  '{',
+	'}':  '}',
+}
+
+// parseEscape decodes the escape sequence after a backslash and returns why
+// it is invalid if it is.
+func (l *Lexer) parseEscape(sb *strings.Builder) string {
+	ch := l.peek()
+	if decoded, ok := escapes[ch]; ok {
+		l.advance()
+		sb.WriteRune(decoded)
+		return ""
+	}
+
+	switch {
+	case l.eof():
+		return ""
+	case ch != 'u':
+		l.advance()
+		return fmt.Sprintf("Invalid escape sequence \\%c", ch)
+	}
+
+	l.advance()
+
+	if l.peek() != '{' {
+		return "Expected { after \\u"
+	}
+
+	l.advance()
+
+	start := l.currPos.Idx
+	l.advanceWhile(isHexDigit)
+	digits := l.text(start, l.currPos.Idx)
+
+	if l.peek() != '}' {
+		return "Unicode escape sequence not closed"
+	}
+
+	l.advance()
+
+	value, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil || len(digits) > 6 || !utf8.ValidRune(rune(value)) {
+		return fmt.Sprintf("Invalid unicode escape sequence \\u{%s}", digits)
+	}
+
+	sb.WriteRune(rune(value))
+	return ""
+}
+
+// parseRawStringLiteral parses r"..." and r#"..."#, whose characters are
+// taken as they are. The string is closed by a quote followed by as many #
+// as it was opened with, so it can contain quotes as well.
+func (l *Lexer) parseRawStringLiteral() bool {
+	if l.peek() != 'r' {
+		return false
+	}
+
+	l.advance()
+
+	hashes := 0
+	for l.peek() == '#' {
+		l.advance()
+		hashes++
+	}
+
 	if l.peek() != '"' {
+		l.rollback()
 		return false
 	}
 
 	l.advance()
 
-	var prevCh rune
-	l.advanceWhile(func(ch rune) bool {
-		if prevCh == '\\' {
-			prevCh = 0
+	var sb strings.Builder
+	for !l.eof() {
+		ch := l.advance()
+
+		if ch == '"' && l.closesRawString(hashes) {
+			for range hashes {
+				l.advance()
+			}
+
+			l.commitDecoded(STRING_LITERAL, STRING_LITERAL_ERROR, sb.String(), "")
 			return true
 		}
 
-		prevCh = ch
-		return ch != '"'
-	})
+		sb.WriteRune(ch)
+	}
 
-	if l.eof() {
-		l.commitErr(STRING_LITERAL_ERROR, "String literal not closed")
-		return true
+	l.commitErr(STRING_LITERAL_ERROR, "Raw string literal not closed")
+	return true
+}
+
+// parseByteStringLiteral parses b"...", whose characters are single bytes.
+// Bytes outside of ASCII are written as \x escapes and braces do not start
+// interpolations.
+func (l *Lexer) parseByteStringLiteral() bool {
+	if l.peek() != 'b' || l.runeAt(l.currPos.Idx+1) != '"' {
+		return false
 	}
 
 	l.advance()
+	l.advance()
+
+	var sb strings.Builder
+	var errorMsg string
 
-	l.commit(STRING_LITERAL)
+	for !l.eof() {
+		ch := l.advance()
 
+		var msg string
+		switch {
+		case ch == '"':
+			l.commitDecoded(BYTE_STRING_LITERAL, BYTE_STRING_LITERAL_ERROR, sb.String(), errorMsg)
+			return true
+		case ch == '\\' && l.peek() == 'x':
+			l.advance()
+			msg = l.parseByteEscape(&sb)
+		case ch == '\\' && l.peek() == 'u':
+			msg = "Byte string literals cannot contain unicode escape sequences"
+		case ch == '\\':
+			msg = l.parseEscape(&sb)
+		case ch >= 0x80:
+			msg = fmt.Sprintf("Byte string literals can only contain ASCII characters, %c has to be escaped", ch)
+		default:
+			sb.WriteByte(byte(ch))
+		}
+
+		if errorMsg == "" {
+			errorMsg = msg
+		}
+	}
+
+	l.commitErr(BYTE_STRING_LITERAL_ERROR, "Byte string literal not closed")
 	return true
 }
 
-func (l *lexer) parseIdentifierOrKeyword() bool {
-	matchUnderscore := func(ch rune) bool {
-		return ch == '_'
+// parseByteEscape decodes the two hex digits of a \x escape sequence.
+func (l *Lexer) parseByteEscape(sb *strings.Builder) string {
+	start := l.currPos.Idx
+	for range 2 {
+		if !isHexDigit(l.peek()) {
+			return "Expected two hex digits after \\x"
+		}
+		l.advance()
 	}
 
-	matchLowerAtoZ := func(ch rune) bool {
-		return ch >= 'a' && ch <= 'z'
+	value, _ := strconv.ParseUint(l.text(start, l.currPos.Idx), 16, 8)
+	sb.WriteByte(byte(value))
+	return ""
+}
+
+// parseSymLiteral parses a tick followed by the name of a symbol, like 'ok.
+func (l *Lexer) parseSymLiteral() bool {
+	if l.peek() != '\'' {
+		return false
 	}
 
-	matchUpperAtoZ := func(ch rune) bool {
-		return ch >= 'A' && ch <= 'Z'
+	l.advance()
+
+	if !isIdentifierStart(l.peek()) {
+		l.commitErr(SYM_LITERAL_ERROR, "Expected the name of a symbol after '")
+		return true
 	}
 
-	match0to9 := func(ch rune) bool {
-		return ch >= '0' && ch <= '9'
+	start := l.currPos.Idx
+	l.advanceWhile(isIdentifierContinue)
+
+	l.commitDecoded(SYM_LITERAL, SYM_LITERAL_ERROR, normalize(l.text(start, l.currPos.Idx)), "")
+	return true
+}
+
+func (l *Lexer) closesRawString(hashes int) bool {
+	for idx := range hashes {
+		if l.runeAt(l.currPos.Idx+idx) != '#' {
+			return false
+		}
 	}
 
-	firstCh := l.peek()
+	return true
+}
 
-	if !(matchUnderscore(firstCh) || matchLowerAtoZ(firstCh) || matchUpperAtoZ(firstCh)) {
+func (l *Lexer) parseIdentifierOrKeyword() bool {
+	if !isIdentifierStart(l.peek()) {
 		return false
 	}
 
-	l.advanceWhile(func(ch rune) bool {
-		return (matchUnderscore(ch) ||
-			matchLowerAtoZ(ch) ||
-			matchUpperAtoZ(ch) ||
-			match0to9(ch))
-	})
+	l.advanceWhile(isIdentifierContinue)
+
+	// Identifiers are compared in NFC, the literal of the token is the
+	// normalised name while its position still covers the source text
+	name := normalize(l.literal())
+
+	if name == "let" && l.peek() == '!' && l.runeAt(l.currPos.Idx+1) != '=' {
+		// The ! of let! is not an identifier character, so it is only taken
+		// if it directly follows and does not start a !=
+		l.advance()
+		l.commit(KEYWORD_LET_EXCLAMATION)
+		return true
+	}
 
-	if matchUnderscore(firstCh) {
-		l.commit(MUTED_IDENTIFIER)
+	if tokenType, ok := keywords[name]; ok {
+		l.commit(tokenType)
 		return true
 	}
 
-	switch l.literal() {
-	case "namespace":
-		l.commit(KEYWORD_NAMESPACE)
-	case "import":
-		l.commit(KEYWORD_IMPORT)
-	case "from":
-		l.commit(KEYWORD_FROM)
-	case "as":
-		l.commit(KEYWORD_AS)
-	case "let!":
-		l.commit(KEYWORD_LET_EXCLAMATION)
-	case "let":
-		l.commit(KEYWORD_LET)
-	case "const":
-		l.commit(KEYWORD_CONST)
-	case "ext":
-		l.commit(KEYWORD_EXT)
-	case "pub":
-		l.commit(KEYWORD_PUB)
-	case "fn":
-		l.commit(KEYWORD_FN)
-	case "struct":
-		l.commit(KEYWORD_STRUCT)
-	case "trait":
-		l.commit(KEYWORD_TRAIT)
-	case "impl":
-		l.commit(KEYWORD_IMPL)
-	case "self":
-		l.commit(KEYWORD_SELF)
-	case "nil":
-		l.commit(KEYWORD_NIL)
-	case "if":
-		l.commit(KEYWORD_IF)
-	case "cond":
-		l.commit(KEYWORD_COND)
-	case "case":
-		l.commit(KEYWORD_CASE)
-	case "else":
-		l.commit(KEYWORD_ELSE)
-	case "return":
-		l.commit(KEYWORD_RETURN)
-	case "not":
-		l.commit(KEYWORD_NOT)
-	case "and":
-		l.commit(KEYWORD_AND)
-	case "or":
-		l.commit(KEYWORD_OR)
-	case "xor":
-		l.commit(KEYWORD_XOR)
-	case "shl":
-		l.commit(KEYWORD_SHL)
-	case "shr":
-		l.commit(KEYWORD_SHR)
-	case "ashr":
-		l.commit(KEYWORD_ASHR)
-	case "cshl":
-		l.commit(KEYWORD_CSHL)
-	case "cshr":
-		l.commit(KEYWORD_CSHR)
-	case "true":
-		l.commit(KEYWORD_TRUE)
-	case "false":
-		l.commit(KEYWORD_FALSE)
-	case "bool":
-		l.commit(KEYWORD_NAMESPACE)
-	case "u8":
-		l.commit(KEYWORD_NAMESPACE)
-	case "u16":
-		l.commit(KEYWORD_NAMESPACE)
-	case "u32":
-		l.commit(KEYWORD_NAMESPACE)
-	case "u64":
-		l.commit(KEYWORD_NAMESPACE)
-	case "i8":
-		l.commit(KEYWORD_NAMESPACE)
-	case "i16":
-		l.commit(KEYWORD_NAMESPACE)
-	case "i32":
-		l.commit(KEYWORD_NAMESPACE)
-	case "i64":
-		l.commit(KEYWORD_NAMESPACE)
-	case "f32":
-		l.commit(KEYWORD_NAMESPACE)
-	case "f64":
-		l.commit(KEYWORD_NAMESPACE)
-	case "num":
-		l.commit(KEYWORD_NAMESPACE)
-	case "sym":
-		l.commit(KEYWORD_NAMESPACE)
-	case "bin":
-		l.commit(KEYWORD_NAMESPACE)
-	default:
-		l.commit(IDENTIFIER)
+	if scripts := mixedScripts(name); scripts != nil {
+		l.commitErr(IDENTIFIER_ERROR, fmt.Sprintf("Identifier %s mixes the scripts %s", name, strings.Join(scripts, " and ")))
+		return true
+	}
+
+	// Identifiers that look like other identifiers are found by the resolver,
+	// the lexer would have to keep every name it has seen
+	if skeleton := Skeleton(name); keywords[skeleton] != "" {
+		l.commitErr(IDENTIFIER_ERROR, fmt.Sprintf("Identifier %s can be confused with the keyword %s", name, skeleton))
+		return true
+	}
+
+	if name[0] == '_' {
+		l.commitName(MUTED_IDENTIFIER, name)
+	} else {
+		l.commitName(IDENTIFIER, name)
 	}
 
 	return true
 }
 
-func (l *lexer) parseXaryNumLiteral(
+// numberSuffixes are the types a number literal can be suffixed with, like
+// 255u8 or 1.5f32. The value is true for float types, which can only follow
+// normal number literals.
+var numberSuffixes = map[string]bool{
+	"u8":  false,
+	"u16": false,
+	"u32": false,
+	"u64": false,
+	"i8":  false,
+	"i16": false,
+	"i32": false,
+	"i64": false,
+	"f32": true,
+	"f64": true,
+}
+
+func (l *Lexer) parseXaryNumLiteral(
 	identifier rune,
 	match func(rune) bool,
 	tokenType TokenType,
@@ -496,31 +919,31 @@ func (l *lexer) parseXaryNumLiteral(
 
 	l.advance()
 
-	var foundData bool
-	l.advanceWhile(func(ch rune) bool {
-		if match(ch) {
-			foundData = true
-			return true
-		}
-		return ch == '_'
-	})
+	foundData, errorMsg := l.parseDigits(match, true)
 
-	if errMatch(l.peek()) {
+	if errMatch(l.peek()) && !isSuffixStart(l.peek()) {
 		l.advanceWhile(errMatch)
 		l.commitErr(errTokenType, "Xary number literals can't be followed by a-z, A-Z, 0-9 or _")
 		return true
 	}
 
-	if !foundData {
+	suffix, suffixMsg := l.parseNumberSuffix(errMatch, false, false)
+
+	switch {
+	case !foundData:
 		l.commitErr(errTokenType, "Xary number literal defined without data")
-		return true
+	case errorMsg != "":
+		l.commitErr(errTokenType, errorMsg)
+	case suffixMsg != "":
+		l.commitErr(errTokenType, suffixMsg)
+	default:
+		l.commitNumber(tokenType, suffix)
 	}
 
-	l.commit(tokenType)
 	return true
 }
 
-func (l *lexer) parseNormalNumLiteral() bool {
+func (l *Lexer) parseNormalNumLiteral() bool {
 	errMatch := func(ch rune) bool {
 		return ch == '_' ||
 			ch == '.' ||
@@ -539,24 +962,14 @@ func (l *lexer) parseNormalNumLiteral() bool {
 		return false
 	}
 
-	l.advanceWhile(match0to9)
-
-	if !errMatch(l.peek()) {
-		l.commit(NORMAL_NUM_LITERAL)
-		return true
-	}
-
-	ch := l.peek()
-	if ch != '.' && ch != 'e' {
-		l.advanceWhile(errMatch)
-		l.commitErr(NORMAL_NUM_LITERAL_ERROR, "Number literals cannot contain a-z, A-Z, 0-9, _ or .")
-		return true
-	}
+	_, errorMsg := l.parseDigits(match0to9, false)
+	isFloat := false
 
 	/* Handle decimal part */
 
 	if l.peek() == '.' {
 		l.advance()
+		isFloat = true
 
 		if !match0to9(l.peek()) {
 			l.advanceWhile(errMatch)
@@ -564,49 +977,133 @@ func (l *lexer) parseNormalNumLiteral() bool {
 			return true
 		}
 
-		l.advanceWhile(match0to9)
-
-		ch = l.peek()
-		if ch != 'e' {
-			if errMatch(ch) {
-				l.advanceWhile(errMatch)
-				l.commitErr(NORMAL_NUM_LITERAL_ERROR, "Number literals cannot contain a-z, A-Z, 0-9, _ or .")
-				return true
-			} else {
-				l.commit(NORMAL_NUM_LITERAL)
-				return true
-			}
+		_, msg := l.parseDigits(match0to9, false)
+		if errorMsg == "" {
+			errorMsg = msg
 		}
 	}
 
 	/* Handle exponent part */
 
-	l.advance()
-
-	ch = l.peek()
-	if ch == '+' || ch == '-' {
+	if l.peek() == 'e' {
 		l.advance()
-	}
+		isFloat = true
 
-	if !match0to9(l.peek()) {
-		l.advanceWhile(errMatch)
-		l.commitErr(NORMAL_NUM_LITERAL_ERROR, "No numbers specified after exponent sign")
-		return true
+		ch := l.peek()
+		if ch == '+' || ch == '-' {
+			l.advance()
+		}
+
+		if !match0to9(l.peek()) {
+			l.advanceWhile(errMatch)
+			l.commitErr(NORMAL_NUM_LITERAL_ERROR, "No numbers specified after exponent sign")
+			return true
+		}
+
+		_, msg := l.parseDigits(match0to9, false)
+		if errorMsg == "" {
+			errorMsg = msg
+		}
 	}
 
-	l.advanceWhile(match0to9)
+	/* Handle suffix */
 
-	if errMatch(l.peek()) {
+	if errMatch(l.peek()) && !isSuffixStart(l.peek()) {
 		l.advanceWhile(errMatch)
 		l.commitErr(NORMAL_NUM_LITERAL_ERROR, "Number literals cannot contain a-z, A-Z, 0-9, _ or .")
 		return true
 	}
 
-	l.commit(NORMAL_NUM_LITERAL)
+	suffix, suffixMsg := l.parseNumberSuffix(errMatch, isFloat, true)
+
+	switch {
+	case errorMsg != "":
+		l.commitErr(NORMAL_NUM_LITERAL_ERROR, errorMsg)
+	case suffixMsg != "":
+		l.commitErr(NORMAL_NUM_LITERAL_ERROR, suffixMsg)
+	default:
+		l.commitNumber(NORMAL_NUM_LITERAL, suffix)
+	}
+
 	return true
 }
 
-func (l *lexer) parseUnknown() bool {
+// parseDigits parses digits that may be separated by single underscores. An
+// underscore may also follow the prefix of a xary number literal, like in
+// 0x_FF, but never ends the digits.
+func (l *Lexer) parseDigits(match func(rune) bool, afterPrefix bool) (bool, string) {
+	var foundData bool
+	var errorMsg string
+
+	last := rune(0)
+	for !l.eof() {
+		ch := l.peek()
+
+		switch {
+		case match(ch):
+			foundData = true
+		case ch == '_':
+			if last == '_' || (last == 0 && !afterPrefix) {
+				errorMsg = "Digit separators _ have to be placed between digits"
+			}
+		default:
+			if last == '_' && errorMsg == "" {
+				errorMsg = "Digit separators _ have to be placed between digits"
+			}
+			return foundData, errorMsg
+		}
+
+		last = ch
+		l.advance()
+	}
+
+	if last == '_' && errorMsg == "" {
+		errorMsg = "Digit separators _ have to be placed between digits"
+	}
+
+	return foundData, errorMsg
+}
+
+func isSuffixStart(ch rune) bool {
+	return ch == 'u' || ch == 'i' || ch == 'f'
+}
+
+// parseNumberSuffix parses the type suffix of a number literal if it has
+// one. Float suffixes turn integers into floats, but integer suffixes cannot
+// be given to floats.
+func (l *Lexer) parseNumberSuffix(match func(rune) bool, isFloat bool, allowFloat bool) (string, string) {
+	start := l.currPos.Idx
+	l.advanceWhile(match)
+	suffix := l.text(start, l.currPos.Idx)
+
+	if suffix == "" {
+		return "", ""
+	}
+
+	float, ok := numberSuffixes[suffix]
+	switch {
+	case !ok:
+		return suffix, fmt.Sprintf("Unknown number literal suffix %s", suffix)
+	case float && !allowFloat:
+		return suffix, fmt.Sprintf("Only decimal number literals can have the suffix %s", suffix)
+	case isFloat && !float:
+		return suffix, fmt.Sprintf("Float literals cannot have the integer suffix %s", suffix)
+	}
+
+	return suffix, ""
+}
+
+func (l *Lexer) commitDoc(text string) {
+	l.commit(DOC_COMMENT)
+	l.tokens[len(l.tokens)-1].Value = text
+}
+
+func (l *Lexer) commitNumber(tokenType TokenType, suffix string) {
+	l.commit(tokenType)
+	l.tokens[len(l.tokens)-1].Suffix = suffix
+}
+
+func (l *Lexer) parseUnknown() bool {
 	l.advance()
 
 	l.commitErr(UNKNOWN, "The specified characters are unknown")
@@ -1,8 +1,12 @@
 package lexer_test
 
 import (
+	"errors"
+	"io"
 	"reflect"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/henryk-kramer/quartz-lang/internal/pkg/lexer"
 	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
@@ -13,10 +17,20 @@ type testStruct struct {
 	want  []lexer.Token
 }
 
+// runePosition leaves out the byte and UTF-16 positions, which are tested by
+// TestPositions.
+func runePosition(pos util.Position) util.Position {
+	pos.Offset = 0
+	pos.Col16 = 0
+	pos.Size = 0
+	return pos
+}
+
 func testHelper(t *testing.T, tests []testStruct) {
 	for _, test := range tests {
 		t.Run(test.input, func(t *testing.T) {
-			tokens := lexer.Run(test.input, "")
+			file := util.NewFileSet().AddFile("", test.input)
+			tokens := lexer.Run(file)
 
 			if len(tokens) != len(test.want) {
 				t.Errorf("\n%s\n%s\n%s\n%s",
@@ -31,8 +45,10 @@ func testHelper(t *testing.T, tests []testStruct) {
 			for idx, token := range tokens {
 				matchingType := token.Type == test.want[idx].Type
 				matchingHasError := token.HasError == test.want[idx].HasError
-				matchingLiteral := token.Literal == test.want[idx].Literal
-				matchingPos := reflect.DeepEqual(token.Pos, test.want[idx].Pos)
+				matchingLiteral := token.Literal == test.want[idx].Literal && token.Value == test.want[idx].Value && token.Suffix == test.want[idx].Suffix
+				want := test.want[idx].Pos
+				want.File = file
+				matchingPos := reflect.DeepEqual(runePosition(token.Pos), want)
 
 				if !matchingType || !matchingHasError || !matchingLiteral || !matchingPos {
 					t.Errorf("\n%s\nidx: %d\ntype: %t\nerror: %t\nliteral: %t\npos: %t\n%s\n%s\n%s\n%s",
@@ -93,6 +109,16 @@ func TestParseSingleLineComment(t *testing.T) {
 	testHelper(t, []testStruct{
 		{"//", []lexer.Token{{Type: lexer.SINGLE_LINE_COMMENT, Literal: "//", HasError: false, Pos: util.Position{Len: 2}}}},
 		{"//test", []lexer.Token{{Type: lexer.SINGLE_LINE_COMMENT, Literal: "//test", HasError: false, Pos: util.Position{Len: 6}}}},
+		{"////test", []lexer.Token{{Type: lexer.SINGLE_LINE_COMMENT, Literal: "////test", HasError: false, Pos: util.Position{Len: 8}}}},
+		{"//a\r\nb", []lexer.Token{
+			{Type: lexer.SINGLE_LINE_COMMENT, Literal: "//a", HasError: false, Pos: util.Position{Len: 3}},
+			{Type: lexer.NEWLINE, Literal: "\r\n", HasError: false, Pos: util.Position{Idx: 3, Col: 3, Len: 2}},
+			{Type: lexer.IDENTIFIER, Literal: "b", HasError: false, Pos: util.Position{Idx: 5, Row: 1, Len: 1}},
+		}},
+
+		// Doc comments
+		{"///", []lexer.Token{{Type: lexer.DOC_COMMENT, Literal: "///", HasError: false, Pos: util.Position{Len: 3}}}},
+		{"/// Adds  two", []lexer.Token{{Type: lexer.DOC_COMMENT, Literal: "/// Adds  two", Value: "Adds  two", HasError: false, Pos: util.Position{Len: 13}}}},
 	})
 }
 
@@ -102,10 +128,21 @@ func TestParseMultiLineComment(t *testing.T) {
 		{"/**/", []lexer.Token{{Type: lexer.MULTI_LINE_COMMENT, Literal: "/**/", HasError: false, Pos: util.Position{Len: 4}}}},
 		{"/*test*/", []lexer.Token{{Type: lexer.MULTI_LINE_COMMENT, Literal: "/*test*/", HasError: false, Pos: util.Position{Len: 8}}}},
 		{"/*\n*/", []lexer.Token{{Type: lexer.MULTI_LINE_COMMENT, Literal: "/*\n*/", HasError: false, Pos: util.Position{Len: 5}}}},
+		{"/*/**/*/", []lexer.Token{{Type: lexer.MULTI_LINE_COMMENT, Literal: "/*/**/*/", HasError: false, Pos: util.Position{Len: 8}}}},
+		{"/* a /* b */ c */", []lexer.Token{{Type: lexer.MULTI_LINE_COMMENT, Literal: "/* a /* b */ c */", HasError: false, Pos: util.Position{Len: 17}}}},
+		{"/***/", []lexer.Token{{Type: lexer.MULTI_LINE_COMMENT, Literal: "/***/", HasError: false, Pos: util.Position{Len: 5}}}},
+		{"/*** a */", []lexer.Token{{Type: lexer.MULTI_LINE_COMMENT, Literal: "/*** a */", HasError: false, Pos: util.Position{Len: 9}}}},
+
+		// Doc comments
+		{"/** Adds */", []lexer.Token{{Type: lexer.DOC_COMMENT, Literal: "/** Adds */", Value: "Adds", HasError: false, Pos: util.Position{Len: 11}}}},
+		{"/**\n * Adds\n *   two\n */", []lexer.Token{{Type: lexer.DOC_COMMENT, Literal: "/**\n * Adds\n *   two\n */", Value: "Adds\n  two", HasError: false, Pos: util.Position{Len: 24}}}},
+		{"/** a /* b */ */", []lexer.Token{{Type: lexer.DOC_COMMENT, Literal: "/** a /* b */ */", Value: "a /* b */", HasError: false, Pos: util.Position{Len: 16}}}},
 
 		// Wrong
 		{"/*", []lexer.Token{{Type: lexer.MULTI_LINE_COMMENT_ERROR, Literal: "/*", HasError: true, Pos: util.Position{Len: 2}}}},
 		{"/*test", []lexer.Token{{Type: lexer.MULTI_LINE_COMMENT_ERROR, Literal: "/*test", HasError: true, Pos: util.Position{Len: 6}}}},
+		{"/*/", []lexer.Token{{Type: lexer.MULTI_LINE_COMMENT_ERROR, Literal: "/*/", HasError: true, Pos: util.Position{Len: 3}}}},
+		{"/* /* */", []lexer.Token{{Type: lexer.MULTI_LINE_COMMENT_ERROR, Literal: "/* /* */", HasError: true, Pos: util.Position{Len: 8}}}},
 	})
 }
 
@@ -113,21 +150,177 @@ func TestParseString(t *testing.T) {
 	testHelper(t, []testStruct{
 		// Correct
 		{"\"\"", []lexer.Token{{Type: lexer.STRING_LITERAL, Literal: "\"\"", HasError: false, Pos: util.Position{Len: 2}}}},
-		{"\"test\"", []lexer.Token{{Type: lexer.STRING_LITERAL, Literal: "\"test\"", HasError: false, Pos: util.Position{Len: 6}}}},
-		{"\"\n\"", []lexer.Token{{Type: lexer.STRING_LITERAL, Literal: "\"\n\"", HasError: false, Pos: util.Position{Len: 3}}}},
-		{"\"\\\"\"", []lexer.Token{{Type: lexer.STRING_LITERAL, Literal: "\"\\\"\"", HasError: false, Pos: util.Position{Len: 4}}}},
+		{"\"test\"", []lexer.Token{{Type: lexer.STRING_LITERAL, Literal: "\"test\"", Value: "test", HasError: false, Pos: util.Position{Len: 6}}}},
+		{"\"\n\"", []lexer.Token{{Type: lexer.STRING_LITERAL, Literal: "\"\n\"", Value: "\n", HasError: false, Pos: util.Position{Len: 3}}}},
+		{"\"\r\n\"", []lexer.Token{{Type: lexer.STRING_LITERAL, Literal: "\"\r\n\"", Value: "\n", HasError: false, Pos: util.Position{Len: 4}}}},
+
+		// Escapes
+		{"\"\\\"\"", []lexer.Token{{Type: lexer.STRING_LITERAL, Literal: "\"\\\"\"", Value: "\"", HasError: false, Pos: util.Position{Len: 4}}}},
+		{"\"a\\nb\\t\\\\\"", []lexer.Token{{Type: lexer.STRING_LITERAL, Literal: "\"a\\nb\\t\\\\\"", Value: "a\nb\t\\", HasError: false, Pos: util.Position{Len: 10}}}},
+		{"\"\\{\\}\\0\"", []lexer.Token{{Type: lexer.STRING_LITERAL, Literal: "\"\\{\\}\\0\"", Value: "{}\x00", HasError: false, Pos: util.Position{Len: 8}}}},
+		{"\"\\u{41}\\u{e9}\\u{1F600}\"", []lexer.Token{{Type: lexer.STRING_LITERAL, Literal: "\"\\u{41}\\u{e9}\\u{1F600}\"", Value: "Aé😀", HasError: false, Pos: util.Position{Len: 23}}}},
+
+		// Raw strings
+		{"r\"a\\n{b}\"", []lexer.Token{{Type: lexer.STRING_LITERAL, Literal: "r\"a\\n{b}\"", Value: "a\\n{b}", HasError: false, Pos: util.Position{Len: 9}}}},
+		{"r#\"say \"hi\"\n\"#", []lexer.Token{{Type: lexer.STRING_LITERAL, Literal: "r#\"say \"hi\"\n\"#", Value: "say \"hi\"\n", HasError: false, Pos: util.Position{Len: 14}}}},
+		{"r#x", []lexer.Token{
+			{Type: lexer.IDENTIFIER, Literal: "r", HasError: false, Pos: util.Position{Len: 1}},
+			{Type: lexer.UNKNOWN, Literal: "#", HasError: true, Pos: util.Position{Idx: 1, Col: 1, Len: 1}},
+			{Type: lexer.IDENTIFIER, Literal: "x", HasError: false, Pos: util.Position{Idx: 2, Col: 2, Len: 1}},
+		}},
 
 		// Wrong
 		{"\"", []lexer.Token{{Type: lexer.STRING_LITERAL_ERROR, Literal: "\"", HasError: true, Pos: util.Position{Len: 1}}}},
 		{"\"test", []lexer.Token{{Type: lexer.STRING_LITERAL_ERROR, Literal: "\"test", HasError: true, Pos: util.Position{Len: 5}}}},
+		{"\"\\q\"", []lexer.Token{{Type: lexer.STRING_LITERAL_ERROR, Literal: "\"\\q\"", HasError: true, Pos: util.Position{Len: 4}}}},
+		{"\"\\u41\"", []lexer.Token{{Type: lexer.STRING_LITERAL_ERROR, Literal: "\"\\u41\"", Value: "41", HasError: true, Pos: util.Position{Len: 6}}}},
+		{"\"\\u{D800}\"", []lexer.Token{{Type: lexer.STRING_LITERAL_ERROR, Literal: "\"\\u{D800}\"", HasError: true, Pos: util.Position{Len: 10}}}},
+		{"\"\\u{1234567}\"", []lexer.Token{{Type: lexer.STRING_LITERAL_ERROR, Literal: "\"\\u{1234567}\"", HasError: true, Pos: util.Position{Len: 13}}}},
+		{"r#\"a\"", []lexer.Token{{Type: lexer.STRING_LITERAL_ERROR, Literal: "r#\"a\"", HasError: true, Pos: util.Position{Len: 5}}}},
+	})
+}
+
+func TestParseInterpolation(t *testing.T) {
+	testHelper(t, []testStruct{
+		{"\"value: {x}\"", []lexer.Token{
+			{Type: lexer.INTERPOLATION_START, Literal: "\"value: {", Value: "value: ", HasError: false, Pos: util.Position{Len: 9}},
+			{Type: lexer.IDENTIFIER, Literal: "x", HasError: false, Pos: util.Position{Idx: 9, Col: 9, Len: 1}},
+			{Type: lexer.INTERPOLATION_END, Literal: "}\"", HasError: false, Pos: util.Position{Idx: 10, Col: 10, Len: 2}},
+		}},
+		{"\"{a} and {b}!\"", []lexer.Token{
+			{Type: lexer.INTERPOLATION_START, Literal: "\"{", HasError: false, Pos: util.Position{Len: 2}},
+			{Type: lexer.IDENTIFIER, Literal: "a", HasError: false, Pos: util.Position{Idx: 2, Col: 2, Len: 1}},
+			{Type: lexer.INTERPOLATION_MIDDLE, Literal: "} and {", Value: " and ", HasError: false, Pos: util.Position{Idx: 3, Col: 3, Len: 7}},
+			{Type: lexer.IDENTIFIER, Literal: "b", HasError: false, Pos: util.Position{Idx: 10, Col: 10, Len: 1}},
+			{Type: lexer.INTERPOLATION_END, Literal: "}!\"", Value: "!", HasError: false, Pos: util.Position{Idx: 11, Col: 11, Len: 3}},
+		}},
+		{"\"{P {}}\"", []lexer.Token{
+			{Type: lexer.INTERPOLATION_START, Literal: "\"{", HasError: false, Pos: util.Position{Len: 2}},
+			{Type: lexer.IDENTIFIER, Literal: "P", HasError: false, Pos: util.Position{Idx: 2, Col: 2, Len: 1}},
+			{Type: lexer.WHITESPACE, Literal: " ", HasError: false, Pos: util.Position{Idx: 3, Col: 3, Len: 1}},
+			{Type: lexer.OPENED_BRACE, Literal: "{", HasError: false, Pos: util.Position{Idx: 4, Col: 4, Len: 1}},
+			{Type: lexer.CLOSED_BRACE, Literal: "}", HasError: false, Pos: util.Position{Idx: 5, Col: 5, Len: 1}},
+			{Type: lexer.INTERPOLATION_END, Literal: "}\"", HasError: false, Pos: util.Position{Idx: 6, Col: 6, Len: 2}},
+		}},
+		{"\"a{\"b{c}\"}\"", []lexer.Token{
+			{Type: lexer.INTERPOLATION_START, Literal: "\"a{", Value: "a", HasError: false, Pos: util.Position{Len: 3}},
+			{Type: lexer.INTERPOLATION_START, Literal: "\"b{", Value: "b", HasError: false, Pos: util.Position{Idx: 3, Col: 3, Len: 3}},
+			{Type: lexer.IDENTIFIER, Literal: "c", HasError: false, Pos: util.Position{Idx: 6, Col: 6, Len: 1}},
+			{Type: lexer.INTERPOLATION_END, Literal: "}\"", HasError: false, Pos: util.Position{Idx: 7, Col: 7, Len: 2}},
+			{Type: lexer.INTERPOLATION_END, Literal: "}\"", HasError: false, Pos: util.Position{Idx: 9, Col: 9, Len: 2}},
+		}},
+		{"\"{x}\\q\"", []lexer.Token{
+			{Type: lexer.INTERPOLATION_START, Literal: "\"{", HasError: false, Pos: util.Position{Len: 2}},
+			{Type: lexer.IDENTIFIER, Literal: "x", HasError: false, Pos: util.Position{Idx: 2, Col: 2, Len: 1}},
+			{Type: lexer.STRING_LITERAL_ERROR, Literal: "}\\q\"", HasError: true, Pos: util.Position{Idx: 3, Col: 3, Len: 4}},
+		}},
+		{"\"{x", []lexer.Token{
+			{Type: lexer.INTERPOLATION_START, Literal: "\"{", HasError: false, Pos: util.Position{Len: 2}},
+			{Type: lexer.IDENTIFIER, Literal: "x", HasError: false, Pos: util.Position{Idx: 2, Col: 2, Len: 1}},
+		}},
+	})
+}
+
+func TestParseByteString(t *testing.T) {
+	testHelper(t, []testStruct{
+		// Correct
+		{"b\"\"", []lexer.Token{{Type: lexer.BYTE_STRING_LITERAL, Literal: "b\"\"", HasError: false, Pos: util.Position{Len: 3}}}},
+		{"b\"a{b}\"", []lexer.Token{{Type: lexer.BYTE_STRING_LITERAL, Literal: "b\"a{b}\"", Value: "a{b}", HasError: false, Pos: util.Position{Len: 7}}}},
+		{"b\"\\x00\\xfF\\n\"", []lexer.Token{{Type: lexer.BYTE_STRING_LITERAL, Literal: "b\"\\x00\\xfF\\n\"", Value: "\x00\xff\n", HasError: false, Pos: util.Position{Len: 13}}}},
+		{"b", []lexer.Token{{Type: lexer.IDENTIFIER, Literal: "b", HasError: false, Pos: util.Position{Len: 1}}}},
+
+		// Wrong
+		{"b\"a", []lexer.Token{{Type: lexer.BYTE_STRING_LITERAL_ERROR, Literal: "b\"a", Value: "", HasError: true, Pos: util.Position{Len: 3}}}},
+		{"b\"é\"", []lexer.Token{{Type: lexer.BYTE_STRING_LITERAL_ERROR, Literal: "b\"é\"", HasError: true, Pos: util.Position{Len: 4}}}},
+		{"b\"\\x4\"", []lexer.Token{{Type: lexer.BYTE_STRING_LITERAL_ERROR, Literal: "b\"\\x4\"", HasError: true, Pos: util.Position{Len: 6}}}},
+		{"b\"\\u{41}\"", []lexer.Token{{Type: lexer.BYTE_STRING_LITERAL_ERROR, Literal: "b\"\\u{41}\"", Value: "u{41}", HasError: true, Pos: util.Position{Len: 9}}}},
+	})
+}
+
+func TestParseSymLiteral(t *testing.T) {
+	testHelper(t, []testStruct{
+		// Correct
+		{"'ok", []lexer.Token{{Type: lexer.SYM_LITERAL, Literal: "'ok", Value: "ok", HasError: false, Pos: util.Position{Len: 3}}}},
+		{"'not_found2", []lexer.Token{{Type: lexer.SYM_LITERAL, Literal: "'not_found2", Value: "not_found2", HasError: false, Pos: util.Position{Len: 11}}}},
+		{"'e\u0301", []lexer.Token{{Type: lexer.SYM_LITERAL, Literal: "'e\u0301", Value: "\u00e9", HasError: false, Pos: util.Position{Len: 3}}}},
+		{"'if", []lexer.Token{{Type: lexer.SYM_LITERAL, Literal: "'if", Value: "if", HasError: false, Pos: util.Position{Len: 3}}}},
+		{"'a'b", []lexer.Token{
+			{Type: lexer.SYM_LITERAL, Literal: "'a", Value: "a", HasError: false, Pos: util.Position{Len: 2}},
+			{Type: lexer.SYM_LITERAL, Literal: "'b", Value: "b", HasError: false, Pos: util.Position{Idx: 2, Col: 2, Len: 2}},
+		}},
+
+		// Wrong
+		{"'", []lexer.Token{{Type: lexer.SYM_LITERAL_ERROR, Literal: "'", HasError: true, Pos: util.Position{Len: 1}}}},
+		{"'1", []lexer.Token{
+			{Type: lexer.SYM_LITERAL_ERROR, Literal: "'", HasError: true, Pos: util.Position{Len: 1}},
+			{Type: lexer.NORMAL_NUM_LITERAL, Literal: "1", HasError: false, Pos: util.Position{Idx: 1, Col: 1, Len: 1}},
+		}},
 	})
 }
 
 func TestParseIdentifierOrKeyword(t *testing.T) {
 	testHelper(t, []testStruct{
 		{"let", []lexer.Token{{Type: lexer.KEYWORD_LET, Literal: "let", HasError: false, Pos: util.Position{Len: 3}}}},
+		{"let!", []lexer.Token{{Type: lexer.KEYWORD_LET_EXCLAMATION, Literal: "let!", HasError: false, Pos: util.Position{Len: 4}}}},
+		{"let!=", []lexer.Token{
+			{Type: lexer.KEYWORD_LET, Literal: "let", HasError: false, Pos: util.Position{Len: 3}},
+			{Type: lexer.NOT_EQUALS, Literal: "!=", HasError: false, Pos: util.Position{Idx: 3, Col: 3, Len: 2}},
+		}},
+		{"let !", []lexer.Token{
+			{Type: lexer.KEYWORD_LET, Literal: "let", HasError: false, Pos: util.Position{Len: 3}},
+			{Type: lexer.WHITESPACE, Literal: " ", HasError: false, Pos: util.Position{Idx: 3, Col: 3, Len: 1}},
+			{Type: lexer.UNKNOWN, Literal: "!", HasError: true, Pos: util.Position{Idx: 4, Col: 4, Len: 1}},
+		}},
 		{"_let", []lexer.Token{{Type: lexer.MUTED_IDENTIFIER, Literal: "_let", HasError: false, Pos: util.Position{Len: 4}}}},
 		{"letme", []lexer.Token{{Type: lexer.IDENTIFIER, Literal: "letme", HasError: false, Pos: util.Position{Len: 5}}}},
+		{"for", []lexer.Token{{Type: lexer.KEYWORD_FOR, Literal: "for", HasError: false, Pos: util.Position{Len: 3}}}},
+		{"u8", []lexer.Token{{Type: lexer.KEYWORD_U8, Literal: "u8", HasError: false, Pos: util.Position{Len: 2}}}},
+		{"f64", []lexer.Token{{Type: lexer.KEYWORD_F64, Literal: "f64", HasError: false, Pos: util.Position{Len: 3}}}},
+	})
+}
+
+func TestParseUnicodeIdentifier(t *testing.T) {
+	testHelper(t, []testStruct{
+		// Correct
+		{"größe", []lexer.Token{{Type: lexer.IDENTIFIER, Literal: "größe", HasError: false, Pos: util.Position{Len: 5}}}},
+		{"_größe", []lexer.Token{{Type: lexer.MUTED_IDENTIFIER, Literal: "_größe", HasError: false, Pos: util.Position{Len: 6}}}},
+		{"число2", []lexer.Token{{Type: lexer.IDENTIFIER, Literal: "число2", HasError: false, Pos: util.Position{Len: 6}}}},
+		{"変数かな", []lexer.Token{{Type: lexer.IDENTIFIER, Literal: "変数かな", HasError: false, Pos: util.Position{Len: 4}}}},
+		{"id漢字", []lexer.Token{{Type: lexer.IDENTIFIER, Literal: "id漢字", HasError: false, Pos: util.Position{Len: 4}}}},
+		{"a·b", []lexer.Token{{Type: lexer.IDENTIFIER, Literal: "a·b", HasError: false, Pos: util.Position{Len: 3}}}},
+		{"℘", []lexer.Token{{Type: lexer.IDENTIFIER, Literal: "℘", HasError: false, Pos: util.Position{Len: 1}}}},
+
+		// Normalisation
+		{"cafe\u0301", []lexer.Token{{Type: lexer.IDENTIFIER, Literal: "caf\u00e9", HasError: false, Pos: util.Position{Len: 5}}}},
+		{"\u1100\u1161\u11a8", []lexer.Token{{Type: lexer.IDENTIFIER, Literal: "\uac01", HasError: false, Pos: util.Position{Len: 3}}}},
+		{"q\u0323\u0307 q\u0307\u0323", []lexer.Token{
+			{Type: lexer.IDENTIFIER, Literal: "q\u0323\u0307", HasError: false, Pos: util.Position{Len: 3}},
+			{Type: lexer.WHITESPACE, Literal: " ", HasError: false, Pos: util.Position{Idx: 3, Col: 3, Len: 1}},
+			{Type: lexer.IDENTIFIER, Literal: "q\u0323\u0307", HasError: false, Pos: util.Position{Idx: 4, Col: 4, Len: 3}},
+		}},
+
+		// Not identifier characters
+		{"٣x", []lexer.Token{
+			{Type: lexer.UNKNOWN, Literal: "٣", HasError: true, Pos: util.Position{Len: 1}},
+			{Type: lexer.IDENTIFIER, Literal: "x", HasError: false, Pos: util.Position{Idx: 1, Col: 1, Len: 1}},
+		}},
+		{"a\u200db", []lexer.Token{
+			{Type: lexer.IDENTIFIER, Literal: "a", HasError: false, Pos: util.Position{Len: 1}},
+			{Type: lexer.UNKNOWN, Literal: "\u200d", HasError: true, Pos: util.Position{Idx: 1, Col: 1, Len: 1}},
+			{Type: lexer.IDENTIFIER, Literal: "b", HasError: false, Pos: util.Position{Idx: 2, Col: 2, Len: 1}},
+		}},
+
+		// Mixed scripts and confusables
+		{"p\u0430ypal", []lexer.Token{{Type: lexer.IDENTIFIER_ERROR, Literal: "p\u0430ypal", HasError: true, Pos: util.Position{Len: 6}}}},
+		{"\u0430\u0455", []lexer.Token{{Type: lexer.IDENTIFIER_ERROR, Literal: "\u0430\u0455", HasError: true, Pos: util.Position{Len: 2}}}},
+		{"ｆｎ", []lexer.Token{{Type: lexer.IDENTIFIER_ERROR, Literal: "ｆｎ", HasError: true, Pos: util.Position{Len: 2}}}},
+
+		// Names that look alike are compared by the resolver
+		{"\u0440\u0430\u0455 pas", []lexer.Token{
+			{Type: lexer.IDENTIFIER, Literal: "\u0440\u0430\u0455", HasError: false, Pos: util.Position{Len: 3}},
+			{Type: lexer.WHITESPACE, Literal: " ", HasError: false, Pos: util.Position{Idx: 3, Col: 3, Len: 1}},
+			{Type: lexer.IDENTIFIER, Literal: "pas", HasError: false, Pos: util.Position{Idx: 4, Col: 4, Len: 3}},
+		}},
 	})
 }
 
@@ -136,13 +329,26 @@ func TestParseXaryNumLiteral(t *testing.T) {
 		// Correct
 		{"0b0", []lexer.Token{{Type: lexer.BIN_NUM_LITERAL, Literal: "0b0", HasError: false, Pos: util.Position{Len: 3}}}},
 		{"0b_0", []lexer.Token{{Type: lexer.BIN_NUM_LITERAL, Literal: "0b_0", HasError: false, Pos: util.Position{Len: 4}}}},
-		{"0b0_", []lexer.Token{{Type: lexer.BIN_NUM_LITERAL, Literal: "0b0_", HasError: false, Pos: util.Position{Len: 4}}}},
-		{"0b_0_", []lexer.Token{{Type: lexer.BIN_NUM_LITERAL, Literal: "0b_0_", HasError: false, Pos: util.Position{Len: 5}}}},
+		{"0b0_1", []lexer.Token{{Type: lexer.BIN_NUM_LITERAL, Literal: "0b0_1", HasError: false, Pos: util.Position{Len: 5}}}},
+		{"0x1_FFu8", []lexer.Token{{Type: lexer.HEX_NUM_LITERAL, Literal: "0x1_FFu8", Suffix: "u8", HasError: false, Pos: util.Position{Len: 8}}}},
+		{"0o17i64", []lexer.Token{{Type: lexer.OCT_NUM_LITERAL, Literal: "0o17i64", Suffix: "i64", HasError: false, Pos: util.Position{Len: 7}}}},
+		{"0xf32", []lexer.Token{{Type: lexer.HEX_NUM_LITERAL, Literal: "0xf32", HasError: false, Pos: util.Position{Len: 5}}}},
 
 		// No data error
 		{"0b", []lexer.Token{{Type: lexer.BIN_NUM_LITERAL_ERROR, Literal: "0b", HasError: true, Pos: util.Position{Len: 2}}}},
 		{"0b_", []lexer.Token{{Type: lexer.BIN_NUM_LITERAL_ERROR, Literal: "0b_", HasError: true, Pos: util.Position{Len: 3}}}},
 		{"0b__", []lexer.Token{{Type: lexer.BIN_NUM_LITERAL_ERROR, Literal: "0b__", HasError: true, Pos: util.Position{Len: 4}}}},
+		{"0bu8", []lexer.Token{{Type: lexer.BIN_NUM_LITERAL_ERROR, Literal: "0bu8", HasError: true, Pos: util.Position{Len: 4}}}},
+
+		// Separator error
+		{"0b0_", []lexer.Token{{Type: lexer.BIN_NUM_LITERAL_ERROR, Literal: "0b0_", HasError: true, Pos: util.Position{Len: 4}}}},
+		{"0b_0_", []lexer.Token{{Type: lexer.BIN_NUM_LITERAL_ERROR, Literal: "0b_0_", HasError: true, Pos: util.Position{Len: 5}}}},
+		{"0b0__1", []lexer.Token{{Type: lexer.BIN_NUM_LITERAL_ERROR, Literal: "0b0__1", HasError: true, Pos: util.Position{Len: 6}}}},
+		{"0x1_u8", []lexer.Token{{Type: lexer.HEX_NUM_LITERAL_ERROR, Literal: "0x1_u8", HasError: true, Pos: util.Position{Len: 6}}}},
+
+		// Suffix error
+		{"0b1u7", []lexer.Token{{Type: lexer.BIN_NUM_LITERAL_ERROR, Literal: "0b1u7", HasError: true, Pos: util.Position{Len: 5}}}},
+		{"0b1f32", []lexer.Token{{Type: lexer.BIN_NUM_LITERAL_ERROR, Literal: "0b1f32", HasError: true, Pos: util.Position{Len: 6}}}},
 
 		// Wrong format error
 		{"0b2", []lexer.Token{{Type: lexer.BIN_NUM_LITERAL_ERROR, Literal: "0b2", HasError: true, Pos: util.Position{Len: 3}}}},
@@ -163,6 +369,13 @@ func TestParseNormalNumLiteral(t *testing.T) {
 		{"0e0", []lexer.Token{{Type: lexer.NORMAL_NUM_LITERAL, Literal: "0e0", HasError: false, Pos: util.Position{Len: 3}}}},
 		{"0.0e0", []lexer.Token{{Type: lexer.NORMAL_NUM_LITERAL, Literal: "0.0e0", HasError: false, Pos: util.Position{Len: 5}}}},
 		{"0e+0", []lexer.Token{{Type: lexer.NORMAL_NUM_LITERAL, Literal: "0e+0", HasError: false, Pos: util.Position{Len: 4}}}},
+		{"1_000.000_1e1_0", []lexer.Token{{Type: lexer.NORMAL_NUM_LITERAL, Literal: "1_000.000_1e1_0", HasError: false, Pos: util.Position{Len: 15}}}},
+
+		// Suffixes
+		{"255u8", []lexer.Token{{Type: lexer.NORMAL_NUM_LITERAL, Literal: "255u8", Suffix: "u8", HasError: false, Pos: util.Position{Len: 5}}}},
+		{"1.0f32", []lexer.Token{{Type: lexer.NORMAL_NUM_LITERAL, Literal: "1.0f32", Suffix: "f32", HasError: false, Pos: util.Position{Len: 6}}}},
+		{"1e3f64", []lexer.Token{{Type: lexer.NORMAL_NUM_LITERAL, Literal: "1e3f64", Suffix: "f64", HasError: false, Pos: util.Position{Len: 6}}}},
+		{"1f32", []lexer.Token{{Type: lexer.NORMAL_NUM_LITERAL, Literal: "1f32", Suffix: "f32", HasError: false, Pos: util.Position{Len: 4}}}},
 
 		// No data error
 		{"0.", []lexer.Token{{Type: lexer.NORMAL_NUM_LITERAL_ERROR, Literal: "0.", HasError: true, Pos: util.Position{Len: 2}}}},
@@ -176,6 +389,96 @@ func TestParseNormalNumLiteral(t *testing.T) {
 		{"0e+a", []lexer.Token{{Type: lexer.NORMAL_NUM_LITERAL_ERROR, Literal: "0e+a", HasError: true, Pos: util.Position{Len: 4}}}},
 		{"0.0a", []lexer.Token{{Type: lexer.NORMAL_NUM_LITERAL_ERROR, Literal: "0.0a", HasError: true, Pos: util.Position{Len: 4}}}},
 		{"0e0a", []lexer.Token{{Type: lexer.NORMAL_NUM_LITERAL_ERROR, Literal: "0e0a", HasError: true, Pos: util.Position{Len: 4}}}},
+
+		// Separator error
+		{"1__0", []lexer.Token{{Type: lexer.NORMAL_NUM_LITERAL_ERROR, Literal: "1__0", HasError: true, Pos: util.Position{Len: 4}}}},
+		{"1_", []lexer.Token{{Type: lexer.NORMAL_NUM_LITERAL_ERROR, Literal: "1_", HasError: true, Pos: util.Position{Len: 2}}}},
+		{"1_.5", []lexer.Token{{Type: lexer.NORMAL_NUM_LITERAL_ERROR, Literal: "1_.5", HasError: true, Pos: util.Position{Len: 4}}}},
+		{"1._5", []lexer.Token{{Type: lexer.NORMAL_NUM_LITERAL_ERROR, Literal: "1._5", HasError: true, Pos: util.Position{Len: 4}}}},
+		{"1_u8", []lexer.Token{{Type: lexer.NORMAL_NUM_LITERAL_ERROR, Literal: "1_u8", HasError: true, Pos: util.Position{Len: 4}}}},
+
+		// Suffix error
+		{"1u7", []lexer.Token{{Type: lexer.NORMAL_NUM_LITERAL_ERROR, Literal: "1u7", HasError: true, Pos: util.Position{Len: 3}}}},
+		{"1.5u8", []lexer.Token{{Type: lexer.NORMAL_NUM_LITERAL_ERROR, Literal: "1.5u8", HasError: true, Pos: util.Position{Len: 5}}}},
+		{"1u8.5", []lexer.Token{{Type: lexer.NORMAL_NUM_LITERAL_ERROR, Literal: "1u8.5", HasError: true, Pos: util.Position{Len: 5}}}},
+	})
+}
+
+func TestParseOperator(t *testing.T) {
+	testHelper(t, []testStruct{
+		{"<=", []lexer.Token{{Type: lexer.LESS_THAN_OR_EQUALS, Literal: "<=", HasError: false, Pos: util.Position{Len: 2}}}},
+		{"<", []lexer.Token{{Type: lexer.LESS_THAN, Literal: "<", HasError: false, Pos: util.Position{Len: 1}}}},
+		{">=", []lexer.Token{{Type: lexer.GREATER_THAN_OR_EQUALS, Literal: ">=", HasError: false, Pos: util.Position{Len: 2}}}},
+		{">", []lexer.Token{{Type: lexer.GREATER_THAN, Literal: ">", HasError: false, Pos: util.Position{Len: 1}}}},
+		{"==", []lexer.Token{{Type: lexer.EQUALS, Literal: "==", HasError: false, Pos: util.Position{Len: 2}}}},
+		{"!=", []lexer.Token{{Type: lexer.NOT_EQUALS, Literal: "!=", HasError: false, Pos: util.Position{Len: 2}}}},
+		{"->", []lexer.Token{{Type: lexer.RETURN_TYPE_INDICATOR, Literal: "->", HasError: false, Pos: util.Position{Len: 2}}}},
+		{"??", []lexer.Token{{Type: lexer.IF_NIL, Literal: "??", HasError: false, Pos: util.Position{Len: 2}}}},
+		{"&&", []lexer.Token{{Type: lexer.LOGICAL_AND, Literal: "&&", HasError: false, Pos: util.Position{Len: 2}}}},
+		{"||", []lexer.Token{{Type: lexer.LOGICAL_OR, Literal: "||", HasError: false, Pos: util.Position{Len: 2}}}},
+		{"::", []lexer.Token{{Type: lexer.DOUBLE_SEMICOLON, Literal: "::", HasError: false, Pos: util.Position{Len: 2}}}},
+		{"=", []lexer.Token{{Type: lexer.BINDING, Literal: "=", HasError: false, Pos: util.Position{Len: 1}}}},
+		{"+=", []lexer.Token{{Type: lexer.PLUS_ASSIGNMENT, Literal: "+=", HasError: false, Pos: util.Position{Len: 2}}}},
+		{"-=", []lexer.Token{{Type: lexer.MINUS_ASSIGNMENT, Literal: "-=", HasError: false, Pos: util.Position{Len: 2}}}},
+		{"*=", []lexer.Token{{Type: lexer.STAR_ASSIGNMENT, Literal: "*=", HasError: false, Pos: util.Position{Len: 2}}}},
+		{"/=", []lexer.Token{{Type: lexer.SLASH_ASSIGNMENT, Literal: "/=", HasError: false, Pos: util.Position{Len: 2}}}},
+		{"^", []lexer.Token{{Type: lexer.CIRCUMFLEX, Literal: "^", HasError: false, Pos: util.Position{Len: 1}}}},
+		{"|", []lexer.Token{{Type: lexer.PIPE, Literal: "|", HasError: false, Pos: util.Position{Len: 1}}}},
+		{",", []lexer.Token{{Type: lexer.COMMA, Literal: ",", HasError: false, Pos: util.Position{Len: 1}}}},
+		{".", []lexer.Token{{Type: lexer.DOT, Literal: ".", HasError: false, Pos: util.Position{Len: 1}}}},
+		{":", []lexer.Token{{Type: lexer.TYPE_INDICATOR, Literal: ":", HasError: false, Pos: util.Position{Len: 1}}}},
+		{"+", []lexer.Token{{Type: lexer.PLUS_SIGN, Literal: "+", HasError: false, Pos: util.Position{Len: 1}}}},
+		{"-", []lexer.Token{{Type: lexer.MINUS_SIGN, Literal: "-", HasError: false, Pos: util.Position{Len: 1}}}},
+		{"*", []lexer.Token{{Type: lexer.STAR_SIGN, Literal: "*", HasError: false, Pos: util.Position{Len: 1}}}},
+		{"/", []lexer.Token{{Type: lexer.SLASH_SIGN, Literal: "/", HasError: false, Pos: util.Position{Len: 1}}}},
+		{"(", []lexer.Token{{Type: lexer.OPENED_PARENTHESIS, Literal: "(", HasError: false, Pos: util.Position{Len: 1}}}},
+		{")", []lexer.Token{{Type: lexer.CLOSED_PARENTHESIS, Literal: ")", HasError: false, Pos: util.Position{Len: 1}}}},
+		{"{", []lexer.Token{{Type: lexer.OPENED_BRACE, Literal: "{", HasError: false, Pos: util.Position{Len: 1}}}},
+		{"}", []lexer.Token{{Type: lexer.CLOSED_BRACE, Literal: "}", HasError: false, Pos: util.Position{Len: 1}}}},
+		{"[", []lexer.Token{{Type: lexer.OPENED_BRACKET, Literal: "[", HasError: false, Pos: util.Position{Len: 1}}}},
+		{"]", []lexer.Token{{Type: lexer.CLOSED_BRACKET, Literal: "]", HasError: false, Pos: util.Position{Len: 1}}}},
+
+		// Longest match
+		{"|||", []lexer.Token{
+			{Type: lexer.LOGICAL_OR, Literal: "||", HasError: false, Pos: util.Position{Len: 2}},
+			{Type: lexer.PIPE, Literal: "|", HasError: false, Pos: util.Position{Idx: 2, Col: 2, Len: 1}},
+		}},
+		{"&&&&", []lexer.Token{
+			{Type: lexer.LOGICAL_AND, Literal: "&&", HasError: false, Pos: util.Position{Len: 2}},
+			{Type: lexer.LOGICAL_AND, Literal: "&&", HasError: false, Pos: util.Position{Idx: 2, Col: 2, Len: 2}},
+		}},
+		{"<==", []lexer.Token{
+			{Type: lexer.LESS_THAN_OR_EQUALS, Literal: "<=", HasError: false, Pos: util.Position{Len: 2}},
+			{Type: lexer.BINDING, Literal: "=", HasError: false, Pos: util.Position{Idx: 2, Col: 2, Len: 1}},
+		}},
+		{"===", []lexer.Token{
+			{Type: lexer.EQUALS, Literal: "==", HasError: false, Pos: util.Position{Len: 2}},
+			{Type: lexer.BINDING, Literal: "=", HasError: false, Pos: util.Position{Idx: 2, Col: 2, Len: 1}},
+		}},
+		{"-=>", []lexer.Token{
+			{Type: lexer.MINUS_ASSIGNMENT, Literal: "-=", HasError: false, Pos: util.Position{Len: 2}},
+			{Type: lexer.GREATER_THAN, Literal: ">", HasError: false, Pos: util.Position{Idx: 2, Col: 2, Len: 1}},
+		}},
+		{"->=", []lexer.Token{
+			{Type: lexer.RETURN_TYPE_INDICATOR, Literal: "->", HasError: false, Pos: util.Position{Len: 2}},
+			{Type: lexer.BINDING, Literal: "=", HasError: false, Pos: util.Position{Idx: 2, Col: 2, Len: 1}},
+		}},
+		{":::", []lexer.Token{
+			{Type: lexer.DOUBLE_SEMICOLON, Literal: "::", HasError: false, Pos: util.Position{Len: 2}},
+			{Type: lexer.TYPE_INDICATOR, Literal: ":", HasError: false, Pos: util.Position{Idx: 2, Col: 2, Len: 1}},
+		}},
+		{"+==", []lexer.Token{
+			{Type: lexer.PLUS_ASSIGNMENT, Literal: "+=", HasError: false, Pos: util.Position{Len: 2}},
+			{Type: lexer.BINDING, Literal: "=", HasError: false, Pos: util.Position{Idx: 2, Col: 2, Len: 1}},
+		}},
+		{"*-", []lexer.Token{
+			{Type: lexer.STAR_SIGN, Literal: "*", HasError: false, Pos: util.Position{Len: 1}},
+			{Type: lexer.MINUS_SIGN, Literal: "-", HasError: false, Pos: util.Position{Idx: 1, Col: 1, Len: 1}},
+		}},
+
+		// Lone halves of operators are unknown
+		{"&", []lexer.Token{{Type: lexer.UNKNOWN, Literal: "&", HasError: true, Pos: util.Position{Len: 1}}}},
+		{"!", []lexer.Token{{Type: lexer.UNKNOWN, Literal: "!", HasError: true, Pos: util.Position{Len: 1}}}},
 	})
 }
 
@@ -185,3 +488,118 @@ func TestParseUnknown(t *testing.T) {
 		{"§§", []lexer.Token{{Type: lexer.UNKNOWN, Literal: "§§", HasError: true, Pos: util.Position{Len: 2}}}},
 	})
 }
+
+func TestStream(t *testing.T) {
+	inputs := []string{
+		"fn main() -> u8 {\n\tlet a = 0x1_FFu8 // comment\n\t\"a{b + \"{c}\"}\" §§ 'ok\n}",
+		"/** doc */\r\nconst größe = r#\"x\"# + b\"\\x41\" /* a /* b */ c */",
+		"§§",
+		"",
+	}
+
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			// Reading a byte at a time splits runes and tokens between reads
+			file := util.NewFileSet().AddFile("file", input)
+			l := lexer.New(iotest.OneByteReader(strings.NewReader(input)), file)
+
+			var tokens []lexer.Token
+			for token := l.Next(); token.Type != lexer.EOF; token = l.Next() {
+				tokens = append(tokens, token)
+			}
+
+			if want := lexer.Run(file); !reflect.DeepEqual(tokens, want) {
+				t.Errorf("\n%s\n%s\n%s\n%s", "---- EXPECTED ----", want, "---- ACTUAL ----", tokens)
+			}
+
+			eof := l.Next()
+			if eof.Type != lexer.EOF || eof.Pos.Idx != len([]rune(input)) || l.Err() != nil {
+				t.Errorf("expected EOF at %d but got %s with error %v", len([]rune(input)), eof, l.Err())
+			}
+		})
+	}
+}
+
+func TestStreamError(t *testing.T) {
+	broken := errors.New("broken")
+	l := lexer.New(io.MultiReader(strings.NewReader("a b"), iotest.ErrReader(broken)), nil)
+
+	var literals []string
+	for token := l.Next(); token.Type != lexer.EOF; token = l.Next() {
+		literals = append(literals, token.Literal)
+	}
+
+	if !reflect.DeepEqual(literals, []string{"a", " ", "b"}) || l.Err() != broken {
+		t.Errorf("expected the tokens of \"a b\" and the read error but got %q and %v", literals, l.Err())
+	}
+}
+
+func TestPositions(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []util.Position
+	}{
+		{"a\r\nö 😀b\n\r'é\r\nz", []util.Position{
+			{Idx: 0, Offset: 0, Col: 0, Col16: 0, Row: 0, Len: 1, Size: 1},
+			{Idx: 1, Offset: 1, Col: 1, Col16: 1, Row: 0, Len: 2, Size: 2},
+			{Idx: 3, Offset: 3, Col: 0, Col16: 0, Row: 1, Len: 1, Size: 2},
+			{Idx: 4, Offset: 5, Col: 1, Col16: 1, Row: 1, Len: 1, Size: 1},
+			{Idx: 5, Offset: 6, Col: 2, Col16: 2, Row: 1, Len: 1, Size: 4},
+			{Idx: 6, Offset: 10, Col: 3, Col16: 4, Row: 1, Len: 1, Size: 1},
+			{Idx: 7, Offset: 11, Col: 4, Col16: 5, Row: 1, Len: 1, Size: 1},
+			{Idx: 8, Offset: 12, Col: 0, Col16: 0, Row: 2, Len: 1, Size: 1},
+			{Idx: 9, Offset: 13, Col: 0, Col16: 0, Row: 3, Len: 2, Size: 3},
+			{Idx: 11, Offset: 16, Col: 2, Col16: 2, Row: 3, Len: 2, Size: 2},
+			{Idx: 13, Offset: 18, Col: 0, Col16: 0, Row: 4, Len: 1, Size: 1},
+			{Idx: 14, Offset: 19, Col: 1, Col16: 1, Row: 4},
+		}},
+		{"/* ü\r\n😀 */x", []util.Position{
+			{Idx: 0, Offset: 0, Col: 0, Col16: 0, Row: 0, Len: 10, Size: 14},
+			{Idx: 10, Offset: 14, Col: 4, Col16: 5, Row: 1, Len: 1, Size: 1},
+			{Idx: 11, Offset: 15, Col: 5, Col16: 6, Row: 1},
+		}},
+		{"\"\n\r\n\"", []util.Position{
+			{Idx: 0, Offset: 0, Col: 0, Col16: 0, Row: 0, Len: 5, Size: 5},
+			{Idx: 5, Offset: 5, Col: 1, Col16: 1, Row: 2},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			l := lexer.New(strings.NewReader(test.input), nil)
+
+			var positions []util.Position
+			var literals []string
+			for token := l.Next(); ; token = l.Next() {
+				positions = append(positions, token.Pos)
+				literals = append(literals, token.Literal)
+
+				if token.Type == lexer.EOF {
+					break
+				}
+			}
+
+			if !reflect.DeepEqual(positions, test.want) {
+				t.Fatalf("\n%s\n%+v\n%s\n%+v", "---- EXPECTED ----", test.want, "---- ACTUAL ----", positions)
+			}
+
+			// Every token starts where the one before it ends
+			for idx := 1; idx < len(positions); idx++ {
+				start := positions[idx]
+				start.Len = 0
+				start.Size = 0
+
+				if end := positions[idx-1].Advance(literals[idx-1]); end != start {
+					t.Errorf("expected %q to end at %+v but it ends at %+v", literals[idx-1], start, end)
+				}
+			}
+
+			// The byte positions point into the source
+			for idx, pos := range positions[:len(positions)-1] {
+				if source := test.input[pos.Offset : pos.Offset+pos.Size]; source != literals[idx] {
+					t.Errorf("expected %q at byte %d but found %q", literals[idx], pos.Offset, source)
+				}
+			}
+		})
+	}
+}
@@ -35,19 +35,31 @@ const (
 	RETURN_TYPE_INDICATOR  TokenType = "Return type indicator"
 	BINDING                TokenType = "Binding"
 	MUTED                  TokenType = "Muted"
-	TICK                   TokenType = "Tick"
 	CIRCUMFLEX             TokenType = "Circumflex"
 	PIPE                   TokenType = "Pipe"
 	COMMA                  TokenType = "Comma"
+	DOT                    TokenType = "Dot"
 	IF_NIL                 TokenType = "If nil"
-	LOGICAL_AND            TokenType = "Logical end"
+	LOGICAL_AND            TokenType = "Logical and"
 	LOGICAL_OR             TokenType = "Logical or"
+	PLUS_ASSIGNMENT        TokenType = "Plus assignment"
+	MINUS_ASSIGNMENT       TokenType = "Minus assignment"
+	STAR_ASSIGNMENT        TokenType = "Star assignment"
+	SLASH_ASSIGNMENT       TokenType = "Slash assignment"
 
-	SINGLE_LINE_COMMENT      TokenType = "Single line comment"
-	MULTI_LINE_COMMENT       TokenType = "Multi line comment"
-	MULTI_LINE_COMMENT_ERROR TokenType = "Multi line comment error"
-	STRING_LITERAL           TokenType = "String literal"
-	STRING_LITERAL_ERROR     TokenType = "String literal error"
+	SINGLE_LINE_COMMENT       TokenType = "Single line comment"
+	MULTI_LINE_COMMENT        TokenType = "Multi line comment"
+	MULTI_LINE_COMMENT_ERROR  TokenType = "Multi line comment error"
+	DOC_COMMENT               TokenType = "Doc comment"
+	STRING_LITERAL            TokenType = "String literal"
+	STRING_LITERAL_ERROR      TokenType = "String literal error"
+	INTERPOLATION_START       TokenType = "Interpolation start"
+	INTERPOLATION_MIDDLE      TokenType = "Interpolation middle"
+	INTERPOLATION_END         TokenType = "Interpolation end"
+	BYTE_STRING_LITERAL       TokenType = "Byte string literal"
+	BYTE_STRING_LITERAL_ERROR TokenType = "Byte string literal error"
+	SYM_LITERAL               TokenType = "Sym literal"
+	SYM_LITERAL_ERROR         TokenType = "Sym literal error"
 
 	KEYWORD_NAMESPACE       TokenType = "Keyword 'namespace'"
 	KEYWORD_IMPORT          TokenType = "Keyword 'import'"
@@ -63,6 +75,7 @@ const (
 	KEYWORD_STRUCT          TokenType = "Keyword 'struct'"
 	KEYWORD_TRAIT           TokenType = "Keyword 'trait'"
 	KEYWORD_IMPL            TokenType = "Keyword 'impl'"
+	KEYWORD_FOR             TokenType = "Keyword 'for'"
 	KEYWORD_SELF            TokenType = "Keyword 'self'"
 	KEYWORD_NIL             TokenType = "Keyword 'nil'"
 	KEYWORD_IF              TokenType = "Keyword 'if'"
@@ -91,12 +104,13 @@ const (
 	KEYWORD_I32             TokenType = "Keyword 'i32'"
 	KEYWORD_I64             TokenType = "Keyword 'i64'"
 	KEYWORD_F32             TokenType = "Keyword 'f32'"
-	KEYWORD_F64             TokenType = "Keyword 'f64e'"
+	KEYWORD_F64             TokenType = "Keyword 'f64'"
 	KEYWORD_NUM             TokenType = "Keyword 'num'"
 	KEYWORD_SYM             TokenType = "Keyword 'sym'"
 	KEYWORD_BIN             TokenType = "Keyword 'bin'"
 	IDENTIFIER              TokenType = "Identifier"
 	MUTED_IDENTIFIER        TokenType = "Muted identifier"
+	IDENTIFIER_ERROR        TokenType = "Identifier error"
 
 	BIN_NUM_LITERAL          TokenType = "Bin num literal"
 	BIN_NUM_LITERAL_ERROR    TokenType = "Bin num literal error"
@@ -119,6 +133,11 @@ type Token struct {
 	ErrorMsg string
 	Literal  string
 	Pos      util.Position
+	// Value is the decoded text of string literals, of the parts of
+	// interpolated strings and of doc comments
+	Value string
+	// Suffix is the type a number literal is suffixed with, like u8
+	Suffix string
 }
 
 func (token Token) String() string {
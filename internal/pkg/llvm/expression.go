@@ -0,0 +1,244 @@
+package llvm
+
+import (
+	"strings"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/ir"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/types"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
+)
+
+var operators = map[ir.Op]string{
+	ir.OP_ADD:  "add",
+	ir.OP_SUB:  "sub",
+	ir.OP_MUL:  "mul",
+	ir.OP_DIV:  "div",
+	ir.OP_POW:  "pow",
+	ir.OP_SHL:  "shl",
+	ir.OP_SHR:  "shr",
+	ir.OP_ASHR: "ashr",
+	ir.OP_CSHL: "cshl",
+	ir.OP_CSHR: "cshr",
+	ir.OP_AND:  "and",
+	ir.OP_OR:   "or",
+	ir.OP_XOR:  "xor",
+}
+
+// The predicates of signed, unsigned and float comparisons.
+var predicates = map[ir.Op][3]string{
+	ir.OP_EQ: {"eq", "eq", "oeq"},
+	ir.OP_NE: {"ne", "ne", "une"},
+	ir.OP_LT: {"slt", "ult", "olt"},
+	ir.OP_LE: {"sle", "ule", "ole"},
+	ir.OP_GT: {"sgt", "ugt", "ogt"},
+	ir.OP_GE: {"sge", "uge", "oge"},
+}
+
+/* Instructions */
+
+// operand returns a value used by an instruction at pos: constants are
+// written as they are, all other values are SSA values.
+func (g *generator) operand(value *ir.Value, pos util.Position) operand {
+	switch value.Op {
+	case ir.OP_CONST:
+		return g.literal(value.Const, value.Type, pos)
+	case ir.OP_FUNCTION:
+		g.errorf(value.Pos, diagnostics.UNSUPPORTED, "Function values are not supported by the LLVM backend")
+		return operand{"ptr", "null"}
+	}
+
+	return g.values[value]
+}
+
+// instruction writes an instruction that is not a terminator and returns
+// its value.
+func (g *generator) instruction(instr *ir.Value) operand {
+	var args []operand
+	for _, arg := range instr.Args {
+		args = append(args, g.operand(arg, instr.Pos))
+	}
+
+	switch instr.Op {
+	case ir.OP_PHI:
+		var incoming []string
+		for idx, block := range instr.Blocks {
+			incoming = append(incoming, "[ "+args[idx].v+", %"+g.labels[block]+" ]")
+		}
+		ltype := g.ltype(instr.Type, instr.Pos)
+		return g.temp(ltype, "phi %s %s", ltype, strings.Join(incoming, ", "))
+	case ir.OP_NEG, ir.OP_NOT:
+		basic, ok := g.number(instr.Args[0].Type, instr.Pos)
+		switch {
+		case !ok:
+			return operand{"i1", "false"}
+		case instr.Op == ir.OP_NEG && basic.IsFloat():
+			return g.temp(args[0].t, "fneg %s %s", args[0].t, args[0].v)
+		case instr.Op == ir.OP_NEG:
+			return g.temp(args[0].t, "call %s %s(ptr %s, %s 0, %s %s)", args[0].t, g.intHelper("sub", basic), g.position(instr.Pos), args[0].t, args[0].t, args[0].v)
+		case basic == types.Bool:
+			return g.temp(args[0].t, "xor i1 %s, true", args[0].v)
+		}
+		return g.temp(args[0].t, "xor %s %s, -1", args[0].t, args[0].v)
+	case ir.OP_EQ, ir.OP_NE, ir.OP_LT, ir.OP_LE, ir.OP_GT, ir.OP_GE:
+		return g.compare(instr.Op, args[0], args[1], instr.Args[0].Type)
+	case ir.OP_CONVERT:
+		g.ltype(instr.Type, instr.Pos)
+		return g.convert(args[0], instr.Args[0].Type, instr.Type)
+	case ir.OP_STRUCT:
+		value := operand{g.ltype(instr.Type, instr.Pos), "zeroinitializer"}
+		for idx, field := range args {
+			value = g.temp(value.t, "insertvalue %s %s, %s %s, %d", value.t, value.v, field.t, field.v, idx)
+		}
+		return value
+	case ir.OP_FIELD:
+		ltype := g.ltype(instr.Type, instr.Pos)
+		return g.temp(ltype, "extractvalue %s %s, %d", args[0].t, args[0].v, instr.Index)
+	case ir.OP_INSERT:
+		return g.temp(args[0].t, "insertvalue %s %s, %s %s, %d", args[0].t, args[0].v, args[1].t, args[1].v, instr.Index)
+	case ir.OP_INDEX, ir.OP_SET_INDEX, ir.OP_FORMAT:
+		g.errorf(instr.Pos, diagnostics.UNSUPPORTED, "Byte strings are not supported by the LLVM backend")
+		return operand{"i8", "0"}
+	case ir.OP_IS:
+		trait, ok := instr.Args[0].Type.(*types.Trait)
+		if !ok || !instr.Struct.Implements(trait) {
+			return operand{"i1", "false"}
+		}
+		vtable := g.temp("ptr", "extractvalue %s %s, 1", args[0].t, args[0].v)
+		return g.temp("i1", "icmp eq ptr %s, %s", vtable.v, g.vtable(instr.Struct, trait))
+	case ir.OP_LOAD:
+		ltype := g.ltype(instr.Type, instr.Pos)
+		return g.temp(ltype, "load %s, ptr %s", ltype, g.names[instr.Global])
+	case ir.OP_STORE:
+		g.ltype(instr.Global.Type, instr.Pos)
+		g.line("store %s %s, ptr %s", args[0].t, args[0].v, g.names[instr.Global])
+		return operand{}
+	case ir.OP_CALL:
+		return g.call(instr, g.names[instr.Function], args)
+	case ir.OP_CALL_VALUE:
+		g.errorf(instr.Pos, diagnostics.UNSUPPORTED, "Function values are not supported by the LLVM backend")
+		return operand{}
+	case ir.OP_CALL_METHOD:
+		return g.dispatch(instr, args)
+	}
+
+	return g.binary(instr, args[0], args[1])
+}
+
+// number returns the type of the operands of an operator, it reports an
+// error if the type is neither a number nor bool.
+func (g *generator) number(t types.Type, pos util.Position) (*types.Basic, bool) {
+	basic, ok := t.(*types.Basic)
+	if !ok || !(basic.IsNumeric() || basic == types.Bool) {
+		g.ltype(t, pos)
+		return nil, false
+	}
+
+	return basic, true
+}
+
+// dispatch calls a method of a trait value through the method table of the
+// struct it holds, which passes the pointer to the struct on.
+func (g *generator) dispatch(instr *ir.Value, args []operand) operand {
+	trait := instr.Args[0].Type.(*types.Trait)
+	vtable := g.temp("ptr", "extractvalue %s %s, 1", args[0].t, args[0].v)
+	entry := g.temp("ptr", "getelementptr inbounds ptr, ptr %s, i64 %d", vtable.v, slot(trait, instr.Name))
+	method := g.temp("ptr", "load ptr, ptr %s", entry.v)
+	self := g.temp("ptr", "extractvalue %s %s, 0", args[0].t, args[0].v)
+
+	return g.call(instr, method.v, append([]operand{self}, args[1:]...))
+}
+
+func (g *generator) call(instr *ir.Value, callee string, args []operand) operand {
+	var list []string
+	for _, arg := range args {
+		list = append(list, arg.t+" "+arg.v)
+	}
+
+	if !pushes(instr.Type) {
+		g.line("call void %s(%s)", callee, strings.Join(list, ", "))
+		return operand{}
+	}
+
+	ltype := g.ltype(instr.Type, instr.Pos)
+	return g.temp(ltype, "call %s %s(%s)", ltype, callee, strings.Join(list, ", "))
+}
+
+// binary applies a bitwise or arithmetic operator. Arithmetic that can fail
+// calls a checked helper.
+func (g *generator) binary(instr *ir.Value, left operand, right operand) operand {
+	basic, ok := g.number(instr.Args[0].Type, instr.Pos)
+	if !ok {
+		return operand{"i1", "false"}
+	}
+
+	var helper string
+	switch operator := operators[instr.Op]; {
+	case instr.Op == ir.OP_AND || instr.Op == ir.OP_OR || instr.Op == ir.OP_XOR:
+		return g.temp(left.t, "%s %s %s, %s", operator, left.t, left.v, right.v)
+	case basic.IsFloat():
+		helper = g.floatHelper(operator, basic)
+	case instr.Op == ir.OP_POW:
+		helper = g.powHelper(basic)
+	case instr.Op >= ir.OP_SHL && instr.Op <= ir.OP_CSHR:
+		helper = g.shiftHelper(operator, basic)
+	default:
+		helper = g.intHelper(operator, basic)
+	}
+
+	return g.temp(left.t, "call %s %s(ptr %s, %s %s, %s %s)", left.t, helper, g.position(instr.Pos), left.t, left.v, right.t, right.v)
+}
+
+// compare compares two values of the same type.
+func (g *generator) compare(op ir.Op, left operand, right operand, t types.Type) operand {
+	switch t := t.(type) {
+	case *types.Struct:
+		return g.equal(op, g.eqHelper(t), left, right)
+	case *types.Trait:
+		return g.equal(op, g.traitEqHelper(t), left, right)
+	case *types.Basic:
+		switch {
+		case t.IsFloat():
+			return g.temp("i1", "fcmp %s %s %s, %s", predicates[op][2], left.t, left.v, right.v)
+		case t.IsSigned():
+			return g.temp("i1", "icmp %s %s %s, %s", predicates[op][0], left.t, left.v, right.v)
+		case t.IsUnsigned() || t == types.Bool || t == types.Nil:
+			return g.temp("i1", "icmp %s %s %s, %s", predicates[op][1], left.t, left.v, right.v)
+		}
+	}
+
+	// Values of other types were reported where they were created
+	return operand{"i1", "false"}
+}
+
+// equal compares two structs or trait values with their helper.
+func (g *generator) equal(op ir.Op, helper string, left operand, right operand) operand {
+	equal := g.temp("i1", "call i1 %s(%s %s, %s %s)", helper, left.t, left.v, right.t, right.v)
+	if op == ir.OP_NE {
+		return g.temp("i1", "xor i1 %s, true", equal.v)
+	}
+
+	return equal
+}
+
+/* Control flow */
+
+func (g *generator) terminator(instr *ir.Value) {
+	switch instr.Op {
+	case ir.OP_JUMP:
+		g.line("br label %%%s", g.labels[instr.Blocks[0]])
+	case ir.OP_BRANCH:
+		condition := g.operand(instr.Args[0], instr.Pos)
+		g.line("br i1 %s, label %%%s, label %%%s", condition.v, g.labels[instr.Blocks[0]], g.labels[instr.Blocks[1]])
+	case ir.OP_RETURN:
+		if len(instr.Args) == 0 {
+			g.line("ret void")
+			return
+		}
+		value := g.operand(instr.Args[0], instr.Pos)
+		g.line("ret %s %s", value.t, value.v)
+	case ir.OP_UNREACHABLE:
+		g.line("call void @q.panic(ptr %s, ptr @q.unreachable)", g.position(instr.Pos))
+		g.line("unreachable")
+	}
+}
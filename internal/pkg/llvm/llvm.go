@@ -0,0 +1,510 @@
+package llvm
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/consteval"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/ir"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/types"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
+)
+
+// operand is a constant or SSA value with its LLVM type. Instructions
+// without a value return the zero operand.
+type operand struct {
+	t string
+	v string
+}
+
+type generator struct {
+	program *ir.Program
+
+	// LLVM names of functions, global bindings and structs, and the names
+	// of structs and traits in the names of their helpers
+	names    map[any]string
+	bases    map[types.Type]string
+	taken    map[string]bool
+	strings  map[string]string
+	declared map[string]bool
+
+	typedefs     strings.Builder
+	data         strings.Builder
+	declarations strings.Builder
+	helpers      strings.Builder
+	code         strings.Builder
+
+	// The function that is generated: its body, the SSA values of its
+	// instructions and the labels of its blocks
+	body   *strings.Builder
+	temps  int
+	uses   map[*ir.Value]int
+	values map[*ir.Value]operand
+	labels map[*ir.Block]string
+
+	errors []diagnostics.Diagnostic
+	failed map[util.Position]bool
+}
+
+// Generate translates the IR of a program into a module of LLVM IR text,
+// written for LLVM 15 and later. Structs are LLVM structs passed by value
+// and the values of the IR are SSA values of LLVM. Arithmetic that can fail
+// calls checked helpers that stop the program with the same messages as
+// the other backends. If there is a main function, the module has a C main
+// that runs it and exits with its result if that is an integer. A trait
+// value is a pointer to a copy of the struct it holds and a pointer to the
+// method table of that struct. Sym, bin and function values are reported as
+// errors where they are used.
+func Generate(program *ir.Program) (string, []diagnostics.Diagnostic) {
+	g := generator{
+		program:  program,
+		names:    map[any]string{},
+		bases:    map[types.Type]string{},
+		taken:    map[string]bool{"main": true, "dprintf": true, "exit": true, "malloc": true, "q.init": true},
+		strings:  map[string]string{},
+		declared: map[string]bool{},
+		failed:   map[util.Position]bool{},
+	}
+
+	for _, structure := range program.Structs {
+		g.bases[structure] = strings.TrimPrefix(g.unique("type."+structure.Name), "type.")
+		g.names[structure] = identifier("%", "struct."+g.bases[structure])
+	}
+
+	for _, trait := range program.Traits {
+		g.bases[trait] = strings.TrimPrefix(g.unique("type."+trait.Name), "type.")
+	}
+
+	for _, global := range program.Globals {
+		g.names[global] = g.global("g." + global.Name)
+	}
+
+	for _, function := range program.Functions {
+		switch {
+		case function == program.Init:
+			g.names[function] = "@q.init"
+		case function.External:
+			// External functions keep their name to link against C code
+			g.names[function] = identifier("@", function.Decl.Identifer.Name)
+			g.taken[function.Decl.Identifer.Name] = true
+		default:
+			g.names[function] = g.global("q." + function.Name)
+		}
+	}
+
+	g.declareTypes()
+
+	for _, global := range program.Globals {
+		ltype, _ := g.lower(global.Type)
+		fmt.Fprintf(&g.data, "%s = internal global %s zeroinitializer\n", g.names[global], ltype)
+	}
+
+	for _, function := range program.Functions {
+		if function.External {
+			fmt.Fprintf(&g.declarations, "declare %s\n", g.signature(function, false))
+		} else {
+			g.generateFunction(function)
+		}
+	}
+	g.generateMain()
+
+	var sb strings.Builder
+	sb.WriteString(runtime)
+	for _, section := range []*strings.Builder{&g.typedefs, &g.data, &g.declarations, &g.helpers, &g.code} {
+		if section.Len() > 0 {
+			sb.WriteString("\n")
+			sb.WriteString(strings.TrimRight(section.String(), "\n") + "\n")
+		}
+	}
+
+	return sb.String(), g.errors
+}
+
+// errorf reports an error, only the first error at a position is reported
+// as the others follow from it.
+func (g *generator) errorf(pos util.Position, code string, format string, a ...any) {
+	if g.failed[pos] {
+		return
+	}
+
+	g.failed[pos] = true
+	g.errors = append(g.errors, diagnostics.Errorf(pos, code, format, a...))
+}
+
+var plain = regexp.MustCompile(`^[-a-zA-Z$._][-a-zA-Z$._0-9]*$`)
+
+// identifier writes a name with its sigil, quoted if it has characters
+// LLVM does not allow in plain names.
+func identifier(sigil string, name string) string {
+	if plain.MatchString(name) {
+		return sigil + name
+	}
+
+	return sigil + quote(name)
+}
+
+// unique returns a name of a global or type that is not used yet.
+func (g *generator) unique(name string) string {
+	candidate := name
+	for idx := 2; g.taken[candidate]; idx++ {
+		candidate = name + "." + strconv.Itoa(idx)
+	}
+
+	g.taken[candidate] = true
+	return candidate
+}
+
+func (g *generator) global(name string) string {
+	return identifier("@", g.unique(name))
+}
+
+/* Types */
+
+// The LLVM type of trait values.
+const TRAIT_TYPE = "{ ptr, ptr }"
+
+var basicTypes = map[types.BasicKind]string{
+	types.KIND_BOOL: "i1",
+	types.KIND_U8:   "i8",
+	types.KIND_U16:  "i16",
+	types.KIND_U32:  "i32",
+	types.KIND_U64:  "i64",
+	types.KIND_I8:   "i8",
+	types.KIND_I16:  "i16",
+	types.KIND_I32:  "i32",
+	types.KIND_I64:  "i64",
+	types.KIND_F32:  "float",
+	types.KIND_F64:  "double",
+	types.KIND_NUM:  "double",
+	types.KIND_NIL:  "i1",
+	types.KIND_VOID: "void",
+}
+
+// lower returns the LLVM type of a type. It reports false for types without
+// a representation, whose values are i1s.
+func (g *generator) lower(t types.Type) (string, bool) {
+	switch t := t.(type) {
+	case *types.Basic:
+		if name, ok := basicTypes[t.Kind]; ok {
+			return name, true
+		}
+	case *types.Struct:
+		return g.names[t], true
+	case *types.Trait:
+		return TRAIT_TYPE, true
+	case nil:
+		return "void", true
+	}
+
+	return "i1", false
+}
+
+// ltype returns the LLVM type of a type and reports an error at the
+// position of its use if it has no representation.
+func (g *generator) ltype(t types.Type, pos util.Position) string {
+	ltype, ok := g.lower(t)
+	if !ok {
+		g.errorf(pos, diagnostics.UNSUPPORTED, "Type %s is not supported by the LLVM backend", t)
+	}
+
+	return ltype
+}
+
+// declareTypes defines the structs, ordered so that every struct comes after
+// the structs it contains.
+func (g *generator) declareTypes() {
+	state := map[*types.Struct]int{}
+	var define func(structure *types.Struct)
+	define = func(structure *types.Struct) {
+		switch state[structure] {
+		case 1:
+			g.errorf(structure.Decl.Identifer.Pos, diagnostics.RECURSIVE_STRUCT, "Struct %s contains itself", structure.Name)
+			return
+		case 2:
+			return
+		}
+
+		state[structure] = 1
+		for _, field := range structure.Fields {
+			if nested, ok := field.Type.(*types.Struct); ok {
+				define(nested)
+			}
+		}
+		state[structure] = 2
+
+		var fields []string
+		for _, field := range structure.Fields {
+			fields = append(fields, g.ltype(field.Type, field.Decl.Identifer.Pos))
+		}
+		fmt.Fprintf(&g.typedefs, "%s = type { %s }\n", g.names[structure], strings.Join(fields, ", "))
+	}
+
+	for _, structure := range g.program.Structs {
+		define(structure)
+	}
+}
+
+// signature returns the head of a function, with named parameters if it is
+// defined. The parameters of a defined function are its first values.
+func (g *generator) signature(function *ir.Function, named bool) string {
+	var params []string
+	for idx, param := range function.Params {
+		value := operand{g.ltype(param.Type, param.Pos), identifier("%", "p."+param.Name)}
+		if function.Receiver && idx == 0 {
+			value.v = "%self"
+		}
+
+		if named {
+			g.values[param] = value
+			params = append(params, value.t+" "+value.v)
+		} else {
+			params = append(params, value.t)
+		}
+	}
+
+	var pos util.Position
+	if function.Decl != nil {
+		pos = function.Decl.Identifer.Pos
+	}
+
+	return g.ltype(function.Result, pos) + " " + g.names[function] + "(" + strings.Join(params, ", ") + ")"
+}
+
+/* Functions */
+
+func (g *generator) line(format string, a ...any) {
+	g.body.WriteString("\t")
+	fmt.Fprintf(g.body, format, a...)
+	g.body.WriteString("\n")
+}
+
+// temp writes an instruction into a new SSA value.
+func (g *generator) temp(t string, format string, a ...any) operand {
+	g.temps++
+	name := "%t" + strconv.Itoa(g.temps)
+	g.line("%s = "+format, append([]any{name}, a...)...)
+	return operand{t, name}
+}
+
+// within generates code into a separate body, to write a helper in the
+// middle of a function.
+func (g *generator) within(fn func()) {
+	body, temps := g.body, g.temps
+	fn()
+	g.body, g.temps = body, temps
+}
+
+// generateFunction writes the blocks of a function in reverse postorder,
+// so that every value is defined before it is used. The first block is the
+// entry, the others are named after their position.
+func (g *generator) generateFunction(function *ir.Function) {
+	g.body = &strings.Builder{}
+	g.temps = 0
+	g.uses = function.Uses()
+	g.values = map[*ir.Value]operand{}
+	g.labels = map[*ir.Block]string{}
+
+	signature := g.signature(function, true)
+
+	order := function.Order()
+	for idx, block := range order {
+		g.labels[block] = "entry"
+		if idx > 0 {
+			g.labels[block] = "b" + strconv.Itoa(idx)
+		}
+	}
+
+	for _, block := range order {
+		fmt.Fprintf(g.body, "%s:\n", g.labels[block])
+
+		for _, instr := range block.Instrs {
+			switch {
+			case instr.IsTerminator():
+				g.terminator(instr)
+			case g.uses[instr] == 0 && instr.Effect() == ir.PURE:
+			default:
+				g.values[instr] = g.instruction(instr)
+			}
+		}
+	}
+
+	linkage := "internal "
+	if function.Public {
+		linkage = ""
+	}
+
+	fmt.Fprintf(&g.code, "define %s%s {\n%s}\n\n", linkage, signature, g.body)
+}
+
+// generateMain writes the C main function, which runs the initialisers of
+// the global bindings and main.
+func (g *generator) generateMain() {
+	main := g.program.Main
+	if main == nil {
+		return
+	}
+
+	g.code.WriteString("define i32 @main() {\nentry:\n\tcall void @q.init()\n")
+
+	basic, ok := main.Result.(*types.Basic)
+	switch {
+	case ok && basic.IsInteger() && basic.Bits > 32:
+		fmt.Fprintf(&g.code, "\t%%r = call i64 %s()\n\t%%c = trunc i64 %%r to i32\n\tret i32 %%c\n}\n", g.names[main])
+	case ok && basic.IsInteger() && basic.Bits == 32:
+		fmt.Fprintf(&g.code, "\t%%r = call i32 %s()\n\tret i32 %%r\n}\n", g.names[main])
+	case ok && basic.IsInteger():
+		extend := "zext"
+		if basic.IsSigned() {
+			extend = "sext"
+		}
+		fmt.Fprintf(&g.code, "\t%%r = call %s %s()\n\t%%c = %s %s %%r to i32\n\tret i32 %%c\n}\n", basicTypes[basic.Kind], g.names[main], extend, basicTypes[basic.Kind])
+	default:
+		ltype, _ := g.lower(main.Result)
+		fmt.Fprintf(&g.code, "\tcall %s %s()\n\tret i32 0\n}\n", ltype, g.names[main])
+	}
+}
+
+func pushes(t types.Type) bool {
+	return t != nil && t != types.Void
+}
+
+/* Values */
+
+// quote writes a string in the quotes of LLVM names and strings, escaping
+// everything that is not printable ASCII.
+func quote(value string) string {
+	var sb strings.Builder
+
+	sb.WriteByte('"')
+	for idx := 0; idx < len(value); idx++ {
+		c := value[idx]
+		if c == '"' || c == '\\' || c < 0x20 || c >= 0x7F {
+			fmt.Fprintf(&sb, "\\%02X", c)
+		} else {
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+
+	return sb.String()
+}
+
+// str returns a pointer to a constant C string.
+func (g *generator) str(value string) string {
+	if name, ok := g.strings[value]; ok {
+		return name
+	}
+
+	name := "@.str." + strconv.Itoa(len(g.strings)+1)
+	g.strings[value] = name
+	fmt.Fprintf(&g.data, "%s = private unnamed_addr constant [%d x i8] c%s\n", name, len(value)+1, quote(value+"\x00"))
+
+	return name
+}
+
+func (g *generator) position(pos util.Position) string {
+	return g.str(pos.String())
+}
+
+// float writes a float constant as the hexadecimal double LLVM expects,
+// which is exact for both float and double.
+func float(value float64, basic *types.Basic) string {
+	if basic.Kind == types.KIND_F32 {
+		value = float64(float32(value))
+	}
+
+	return fmt.Sprintf("0x%016X", math.Float64bits(value))
+}
+
+// integer writes the bits of an integer constant as the signed number LLVM
+// reads them as.
+func integer(bits uint64, size int) string {
+	shift := 64 - size
+	return strconv.FormatInt(int64(bits<<shift)>>shift, 10)
+}
+
+// literal returns a constant of the type the type checker gave it.
+func (g *generator) literal(constant consteval.Value, t types.Type, pos util.Position) operand {
+	basic, ok := t.(*types.Basic)
+	if !ok {
+		g.ltype(t, pos)
+		return operand{"i1", "false"}
+	}
+
+	if constant.Type.IsUntyped() && basic.IsNumeric() && !basic.IsUntyped() {
+		converted, err := consteval.Convert(constant, basic)
+		if err != nil {
+			g.errorf(pos, diagnostics.CodeOf(err), "%s", err)
+			return operand{g.ltype(basic, pos), "zeroinitializer"}
+		}
+		constant = converted
+	}
+
+	switch {
+	case basic.IsSigned():
+		return operand{basicTypes[basic.Kind], integer(uint64(constant.Int.Int64()), basic.Bits)}
+	case basic.IsInteger():
+		return operand{basicTypes[basic.Kind], integer(constant.Int.Uint64(), basic.Bits)}
+	case basic.IsFloat():
+		return operand{basicTypes[basic.Kind], float(constant.Float, basic)}
+	case basic == types.Bool:
+		return operand{"i1", strconv.FormatBool(constant.Bool)}
+	case basic == types.Nil:
+		return operand{"i1", "false"}
+	}
+
+	return operand{g.ltype(basic, pos), "zeroinitializer"}
+}
+
+// convert converts a number to another type, a struct to a trait value or
+// a trait value to the struct it holds. Constants in branches can keep their
+// default type while the branches join in a smaller one, these are narrowed.
+func (g *generator) convert(value operand, from types.Type, to types.Type) operand {
+	if from == to || value.v == "" {
+		return value
+	}
+
+	switch to := to.(type) {
+	case *types.Trait:
+		if structure, ok := from.(*types.Struct); ok {
+			return g.temp(TRAIT_TYPE, "call %s %s(%s %s)", TRAIT_TYPE, g.boxHelper(structure, to), value.t, value.v)
+		}
+	case *types.Struct:
+		if _, ok := from.(*types.Trait); ok {
+			self := g.temp("ptr", "extractvalue %s %s, 0", value.t, value.v)
+			return g.temp(g.names[to], "load %s, ptr %s", g.names[to], self.v)
+		}
+	}
+
+	source, ok := from.(*types.Basic)
+	target, isBasic := to.(*types.Basic)
+	if !ok || !isBasic || !source.IsNumeric() {
+		return value
+	}
+
+	ltype := basicTypes[target.Kind]
+	switch {
+	case source.IsInteger() && target.IsInteger() && source.Bits < target.Bits:
+		if source.IsSigned() {
+			return g.temp(ltype, "sext %s %s to %s", value.t, value.v, ltype)
+		}
+		return g.temp(ltype, "zext %s %s to %s", value.t, value.v, ltype)
+	case source.IsInteger() && target.IsInteger() && source.Bits > target.Bits:
+		return g.temp(ltype, "trunc %s %s to %s", value.t, value.v, ltype)
+	case source.IsInteger() && target.IsFloat():
+		if source.IsSigned() {
+			return g.temp(ltype, "sitofp %s %s to %s", value.t, value.v, ltype)
+		}
+		return g.temp(ltype, "uitofp %s %s to %s", value.t, value.v, ltype)
+	case value.t == "float" && ltype == "double":
+		return g.temp(ltype, "fpext float %s to double", value.v)
+	case value.t == "double" && ltype == "float":
+		return g.temp(ltype, "fptrunc double %s to float", value.v)
+	}
+
+	return value
+}
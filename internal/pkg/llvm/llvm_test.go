@@ -0,0 +1,190 @@
+package llvm_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/llvm"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/testutil"
+)
+
+type testStruct struct {
+	input string
+	want  string
+}
+
+// generate generates the module of the input after running the
+// optimisations of the given level on its IR.
+func generate(t *testing.T, input string, level int) (string, []diagnostics.Diagnostic) {
+	return llvm.Generate(testutil.Build(t, level, input))
+}
+
+// run runs the generated module with lli and returns the exit code of the
+// program or the error it stopped with. LLVM before version 15 needs a flag
+// for opaque pointers, later versions do not know it anymore.
+func run(t *testing.T, input string, level int) string {
+	interpreter, err := exec.LookPath("lli")
+	if err != nil {
+		t.Skip("lli is not installed")
+	}
+
+	code, generateErrors := generate(t, input, level)
+	if len(generateErrors) > 0 {
+		t.Fatalf("unexpected generator errors: %s", generateErrors)
+	}
+
+	source := filepath.Join(t.TempDir(), "main.ll")
+	if err := os.WriteFile(source, []byte(code), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{{"-opaque-pointers", source}, {source}} {
+		var stderr strings.Builder
+		command := exec.Command(interpreter, args...)
+		command.Stderr = &stderr
+
+		var exit *exec.ExitError
+		err := command.Run()
+		switch {
+		case strings.Contains(stderr.String(), "opaque-pointers"):
+			continue
+		case strings.Contains(stderr.String(), "lli:"):
+			t.Fatalf("lli failed: %s\n%s", stderr.String(), code)
+		case errors.As(err, &exit) && stderr.Len() > 0:
+			return strings.TrimSpace(stderr.String())
+		case err != nil && exit == nil:
+			t.Fatal(err)
+		}
+
+		return strconv.Itoa(command.ProcessState.ExitCode())
+	}
+
+	t.Fatal("lli does not support opaque pointers")
+	return ""
+}
+
+func testHelper(t *testing.T, tests []testStruct) {
+	for _, level := range []int{0, 2} {
+		for _, test := range tests {
+			t.Run(fmt.Sprintf("O%d/%s", level, test.input), func(t *testing.T) {
+				if actual := run(t, test.input, level); actual != test.want {
+					t.Errorf("expected %q but got %q", test.want, actual)
+				}
+			})
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	code, errors := generate(t, "struct P { x: i32, y: i32 }\npub fn add(a: u8, b: u8) -> u8 { a + b }\nfn f(a: i64) -> i64 {\n\tlet! b = a\n\tif a > 0 { b = 1 }\n\tb\n}", 0)
+	if len(errors) > 0 {
+		t.Fatalf("unexpected generator errors: %s", errors)
+	}
+
+	for _, want := range []string{
+		"%struct.P = type { i32, i32 }",
+		"define i8 @q.add(i8 %p.a, i8 %p.b) {\nentry:\n\t%t1 = call i8 @q.add.u8(ptr @.str.2, i8 %p.a, i8 %p.b)\n\tret i8 %t1\n}",
+		"b2:\n\t%t2 = phi i64 [ %p.a, %entry ], [ 1, %b1 ]\n\tret i64 %t2",
+		"declare { i8, i1 } @llvm.uadd.with.overflow.i8(i8, i8)",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in the generated code\n%s", want, code)
+		}
+	}
+
+	if strings.Contains(code, "define i32 @main()") {
+		t.Errorf("expected no C main without a main function")
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	tests := []testStruct{
+		{"fn f(s: sym) -> sym { s }", "main.ql:1:6: Type sym is not supported by the LLVM backend"},
+		{"fn f(b: bin) -> bin { b }", "main.ql:1:6: Type bin is not supported by the LLVM backend"},
+		{"struct P {\n\tx: i32\n\ts: sym\n}\nfn f(p: P) -> i32 { p.x }", "main.ql:3:2: Type sym is not supported by the LLVM backend"},
+		{"fn f(c: bool) -> bool {\n\t(if c { 'a } else { 'b }) == 'a\n}", "main.ql:2:3: Type sym is not supported by the LLVM backend"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			_, errors := generate(t, test.input, 0)
+			if len(errors) == 0 || errors[0].Error() != test.want {
+				t.Errorf("expected %q but got %v", test.want, errors)
+			}
+		})
+	}
+}
+
+func TestExpressions(t *testing.T) {
+	testHelper(t, []testStruct{
+		{"fn main() -> i64 { 1 + 2 * 3 }", "7"},
+		{"fn main() -> u8 {\n\tlet a: u8 = 200\n\ta + 55\n}", "255"},
+		{"fn main() -> i64 {\n\tlet a: i8 = -5\n\tlet b: i64 = 3\n\ta * b + 20\n}", "5"},
+		{"fn main() -> i32 {\n\tlet a: num = 7.5\n\tif a / 2 > 3.7 { 1 } else { 0 }\n}", "1"},
+		{"fn main() -> i32 {\n\tlet a: f32 = 1.5\n\tif a ^ 2.0 == 2.25 { 1 } else { 0 }\n}", "1"},
+		{"fn main() -> u8 {\n\tlet a: u8 = 1\n\ta cshr 1\n}", "128"},
+		{"fn main() -> u8 {\n\tlet a: u8 = 129\n\ta cshl 9\n}", "3"},
+		{"fn main() -> i8 {\n\tlet a: i8 = -128\n\t(a ashr 2) + 40\n}", "8"},
+		{"fn main() -> i8 {\n\tlet a: i8 = -1\n\ta shr 1\n}", "127"},
+		{"fn main() -> i16 {\n\tlet a: i16 = 3\n\t(a shl 13) shr 8\n}", "96"},
+		{"fn main() -> u8 {\n\tlet a: u8 = 5\n\tnot a\n}", "250"},
+		{"fn main() -> i64 {\n\tlet a: i64 = 3\n\ta ^ 4\n}", "81"},
+		{"fn main() -> i32 {\n\tlet a: i32 = 5\n\t-a + 10\n}", "5"},
+		{"const N: u8 = 7\nlet! g = N * 2\nfn main() -> u8 {\n\tg = g + 1\n\tg + N\n}", "22"},
+
+		// Runtime errors
+		{"fn main() -> u8 {\n\tlet a: u8 = 200\n\ta + 56\n}", "main.ql:3:4: Overflow, the result does not fit into u8"},
+		{"fn main() -> i64 {\n\tlet a = 0\n\t1 / a\n}", "main.ql:3:4: Division by zero"},
+		{"fn main() -> i8 {\n\tlet a: i8 = 64\n\ta shl 1\n}", "main.ql:3:4: Overflow, the result does not fit into i8"},
+		{"fn main() -> i8 {\n\tlet a: i8 = 1\n\tlet n: i8 = -1\n\ta shl n\n}", "main.ql:4:4: Invalid shift amount -1"},
+		{"fn main() -> i16 {\n\tlet a: i16 = 2\n\tlet n: i16 = -1\n\ta ^ n\n}", "main.ql:4:4: Negative exponent -1 for an integer power"},
+		{"fn main() -> i32 {\n\tlet a: f64 = 1e300\n\tif a * a > 0.0 { 1 } else { 0 }\n}", "main.ql:3:7: Overflow, the result does not fit into f64"},
+	})
+}
+
+func TestControlFlow(t *testing.T) {
+	testHelper(t, []testStruct{
+		{"fn main() -> i64 {\n\tlet a = 5\n\tcond {\n\t\ta < 3 -> 1\n\t\ta < 10 -> 2\n\t\telse -> 3\n\t}\n}", "2"},
+		{"fn main() -> i64 {\n\tlet a = -7\n\tcase a {\n\t\t0 -> 1\n\t\t-7 -> 2\n\t\tn -> 3\n\t}\n}", "2"},
+		{"fn main() -> i64 {\n\tlet a = 9\n\tcase a {\n\t\t0 -> 1\n\t\tn -> n * 2\n\t}\n}", "18"},
+		{"fn f(n: i64) -> i64 {\n\tif n < 2 {\n\t\treturn n\n\t}\n\tf(n - 1) + f(n - 2)\n}\nfn main() -> i64 { f(12) }", "144"},
+		{"fn main() -> i64 {\n\tlet! a = 1\n\tlet! b = 2\n\tif a == 1 {\n\t\ta = 10\n\t} else {\n\t\tb = 20\n\t}\n\tcond {\n\t\ta > 5 -> { b = b + 1 }\n\t\telse -> { a = 0 }\n\t}\n\ta + b\n}", "13"},
+		{"fn f(a: bool) -> i32 {\n\tif a {\n\t\treturn 1\n\t} else {\n\t\treturn 2\n\t}\n}\nfn main() -> i32 { f(false) }", "2"},
+		{"fn main() -> i32 {\n\tlet a = true\n\tif a and not false { 1 } else { 0 }\n}", "1"},
+		{"fn main() -> i32 {\n\tlet a: i32 = 0\n\tif a != 0 && 1 / a > 0 { 1 } else { 2 }\n}", "2"},
+	})
+}
+
+func TestStructs(t *testing.T) {
+	point := "struct P { x: i32, y: i32 }\nstruct L { a: P, b: P }\nimpl P {\n\tfn area(self) -> i32 { self.x * self.y }\n\tfn origin() -> P { P { x: 7, y: 0 } }\n}\n"
+
+	testHelper(t, []testStruct{
+		{point + "fn main() -> i32 {\n\tlet! p = P { x: 2, y: 3 }\n\tp.x = 5\n\tp.area()\n}", "15"},
+		{point + "fn main() -> i32 {\n\tlet! p = P { x: 2, y: 3 }\n\tlet q = p\n\tp.x = 5\n\tq.x\n}", "2"},
+		{point + "fn main() -> i32 {\n\tlet! l = L { a: P { x: 1, y: 2 }, b: P { x: 3, y: 4 } }\n\tl.b.y = 9\n\tl.a.x + l.b.y\n}", "10"},
+		{point + "fn main() -> i32 {\n\tlet p = P { x: 1, y: 2 }\n\tlet q = P { x: 1, y: 2 }\n\tif p == q { 1 } else { 0 }\n}", "1"},
+		{point + "fn main() -> i32 {\n\tlet p = P { x: 1, y: 2 }\n\tcase p {\n\t\tP { x: 0 } -> 0\n\t\tP { x, y } -> x + y\n\t}\n}", "3"},
+		{point + "fn main() -> i32 { P.origin().x }", "7"},
+	})
+}
+
+func TestTraits(t *testing.T) {
+	shapes := "struct P { x: i32, y: i32 }\nstruct C { r: i32 }\ntrait Shape {\n\tfn area(self) -> i32\n\tfn double(self) -> i32 { self.area() * 2 }\n\tfn zero() -> i32 { 0 }\n}\n" +
+		"impl Shape for P {\n\tfn area(self) -> i32 { self.x * self.y }\n}\nimpl Shape for C {\n\tfn area(self) -> i32 { self.r * self.r * 3 }\n}\n"
+
+	testHelper(t, []testStruct{
+		{shapes + "fn main() -> i32 { P.zero() + 4 }", "4"},
+		{shapes + "fn main() -> i32 { P { x: 2, y: 3 }.double() }", "12"},
+		{shapes + "fn f(s: Shape) -> i32 { s.area() + s.double() }\nfn main() -> i32 { f(P { x: 2, y: 3 }) + f(C { r: 1 }) }", "27"},
+		{shapes + "fn f(a: Shape, b: Shape) -> i32 { if a == b { 1 } else { 0 } }\nfn main() -> i32 { f(C { r: 1 }, C { r: 1 }) + f(C { r: 1 }, C { r: 2 }) * 2 + f(C { r: 0 }, P { x: 0, y: 0 }) * 4 }", "1"},
+		{shapes + "fn f(s: Shape) -> i32 {\n\tcase s {\n\t\tC { r } -> r\n\t\t_ -> 0\n\t}\n}\nfn main() -> i32 { f(C { r: 5 }) + f(P { x: 1, y: 1 }) }", "5"},
+		{shapes + "struct B { s: Shape }\nfn main() -> i32 {\n\tlet b = B { s: C { r: 2 } }\n\tb.s.double()\n}", "24"},
+	})
+}
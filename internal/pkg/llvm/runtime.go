@@ -0,0 +1,396 @@
+package llvm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/consteval"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/ir"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/types"
+)
+
+// runtime is put in front of every module. Failing operations print their
+// position and message to stderr and exit, like the errors of the C code.
+const runtime = `declare i32 @dprintf(i32, ptr, ...)
+declare void @exit(i32) noreturn
+declare ptr @malloc(i64)
+
+@q.format = private unnamed_addr constant [8 x i8] c"%s: %s\0A\00"
+@q.shift = private unnamed_addr constant [31 x i8] c"%s: Invalid shift amount %lld\0A\00"
+@q.exponent = private unnamed_addr constant [49 x i8] c"%s: Negative exponent %lld for an integer power\0A\00"
+@q.zero = private unnamed_addr constant [17 x i8] c"Division by zero\00"
+@q.unreachable = private unnamed_addr constant [40 x i8] c"Reached a branch that cannot be reached\00"
+@q.runtime = private unnamed_addr constant [8 x i8] c"runtime\00"
+@q.memory = private unnamed_addr constant [14 x i8] c"Out of memory\00"
+
+define internal void @q.panic(ptr %pos, ptr %msg) cold noreturn {
+entry:
+	call i32 (i32, ptr, ...) @dprintf(i32 2, ptr @q.format, ptr %pos, ptr %msg)
+	call void @exit(i32 1)
+	unreachable
+}
+
+define internal void @q.panic.int(ptr %format, ptr %pos, i64 %n) cold noreturn {
+entry:
+	call i32 (i32, ptr, ...) @dprintf(i32 2, ptr %format, ptr %pos, i64 %n)
+	call void @exit(i32 1)
+	unreachable
+}
+
+define internal ptr @q.alloc(i64 %size) {
+entry:
+	%empty = icmp eq i64 %size, 0
+	%n = select i1 %empty, i64 1, i64 %size
+	%memory = call ptr @malloc(i64 %n)
+	%failed = icmp eq ptr %memory, null
+	br i1 %failed, label %fail, label %ok
+fail:
+	call void @q.panic(ptr @q.runtime, ptr @q.memory)
+	unreachable
+ok:
+	ret ptr %memory
+}
+`
+
+// The checked helpers are written into the module the first time they are
+// used. They take the position of the operator as their first argument.
+
+// helper writes a helper function once and returns its name.
+func (g *generator) helper(name string, write func(sb *strings.Builder, name string)) string {
+	name = identifier("@", "q."+name)
+	if g.declared[name] {
+		return name
+	}
+	g.declared[name] = true
+
+	var sb strings.Builder
+	g.within(func() {
+		write(&sb, name)
+	})
+	g.helpers.WriteString(sb.String() + "\n")
+
+	return name
+}
+
+// intrinsic declares an intrinsic of LLVM once.
+func (g *generator) intrinsic(declaration string, name string) string {
+	if !g.declared[name] {
+		g.declared[name] = true
+		fmt.Fprintf(&g.declarations, declaration+"\n", name)
+	}
+
+	return name
+}
+
+// overflow writes the block that stops with an overflow of a type.
+func (g *generator) overflow(sb *strings.Builder, basic *types.Basic) {
+	fmt.Fprintf(sb, "overflow:\n\tcall void @q.panic(ptr %%pos, ptr %s)\n\tunreachable\n", g.str("Overflow, the result does not fit into "+basic.Name))
+}
+
+// intHelper returns the helper of add, sub, mul or div of an integer type.
+// The first three use the overflow intrinsics of LLVM.
+func (g *generator) intHelper(operator string, basic *types.Basic) string {
+	t := basicTypes[basic.Kind]
+
+	return g.helper(operator+"."+basic.Name, func(sb *strings.Builder, name string) {
+		fmt.Fprintf(sb, "define internal %s %s(ptr %%pos, %s %%a, %s %%b) {\nentry:\n", t, name, t, t)
+
+		if operator == "div" {
+			sb.WriteString("\t%z = icmp eq " + t + " %b, 0\n\tbr i1 %z, label %zero, label %check\n")
+			sb.WriteString("zero:\n\tcall void @q.panic(ptr %pos, ptr @q.zero)\n\tunreachable\n")
+			sb.WriteString("check:\n")
+			if basic.IsSigned() {
+				fmt.Fprintf(sb, "\t%%min = icmp eq %s %%a, %s\n\t%%minus = icmp eq %s %%b, -1\n\t%%o = and i1 %%min, %%minus\n\tbr i1 %%o, label %%overflow, label %%ok\n", t, basic.MinInt(), t)
+				g.overflow(sb, basic)
+				fmt.Fprintf(sb, "ok:\n\t%%r = sdiv %s %%a, %%b\n\tret %s %%r\n}\n", t, t)
+			} else {
+				fmt.Fprintf(sb, "\t%%r = udiv %s %%a, %%b\n\tret %s %%r\n}\n", t, t)
+			}
+			return
+		}
+
+		sign := "u"
+		if basic.IsSigned() {
+			sign = "s"
+		}
+		pair := "{ " + t + ", i1 }"
+		checked := g.intrinsic("declare "+pair+" %s("+t+", "+t+")", "@llvm."+sign+operator+".with.overflow."+t)
+
+		fmt.Fprintf(sb, "\t%%r = call %s %s(%s %%a, %s %%b)\n", pair, checked, t, t)
+		fmt.Fprintf(sb, "\t%%o = extractvalue %s %%r, 1\n\tbr i1 %%o, label %%overflow, label %%ok\n", pair)
+		g.overflow(sb, basic)
+		fmt.Fprintf(sb, "ok:\n\t%%v = extractvalue %s %%r, 0\n\tret %s %%v\n}\n", pair, t)
+	})
+}
+
+// powHelper computes a ^ b as (a ^ (b / 2)) ^ 2, times a if b is odd. The
+// intermediate results are never larger than the result, so an overflow of
+// a multiplication is an overflow of the result.
+func (g *generator) powHelper(basic *types.Basic) string {
+	t := basicTypes[basic.Kind]
+	mul := g.intHelper("mul", basic)
+
+	return g.helper("pow."+basic.Name, func(sb *strings.Builder, name string) {
+		fmt.Fprintf(sb, "define internal %s %s(ptr %%pos, %s %%a, %s %%b) {\nentry:\n", t, name, t, t)
+
+		if basic.IsSigned() {
+			fmt.Fprintf(sb, "\t%%negative = icmp slt %s %%b, 0\n\tbr i1 %%negative, label %%invalid, label %%check\n", t)
+			sb.WriteString("invalid:\n")
+			exponent := "%b"
+			if t != "i64" {
+				fmt.Fprintf(sb, "\t%%n = sext %s %%b to i64\n", t)
+				exponent = "%n"
+			}
+			fmt.Fprintf(sb, "\tcall void @q.panic.int(ptr @q.exponent, ptr %%pos, i64 %s)\n\tunreachable\ncheck:\n", exponent)
+		}
+
+		fmt.Fprintf(sb, "\t%%zero = icmp eq %s %%b, 0\n\tbr i1 %%zero, label %%one, label %%half\n", t)
+		fmt.Fprintf(sb, "one:\n\tret %s 1\n", t)
+		fmt.Fprintf(sb, "half:\n\t%%h = lshr %s %%b, 1\n\t%%p = call %s %s(ptr %%pos, %s %%a, %s %%h)\n", t, t, name, t, t)
+		fmt.Fprintf(sb, "\t%%s = call %s %s(ptr %%pos, %s %%p, %s %%p)\n", t, mul, t, t)
+		fmt.Fprintf(sb, "\t%%bit = and %s %%b, 1\n\t%%odd = icmp ne %s %%bit, 0\n\tbr i1 %%odd, label %%times, label %%even\n", t, t)
+		fmt.Fprintf(sb, "times:\n\t%%r = call %s %s(ptr %%pos, %s %%s, %s %%a)\n\tret %s %%r\n", t, mul, t, t, t)
+		fmt.Fprintf(sb, "even:\n\tret %s %%s\n}\n", t)
+	})
+}
+
+// shiftHelper returns the helper of a shift. The amount is an i64, amounts
+// above consteval.MAX_UNTYPED_BITS panic. cshl and
+// cshr are the funnel shifts of LLVM with both halves set to the value.
+func (g *generator) shiftHelper(operator string, basic *types.Basic) string {
+	t := basicTypes[basic.Kind]
+	bits := basic.Bits
+
+	return g.helper(operator+"."+basic.Name, func(sb *strings.Builder, name string) {
+		fmt.Fprintf(sb, "define internal %s %s(ptr %%pos, %s %%a, i64 %%n) {\nentry:\n", t, name, t)
+		fmt.Fprintf(sb, "\t%%negative = icmp slt i64 %%n, 0\n\t%%large = icmp sgt i64 %%n, %d\n\t%%bad = or i1 %%negative, %%large\n", consteval.MAX_UNTYPED_BITS)
+		sb.WriteString("\tbr i1 %bad, label %invalid, label %valid\n")
+		sb.WriteString("invalid:\n\tcall void @q.panic.int(ptr @q.shift, ptr %pos, i64 %n)\n\tunreachable\n")
+		sb.WriteString("valid:\n")
+
+		// amount truncates an i64 to the type of the value
+		amount := func(from string) {
+			if bits == 64 {
+				fmt.Fprintf(sb, "\t%%m = add i64 %s, 0\n", from)
+			} else {
+				fmt.Fprintf(sb, "\t%%m = trunc i64 %s to %s\n", from, t)
+			}
+		}
+
+		switch operator {
+		case "shl":
+			fmt.Fprintf(sb, "\t%%zero = icmp eq %s %%a, 0\n\tbr i1 %%zero, label %%done, label %%check\n", t)
+			fmt.Fprintf(sb, "done:\n\tret %s 0\n", t)
+			fmt.Fprintf(sb, "check:\n\t%%wide = icmp sge i64 %%n, %d\n\tbr i1 %%wide, label %%overflow, label %%shift\n", bits)
+			sb.WriteString("shift:\n")
+			amount("%n")
+
+			// Shifting out set bits, or bits unlike the sign, is an overflow
+			value := "%a"
+			if basic.IsSigned() {
+				fmt.Fprintf(sb, "\t%%inverted = xor %s %%a, -1\n\t%%sign = icmp slt %s %%a, 0\n\t%%v = select i1 %%sign, %s %%inverted, %s %%a\n", t, t, t, t)
+				value = "%v"
+			}
+			fmt.Fprintf(sb, "\t%%max = lshr %s %s, %%m\n\t%%o = icmp ugt %s %s, %%max\n\tbr i1 %%o, label %%overflow, label %%ok\n", t, integer(basic.MaxInt().Uint64(), bits), t, value)
+			g.overflow(sb, basic)
+			fmt.Fprintf(sb, "ok:\n\t%%r = shl %s %%a, %%m\n\tret %s %%r\n}\n", t, t)
+		case "shr":
+			fmt.Fprintf(sb, "\t%%wide = icmp sge i64 %%n, %d\n\tbr i1 %%wide, label %%done, label %%shift\n", bits)
+			fmt.Fprintf(sb, "done:\n\tret %s 0\n", t)
+			sb.WriteString("shift:\n")
+			amount("%n")
+			fmt.Fprintf(sb, "\t%%r = lshr %s %%a, %%m\n\tret %s %%r\n}\n", t, t)
+		case "ashr":
+			fmt.Fprintf(sb, "\t%%wide = icmp sge i64 %%n, %d\n\t%%c = select i1 %%wide, i64 %d, i64 %%n\n", bits, bits-1)
+			amount("%c")
+			op := "lshr"
+			if basic.IsSigned() {
+				op = "ashr"
+			}
+			fmt.Fprintf(sb, "\t%%r = %s %s %%a, %%m\n\tret %s %%r\n}\n", op, t, t)
+		default:
+			funnel := "@llvm.fshl." + t
+			if operator == "cshr" {
+				funnel = "@llvm.fshr." + t
+			}
+			g.intrinsic("declare "+t+" %s("+t+", "+t+", "+t+")", funnel)
+
+			fmt.Fprintf(sb, "\t%%c = urem i64 %%n, %d\n", bits)
+			amount("%c")
+			fmt.Fprintf(sb, "\t%%r = call %s %s(%s %%a, %s %%a, %s %%m)\n\tret %s %%r\n}\n", t, funnel, t, t, t, t)
+		}
+	})
+}
+
+var floatOps = map[string]string{
+	"add": "fadd",
+	"sub": "fsub",
+	"mul": "fmul",
+	"div": "fdiv",
+}
+
+// floatHelper returns the helper of a float operation, which stops on
+// division by zero and on infinite results.
+func (g *generator) floatHelper(operator string, basic *types.Basic) string {
+	t := basicTypes[basic.Kind]
+	suffix := "f64"
+	if t == "float" {
+		suffix = "f32"
+	}
+
+	return g.helper(operator+"."+basic.Name, func(sb *strings.Builder, name string) {
+		fabs := g.intrinsic("declare "+t+" %s("+t+")", "@llvm.fabs."+suffix)
+
+		fmt.Fprintf(sb, "define internal %s %s(ptr %%pos, %s %%a, %s %%b) {\nentry:\n", t, name, t, t)
+
+		if operator == "div" {
+			fmt.Fprintf(sb, "\t%%z = fcmp oeq %s %%b, 0.0\n\tbr i1 %%z, label %%zero, label %%compute\n", t)
+			sb.WriteString("zero:\n\tcall void @q.panic(ptr %pos, ptr @q.zero)\n\tunreachable\n")
+			sb.WriteString("compute:\n")
+		}
+
+		if operator == "pow" {
+			pow := g.intrinsic("declare "+t+" %s("+t+", "+t+")", "@llvm.pow."+suffix)
+			fmt.Fprintf(sb, "\t%%r = call %s %s(%s %%a, %s %%b)\n", t, pow, t, t)
+		} else {
+			fmt.Fprintf(sb, "\t%%r = %s %s %%a, %%b\n", floatOps[operator], t)
+		}
+
+		fmt.Fprintf(sb, "\t%%abs = call %s %s(%s %%r)\n\t%%inf = fcmp oeq %s %%abs, 0x7FF0000000000000\n", t, fabs, t, t)
+		sb.WriteString("\tbr i1 %inf, label %overflow, label %ok\n")
+		g.overflow(sb, basic)
+		fmt.Fprintf(sb, "ok:\n\tret %s %%r\n}\n", t)
+	})
+}
+
+// eqHelper returns the helper that compares two structs field by field.
+func (g *generator) eqHelper(structure *types.Struct) string {
+	t := g.names[structure]
+
+	return g.helper("eq."+g.bases[structure], func(sb *strings.Builder, name string) {
+		g.body = sb
+		g.temps = 0
+
+		fmt.Fprintf(sb, "define internal i1 %s(%s %%a, %s %%b) {\nentry:\n", name, t, t)
+
+		result := operand{"i1", "true"}
+		for idx, field := range structure.Fields {
+			ltype := g.ltype(field.Type, field.Decl.Identifer.Pos)
+			a := g.temp(ltype, "extractvalue %s %%a, %d", t, idx)
+			b := g.temp(ltype, "extractvalue %s %%b, %d", t, idx)
+			equal := g.compare(ir.OP_EQ, a, b, field.Type)
+			if idx == 0 {
+				result = equal
+			} else {
+				result = g.temp("i1", "and i1 %s, %s", result.v, equal.v)
+			}
+		}
+
+		fmt.Fprintf(sb, "\tret i1 %s\n}\n", result.v)
+	})
+}
+
+/* Traits */
+
+// slot returns the index of a method in the method tables of a trait. The
+// first entry compares the structs two trait values hold, the methods with a
+// receiver follow in the order of the trait.
+func slot(trait *types.Trait, name string) int {
+	idx := 1
+	for _, method := range trait.Methods {
+		if method.Name == name {
+			break
+		}
+		if method.Self {
+			idx++
+		}
+	}
+
+	return idx
+}
+
+// boxHelper returns the helper that copies a struct to the heap and makes it
+// a value of a trait.
+func (g *generator) boxHelper(structure *types.Struct, trait *types.Trait) string {
+	t := g.names[structure]
+	vtable := g.vtable(structure, trait)
+
+	return g.helper("box."+g.bases[structure]+"."+g.bases[trait], func(sb *strings.Builder, name string) {
+		fmt.Fprintf(sb, "define internal %s %s(%s %%value) {\nentry:\n", TRAIT_TYPE, name, t)
+		fmt.Fprintf(sb, "\t%%end = getelementptr %s, ptr null, i32 1\n\t%%size = ptrtoint ptr %%end to i64\n", t)
+		fmt.Fprintf(sb, "\t%%self = call ptr @q.alloc(i64 %%size)\n\tstore %s %%value, ptr %%self\n", t)
+		fmt.Fprintf(sb, "\t%%t = insertvalue %s undef, ptr %%self, 0\n\t%%r = insertvalue %s %%t, ptr %s, 1\n", TRAIT_TYPE, TRAIT_TYPE, vtable)
+		fmt.Fprintf(sb, "\tret %s %%r\n}\n", TRAIT_TYPE)
+	})
+}
+
+// vtable returns the method table of a struct for a trait. Its entries take
+// a pointer to the struct: they load the struct for the methods of the impl
+// and make a trait value of it again for default methods.
+func (g *generator) vtable(structure *types.Struct, trait *types.Trait) string {
+	pair := g.bases[structure] + "." + g.bases[trait]
+
+	return g.helper("vtable."+pair, func(sb *strings.Builder, name string) {
+		entries := []string{"ptr " + g.unboxedEqHelper(structure)}
+		for _, method := range trait.Methods {
+			if !method.Self {
+				continue
+			}
+
+			implementation := g.program.Methods[structure][method.Name]
+			wrapper := identifier("@", "q.method."+pair+"."+method.Name)
+			entries = append(entries, "ptr "+wrapper)
+
+			params, args := []string{"ptr %self"}, []string{}
+			for idx, parameter := range method.Signature.Parameters {
+				ltype, _ := g.lower(parameter)
+				params = append(params, ltype+" %a"+strconv.Itoa(idx))
+				args = append(args, ltype+" %a"+strconv.Itoa(idx))
+			}
+			result, _ := g.lower(method.Signature.Result)
+
+			fmt.Fprintf(sb, "define internal %s %s(%s) {\nentry:\n", result, wrapper, strings.Join(params, ", "))
+			if owner, ok := implementation.Params[0].Type.(*types.Trait); ok {
+				fmt.Fprintf(sb, "\t%%t = insertvalue %s undef, ptr %%self, 0\n\t%%value = insertvalue %s %%t, ptr %s, 1\n", TRAIT_TYPE, TRAIT_TYPE, g.vtable(structure, owner))
+				args = append([]string{TRAIT_TYPE + " %value"}, args...)
+			} else {
+				fmt.Fprintf(sb, "\t%%value = load %s, ptr %%self\n", g.names[structure])
+				args = append([]string{g.names[structure] + " %value"}, args...)
+			}
+
+			if pushes(method.Signature.Result) {
+				fmt.Fprintf(sb, "\t%%r = call %s %s(%s)\n\tret %s %%r\n}\n\n", result, g.names[implementation], strings.Join(args, ", "), result)
+			} else {
+				fmt.Fprintf(sb, "\tcall void %s(%s)\n\tret void\n}\n\n", g.names[implementation], strings.Join(args, ", "))
+			}
+		}
+
+		fmt.Fprintf(sb, "%s = internal constant { %s } { %s }\n", name, strings.Repeat("ptr, ", len(entries)-1)+"ptr", strings.Join(entries, ", "))
+	})
+}
+
+// unboxedEqHelper returns the helper that compares two structs behind
+// pointers, the first entry of the method tables of the struct.
+func (g *generator) unboxedEqHelper(structure *types.Struct) string {
+	t := g.names[structure]
+	equal := g.eqHelper(structure)
+
+	return g.helper("eq.ptr."+g.bases[structure], func(sb *strings.Builder, name string) {
+		fmt.Fprintf(sb, "define internal i1 %s(ptr %%a, ptr %%b) {\nentry:\n", name)
+		fmt.Fprintf(sb, "\t%%x = load %s, ptr %%a\n\t%%y = load %s, ptr %%b\n", t, t)
+		fmt.Fprintf(sb, "\t%%r = call i1 %s(%s %%x, %s %%y)\n\tret i1 %%r\n}\n", equal, t, t)
+	})
+}
+
+// traitEqHelper returns the helper that compares two trait values, they are
+// equal if they hold equal structs of the same type.
+func (g *generator) traitEqHelper(trait *types.Trait) string {
+	return g.helper("eq."+g.bases[trait], func(sb *strings.Builder, name string) {
+		fmt.Fprintf(sb, "define internal i1 %s(%s %%a, %s %%b) {\nentry:\n", name, TRAIT_TYPE, TRAIT_TYPE)
+		fmt.Fprintf(sb, "\t%%va = extractvalue %s %%a, 1\n\t%%vb = extractvalue %s %%b, 1\n", TRAIT_TYPE, TRAIT_TYPE)
+		sb.WriteString("\t%same = icmp eq ptr %va, %vb\n\tbr i1 %same, label %compare, label %different\n")
+		fmt.Fprintf(sb, "compare:\n\t%%equal = load ptr, ptr %%va\n\t%%sa = extractvalue %s %%a, 0\n\t%%sb = extractvalue %s %%b, 0\n", TRAIT_TYPE, TRAIT_TYPE)
+		sb.WriteString("\t%r = call i1 %equal(ptr %sa, ptr %sb)\n\tret i1 %r\ndifferent:\n\tret i1 false\n}\n")
+	})
+}
@@ -1,12 +1,39 @@
 package parser
 
+import (
+	"math/big"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/lexer"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
+)
+
 type Program struct {
 	Scopes []*Scope
 }
 
 type Scope struct {
-	Constants []*Constant
-	Functions []*Function
+	Namespace  *Namespace
+	Imports    []*Import
+	Constants  []*Constant
+	Functions  []*Function
+	Structs    []*Struct
+	Traits     []*Trait
+	Impls      []*Impl
+	Statements []Statement
+}
+
+type Namespace struct {
+	Path *Path
+}
+
+type Import struct {
+	Path  *Path
+	Alias *Identifer
+	From  *Path
+}
+
+type Path struct {
+	Identifers []*Identifer
 }
 
 type Visibility int
@@ -17,17 +44,57 @@ const (
 	EXTERNAL
 )
 
-type Statement struct {
+/* Statements */
+
+type Statement interface {
+	statement()
+}
+
+type ExpressionStatement struct {
+	Expression Expression
+}
+
+type Return struct {
+	Expression Expression
+	Pos        util.Position
 }
 
+// A binding declared with let is immutable, one declared with let! can be
+// reassigned. Pos is the position of let or let!.
+type Binding struct {
+	Visibility *Visibility
+	Mutable    bool
+	Identifer  *Identifer
+	Type       *Type
+	Expression Expression
+	Pos        util.Position
+}
+
+type Assignment struct {
+	Target     Expression
+	Expression Expression
+	Pos        util.Position
+}
+
+func (*ExpressionStatement) statement() {}
+func (*Return) statement()              {}
+func (*Binding) statement()             {}
+func (*Assignment) statement()          {}
+
 type Constant struct {
 	Visibility *Visibility
 	Identifer  *Identifer
+	Type       *Type
 	Expression *CompileTimeExpression
+	// Doc is the text of the doc comments in front of the declaration, one
+	// line for each line of the comments
+	Doc string
 }
 
 type Type struct {
 	Visibility *Visibility
+	Namespace  *Path
+	Identifer  *Identifer
 }
 
 type Function struct {
@@ -36,16 +103,294 @@ type Function struct {
 	Parameters []*FunctionParameter
 	ReturnType *Type
 	Body       *Scope
+	Doc        string
 }
 
+// The self parameter of a method has no type, it is the type of the impl
+// block the method belongs to.
 type FunctionParameter struct {
 	Identifer *Identifer
 	Type      *Type
 }
 
+type Struct struct {
+	Visibility *Visibility
+	Identifer  *Identifer
+	Fields     []*StructField
+	Doc        string
+}
+
+type StructField struct {
+	Visibility *Visibility
+	Identifer  *Identifer
+	Type       *Type
+}
+
+type Trait struct {
+	Visibility *Visibility
+	Identifer  *Identifer
+	Methods    []*Function
+	Doc        string
+}
+
+type Impl struct {
+	Trait   *Type
+	Type    *Type
+	Methods []*Function
+}
+
 type Identifer struct {
-	name string
+	Name string
+	Pos  util.Position
 }
 
 type CompileTimeExpression struct {
+	Expression Expression
+}
+
+/* Expressions */
+
+type Expression interface {
+	expression()
+}
+
+type Literal struct {
+	Kind  lexer.TokenType
+	Value string
+	// Suffix is the type a number literal is suffixed with, Int and Float
+	// hold the value of integer and float literals
+	Suffix string
+	Int    *big.Int
+	Float  *big.Float
+	Pos    util.Position
+}
+
+type IdentifierExpression struct {
+	Identifer *Identifer
+}
+
+type PathExpression struct {
+	Namespace *Path
+	Identifer *Identifer
+}
+
+type SelfExpression struct {
+	Pos util.Position
+}
+
+type StructLiteral struct {
+	Type   *Type
+	Fields []*StructLiteralField
+}
+
+type StructLiteralField struct {
+	Identifer  *Identifer
+	Expression Expression
 }
+
+type FieldAccess struct {
+	Target    Expression
+	Identifer *Identifer
+	Pos       util.Position
+}
+
+// An else if is stored as an Else scope that only contains the next If.
+type If struct {
+	Condition Expression
+	Then      *Scope
+	Else      *Scope
+	Pos       util.Position
+}
+
+type Cond struct {
+	Branches []*CondBranch
+	Else     *Scope
+	Pos      util.Position
+}
+
+type CondBranch struct {
+	Condition Expression
+	Body      *Scope
+}
+
+type Case struct {
+	Subject  Expression
+	Branches []*CaseBranch
+	Pos      util.Position
+}
+
+type CaseBranch struct {
+	Pattern Pattern
+	Body    *Scope
+}
+
+type Binary struct {
+	Operator lexer.TokenType
+	Left     Expression
+	Right    Expression
+	Pos      util.Position
+}
+
+type Unary struct {
+	Operator lexer.TokenType
+	Operand  Expression
+	Pos      util.Position
+}
+
+type Call struct {
+	Callee    Expression
+	Arguments []Expression
+	Pos       util.Position
+}
+
+type Index struct {
+	Target Expression
+	Index  Expression
+	Pos    util.Position
+}
+
+type Grouping struct {
+	Expression Expression
+	Pos        util.Position
+}
+
+// Interpolation is a string with embedded expressions, it has one more part
+// than expressions.
+type Interpolation struct {
+	Parts         []*Literal
+	Expressions   []Expression
+	Pos           util.Position
+	concatenation Expression
+}
+
+// Concatenation returns the parts and expressions of an interpolation joined
+// with +, which is how it is evaluated. It is only built once, so the types
+// recorded for its nodes stay valid.
+func (interpolation *Interpolation) Concatenation() Expression {
+	if interpolation.concatenation != nil {
+		return interpolation.concatenation
+	}
+
+	var concatenation Expression
+	join := func(right Expression) {
+		if concatenation == nil {
+			concatenation = right
+			return
+		}
+		concatenation = &Binary{Operator: lexer.PLUS_SIGN, Left: concatenation, Right: right, Pos: ExpressionPos(right)}
+	}
+
+	for idx, part := range interpolation.Parts {
+		if part.Value != "" {
+			join(part)
+		}
+		if idx < len(interpolation.Expressions) {
+			join(interpolation.Expressions[idx])
+		}
+	}
+
+	if concatenation == nil {
+		concatenation = interpolation.Parts[0]
+	}
+
+	interpolation.concatenation = concatenation
+	return concatenation
+}
+
+// ExpressionPos returns the position an expression starts at.
+func ExpressionPos(expression Expression) util.Position {
+	switch expression := expression.(type) {
+	case *Literal:
+		return expression.Pos
+	case *IdentifierExpression:
+		return expression.Identifer.Pos
+	case *PathExpression:
+		return expression.Namespace.Identifers[0].Pos
+	case *SelfExpression:
+		return expression.Pos
+	case *StructLiteral:
+		if expression.Type.Namespace != nil {
+			return expression.Type.Namespace.Identifers[0].Pos
+		}
+		return expression.Type.Identifer.Pos
+	case *FieldAccess:
+		return ExpressionPos(expression.Target)
+	case *If:
+		return expression.Pos
+	case *Cond:
+		return expression.Pos
+	case *Case:
+		return expression.Pos
+	case *Binary:
+		return ExpressionPos(expression.Left)
+	case *Unary:
+		return expression.Pos
+	case *Call:
+		return ExpressionPos(expression.Callee)
+	case *Index:
+		return ExpressionPos(expression.Target)
+	case *Grouping:
+		return expression.Pos
+	case *Interpolation:
+		return expression.Pos
+	}
+
+	return util.Position{}
+}
+
+func (*Literal) expression()              {}
+func (*IdentifierExpression) expression() {}
+func (*PathExpression) expression()       {}
+func (*SelfExpression) expression()       {}
+func (*StructLiteral) expression()        {}
+func (*FieldAccess) expression()          {}
+func (*If) expression()                   {}
+func (*Cond) expression()                 {}
+func (*Case) expression()                 {}
+func (*Binary) expression()               {}
+func (*Unary) expression()                {}
+func (*Call) expression()                 {}
+func (*Index) expression()                {}
+func (*Grouping) expression()             {}
+func (*Interpolation) expression()        {}
+
+/* Patterns */
+
+type Pattern interface {
+	pattern()
+}
+
+type LiteralPattern struct {
+	Literal  *Literal
+	Negative bool
+}
+
+type NilPattern struct {
+	Pos util.Position
+}
+
+type BindingPattern struct {
+	Identifer *Identifer
+}
+
+// A muted pattern is written as _ or _name and matches without binding.
+type MutedPattern struct {
+	Identifer *Identifer
+}
+
+// Fields that are not listed in a struct pattern are not matched.
+type StructPattern struct {
+	Type   *Type
+	Fields []*StructPatternField
+}
+
+type StructPatternField struct {
+	Identifer *Identifer
+	Pattern   Pattern
+}
+
+func (*LiteralPattern) pattern() {}
+func (*NilPattern) pattern()     {}
+func (*BindingPattern) pattern() {}
+func (*MutedPattern) pattern()   {}
+func (*StructPattern) pattern()  {}
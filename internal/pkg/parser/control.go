@@ -0,0 +1,258 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/lexer"
+)
+
+func (p *parser) parseIf() Expression {
+	token := p.advanceIgnoreSpace() // skip 'if'
+
+	condition := p.parseCondition()
+	if condition == nil {
+		return nil
+	}
+
+	then := p.parseScope()
+	if then == nil {
+		return nil
+	}
+
+	expression := &If{Condition: condition, Then: then, Pos: token.Pos}
+
+	if p.peekIgnoreSpace().Type != lexer.KEYWORD_ELSE {
+		return expression
+	}
+
+	p.advanceIgnoreSpace()
+
+	if p.peekIgnoreSpace().Type == lexer.KEYWORD_IF {
+		elseIf := p.parseIf()
+		if elseIf == nil {
+			return nil
+		}
+
+		expression.Else = expressionScope(elseIf)
+		return expression
+	}
+
+	if expression.Else = p.parseScope(); expression.Else == nil {
+		return nil
+	}
+
+	return expression
+}
+
+// Branches of cond and case are separated by commas or line breaks. Their
+// body follows a -> and is either a scope or a single expression.
+func (p *parser) parseBranchBody() *Scope {
+	if p.expect(lexer.RETURN_TYPE_INDICATOR, "->") == nil {
+		return nil
+	}
+
+	if p.peekIgnoreSpace().Type == lexer.OPENED_BRACE {
+		return p.parseScope()
+	}
+
+	expression := p.parseExpression()
+	if expression == nil {
+		return nil
+	}
+
+	return expressionScope(expression)
+}
+
+func (p *parser) endBranch() bool {
+	if p.peekIgnoreSpace().Type == lexer.COMMA {
+		p.advanceIgnoreSpace()
+		return true
+	}
+
+	if next := p.peekSameLine(); next.Type != lexer.EOF && next.Type != lexer.CLOSED_BRACE {
+		p.commitErr(*next, fmt.Sprintf("Expected a new line after the branch but found %s", next.Type))
+		return false
+	}
+
+	return true
+}
+
+func (p *parser) parseCond() Expression {
+	token := p.advanceIgnoreSpace() // skip 'cond'
+
+	if p.expect(lexer.OPENED_BRACE, "{") == nil {
+		return nil
+	}
+
+	expression := &Cond{Pos: token.Pos}
+
+	for p.peekIgnoreSpace().Type != lexer.CLOSED_BRACE {
+		if elseToken := p.peekIgnoreSpace(); elseToken.Type == lexer.KEYWORD_ELSE {
+			if expression.Else != nil {
+				p.commitErr(*elseToken, "A cond can only have one else branch")
+				return nil
+			}
+
+			p.advanceIgnoreSpace()
+
+			if expression.Else = p.parseBranchBody(); expression.Else == nil {
+				return nil
+			}
+		} else {
+			if expression.Else != nil {
+				p.commitErr(*elseToken, "The else branch has to be the last branch of a cond")
+				return nil
+			}
+
+			condition := p.parseExpression()
+			if condition == nil {
+				return nil
+			}
+
+			body := p.parseBranchBody()
+			if body == nil {
+				return nil
+			}
+
+			expression.Branches = append(expression.Branches, &CondBranch{Condition: condition, Body: body})
+		}
+
+		if !p.endBranch() {
+			return nil
+		}
+	}
+
+	p.advanceIgnoreSpace() // skip '}'
+
+	return expression
+}
+
+func (p *parser) parseCase() Expression {
+	token := p.advanceIgnoreSpace() // skip 'case'
+
+	subject := p.parseCondition()
+	if subject == nil {
+		return nil
+	}
+
+	if p.expect(lexer.OPENED_BRACE, "{") == nil {
+		return nil
+	}
+
+	expression := &Case{Subject: subject, Pos: token.Pos}
+
+	for p.peekIgnoreSpace().Type != lexer.CLOSED_BRACE {
+		pattern := p.parsePattern()
+		if pattern == nil {
+			return nil
+		}
+
+		body := p.parseBranchBody()
+		if body == nil {
+			return nil
+		}
+
+		expression.Branches = append(expression.Branches, &CaseBranch{Pattern: pattern, Body: body})
+
+		if !p.endBranch() {
+			return nil
+		}
+	}
+
+	p.advanceIgnoreSpace() // skip '}'
+
+	return expression
+}
+
+func (p *parser) parsePattern() Pattern {
+	token := p.peekIgnoreSpace()
+
+	switch token.Type {
+	case lexer.BIN_NUM_LITERAL,
+		lexer.OCT_NUM_LITERAL,
+		lexer.DEC_NUM_LITERAL,
+		lexer.HEX_NUM_LITERAL,
+		lexer.NORMAL_NUM_LITERAL,
+		lexer.STRING_LITERAL,
+		lexer.BYTE_STRING_LITERAL,
+		lexer.SYM_LITERAL,
+		lexer.KEYWORD_TRUE,
+		lexer.KEYWORD_FALSE:
+		p.advanceIgnoreSpace()
+		return &LiteralPattern{Literal: literal(token)}
+	case lexer.MINUS_SIGN:
+		p.advanceIgnoreSpace()
+
+		number := p.peek()
+		switch number.Type {
+		case lexer.BIN_NUM_LITERAL, lexer.OCT_NUM_LITERAL, lexer.DEC_NUM_LITERAL, lexer.HEX_NUM_LITERAL, lexer.NORMAL_NUM_LITERAL:
+			p.advance()
+			return &LiteralPattern{Literal: literal(number), Negative: true}
+		}
+
+		p.commitErr(*number, fmt.Sprintf("Expected a number but found %s", number.Type))
+		return nil
+	case lexer.KEYWORD_NIL:
+		p.advanceIgnoreSpace()
+		return &NilPattern{Pos: token.Pos}
+	case lexer.MUTED_IDENTIFIER:
+		p.advanceIgnoreSpace()
+		return &MutedPattern{Identifer: &Identifer{Name: token.Literal, Pos: token.Pos}}
+	case lexer.IDENTIFIER:
+		path := p.parsePath()
+		if path == nil {
+			return nil
+		}
+
+		namespace, identifier := splitPath(path)
+
+		if p.peekIgnoreSpace().Type == lexer.OPENED_BRACE {
+			return p.parseStructPattern(&Type{Namespace: namespace, Identifer: identifier})
+		}
+
+		if namespace != nil {
+			p.commitErr(*token, fmt.Sprintf("Expected a struct pattern after %s", path))
+			return nil
+		}
+
+		return &BindingPattern{Identifer: identifier}
+	}
+
+	p.commitErr(*token, fmt.Sprintf("Expected a pattern but found %s", token.Type))
+	return nil
+}
+
+// A field without a pattern binds the field to a variable of the same name:
+// Point { x, y: 0 }.
+func (p *parser) parseStructPattern(structType *Type) Pattern {
+	p.advanceIgnoreSpace() // skip '{'
+
+	pattern := &StructPattern{Type: structType}
+
+	for p.peekIgnoreSpace().Type != lexer.CLOSED_BRACE {
+		identifier := p.parseIdentifier()
+		if identifier == nil {
+			return nil
+		}
+
+		var fieldPattern Pattern = &BindingPattern{Identifer: identifier}
+
+		if p.peekIgnoreSpace().Type == lexer.TYPE_INDICATOR {
+			p.advanceIgnoreSpace()
+
+			if fieldPattern = p.parsePattern(); fieldPattern == nil {
+				return nil
+			}
+		}
+
+		pattern.Fields = append(pattern.Fields, &StructPatternField{Identifer: identifier, Pattern: fieldPattern})
+
+		if p.peekIgnoreSpace().Type == lexer.COMMA {
+			p.advanceIgnoreSpace()
+		}
+	}
+
+	p.advanceIgnoreSpace() // skip '}'
+
+	return pattern
+}
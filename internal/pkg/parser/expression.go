@@ -0,0 +1,455 @@
+package parser
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/lexer"
+)
+
+type Precedence int
+
+const (
+	PRECEDENCE_LOWEST Precedence = iota
+	PRECEDENCE_PIPE
+	PRECEDENCE_IF_NIL
+	PRECEDENCE_LOGICAL_OR
+	PRECEDENCE_LOGICAL_AND
+	PRECEDENCE_OR
+	PRECEDENCE_AND
+	PRECEDENCE_COMPARISON
+	PRECEDENCE_SHIFT
+	PRECEDENCE_SUM
+	PRECEDENCE_PRODUCT
+	PRECEDENCE_PREFIX
+	PRECEDENCE_POWER
+	PRECEDENCE_POSTFIX
+)
+
+type Associativity int
+
+const (
+	LEFT Associativity = iota
+	RIGHT
+	NONE
+)
+
+type operator struct {
+	precedence    Precedence
+	associativity Associativity
+}
+
+// Binary operators from the loosest to the tightest binding:
+//
+//	|                          pipe            left
+//	??                         if nil          right
+//	||                         logical or      left
+//	&&                         logical and     left
+//	or xor                     bitwise or      left
+//	and                        bitwise and     left
+//	== != < <= > >=            comparison      none
+//	shl shr ashr cshl cshr     shift           left
+//	+ -                        sum             left
+//	* /                        product         left
+//	- not                      prefix          (unary)
+//	^                          power           right
+//	f(x) a[i]                  postfix         (call and index)
+//
+// The power operator binds tighter than the prefix operators, so -2^2 is
+// -(2^2). A pipe feeds its left side as first argument into the call on its
+// right side and is desugared into a Call: a | f(b) is f(a, b).
+var binaryOperators = map[lexer.TokenType]operator{
+	lexer.PIPE:                   {PRECEDENCE_PIPE, LEFT},
+	lexer.IF_NIL:                 {PRECEDENCE_IF_NIL, RIGHT},
+	lexer.LOGICAL_OR:             {PRECEDENCE_LOGICAL_OR, LEFT},
+	lexer.LOGICAL_AND:            {PRECEDENCE_LOGICAL_AND, LEFT},
+	lexer.KEYWORD_OR:             {PRECEDENCE_OR, LEFT},
+	lexer.KEYWORD_XOR:            {PRECEDENCE_OR, LEFT},
+	lexer.KEYWORD_AND:            {PRECEDENCE_AND, LEFT},
+	lexer.EQUALS:                 {PRECEDENCE_COMPARISON, NONE},
+	lexer.NOT_EQUALS:             {PRECEDENCE_COMPARISON, NONE},
+	lexer.LESS_THAN:              {PRECEDENCE_COMPARISON, NONE},
+	lexer.LESS_THAN_OR_EQUALS:    {PRECEDENCE_COMPARISON, NONE},
+	lexer.GREATER_THAN:           {PRECEDENCE_COMPARISON, NONE},
+	lexer.GREATER_THAN_OR_EQUALS: {PRECEDENCE_COMPARISON, NONE},
+	lexer.KEYWORD_SHL:            {PRECEDENCE_SHIFT, LEFT},
+	lexer.KEYWORD_SHR:            {PRECEDENCE_SHIFT, LEFT},
+	lexer.KEYWORD_ASHR:           {PRECEDENCE_SHIFT, LEFT},
+	lexer.KEYWORD_CSHL:           {PRECEDENCE_SHIFT, LEFT},
+	lexer.KEYWORD_CSHR:           {PRECEDENCE_SHIFT, LEFT},
+	lexer.PLUS_SIGN:              {PRECEDENCE_SUM, LEFT},
+	lexer.MINUS_SIGN:             {PRECEDENCE_SUM, LEFT},
+	lexer.STAR_SIGN:              {PRECEDENCE_PRODUCT, LEFT},
+	lexer.SLASH_SIGN:             {PRECEDENCE_PRODUCT, LEFT},
+	lexer.CIRCUMFLEX:             {PRECEDENCE_POWER, RIGHT},
+}
+
+var prefixOperators = map[lexer.TokenType]bool{
+	lexer.MINUS_SIGN:  true,
+	lexer.KEYWORD_NOT: true,
+}
+
+func (p *parser) parseExpression() Expression {
+	return p.parseBinary(PRECEDENCE_LOWEST)
+}
+
+// parseCondition parses the expression in front of the braces of an if or a
+// case. Struct literals are not allowed there, otherwise the opening brace of
+// the body would be taken for the start of a struct literal.
+func (p *parser) parseCondition() Expression {
+	noStructLiteral := p.noStructLiteral
+	p.noStructLiteral = true
+	defer func() { p.noStructLiteral = noStructLiteral }()
+
+	return p.parseExpression()
+}
+
+// parseNestedExpression parses an expression inside parentheses or brackets,
+// where struct literals are unambiguous again.
+func (p *parser) parseNestedExpression() Expression {
+	noStructLiteral := p.noStructLiteral
+	p.noStructLiteral = false
+	defer func() { p.noStructLiteral = noStructLiteral }()
+
+	return p.parseExpression()
+}
+
+// Infix and postfix operators have to start on the same line as their left
+// operand, otherwise the expression ends at the line break.
+func (p *parser) peekSameLine() *lexer.Token {
+	for idx := p.currIdx; idx < len(p.tokens); idx++ {
+		tokenType := p.tokens[idx].Type
+		if tokenType == lexer.NEWLINE {
+			break
+		}
+
+		if isSpace(tokenType) {
+			continue
+		}
+
+		return &p.tokens[idx]
+	}
+
+	return p.eofToken()
+}
+
+func (p *parser) parseBinary(minPrecedence Precedence) Expression {
+	left := p.parsePrefix()
+	if left == nil {
+		return nil
+	}
+
+	for {
+		token := p.peekSameLine()
+
+		op, ok := binaryOperators[token.Type]
+		if !ok || op.precedence <= minPrecedence {
+			return left
+		}
+
+		p.advanceIgnoreSpace()
+
+		rightPrecedence := op.precedence
+		if op.associativity == RIGHT {
+			rightPrecedence--
+		}
+
+		right := p.parseBinary(rightPrecedence)
+		if right == nil {
+			return nil
+		}
+
+		if op.associativity == NONE {
+			next := p.peekSameLine()
+			if nextOp, ok := binaryOperators[next.Type]; ok && nextOp.precedence == op.precedence {
+				p.commitErr(*next, fmt.Sprintf("%s cannot be chained with %s", next.Type, token.Type))
+				return nil
+			}
+		}
+
+		if token.Type == lexer.PIPE {
+			if left = p.desugarPipe(token, left, right); left == nil {
+				return nil
+			}
+			continue
+		}
+
+		left = &Binary{Operator: token.Type, Left: left, Right: right, Pos: token.Pos}
+	}
+}
+
+func (p *parser) desugarPipe(token *lexer.Token, left Expression, right Expression) Expression {
+	switch right := right.(type) {
+	case *Call:
+		right.Arguments = append([]Expression{left}, right.Arguments...)
+		return right
+	case *IdentifierExpression:
+		return &Call{Callee: right, Arguments: []Expression{left}, Pos: token.Pos}
+	}
+
+	p.commitErr(*token, "Expected a function or a call on the right side of a pipe")
+	return nil
+}
+
+func (p *parser) parsePrefix() Expression {
+	token := p.peekIgnoreSpace()
+
+	if prefixOperators[token.Type] {
+		p.advanceIgnoreSpace()
+
+		operand := p.parseBinary(PRECEDENCE_PREFIX)
+		if operand == nil {
+			return nil
+		}
+
+		return &Unary{Operator: token.Type, Operand: operand, Pos: token.Pos}
+	}
+
+	primary := p.parsePrimary()
+	if primary == nil {
+		return nil
+	}
+
+	return p.parsePostfix(primary)
+}
+
+func (p *parser) parsePostfix(expression Expression) Expression {
+	for {
+		token := p.peekSameLine()
+
+		switch token.Type {
+		case lexer.OPENED_PARENTHESIS:
+			p.advanceIgnoreSpace()
+
+			arguments, ok := p.parseArguments()
+			if !ok {
+				return nil
+			}
+
+			expression = &Call{Callee: expression, Arguments: arguments, Pos: token.Pos}
+		case lexer.OPENED_BRACKET:
+			p.advanceIgnoreSpace()
+
+			index := p.parseNestedExpression()
+			if index == nil {
+				return nil
+			}
+
+			if p.expect(lexer.CLOSED_BRACKET, "]") == nil {
+				return nil
+			}
+
+			expression = &Index{Target: expression, Index: index, Pos: token.Pos}
+		case lexer.DOT:
+			p.advanceIgnoreSpace()
+
+			identifier := p.parseIdentifier()
+			if identifier == nil {
+				return nil
+			}
+
+			expression = &FieldAccess{Target: expression, Identifer: identifier, Pos: token.Pos}
+		default:
+			return expression
+		}
+	}
+}
+
+// Fields of a struct literal are separated by commas or line breaks. A field
+// without a value takes the variable of the same name: Point { x, y }.
+func (p *parser) parseStructLiteral(structType *Type) Expression {
+	p.advanceIgnoreSpace() // skip '{'
+
+	literal := &StructLiteral{Type: structType}
+
+	for p.peekIgnoreSpace().Type != lexer.CLOSED_BRACE {
+		identifier := p.parseIdentifier()
+		if identifier == nil {
+			return nil
+		}
+
+		var expression Expression = &IdentifierExpression{Identifer: identifier}
+
+		if p.peekIgnoreSpace().Type == lexer.TYPE_INDICATOR {
+			p.advanceIgnoreSpace()
+
+			if expression = p.parseExpression(); expression == nil {
+				return nil
+			}
+		}
+
+		literal.Fields = append(literal.Fields, &StructLiteralField{Identifer: identifier, Expression: expression})
+
+		if p.peekIgnoreSpace().Type == lexer.COMMA {
+			p.advanceIgnoreSpace()
+		}
+	}
+
+	p.advanceIgnoreSpace() // skip '}'
+
+	return literal
+}
+
+func (p *parser) parseArguments() ([]Expression, bool) {
+	var arguments []Expression
+
+	for p.peekIgnoreSpace().Type != lexer.CLOSED_PARENTHESIS {
+		argument := p.parseNestedExpression()
+		if argument == nil {
+			return nil, false
+		}
+
+		arguments = append(arguments, argument)
+
+		if p.peekIgnoreSpace().Type != lexer.COMMA {
+			break
+		}
+
+		p.advanceIgnoreSpace()
+	}
+
+	if p.expect(lexer.CLOSED_PARENTHESIS, ")") == nil {
+		return nil, false
+	}
+
+	return arguments, true
+}
+
+// literal returns the literal of a token, strings and symbols with their
+// decoded value and numbers with their parsed value.
+func literal(token *lexer.Token) *Literal {
+	switch token.Type {
+	case lexer.STRING_LITERAL, lexer.BYTE_STRING_LITERAL, lexer.SYM_LITERAL:
+		return &Literal{Kind: token.Type, Value: token.Value, Pos: token.Pos}
+	case lexer.BIN_NUM_LITERAL, lexer.OCT_NUM_LITERAL, lexer.DEC_NUM_LITERAL, lexer.HEX_NUM_LITERAL, lexer.NORMAL_NUM_LITERAL:
+		return number(token)
+	}
+
+	return &Literal{Kind: token.Type, Value: token.Literal, Pos: token.Pos}
+}
+
+// parseInterpolation parses the parts of an interpolated string the lexer
+// split it into, the expressions between them are parsed as usual.
+func (p *parser) parseInterpolation() Expression {
+	start := p.advanceIgnoreSpace()
+	interpolation := &Interpolation{Pos: start.Pos}
+	interpolation.Parts = append(interpolation.Parts, &Literal{Kind: lexer.STRING_LITERAL, Value: start.Value, Pos: start.Pos})
+
+	for {
+		expression := p.parseNestedExpression()
+		if expression == nil {
+			return nil
+		}
+		interpolation.Expressions = append(interpolation.Expressions, expression)
+
+		token := p.peekIgnoreSpace()
+		if token.Type != lexer.INTERPOLATION_MIDDLE && token.Type != lexer.INTERPOLATION_END {
+			p.commitErr(*token, fmt.Sprintf("Expected } after the interpolated expression but found %s", token.Type))
+			return nil
+		}
+
+		p.advanceIgnoreSpace()
+		interpolation.Parts = append(interpolation.Parts, &Literal{Kind: lexer.STRING_LITERAL, Value: token.Value, Pos: token.Pos})
+
+		if token.Type == lexer.INTERPOLATION_END {
+			return interpolation
+		}
+	}
+}
+
+var numberBases = map[lexer.TokenType]int{
+	lexer.BIN_NUM_LITERAL: 2,
+	lexer.OCT_NUM_LITERAL: 8,
+	lexer.DEC_NUM_LITERAL: 10,
+	lexer.HEX_NUM_LITERAL: 16,
+}
+
+// floatPrecision is the number of bits float literals are parsed with, which
+// is enough to round them to f64 or f32 when they are used.
+const floatPrecision = 128
+
+// number returns the literal of a number token. Normal number literals with a
+// decimal point, an exponent or a float suffix are floats, all others are
+// integers.
+func number(token *lexer.Token) *Literal {
+	literal := &Literal{Kind: token.Type, Value: token.Literal, Suffix: token.Suffix, Pos: token.Pos}
+	digits := strings.ReplaceAll(strings.TrimSuffix(token.Literal, token.Suffix), "_", "")
+
+	if base, ok := numberBases[token.Type]; ok {
+		literal.Int, _ = new(big.Int).SetString(digits[2:], base)
+		return literal
+	}
+
+	if strings.ContainsAny(digits, ".e") || strings.HasPrefix(token.Suffix, "f") {
+		literal.Float, _ = new(big.Float).SetPrec(floatPrecision).SetString(digits)
+		return literal
+	}
+
+	literal.Int, _ = new(big.Int).SetString(digits, 10)
+	return literal
+}
+
+func (p *parser) parsePrimary() Expression {
+	token := p.peekIgnoreSpace()
+
+	switch token.Type {
+	case lexer.BIN_NUM_LITERAL,
+		lexer.OCT_NUM_LITERAL,
+		lexer.DEC_NUM_LITERAL,
+		lexer.HEX_NUM_LITERAL,
+		lexer.NORMAL_NUM_LITERAL,
+		lexer.STRING_LITERAL,
+		lexer.BYTE_STRING_LITERAL,
+		lexer.SYM_LITERAL,
+		lexer.KEYWORD_TRUE,
+		lexer.KEYWORD_FALSE,
+		lexer.KEYWORD_NIL:
+		p.advanceIgnoreSpace()
+		return literal(token)
+	case lexer.INTERPOLATION_START:
+		return p.parseInterpolation()
+	case lexer.IDENTIFIER:
+		path := p.parsePath()
+		if path == nil {
+			return nil
+		}
+
+		namespace, identifier := splitPath(path)
+
+		if p.peekSameLine().Type == lexer.OPENED_BRACE && !p.noStructLiteral {
+			return p.parseStructLiteral(&Type{Namespace: namespace, Identifer: identifier})
+		}
+
+		if namespace == nil {
+			return &IdentifierExpression{Identifer: identifier}
+		}
+
+		return &PathExpression{Namespace: namespace, Identifer: identifier}
+	case lexer.KEYWORD_SELF:
+		p.advanceIgnoreSpace()
+		return &SelfExpression{Pos: token.Pos}
+	case lexer.KEYWORD_IF:
+		return p.parseIf()
+	case lexer.KEYWORD_COND:
+		return p.parseCond()
+	case lexer.KEYWORD_CASE:
+		return p.parseCase()
+	case lexer.OPENED_PARENTHESIS:
+		p.advanceIgnoreSpace()
+
+		expression := p.parseNestedExpression()
+		if expression == nil {
+			return nil
+		}
+
+		if p.expect(lexer.CLOSED_PARENTHESIS, ")") == nil {
+			return nil
+		}
+
+		return &Grouping{Expression: expression, Pos: token.Pos}
+	}
+
+	p.commitErr(*token, fmt.Sprintf("Expected an expression but found %s", token.Type))
+	return nil
+}
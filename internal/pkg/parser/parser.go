@@ -1,6 +1,10 @@
 package parser
 
 import (
+	"fmt"
+	"strings"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
 	"github.com/henryk-kramer/quartz-lang/internal/pkg/lexer"
 )
 
@@ -9,23 +13,106 @@ type Error struct {
 	Msg   string
 }
 
+func (err Error) Error() string {
+	return fmt.Sprintf("%s: %s", err.Token.Pos, err.Msg)
+}
+
+// Diagnostic returns the error as a diagnostic that underlines its token,
+// errors the lexer left in the token are reported as such.
+func (err Error) Diagnostic() diagnostics.Diagnostic {
+	code := diagnostics.SYNTAX_ERROR
+	if err.Token.HasError {
+		code = lexerCodes[err.Token.Type]
+	}
+
+	return diagnostics.New(diagnostics.ERROR, code, err.Token.Pos.Span(), err.Msg)
+}
+
+var lexerCodes = map[lexer.TokenType]string{
+	lexer.UNKNOWN:                   diagnostics.UNKNOWN_CHARACTER,
+	lexer.MULTI_LINE_COMMENT_ERROR:  diagnostics.UNCLOSED_COMMENT,
+	lexer.STRING_LITERAL_ERROR:      diagnostics.INVALID_STRING,
+	lexer.BYTE_STRING_LITERAL_ERROR: diagnostics.INVALID_STRING,
+	lexer.SYM_LITERAL_ERROR:         diagnostics.INVALID_SYM,
+	lexer.IDENTIFIER_ERROR:          diagnostics.INVALID_IDENTIFIER,
+	lexer.BIN_NUM_LITERAL_ERROR:     diagnostics.INVALID_NUMBER,
+	lexer.OCT_NUM_LITERAL_ERROR:     diagnostics.INVALID_NUMBER,
+	lexer.DEC_NUM_LITERAL_ERROR:     diagnostics.INVALID_NUMBER,
+	lexer.HEX_NUM_LITERAL_ERROR:     diagnostics.INVALID_NUMBER,
+	lexer.NORMAL_NUM_LITERAL_ERROR:  diagnostics.INVALID_NUMBER,
+}
+
 type parser struct {
-	tokens   []lexer.Token
-	program  Program
-	errors   []Error
-	startIdx int
-	currIdx  int
+	tokens          []lexer.Token
+	program         Program
+	errors          []Error
+	startIdx        int
+	currIdx         int
+	noStructLiteral bool
 }
 
 func Run(tokens []lexer.Token) (Program, []Error) {
 	p := newParser(tokens)
 
-	for !p.eof() {
-
-		p.advance()
+	scope := &Scope{}
+	p.program.Scopes = append(p.program.Scopes, scope)
+
+	for {
+		switch p.peekIgnoreSpace().Type {
+		case lexer.EOF:
+			return p.program, p.errors
+		case lexer.KEYWORD_NAMESPACE:
+			// Every namespace declaration opens a new scope that lasts until
+			// the next namespace declaration or the end of the file
+			if namespace := p.parseNamespace(); namespace != nil {
+				scope = &Scope{Namespace: namespace}
+				p.program.Scopes = append(p.program.Scopes, scope)
+			} else {
+				p.synchronize()
+			}
+		case lexer.KEYWORD_IMPORT:
+			if imp := p.parseImport(); imp != nil {
+				scope.Imports = append(scope.Imports, imp)
+			} else {
+				p.synchronize()
+			}
+		default:
+			p.parseDeclaration(scope)
+		}
 	}
+}
 
-	return p.program, p.errors
+// RunInteractive parses input of an interactive session. Unlike a file it
+// can contain statements next to declarations, which end up in the
+// statements of the only scope.
+func RunInteractive(tokens []lexer.Token) (Program, []Error) {
+	p := newParser(tokens)
+
+	scope := &Scope{}
+	p.program.Scopes = append(p.program.Scopes, scope)
+
+	for {
+		token := p.peekIgnoreSpace()
+
+		switch {
+		case token.Type == lexer.EOF:
+			return p.program, p.errors
+		case token.Type == lexer.KEYWORD_IMPORT:
+			if imp := p.parseImport(); imp != nil {
+				scope.Imports = append(scope.Imports, imp)
+			} else {
+				p.synchronize()
+			}
+		case declarationStarts[token.Type] || token.Type == lexer.CLOSED_BRACE:
+			p.parseDeclaration(scope)
+		default:
+			if statement := p.parseStatement(); statement != nil {
+				scope.Statements = append(scope.Statements, statement)
+			} else {
+				p.synchronize()
+			}
+		}
+	}
 }
 
 func newParser(tokens []lexer.Token) parser {
@@ -34,54 +121,81 @@ func newParser(tokens []lexer.Token) parser {
 
 /* Helper methods */
 
+func isSpace(tokenType lexer.TokenType) bool {
+	switch tokenType {
+	case lexer.WHITESPACE, lexer.TAB, lexer.NEWLINE, lexer.SINGLE_LINE_COMMENT, lexer.MULTI_LINE_COMMENT, lexer.DOC_COMMENT:
+		return true
+	}
+
+	return false
+}
+
 func (p *parser) eof() bool {
 	return p.currIdx >= len(p.tokens)
 }
 
 func (p *parser) whitespace() bool {
-	tokenType := p.peek().Type
+	return isSpace(p.peek().Type)
+}
+
+func (p *parser) eofToken() *lexer.Token {
+	token := lexer.Token{Type: lexer.EOF}
+
+	if len(p.tokens) > 0 {
+		last := p.tokens[len(p.tokens)-1]
+		token.Pos = last.Pos.Span().End
+	}
 
-	return tokenType == lexer.WHITESPACE || tokenType == lexer.TAB || tokenType == lexer.NEWLINE
+	return &token
 }
 
 func (p *parser) peek() *lexer.Token {
 	if p.eof() {
-		return &lexer.Token{Type: lexer.EOF}
+		return p.eofToken()
 	}
 
 	return &p.tokens[p.currIdx]
 }
 
 func (p *parser) peekIgnoreSpace() *lexer.Token {
-	idx := p.currIdx
-	for !p.eof() {
-		tokenType := p.tokens[idx].Type
-		if tokenType == lexer.WHITESPACE || tokenType == lexer.TAB || tokenType == lexer.NEWLINE {
-			idx++
+	for idx := p.currIdx; idx < len(p.tokens); idx++ {
+		if isSpace(p.tokens[idx].Type) {
 			continue
 		}
 
 		return &p.tokens[idx]
 	}
 
-	return &lexer.Token{Type: lexer.EOF}
+	return p.eofToken()
 }
 
 func (p *parser) advance() {
 	p.currIdx++
 }
 
-func (p *parser) advanceIgnoreSpace() {
+func (p *parser) advanceIgnoreSpace() *lexer.Token {
 	for !p.eof() {
-		tokenType := p.tokens[p.currIdx].Type
+		token := &p.tokens[p.currIdx]
 		p.currIdx++
 
-		if tokenType == lexer.WHITESPACE || tokenType == lexer.TAB || tokenType == lexer.NEWLINE {
+		if isSpace(token.Type) {
 			continue
 		}
 
-		return
+		return token
+	}
+
+	return p.eofToken()
+}
+
+func (p *parser) expect(tokenType lexer.TokenType, expected string) *lexer.Token {
+	token := p.peekIgnoreSpace()
+	if token.Type != tokenType {
+		p.commitErr(*token, fmt.Sprintf("Expected %s but found %s", expected, token.Type))
+		return nil
 	}
+
+	return p.advanceIgnoreSpace()
 }
 
 func (p *parser) commit() {
@@ -90,6 +204,12 @@ func (p *parser) commit() {
 
 func (p *parser) commitErr(token lexer.Token, msg string) {
 	p.startIdx = p.currIdx
+
+	// Tokens the lexer already rejected carry the more precise message
+	if token.HasError {
+		msg = token.ErrorMsg
+	}
+
 	p.errors = append(p.errors, Error{token, msg})
 }
 
@@ -97,8 +217,72 @@ func (p *parser) rollback() {
 	p.currIdx = p.startIdx
 }
 
+// peekDoc returns the text of the doc comments between the current and the
+// next token, which document the declaration that starts there.
+func (p *parser) peekDoc() string {
+	var lines []string
+	for idx := p.currIdx; idx < len(p.tokens) && isSpace(p.tokens[idx].Type); idx++ {
+		if p.tokens[idx].Type == lexer.DOC_COMMENT {
+			lines = append(lines, p.tokens[idx].Value)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
 /* Parser methods */
 
+func (p *parser) parseDeclaration(scope *Scope) {
+	doc := p.peekDoc()
+	visibility := p.parseVisibility()
+
+	token := p.peekIgnoreSpace()
+
+	switch token.Type {
+	case lexer.KEYWORD_CONST:
+		if constant := p.parseConstant(visibility); constant != nil {
+			constant.Doc = doc
+			scope.Constants = append(scope.Constants, constant)
+			return
+		}
+	case lexer.KEYWORD_LET, lexer.KEYWORD_LET_EXCLAMATION:
+		if binding := p.parseBinding(visibility); binding != nil {
+			scope.Statements = append(scope.Statements, binding)
+			return
+		}
+	case lexer.KEYWORD_FN:
+		if function := p.parseFunction(visibility, false, false); function != nil {
+			function.Doc = doc
+			scope.Functions = append(scope.Functions, function)
+			return
+		}
+	case lexer.KEYWORD_STRUCT:
+		if structure := p.parseStruct(visibility); structure != nil {
+			structure.Doc = doc
+			scope.Structs = append(scope.Structs, structure)
+			return
+		}
+	case lexer.KEYWORD_TRAIT:
+		if trait := p.parseTrait(visibility); trait != nil {
+			trait.Doc = doc
+			scope.Traits = append(scope.Traits, trait)
+			return
+		}
+	case lexer.KEYWORD_IMPL:
+		if *visibility != PRIVATE {
+			p.commitErr(*token, "Impl blocks cannot have a visibility, declare it on their methods instead")
+		} else if impl := p.parseImpl(); impl != nil {
+			scope.Impls = append(scope.Impls, impl)
+			return
+		}
+	default:
+		p.advanceIgnoreSpace()
+		p.commitErr(*token, fmt.Sprintf("Expected a declaration but found %s", token.Type))
+	}
+
+	p.synchronize()
+}
+
 func (p *parser) parseVisibility() *Visibility {
 	token := p.peekIgnoreSpace()
 
@@ -106,73 +290,444 @@ func (p *parser) parseVisibility() *Visibility {
 
 	switch token.Type {
 	case lexer.KEYWORD_EXT:
+		p.advanceIgnoreSpace()
 		p.commit()
 		visibility = EXTERNAL
 	case lexer.KEYWORD_PUB:
+		p.advanceIgnoreSpace()
 		p.commit()
 		visibility = PUBLIC
 	default:
-		p.rollback()
 		visibility = PRIVATE
 	}
 
 	return &visibility
 }
 
-// func (p *parser) parseNamespace() *Namespace {
-// 	var identifiers []string
-
-// 	p.advanceIgnoreSpace() // skip 'namespace'
-
-// 	token := p.peekIgnoreSpace()
-// 	if token.Type != lexer.IDENTIFIER {
-// 		p.errors = append(p.errors, Error{
-// 			Token: *token,
-// 			Msg:   fmt.Sprintf("Expected an identifier but found %s", token.Type),
-// 		})
-// 	} else {
-// 		identifiers = append(identifiers, token.Literal)
-// 	}
-
-// 	p.advanceIgnoreSpace()
-
-// 	for !p.eof() {
-// 		if p.whitespace() {
-// 			break
-// 		}
-
-// 		token = p.peek()
-// 		if token.Type != lexer.DOUBLE_SEMICOLON {
-// 			p.errors = append(p.errors, Error{
-// 				Token: *token,
-// 				Msg:   fmt.Sprintf("Expected :: but found %s", token.Type),
-// 			})
-// 		}
-
-// 		p.advance()
-
-// 		if p.whitespace() {
-// 			p.errors = append(p.errors, Error{
-// 				Token: *token,
-// 				Msg:   "Missing an identifer",
-// 			})
-// 			break
-// 		}
-
-// 		token = p.peek()
-// 		if token.Type != lexer.IDENTIFIER {
-// 			p.errors = append(p.errors, Error{
-// 				Token: *token,
-// 				Msg:   fmt.Sprintf("Expected an identifier but found %s", token.Type),
-// 			})
-// 		} else {
-// 			identifiers = append(identifiers, token.Literal)
-// 		}
-
-// 		p.advance()
-// 	}
-
-// 	return &Namespace{
-// 		Identifiers: identifiers,
-// 	}
-// }
+func (p *parser) parseIdentifier() *Identifer {
+	token := p.expect(lexer.IDENTIFIER, "an identifier")
+	if token == nil {
+		return nil
+	}
+
+	return &Identifer{Name: token.Literal, Pos: token.Pos}
+}
+
+// Paths are written without spaces around the ::, so a::b is a single path
+// while a :: b is not.
+func (p *parser) parsePath() *Path {
+	identifier := p.parseIdentifier()
+	if identifier == nil {
+		return nil
+	}
+
+	path := &Path{Identifers: []*Identifer{identifier}}
+
+	for p.peek().Type == lexer.DOUBLE_SEMICOLON {
+		p.advance()
+
+		token := p.peek()
+		if token.Type != lexer.IDENTIFIER {
+			p.commitErr(*token, fmt.Sprintf("Expected an identifier but found %s", token.Type))
+			return nil
+		}
+
+		p.advance()
+		path.Identifers = append(path.Identifers, &Identifer{Name: token.Literal, Pos: token.Pos})
+	}
+
+	return path
+}
+
+// splitPath separates the namespace part of a path from its last identifier.
+func splitPath(path *Path) (*Path, *Identifer) {
+	last := len(path.Identifers) - 1
+	if last == 0 {
+		return nil, path.Identifers[0]
+	}
+
+	return &Path{Identifers: path.Identifers[:last]}, path.Identifers[last]
+}
+
+func (p *parser) parseNamespace() *Namespace {
+	p.advanceIgnoreSpace() // skip 'namespace'
+
+	path := p.parsePath()
+	if path == nil {
+		return nil
+	}
+
+	p.commit()
+
+	return &Namespace{Path: path}
+}
+
+func (p *parser) parseImport() *Import {
+	p.advanceIgnoreSpace() // skip 'import'
+
+	path := p.parsePath()
+	if path == nil {
+		return nil
+	}
+
+	imp := &Import{Path: path}
+
+	if p.peekIgnoreSpace().Type == lexer.KEYWORD_AS {
+		p.advanceIgnoreSpace()
+
+		if imp.Alias = p.parseIdentifier(); imp.Alias == nil {
+			return nil
+		}
+	}
+
+	if p.peekIgnoreSpace().Type == lexer.KEYWORD_FROM {
+		p.advanceIgnoreSpace()
+
+		if imp.From = p.parsePath(); imp.From == nil {
+			return nil
+		}
+	}
+
+	p.commit()
+
+	return imp
+}
+
+func (p *parser) parseConstant(visibility *Visibility) *Constant {
+	p.advanceIgnoreSpace() // skip 'const'
+
+	identifier := p.parseIdentifier()
+	if identifier == nil {
+		return nil
+	}
+
+	var constantType *Type
+	if p.peekIgnoreSpace().Type == lexer.TYPE_INDICATOR {
+		p.advanceIgnoreSpace()
+
+		if constantType = p.parseType(); constantType == nil {
+			return nil
+		}
+	}
+
+	if p.expect(lexer.BINDING, "=") == nil {
+		return nil
+	}
+
+	expression := p.parseExpression()
+	if expression == nil {
+		return nil
+	}
+
+	p.commit()
+
+	return &Constant{
+		Visibility: visibility,
+		Identifer:  identifier,
+		Type:       constantType,
+		Expression: &CompileTimeExpression{Expression: expression},
+	}
+}
+
+func (p *parser) parseBinding(visibility *Visibility) *Binding {
+	token := p.advanceIgnoreSpace() // skip 'let' or 'let!'
+
+	identifier := p.parseIdentifier()
+	if identifier == nil {
+		return nil
+	}
+
+	var bindingType *Type
+	if p.peekIgnoreSpace().Type == lexer.TYPE_INDICATOR {
+		p.advanceIgnoreSpace()
+
+		if bindingType = p.parseType(); bindingType == nil {
+			return nil
+		}
+	}
+
+	if p.expect(lexer.BINDING, "=") == nil {
+		return nil
+	}
+
+	expression := p.parseExpression()
+	if expression == nil {
+		return nil
+	}
+
+	p.commit()
+
+	return &Binding{
+		Visibility: visibility,
+		Mutable:    token.Type == lexer.KEYWORD_LET_EXCLAMATION,
+		Identifer:  identifier,
+		Type:       bindingType,
+		Expression: expression,
+		Pos:        token.Pos,
+	}
+}
+
+// Methods of traits and impl blocks may take self as their first parameter.
+// Trait methods only need a signature, their body is a default
+// implementation.
+func (p *parser) parseFunction(visibility *Visibility, method bool, optionalBody bool) *Function {
+	p.advanceIgnoreSpace() // skip 'fn'
+
+	identifier := p.parseIdentifier()
+	if identifier == nil {
+		return nil
+	}
+
+	parameters, ok := p.parseFunctionParameters(method)
+	if !ok {
+		return nil
+	}
+
+	var returnType *Type
+	if p.peekIgnoreSpace().Type == lexer.RETURN_TYPE_INDICATOR {
+		p.advanceIgnoreSpace()
+
+		if returnType = p.parseType(); returnType == nil {
+			return nil
+		}
+	}
+
+	// External functions are implemented elsewhere and have no body
+	var body *Scope
+	if !(*visibility == EXTERNAL || optionalBody) || p.peekIgnoreSpace().Type == lexer.OPENED_BRACE {
+		if body = p.parseScope(); body == nil {
+			return nil
+		}
+	}
+
+	p.commit()
+
+	return &Function{
+		Visibility: visibility,
+		Identifer:  identifier,
+		Parameters: parameters,
+		ReturnType: returnType,
+		Body:       body,
+	}
+}
+
+func (p *parser) parseFunctionParameters(method bool) ([]*FunctionParameter, bool) {
+	if p.expect(lexer.OPENED_PARENTHESIS, "(") == nil {
+		return nil, false
+	}
+
+	var parameters []*FunctionParameter
+
+	for p.peekIgnoreSpace().Type != lexer.CLOSED_PARENTHESIS {
+		if token := p.peekIgnoreSpace(); token.Type == lexer.KEYWORD_SELF {
+			if !method || len(parameters) > 0 {
+				p.commitErr(*token, "self is only allowed as the first parameter of a method")
+				return nil, false
+			}
+
+			p.advanceIgnoreSpace()
+			parameters = append(parameters, &FunctionParameter{
+				Identifer: &Identifer{Name: token.Literal, Pos: token.Pos},
+			})
+
+			if p.peekIgnoreSpace().Type != lexer.COMMA {
+				break
+			}
+
+			p.advanceIgnoreSpace()
+			continue
+		}
+
+		identifier := p.parseIdentifier()
+		if identifier == nil {
+			return nil, false
+		}
+
+		if p.expect(lexer.TYPE_INDICATOR, ":") == nil {
+			return nil, false
+		}
+
+		parameterType := p.parseType()
+		if parameterType == nil {
+			return nil, false
+		}
+
+		parameters = append(parameters, &FunctionParameter{
+			Identifer: identifier,
+			Type:      parameterType,
+		})
+
+		if p.peekIgnoreSpace().Type != lexer.COMMA {
+			break
+		}
+
+		p.advanceIgnoreSpace()
+	}
+
+	if p.expect(lexer.CLOSED_PARENTHESIS, ")") == nil {
+		return nil, false
+	}
+
+	return parameters, true
+}
+
+func (p *parser) parseStruct(visibility *Visibility) *Struct {
+	p.advanceIgnoreSpace() // skip 'struct'
+
+	identifier := p.parseIdentifier()
+	if identifier == nil {
+		return nil
+	}
+
+	if p.expect(lexer.OPENED_BRACE, "{") == nil {
+		return nil
+	}
+
+	structure := &Struct{Visibility: visibility, Identifer: identifier}
+
+	// Fields are separated by commas or line breaks
+	for p.peekIgnoreSpace().Type != lexer.CLOSED_BRACE {
+		fieldVisibility := p.parseVisibility()
+
+		fieldIdentifier := p.parseIdentifier()
+		if fieldIdentifier == nil {
+			p.skipBody()
+			return nil
+		}
+
+		if p.expect(lexer.TYPE_INDICATOR, ":") == nil {
+			p.skipBody()
+			return nil
+		}
+
+		fieldType := p.parseType()
+		if fieldType == nil {
+			p.skipBody()
+			return nil
+		}
+
+		structure.Fields = append(structure.Fields, &StructField{
+			Visibility: fieldVisibility,
+			Identifer:  fieldIdentifier,
+			Type:       fieldType,
+		})
+
+		if p.peekIgnoreSpace().Type == lexer.COMMA {
+			p.advanceIgnoreSpace()
+		}
+	}
+
+	p.advanceIgnoreSpace() // skip '}'
+	p.commit()
+
+	return structure
+}
+
+func (p *parser) parseTrait(visibility *Visibility) *Trait {
+	p.advanceIgnoreSpace() // skip 'trait'
+
+	identifier := p.parseIdentifier()
+	if identifier == nil {
+		return nil
+	}
+
+	methods, ok := p.parseMethods(true)
+	if !ok {
+		return nil
+	}
+
+	p.commit()
+
+	return &Trait{Visibility: visibility, Identifer: identifier, Methods: methods}
+}
+
+// impl Type { ... } adds methods to a type, impl Trait for Type { ... }
+// implements a trait for it.
+func (p *parser) parseImpl() *Impl {
+	p.advanceIgnoreSpace() // skip 'impl'
+
+	implType := p.parseType()
+	if implType == nil {
+		return nil
+	}
+
+	impl := &Impl{Type: implType}
+
+	if p.peekIgnoreSpace().Type == lexer.KEYWORD_FOR {
+		p.advanceIgnoreSpace()
+
+		impl.Trait = implType
+		if impl.Type = p.parseType(); impl.Type == nil {
+			return nil
+		}
+	}
+
+	methods, ok := p.parseMethods(false)
+	if !ok {
+		return nil
+	}
+
+	impl.Methods = methods
+	p.commit()
+
+	return impl
+}
+
+func (p *parser) parseMethods(optionalBody bool) ([]*Function, bool) {
+	if p.expect(lexer.OPENED_BRACE, "{") == nil {
+		return nil, false
+	}
+
+	var methods []*Function
+
+	for p.peekIgnoreSpace().Type != lexer.CLOSED_BRACE {
+		doc := p.peekDoc()
+		visibility := p.parseVisibility()
+
+		if token := p.peekIgnoreSpace(); token.Type != lexer.KEYWORD_FN {
+			p.commitErr(*token, fmt.Sprintf("Expected a method but found %s", token.Type))
+			p.skipBody()
+			return nil, false
+		}
+
+		method := p.parseFunction(visibility, true, optionalBody)
+		if method == nil {
+			p.skipBody()
+			return nil, false
+		}
+		method.Doc = doc
+
+		methods = append(methods, method)
+	}
+
+	p.advanceIgnoreSpace() // skip '}'
+
+	return methods, true
+}
+
+func (p *parser) parseType() *Type {
+	token := p.peekIgnoreSpace()
+
+	if token.Type == lexer.IDENTIFIER {
+		path := p.parsePath()
+		if path == nil {
+			return nil
+		}
+
+		namespace, identifier := splitPath(path)
+		return &Type{Namespace: namespace, Identifer: identifier}
+	}
+
+	switch token.Type {
+	case lexer.KEYWORD_BOOL,
+		lexer.KEYWORD_U8, lexer.KEYWORD_U16, lexer.KEYWORD_U32, lexer.KEYWORD_U64,
+		lexer.KEYWORD_I8, lexer.KEYWORD_I16, lexer.KEYWORD_I32, lexer.KEYWORD_I64,
+		lexer.KEYWORD_F32, lexer.KEYWORD_F64,
+		lexer.KEYWORD_NUM, lexer.KEYWORD_SYM, lexer.KEYWORD_BIN:
+		p.advanceIgnoreSpace()
+		return &Type{Identifer: &Identifer{Name: token.Literal, Pos: token.Pos}}
+	}
+
+	p.commitErr(*token, fmt.Sprintf("Expected a type but found %s", token.Type))
+	return nil
+}
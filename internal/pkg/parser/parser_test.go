@@ -0,0 +1,662 @@
+package parser_test
+
+import (
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/lexer"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/parser"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
+)
+
+type testStruct struct {
+	input string
+	want  parser.Program
+}
+
+func testHelper(t *testing.T, tests []testStruct) {
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			program, errors := parser.Run(lexer.Run(util.NewFileSet().AddFile("", test.input)))
+
+			if len(errors) > 0 {
+				t.Errorf("\n%s\n%s", "---- ERRORS ----", errors)
+				return
+			}
+
+			// Positions are not part of the printed tree, so comparing the
+			// printed form checks the structure only
+			if program.String() != test.want.String() {
+				t.Errorf("\n%s\n%s\n%s\n%s",
+					"---- EXPECTED ----",
+					test.want,
+					"---- ACTUAL ----",
+					program,
+				)
+			}
+		})
+	}
+}
+
+func errorHelper(t *testing.T, inputs map[string]string) {
+	for input, msg := range inputs {
+		t.Run(input, func(t *testing.T) {
+			_, errors := parser.Run(lexer.Run(util.NewFileSet().AddFile("", input)))
+
+			if len(errors) == 0 || errors[0].Msg != msg {
+				t.Errorf("expected first error %q but got %s", msg, errors)
+			}
+		})
+	}
+}
+
+func visibility(v parser.Visibility) *parser.Visibility {
+	return &v
+}
+
+func ident(name string) *parser.Identifer {
+	return &parser.Identifer{Name: name}
+}
+
+func typ(name string) *parser.Type {
+	return &parser.Type{Identifer: ident(name)}
+}
+
+// num returns a normal number literal with its parsed value.
+func num(value string) *parser.Literal {
+	if strings.ContainsAny(value, ".e") {
+		float, _ := new(big.Float).SetString(value)
+		return &parser.Literal{Kind: lexer.NORMAL_NUM_LITERAL, Value: value, Float: float}
+	}
+
+	integer, _ := new(big.Int).SetString(value, 10)
+	return &parser.Literal{Kind: lexer.NORMAL_NUM_LITERAL, Value: value, Int: integer}
+}
+
+func str(value string) *parser.Literal {
+	return &parser.Literal{Kind: lexer.STRING_LITERAL, Value: value}
+}
+
+func constant(expression parser.Expression) *parser.CompileTimeExpression {
+	return &parser.CompileTimeExpression{Expression: expression}
+}
+
+func TestParseConstant(t *testing.T) {
+	testHelper(t, []testStruct{
+		{"const a = 1", parser.Program{Scopes: []*parser.Scope{{
+			Constants: []*parser.Constant{
+				{Visibility: visibility(parser.PRIVATE), Identifer: ident("a"), Expression: constant(num("1"))},
+			},
+		}}}},
+		{"pub const a: u8 = 1", parser.Program{Scopes: []*parser.Scope{{
+			Constants: []*parser.Constant{
+				{Visibility: visibility(parser.PUBLIC), Identifer: ident("a"), Type: typ("u8"), Expression: constant(num("1"))},
+			},
+		}}}},
+		{"ext const a = b", parser.Program{Scopes: []*parser.Scope{{
+			Constants: []*parser.Constant{
+				{
+					Visibility: visibility(parser.EXTERNAL),
+					Identifer:  ident("a"),
+					Expression: constant(&parser.IdentifierExpression{Identifer: ident("b")}),
+				},
+			},
+		}}}},
+	})
+}
+
+func TestParseFunction(t *testing.T) {
+	testHelper(t, []testStruct{
+		{"fn a() {}", parser.Program{Scopes: []*parser.Scope{{
+			Functions: []*parser.Function{
+				{Visibility: visibility(parser.PRIVATE), Identifer: ident("a"), Body: &parser.Scope{}},
+			},
+		}}}},
+		{"ext fn a(b: i32, c: f64) -> bool", parser.Program{Scopes: []*parser.Scope{{
+			Functions: []*parser.Function{
+				{
+					Visibility: visibility(parser.EXTERNAL),
+					Identifer:  ident("a"),
+					Parameters: []*parser.FunctionParameter{
+						{Identifer: ident("b"), Type: typ("i32")},
+						{Identifer: ident("c"), Type: typ("f64")},
+					},
+					ReturnType: typ("bool"),
+				},
+			},
+		}}}},
+		{"fn a() {\n\tconst b = 1\n\tfn c() {}\n}", parser.Program{Scopes: []*parser.Scope{{
+			Functions: []*parser.Function{
+				{
+					Visibility: visibility(parser.PRIVATE),
+					Identifer:  ident("a"),
+					Body: &parser.Scope{
+						Constants: []*parser.Constant{
+							{Visibility: visibility(parser.PRIVATE), Identifer: ident("b"), Expression: constant(num("1"))},
+						},
+						Functions: []*parser.Function{
+							{Visibility: visibility(parser.PRIVATE), Identifer: ident("c"), Body: &parser.Scope{}},
+						},
+					},
+				},
+			},
+		}}}},
+	})
+}
+
+func TestParseErrors(t *testing.T) {
+	errorHelper(t, map[string]string{
+		"const = 1":    "Expected an identifier but found Binding",
+		"fn a(b) {}":   "Expected : but found Closed parenthesis",
+		"fn a() -> {}": "Expected a type but found Opened brace",
+		"pub fn a()":   "Expected { but found EOF",
+		"1":            "Expected a declaration but found Normal num literal",
+	})
+}
+
+type expressionTestStruct struct {
+	input string
+	want  parser.Expression
+}
+
+func expressionTestHelper(t *testing.T, tests []expressionTestStruct) {
+	var wrapped []testStruct
+	for _, test := range tests {
+		wrapped = append(wrapped, testStruct{"const a = " + test.input, parser.Program{Scopes: []*parser.Scope{{
+			Constants: []*parser.Constant{
+				{Visibility: visibility(parser.PRIVATE), Identifer: ident("a"), Expression: constant(test.want)},
+			},
+		}}}})
+	}
+
+	testHelper(t, wrapped)
+}
+
+func binary(operator lexer.TokenType, left parser.Expression, right parser.Expression) *parser.Binary {
+	return &parser.Binary{Operator: operator, Left: left, Right: right}
+}
+
+func identExpr(name string) *parser.IdentifierExpression {
+	return &parser.IdentifierExpression{Identifer: ident(name)}
+}
+
+func TestParseExpression(t *testing.T) {
+	a, b, c := identExpr("a"), identExpr("b"), identExpr("c")
+
+	expressionTestHelper(t, []expressionTestStruct{
+		// Precedence
+		{"3.14 * 2", binary(lexer.STAR_SIGN, num("3.14"), num("2"))},
+		{"a + b * c", binary(lexer.PLUS_SIGN, a, binary(lexer.STAR_SIGN, b, c))},
+		{"a * b + c", binary(lexer.PLUS_SIGN, binary(lexer.STAR_SIGN, a, b), c)},
+		{"a shl b + c", binary(lexer.KEYWORD_SHL, a, binary(lexer.PLUS_SIGN, b, c))},
+		{"a <= b and c", binary(lexer.KEYWORD_AND, binary(lexer.LESS_THAN_OR_EQUALS, a, b), c)},
+		{"a or b and c", binary(lexer.KEYWORD_OR, a, binary(lexer.KEYWORD_AND, b, c))},
+		{"a ?? b == c", binary(lexer.IF_NIL, a, binary(lexer.EQUALS, b, c))},
+
+		// Associativity
+		{"a - b - c", binary(lexer.MINUS_SIGN, binary(lexer.MINUS_SIGN, a, b), c)},
+		{"a ^ b ^ c", binary(lexer.CIRCUMFLEX, a, binary(lexer.CIRCUMFLEX, b, c))},
+		{"a ?? b ?? c", binary(lexer.IF_NIL, a, binary(lexer.IF_NIL, b, c))},
+
+		// Unary
+		{"-a ^ b", &parser.Unary{Operator: lexer.MINUS_SIGN, Operand: binary(lexer.CIRCUMFLEX, a, b)}},
+		{"not a and b", binary(lexer.KEYWORD_AND, &parser.Unary{Operator: lexer.KEYWORD_NOT, Operand: a}, b)},
+
+		// Grouping, call and index
+		{"(a + b) * c", binary(lexer.STAR_SIGN, &parser.Grouping{Expression: binary(lexer.PLUS_SIGN, a, b)}, c)},
+		{"a(b, c)[0]", &parser.Index{Target: &parser.Call{Callee: a, Arguments: []parser.Expression{b, c}}, Index: num("0")}},
+		{"a | b(c)", &parser.Call{Callee: b, Arguments: []parser.Expression{a, c}}},
+		{"a | b", &parser.Call{Callee: b, Arguments: []parser.Expression{a}}},
+
+		// Numbers
+		{"255u8", &parser.Literal{Kind: lexer.NORMAL_NUM_LITERAL, Value: "255u8", Suffix: "u8", Int: big.NewInt(255)}},
+		{"0x1_FF", &parser.Literal{Kind: lexer.HEX_NUM_LITERAL, Value: "0x1_FF", Int: big.NewInt(511)}},
+		{"1.5f32", &parser.Literal{Kind: lexer.NORMAL_NUM_LITERAL, Value: "1.5f32", Suffix: "f32", Float: big.NewFloat(1.5)}},
+		{"2f64", &parser.Literal{Kind: lexer.NORMAL_NUM_LITERAL, Value: "2f64", Suffix: "f64", Float: big.NewFloat(2)}},
+
+		// Strings
+		{"\"a\\tb\\u{21}\"", str("a\tb!")},
+		{"r\"a\\tb\"", str("a\\tb")},
+		{"b\"a\\x00{b}\"", &parser.Literal{Kind: lexer.BYTE_STRING_LITERAL, Value: "a\x00{b}"}},
+		{"'ok", &parser.Literal{Kind: lexer.SYM_LITERAL, Value: "ok"}},
+		{"a == 'ok", binary(lexer.EQUALS, a, &parser.Literal{Kind: lexer.SYM_LITERAL, Value: "ok"})},
+		{"\"a{b}c\"", &parser.Interpolation{Parts: []*parser.Literal{str("a"), str("c")}, Expressions: []parser.Expression{b}}},
+		{"\"{a + b}{c}\"", &parser.Interpolation{
+			Parts:       []*parser.Literal{str(""), str(""), str("")},
+			Expressions: []parser.Expression{binary(lexer.PLUS_SIGN, a, b), c},
+		}},
+	})
+}
+
+func TestParseExpressionErrors(t *testing.T) {
+	errorHelper(t, map[string]string{
+		"const a = b < c < d": "Less than cannot be chained with Less than",
+		"const a = (b":        "Expected ) but found EOF",
+		"const a = b +":       "Expected an expression but found EOF",
+		"const a = b | 1":     "Expected a function or a call on the right side of a pipe",
+		"const a = \"{b c}\"": "Expected } after the interpolated expression but found Identifier",
+		"const a = \"\\q\"":   "Invalid escape sequence \\q",
+		"const a = b\"é\"":    "Byte string literals can only contain ASCII characters, é has to be escaped",
+		"const a = '":         "Expected the name of a symbol after '",
+		"const a = 1__000":    "Digit separators _ have to be placed between digits",
+		"const a = 1u7":       "Unknown number literal suffix u7",
+		"const a = 1.5u8":     "Float literals cannot have the integer suffix u8",
+		"const a = 0b1f64":    "Only decimal number literals can have the suffix f64",
+	})
+}
+
+func TestRecovery(t *testing.T) {
+	tests := []struct {
+		input     string
+		errors    []string
+		constants int
+		functions int
+	}{
+		{
+			"const = 1\nconst b = 2 +\nfn c(\nconst d = 4",
+			[]string{
+				"Expected an identifier but found Binding",
+				"Expected an expression but found Keyword 'fn'",
+				"Expected an identifier but found Keyword 'const'",
+			},
+			1, 0,
+		},
+		{
+			"fn a() {\n\tconst = 1\n\tconst b = 2\n}\nfn c() {}",
+			[]string{"Expected an identifier but found Binding"},
+			0, 2,
+		},
+		{
+			"fn a(b) {\n\tconst c = (\n}\nconst d = 1",
+			[]string{"Expected : but found Closed parenthesis"},
+			1, 0,
+		},
+		{
+			"const a = §\nconst b = \"c",
+			[]string{"The specified characters are unknown", "String literal not closed"},
+			0, 0,
+		},
+		{
+			"a = 1 § 2\nconst b = 1",
+			[]string{"Expected a declaration but found Identifier", "The specified characters are unknown"},
+			1, 0,
+		},
+		{
+			"struct P {\n\tx i64\n}\nfn f() {}",
+			[]string{"Expected : but found Keyword 'i64'"},
+			0, 1,
+		},
+		{
+			"trait T {\n\tfn a(self) -> {\n\t\t1\n\t}\n}\nconst b = 1",
+			[]string{"Expected a type but found Opened brace"},
+			1, 0,
+		},
+		{
+			"impl P {\n\tconst a = 1\n\tfn b() {}\n}\nfn c() {}",
+			[]string{"Expected a method but found Keyword 'const'"},
+			0, 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			program, errors := parser.Run(lexer.Run(util.NewFileSet().AddFile("", test.input)))
+
+			var msgs []string
+			for _, err := range errors {
+				msgs = append(msgs, err.Msg)
+			}
+
+			if !reflect.DeepEqual(msgs, test.errors) {
+				t.Errorf("\n%s\n%q\n%s\n%q", "---- EXPECTED ----", test.errors, "---- ACTUAL ----", msgs)
+			}
+
+			scope := program.Scopes[0]
+			if len(scope.Constants) != test.constants || len(scope.Functions) != test.functions {
+				t.Errorf("expected %d constants and %d functions but got %d and %d",
+					test.constants, test.functions, len(scope.Constants), len(scope.Functions))
+			}
+		})
+	}
+}
+
+func path(names ...string) *parser.Path {
+	var identifiers []*parser.Identifer
+	for _, name := range names {
+		identifiers = append(identifiers, ident(name))
+	}
+
+	return &parser.Path{Identifers: identifiers}
+}
+
+func TestParseNamespaceAndImport(t *testing.T) {
+	testHelper(t, []testStruct{
+		{"namespace a::b\nconst c = d::e", parser.Program{Scopes: []*parser.Scope{
+			{},
+			{
+				Namespace: &parser.Namespace{Path: path("a", "b")},
+				Constants: []*parser.Constant{
+					{
+						Visibility: visibility(parser.PRIVATE),
+						Identifer:  ident("c"),
+						Expression: constant(&parser.PathExpression{Namespace: path("d"), Identifer: ident("e")}),
+					},
+				},
+			},
+		}}},
+		{"import a::b\nimport a::c as d\nimport e as f from g::h", parser.Program{Scopes: []*parser.Scope{{
+			Imports: []*parser.Import{
+				{Path: path("a", "b")},
+				{Path: path("a", "c"), Alias: ident("d")},
+				{Path: path("e"), Alias: ident("f"), From: path("g", "h")},
+			},
+		}}}},
+		{"fn a(b: c::d) {}", parser.Program{Scopes: []*parser.Scope{{
+			Functions: []*parser.Function{
+				{
+					Visibility: visibility(parser.PRIVATE),
+					Identifer:  ident("a"),
+					Parameters: []*parser.FunctionParameter{
+						{Identifer: ident("b"), Type: &parser.Type{Namespace: path("c"), Identifer: ident("d")}},
+					},
+					Body: &parser.Scope{},
+				},
+			},
+		}}}},
+	})
+
+	errorHelper(t, map[string]string{
+		"namespace a::":   "Expected an identifier but found EOF",
+		"import a as":     "Expected an identifier but found EOF",
+		"import a from 1": "Expected an identifier but found Normal num literal",
+	})
+}
+
+func TestParseStructTraitImpl(t *testing.T) {
+	self := &parser.FunctionParameter{Identifer: ident("self")}
+	area := &parser.Function{
+		Visibility: visibility(parser.PUBLIC),
+		Identifer:  ident("area"),
+		Parameters: []*parser.FunctionParameter{self},
+		ReturnType: typ("f64"),
+	}
+
+	testHelper(t, []testStruct{
+		{"pub struct Point {\n\tpub x: f64,\n\ty: f64\n}", parser.Program{Scopes: []*parser.Scope{{
+			Structs: []*parser.Struct{
+				{
+					Visibility: visibility(parser.PUBLIC),
+					Identifer:  ident("Point"),
+					Fields: []*parser.StructField{
+						{Visibility: visibility(parser.PUBLIC), Identifer: ident("x"), Type: typ("f64")},
+						{Visibility: visibility(parser.PRIVATE), Identifer: ident("y"), Type: typ("f64")},
+					},
+				},
+			},
+		}}}},
+		{"trait Shape {\n\tpub fn area(self) -> f64\n}", parser.Program{Scopes: []*parser.Scope{{
+			Traits: []*parser.Trait{
+				{Visibility: visibility(parser.PRIVATE), Identifer: ident("Shape"), Methods: []*parser.Function{area}},
+			},
+		}}}},
+		{"impl Shape for Circle {\n\tpub fn area(self) -> f64 {}\n}", parser.Program{Scopes: []*parser.Scope{{
+			Impls: []*parser.Impl{
+				{
+					Trait: typ("Shape"),
+					Type:  typ("Circle"),
+					Methods: []*parser.Function{{
+						Visibility: visibility(parser.PUBLIC),
+						Identifer:  ident("area"),
+						Parameters: []*parser.FunctionParameter{self},
+						ReturnType: typ("f64"),
+						Body:       &parser.Scope{},
+					}},
+				},
+			},
+		}}}},
+		{"impl Circle {\n\tfn scale(self, by: f64) {}\n}", parser.Program{Scopes: []*parser.Scope{{
+			Impls: []*parser.Impl{
+				{
+					Type: typ("Circle"),
+					Methods: []*parser.Function{{
+						Visibility: visibility(parser.PRIVATE),
+						Identifer:  ident("scale"),
+						Parameters: []*parser.FunctionParameter{self, {Identifer: ident("by"), Type: typ("f64")}},
+						Body:       &parser.Scope{},
+					}},
+				},
+			},
+		}}}},
+	})
+
+	expressionTestHelper(t, []expressionTestStruct{
+		{"Point { x: 1, y }", &parser.StructLiteral{
+			Type: typ("Point"),
+			Fields: []*parser.StructLiteralField{
+				{Identifer: ident("x"), Expression: num("1")},
+				{Identifer: ident("y"), Expression: identExpr("y")},
+			},
+		}},
+		{"a.b.c(d)", &parser.Call{
+			Callee:    &parser.FieldAccess{Target: &parser.FieldAccess{Target: identExpr("a"), Identifer: ident("b")}, Identifer: ident("c")},
+			Arguments: []parser.Expression{identExpr("d")},
+		}},
+	})
+
+	errorHelper(t, map[string]string{
+		"fn a(self) {}":               "self is only allowed as the first parameter of a method",
+		"impl A { fn b(c: d, self) }": "self is only allowed as the first parameter of a method",
+		"impl A { fn b(self) }":       "Expected { but found Closed brace",
+		"pub impl A {}":               "Impl blocks cannot have a visibility, declare it on their methods instead",
+		"struct A { b }":              "Expected : but found Closed brace",
+		"trait A { const b = 1 }":     "Expected a method but found Keyword 'const'",
+	})
+}
+
+func TestParseDocComments(t *testing.T) {
+	testHelper(t, []testStruct{
+		{"/// The answer\n/// to everything\npub const a = 42", parser.Program{Scopes: []*parser.Scope{{
+			Constants: []*parser.Constant{
+				{Visibility: visibility(parser.PUBLIC), Identifer: ident("a"), Expression: constant(num("42")), Doc: "The answer\nto everything"},
+			},
+		}}}},
+		{"/**\n * Does nothing\n */\nfn a() {}\n// Not a doc comment\nfn b() {}", parser.Program{Scopes: []*parser.Scope{{
+			Functions: []*parser.Function{
+				{Visibility: visibility(parser.PRIVATE), Identifer: ident("a"), Body: &parser.Scope{}, Doc: "Does nothing"},
+				{Visibility: visibility(parser.PRIVATE), Identifer: ident("b"), Body: &parser.Scope{}},
+			},
+		}}}},
+		{"/// A point\nstruct P { x: i32 }\n/// A shape\ntrait S {\n\t/// The area\n\tfn area(self) -> i32\n}", parser.Program{Scopes: []*parser.Scope{{
+			Structs: []*parser.Struct{
+				{
+					Visibility: visibility(parser.PRIVATE),
+					Identifer:  ident("P"),
+					Fields:     []*parser.StructField{{Visibility: visibility(parser.PRIVATE), Identifer: ident("x"), Type: typ("i32")}},
+					Doc:        "A point",
+				},
+			},
+			Traits: []*parser.Trait{
+				{
+					Visibility: visibility(parser.PRIVATE),
+					Identifer:  ident("S"),
+					Methods: []*parser.Function{{
+						Visibility: visibility(parser.PRIVATE),
+						Identifer:  ident("area"),
+						Parameters: []*parser.FunctionParameter{{Identifer: ident("self")}},
+						ReturnType: typ("i32"),
+						Doc:        "The area",
+					}},
+					Doc: "A shape",
+				},
+			},
+		}}}},
+		{"/* a /* nested */ comment */\nconst a = 1", parser.Program{Scopes: []*parser.Scope{{
+			Constants: []*parser.Constant{
+				{Visibility: visibility(parser.PRIVATE), Identifer: ident("a"), Expression: constant(num("1"))},
+			},
+		}}}},
+	})
+}
+
+func body(statements ...parser.Statement) *parser.Scope {
+	return &parser.Scope{Statements: statements}
+}
+
+func stmt(expression parser.Expression) *parser.ExpressionStatement {
+	return &parser.ExpressionStatement{Expression: expression}
+}
+
+func TestParseStatements(t *testing.T) {
+	function := func(statements ...parser.Statement) parser.Program {
+		return parser.Program{Scopes: []*parser.Scope{{
+			Functions: []*parser.Function{
+				{Visibility: visibility(parser.PRIVATE), Identifer: ident("f"), Body: body(statements...)},
+			},
+		}}}
+	}
+
+	testHelper(t, []testStruct{
+		{"fn f() {\n\ta(b)\n\treturn c\n}", function(
+			stmt(&parser.Call{Callee: identExpr("a"), Arguments: []parser.Expression{identExpr("b")}}),
+			&parser.Return{Expression: identExpr("c")},
+		)},
+		{"fn f() { return }", function(&parser.Return{})},
+		{"fn f() {\n\ta\n\t- b\n}", function(
+			stmt(identExpr("a")),
+			stmt(&parser.Unary{Operator: lexer.MINUS_SIGN, Operand: identExpr("b")}),
+		)},
+	})
+
+	testHelper(t, []testStruct{
+		{"fn f() {\n\tlet a = 1\n\tlet! b: u8 = 2\n\tb = a\n\tc.d[0] = b\n}", function(
+			&parser.Binding{Visibility: visibility(parser.PRIVATE), Identifer: ident("a"), Expression: num("1")},
+			&parser.Binding{Visibility: visibility(parser.PRIVATE), Mutable: true, Identifer: ident("b"), Type: typ("u8"), Expression: num("2")},
+			&parser.Assignment{Target: identExpr("b"), Expression: identExpr("a")},
+			&parser.Assignment{
+				Target:     &parser.Index{Target: &parser.FieldAccess{Target: identExpr("c"), Identifer: ident("d")}, Index: num("0")},
+				Expression: identExpr("b"),
+			},
+		)},
+		{"fn f() {\n\ta += 1\n\tb.c -= a\n\ta *= 2 + 3\n\ta /= b\n}", function(
+			&parser.Assignment{Target: identExpr("a"), Expression: &parser.Binary{Operator: lexer.PLUS_SIGN, Left: identExpr("a"), Right: num("1")}},
+			&parser.Assignment{
+				Target:     &parser.FieldAccess{Target: identExpr("b"), Identifer: ident("c")},
+				Expression: &parser.Binary{Operator: lexer.MINUS_SIGN, Left: &parser.FieldAccess{Target: identExpr("b"), Identifer: ident("c")}, Right: identExpr("a")},
+			},
+			&parser.Assignment{Target: identExpr("a"), Expression: &parser.Binary{
+				Operator: lexer.STAR_SIGN,
+				Left:     identExpr("a"),
+				Right:    &parser.Binary{Operator: lexer.PLUS_SIGN, Left: num("2"), Right: num("3")},
+			}},
+			&parser.Assignment{Target: identExpr("a"), Expression: &parser.Binary{Operator: lexer.SLASH_SIGN, Left: identExpr("a"), Right: identExpr("b")}},
+		)},
+		{"pub let a = 1\nlet! b = 2", parser.Program{Scopes: []*parser.Scope{{
+			Statements: []parser.Statement{
+				&parser.Binding{Visibility: visibility(parser.PUBLIC), Identifer: ident("a"), Expression: num("1")},
+				&parser.Binding{Visibility: visibility(parser.PRIVATE), Mutable: true, Identifer: ident("b"), Expression: num("2")},
+			},
+		}}}},
+	})
+
+	errorHelper(t, map[string]string{
+		"fn f() { a b }":     "Expected a new line after the statement but found Identifier",
+		"fn f() { a() = 1 }": "Only variables, fields and indices can be assigned to",
+		"fn f() { 1 += 1 }":  "Only variables, fields and indices can be assigned to",
+		"fn f() { let = 1 }": "Expected an identifier but found Binding",
+	})
+}
+
+func TestParseControlFlow(t *testing.T) {
+	a, b, c := identExpr("a"), identExpr("b"), identExpr("c")
+
+	expressionTestHelper(t, []expressionTestStruct{
+		{"if a { b }", &parser.If{Condition: a, Then: body(stmt(b))}},
+		{"if a { b } else { c }", &parser.If{Condition: a, Then: body(stmt(b)), Else: body(stmt(c))}},
+		{"if a { b } else if b { c }", &parser.If{
+			Condition: a,
+			Then:      body(stmt(b)),
+			Else:      body(stmt(&parser.If{Condition: b, Then: body(stmt(c))})),
+		}},
+		{"if a == B { b }", &parser.If{
+			Condition: binary(lexer.EQUALS, a, identExpr("B")),
+			Then:      body(stmt(b)),
+		}},
+		{"cond {\n\ta -> b\n\tb -> { c }\n\telse -> a\n}", &parser.Cond{
+			Branches: []*parser.CondBranch{
+				{Condition: a, Body: body(stmt(b))},
+				{Condition: b, Body: body(stmt(c))},
+			},
+			Else: body(stmt(a)),
+		}},
+		{"case a {\n\t1 -> b\n\t-2 -> b\n\tnil -> b\n\t'ok -> b\n\tP { x: _y, z } -> b\n\t_ -> c\n\td -> c\n}", &parser.Case{
+			Subject: a,
+			Branches: []*parser.CaseBranch{
+				{Pattern: &parser.LiteralPattern{Literal: num("1")}, Body: body(stmt(b))},
+				{Pattern: &parser.LiteralPattern{Literal: num("2"), Negative: true}, Body: body(stmt(b))},
+				{Pattern: &parser.NilPattern{}, Body: body(stmt(b))},
+				{Pattern: &parser.LiteralPattern{Literal: &parser.Literal{Kind: lexer.SYM_LITERAL, Value: "ok"}}, Body: body(stmt(b))},
+				{Pattern: &parser.StructPattern{
+					Type: typ("P"),
+					Fields: []*parser.StructPatternField{
+						{Identifer: ident("x"), Pattern: &parser.MutedPattern{Identifer: ident("_y")}},
+						{Identifer: ident("z"), Pattern: &parser.BindingPattern{Identifer: ident("z")}},
+					},
+				}, Body: body(stmt(b))},
+				{Pattern: &parser.MutedPattern{Identifer: ident("_")}, Body: body(stmt(c))},
+				{Pattern: &parser.BindingPattern{Identifer: ident("d")}, Body: body(stmt(c))},
+			},
+		}},
+	})
+
+	errorHelper(t, map[string]string{
+		"const a = cond { else -> 1, b -> 2 }":    "The else branch has to be the last branch of a cond",
+		"const a = cond { else -> 1, else -> 2 }": "A cond can only have one else branch",
+		"const a = case b { c d }":                "Expected -> but found Identifier",
+		"const a = case b { 1 -> 2 3 -> 4 }":      "Expected a new line after the branch but found Normal num literal",
+		"const a = case b { + -> 1 }":             "Expected a pattern but found Plus sign",
+	})
+}
+
+func TestRunInteractive(t *testing.T) {
+	program, errors := parser.RunInteractive(lexer.Run(util.NewFileSet().AddFile("", "let a = 1\nfn f() {}\na + 2\na = 3")))
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %s", errors)
+	}
+
+	want := parser.Program{Scopes: []*parser.Scope{{
+		Functions: []*parser.Function{
+			{Visibility: visibility(parser.PRIVATE), Identifer: ident("f"), Body: body()},
+		},
+		Statements: []parser.Statement{
+			&parser.Binding{Visibility: visibility(parser.PRIVATE), Identifer: ident("a"), Expression: num("1")},
+			stmt(binary(lexer.PLUS_SIGN, identExpr("a"), num("2"))),
+			&parser.Assignment{Target: identExpr("a"), Expression: num("3")},
+		},
+	}}}
+
+	if program.String() != want.String() {
+		t.Errorf("\n%s\n%s\n%s\n%s", "---- EXPECTED ----", want, "---- ACTUAL ----", program)
+	}
+
+	if _, errors := parser.RunInteractive(lexer.Run(util.NewFileSet().AddFile("", "a b\n}\nc"))); len(errors) != 2 {
+		t.Errorf("expected two errors but got %s", errors)
+	}
+}
+
+func TestEOFPosition(t *testing.T) {
+	// The name of the constant is normalised to a shorter literal, the end of
+	// file still lies behind its source text
+	input := "const e\u0301"
+
+	_, errors := parser.Run(lexer.Run(util.NewFileSet().AddFile("", input)))
+	if len(errors) == 0 {
+		t.Fatalf("expected an error at the end of the file")
+	}
+
+	pos := errors[len(errors)-1].Token.Pos
+	if pos.Idx != 8 || pos.Offset != len(input) || pos.Col != 8 || pos.Col16 != 8 {
+		t.Errorf("expected the end of file at rune 8 and byte %d but got %+v", len(input), pos)
+	}
+}
@@ -0,0 +1,100 @@
+package parser
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+func (visibility Visibility) String() string {
+	switch visibility {
+	case PUBLIC:
+		return "pub"
+	case EXTERNAL:
+		return "ext"
+	default:
+		return "private"
+	}
+}
+
+func (identifier Identifer) String() string {
+	return identifier.Name
+}
+
+func (path Path) String() string {
+	var names []string
+	for _, identifier := range path.Identifers {
+		names = append(names, identifier.Name)
+	}
+
+	return strings.Join(names, "::")
+}
+
+func (program Program) String() string {
+	var sb strings.Builder
+	writeStruct(&sb, reflect.ValueOf(program), 0)
+	return sb.String()
+}
+
+func writeNode(sb *strings.Builder, value reflect.Value, depth int) {
+	for value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			sb.WriteString("nil")
+			return
+		}
+
+		if stringer, ok := value.Interface().(fmt.Stringer); ok {
+			sb.WriteString(stringer.String())
+			return
+		}
+
+		value = value.Elem()
+	}
+
+	if stringer, ok := value.Interface().(fmt.Stringer); ok {
+		sb.WriteString(stringer.String())
+		return
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		writeStruct(sb, value, depth)
+	case reflect.Slice:
+		for idx := range value.Len() {
+			sb.WriteString("\n")
+			sb.WriteString(strings.Repeat("  ", depth+1))
+			sb.WriteString("- ")
+			writeNode(sb, value.Index(idx), depth+1)
+		}
+	case reflect.String:
+		fmt.Fprintf(sb, "%q", value.String())
+	default:
+		fmt.Fprintf(sb, "%v", value.Interface())
+	}
+}
+
+func writeStruct(sb *strings.Builder, value reflect.Value, depth int) {
+	sb.WriteString(value.Type().Name())
+
+	for idx := range value.NumField() {
+		field := value.Type().Field(idx)
+		fieldValue := value.Field(idx)
+
+		if field.Name == "Pos" || !field.IsExported() || fieldValue.IsZero() {
+			continue
+		}
+
+		if fieldValue.Kind() == reflect.Slice && fieldValue.Len() == 0 {
+			continue
+		}
+
+		sb.WriteString("\n")
+		sb.WriteString(strings.Repeat("  ", depth+1))
+		sb.WriteString(field.Name)
+		sb.WriteString(":")
+		if fieldValue.Kind() != reflect.Slice {
+			sb.WriteString(" ")
+		}
+		writeNode(sb, fieldValue, depth+1)
+	}
+}
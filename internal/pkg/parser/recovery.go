@@ -0,0 +1,99 @@
+package parser
+
+import (
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/lexer"
+)
+
+var synchronizationPoints = map[lexer.TokenType]bool{
+	lexer.KEYWORD_PUB:             true,
+	lexer.KEYWORD_EXT:             true,
+	lexer.KEYWORD_CONST:           true,
+	lexer.KEYWORD_LET:             true,
+	lexer.KEYWORD_LET_EXCLAMATION: true,
+	lexer.KEYWORD_TYPE:            true,
+	lexer.KEYWORD_FN:              true,
+	lexer.KEYWORD_STRUCT:          true,
+	lexer.KEYWORD_TRAIT:           true,
+	lexer.KEYWORD_IMPL:            true,
+	lexer.KEYWORD_NAMESPACE:       true,
+	lexer.KEYWORD_IMPORT:          true,
+}
+
+// synchronize skips the rest of a declaration after a syntax error. It stops
+// in front of the next keyword that starts a declaration or in front of the
+// closing brace of the surrounding scope. Braces opened while skipping are
+// skipped as a whole, so a broken function does not end its parent scope.
+//
+// Errors the lexer reported on skipped tokens are still collected, every
+// other token is dropped silently to avoid follow-up errors.
+func (p *parser) synchronize() {
+	depth := 0
+
+	for {
+		token := p.peekIgnoreSpace()
+
+		switch token.Type {
+		case lexer.EOF:
+			p.commit()
+			return
+		case lexer.OPENED_BRACE:
+			depth++
+		case lexer.CLOSED_BRACE:
+			if depth == 0 {
+				p.commit()
+				return
+			}
+			depth--
+		default:
+			if depth == 0 && synchronizationPoints[token.Type] {
+				p.commit()
+				return
+			}
+		}
+
+		p.advanceIgnoreSpace()
+
+		if token.HasError && !p.reported(token) {
+			p.commitErr(*token, token.ErrorMsg)
+		}
+	}
+}
+
+// skipBody skips the rest of a struct, trait or impl body after a syntax error
+// in one of its members, including the closing brace of the body. synchronize
+// alone would stop in front of that brace as if it closed the surrounding
+// scope.
+func (p *parser) skipBody() {
+	depth := 0
+
+	for {
+		token := p.peekIgnoreSpace()
+		if token.Type == lexer.EOF {
+			return
+		}
+
+		p.advanceIgnoreSpace()
+
+		if token.HasError && !p.reported(token) {
+			p.commitErr(*token, token.ErrorMsg)
+		}
+
+		switch token.Type {
+		case lexer.OPENED_BRACE:
+			depth++
+		case lexer.CLOSED_BRACE:
+			if depth == 0 {
+				return
+			}
+			depth--
+		}
+	}
+}
+
+func (p *parser) reported(token *lexer.Token) bool {
+	if len(p.errors) == 0 {
+		return false
+	}
+
+	return p.errors[len(p.errors)-1].Token.Pos == token.Pos
+}
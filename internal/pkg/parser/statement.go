@@ -0,0 +1,144 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/lexer"
+)
+
+var declarationStarts = map[lexer.TokenType]bool{
+	lexer.KEYWORD_PUB:    true,
+	lexer.KEYWORD_EXT:    true,
+	lexer.KEYWORD_CONST:  true,
+	lexer.KEYWORD_FN:     true,
+	lexer.KEYWORD_STRUCT: true,
+	lexer.KEYWORD_TRAIT:  true,
+	lexer.KEYWORD_IMPL:   true,
+}
+
+func (p *parser) parseScope() *Scope {
+	if p.expect(lexer.OPENED_BRACE, "{") == nil {
+		return nil
+	}
+
+	// Braces reset the restriction on struct literals of the enclosing
+	// condition
+	noStructLiteral := p.noStructLiteral
+	p.noStructLiteral = false
+	defer func() { p.noStructLiteral = noStructLiteral }()
+
+	scope := &Scope{}
+
+	for {
+		token := p.peekIgnoreSpace()
+		if token.Type == lexer.CLOSED_BRACE || token.Type == lexer.EOF {
+			break
+		}
+
+		if declarationStarts[token.Type] {
+			p.parseDeclaration(scope)
+			continue
+		}
+
+		if statement := p.parseStatement(); statement != nil {
+			scope.Statements = append(scope.Statements, statement)
+		} else {
+			p.synchronize()
+		}
+	}
+
+	if p.expect(lexer.CLOSED_BRACE, "}") == nil {
+		return nil
+	}
+
+	return scope
+}
+
+// A statement ends at the end of its line or at the closing brace of its
+// scope.
+func (p *parser) parseStatement() Statement {
+	var statement Statement
+
+	switch token := p.peekIgnoreSpace(); token.Type {
+	case lexer.KEYWORD_RETURN:
+		p.advanceIgnoreSpace()
+
+		ret := &Return{Pos: token.Pos}
+
+		if next := p.peekSameLine(); next.Type != lexer.EOF && next.Type != lexer.CLOSED_BRACE {
+			if ret.Expression = p.parseExpression(); ret.Expression == nil {
+				return nil
+			}
+		}
+
+		statement = ret
+	case lexer.KEYWORD_LET, lexer.KEYWORD_LET_EXCLAMATION:
+		visibility := PRIVATE
+
+		binding := p.parseBinding(&visibility)
+		if binding == nil {
+			return nil
+		}
+
+		statement = binding
+	default:
+		expression := p.parseExpression()
+		if expression == nil {
+			return nil
+		}
+
+		statement = &ExpressionStatement{Expression: expression}
+
+		if next := p.peekSameLine(); next.Type == lexer.BINDING || compoundAssignments[next.Type] != "" {
+			if statement = p.parseAssignment(expression); statement == nil {
+				return nil
+			}
+		}
+	}
+
+	if next := p.peekSameLine(); next.Type != lexer.EOF && next.Type != lexer.CLOSED_BRACE {
+		p.commitErr(*next, fmt.Sprintf("Expected a new line after the statement but found %s", next.Type))
+		return nil
+	}
+
+	p.commit()
+
+	return statement
+}
+
+// compoundAssignments are the operators of the compound assignments, `a += b`
+// is parsed as `a = a + b`.
+var compoundAssignments = map[lexer.TokenType]lexer.TokenType{
+	lexer.PLUS_ASSIGNMENT:  lexer.PLUS_SIGN,
+	lexer.MINUS_ASSIGNMENT: lexer.MINUS_SIGN,
+	lexer.STAR_ASSIGNMENT:  lexer.STAR_SIGN,
+	lexer.SLASH_ASSIGNMENT: lexer.SLASH_SIGN,
+}
+
+func (p *parser) parseAssignment(target Expression) Statement {
+	token := p.advanceIgnoreSpace() // skip '=' or the compound assignment
+
+	switch target.(type) {
+	case *IdentifierExpression, *FieldAccess, *Index:
+	default:
+		p.commitErr(*token, "Only variables, fields and indices can be assigned to")
+		return nil
+	}
+
+	expression := p.parseExpression()
+	if expression == nil {
+		return nil
+	}
+
+	if operator, ok := compoundAssignments[token.Type]; ok {
+		expression = &Binary{Operator: operator, Left: target, Right: expression, Pos: token.Pos}
+	}
+
+	return &Assignment{Target: target, Expression: expression, Pos: token.Pos}
+}
+
+// expressionScope wraps a single expression into a scope, so that branch
+// bodies are scopes no matter if they were written with braces or not.
+func expressionScope(expression Expression) *Scope {
+	return &Scope{Statements: []Statement{&ExpressionStatement{Expression: expression}}}
+}
@@ -0,0 +1,41 @@
+package parser
+
+import (
+	"reflect"
+)
+
+// Inspect traverses the syntax tree below node in depth-first order and calls
+// fn for node itself and every node reachable from it. Nodes are the pointers
+// to AST structs. If fn returns false, the children of that node are skipped.
+func Inspect(node any, fn func(node any) bool) {
+	inspect(reflect.ValueOf(node), fn)
+}
+
+func inspect(value reflect.Value, fn func(node any) bool) {
+	switch value.Kind() {
+	case reflect.Interface:
+		if !value.IsNil() {
+			inspect(value.Elem(), fn)
+		}
+	case reflect.Pointer:
+		if value.IsNil() || value.Elem().Kind() != reflect.Struct {
+			return
+		}
+
+		if !fn(value.Interface()) {
+			return
+		}
+
+		inspect(value.Elem(), fn)
+	case reflect.Struct:
+		for idx := range value.NumField() {
+			if value.Type().Field(idx).IsExported() {
+				inspect(value.Field(idx), fn)
+			}
+		}
+	case reflect.Slice:
+		for idx := range value.Len() {
+			inspect(value.Index(idx), fn)
+		}
+	}
+}
@@ -0,0 +1,428 @@
+package resolver
+
+import (
+	"strings"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/lexer"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/parser"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
+)
+
+type SymbolKind int
+
+const (
+	NAMESPACE SymbolKind = iota
+	CONSTANT
+	FUNCTION
+	STRUCT
+	TRAIT
+)
+
+type Symbol struct {
+	Kind       SymbolKind
+	Name       string
+	Namespace  string
+	Visibility parser.Visibility
+	Pos        util.Position
+	Node       any
+}
+
+// Resolution holds the result of resolving all paths of a set of programs.
+// Paths maps every *parser.PathExpression and every qualified *parser.Type to
+// the symbol it names, Imports maps the names a scope imports to their
+// symbols.
+type Resolution struct {
+	Paths   map[any]*Symbol
+	Imports map[*parser.Scope]map[string]*Symbol
+}
+
+type namespace struct {
+	symbol   *Symbol
+	children map[string]*namespace
+	symbols  map[string][]*Symbol
+	// skeletons holds the declared names of every skeleton to find names
+	// that look alike
+	skeletons map[string][]*parser.Identifer
+}
+
+type resolver struct {
+	root       *namespace
+	resolution *Resolution
+	errors     []diagnostics.Diagnostic
+}
+
+// Run resolves the paths of all given programs against each other. The
+// programs share one namespace tree: scopes without a namespace declaration
+// contribute to the root namespace and equally named namespaces of different
+// files are merged.
+func Run(programs []parser.Program) (*Resolution, []diagnostics.Diagnostic) {
+	r := resolver{
+		root: newNamespace(""),
+		resolution: &Resolution{
+			Paths:   map[any]*Symbol{},
+			Imports: map[*parser.Scope]map[string]*Symbol{},
+		},
+	}
+
+	for _, program := range programs {
+		for _, scope := range program.Scopes {
+			r.declare(scope)
+		}
+	}
+
+	for _, program := range programs {
+		for _, scope := range program.Scopes {
+			r.resolveImports(scope)
+		}
+	}
+
+	for _, program := range programs {
+		for _, scope := range program.Scopes {
+			r.resolvePaths(scope)
+		}
+	}
+
+	return r.resolution, r.errors
+}
+
+func newNamespace(name string) *namespace {
+	return &namespace{
+		symbol:    &Symbol{Kind: NAMESPACE, Name: name, Visibility: parser.PUBLIC},
+		children:  map[string]*namespace{},
+		symbols:   map[string][]*Symbol{},
+		skeletons: map[string][]*parser.Identifer{},
+	}
+}
+
+func join(namespace string, name string) string {
+	if namespace == "" {
+		return name
+	}
+
+	return namespace + "::" + name
+}
+
+func scopeNamespace(scope *parser.Scope) string {
+	if scope.Namespace == nil {
+		return ""
+	}
+
+	return scope.Namespace.Path.String()
+}
+
+func (r *resolver) errorf(pos util.Position, code string, format string, a ...any) *diagnostics.Diagnostic {
+	r.errors = append(r.errors, diagnostics.Errorf(pos, code, format, a...))
+	return &r.errors[len(r.errors)-1]
+}
+
+/* Declaration */
+
+func (r *resolver) namespaceOf(scope *parser.Scope) *namespace {
+	ns := r.root
+	if scope.Namespace == nil {
+		return ns
+	}
+
+	for _, identifier := range scope.Namespace.Path.Identifers {
+		child, ok := ns.children[identifier.Name]
+		if !ok {
+			child = newNamespace(join(ns.symbol.Name, identifier.Name))
+			child.symbol.Pos = identifier.Pos
+			ns.children[identifier.Name] = child
+		}
+
+		ns = child
+	}
+
+	return ns
+}
+
+func (r *resolver) declare(scope *parser.Scope) {
+	ns := r.namespaceOf(scope)
+
+	add := func(kind SymbolKind, identifier *parser.Identifer, visibility *parser.Visibility, node any) {
+		ns.symbols[identifier.Name] = append(ns.symbols[identifier.Name], &Symbol{
+			Kind:       kind,
+			Name:       join(ns.symbol.Name, identifier.Name),
+			Namespace:  ns.symbol.Name,
+			Visibility: *visibility,
+			Pos:        identifier.Pos,
+			Node:       node,
+		})
+		r.checkLookalikes(ns, identifier)
+	}
+
+	for _, constant := range scope.Constants {
+		add(CONSTANT, constant.Identifer, constant.Visibility, constant)
+	}
+
+	for _, function := range scope.Functions {
+		add(FUNCTION, function.Identifer, function.Visibility, function)
+	}
+
+	for _, structure := range scope.Structs {
+		add(STRUCT, structure.Identifer, structure.Visibility, structure)
+	}
+
+	for _, trait := range scope.Traits {
+		add(TRAIT, trait.Identifer, trait.Visibility, trait)
+	}
+}
+
+// checkLookalikes reports the names of a namespace that look like another
+// one. Only the spelling with confusable characters is reported, ASCII names
+// are their own skeleton, so it does not matter which one is declared first.
+// Local names are not compared.
+func (r *resolver) checkLookalikes(ns *namespace, identifier *parser.Identifer) {
+	skeleton := lexer.Skeleton(identifier.Name)
+
+	report := func(name *parser.Identifer, other *parser.Identifer) {
+		if name.Name != skeleton {
+			r.errorf(name.Pos, diagnostics.INVALID_IDENTIFIER, "%s can be confused with %s", name.Name, other.Name).
+				At(other.Pos, "declared here")
+		}
+	}
+
+	for _, other := range ns.skeletons[skeleton] {
+		if other.Name != identifier.Name {
+			report(identifier, other)
+			report(other, identifier)
+		}
+	}
+
+	ns.skeletons[skeleton] = append(ns.skeletons[skeleton], identifier)
+}
+
+/* Resolution */
+
+func (r *resolver) resolveImports(scope *parser.Scope) {
+	current := r.namespaceOf(scope)
+	imports := map[string]*Symbol{}
+	r.resolution.Imports[scope] = imports
+
+	for _, imp := range scope.Imports {
+		var identifiers []*parser.Identifer
+		if imp.From != nil {
+			identifiers = append(identifiers, imp.From.Identifers...)
+		}
+		identifiers = append(identifiers, imp.Path.Identifers...)
+
+		symbol := r.resolve(current, []*namespace{r.root.children[identifiers[0].Name]}, identifiers, true)
+		if symbol == nil {
+			continue
+		}
+
+		alias := imp.Path.Identifers[len(imp.Path.Identifers)-1]
+		if imp.Alias != nil {
+			alias = imp.Alias
+		}
+
+		if _, ok := imports[alias.Name]; ok {
+			r.errorf(alias.Pos, diagnostics.DUPLICATE_IMPORT, "%s is imported more than once", alias.Name)
+			continue
+		}
+
+		imports[alias.Name] = symbol
+	}
+}
+
+func (r *resolver) resolvePaths(scope *parser.Scope) {
+	current := r.namespaceOf(scope)
+
+	var declarations []any
+	for _, constant := range scope.Constants {
+		declarations = append(declarations, constant)
+	}
+	for _, function := range scope.Functions {
+		declarations = append(declarations, function)
+	}
+	for _, structure := range scope.Structs {
+		declarations = append(declarations, structure)
+	}
+	for _, trait := range scope.Traits {
+		declarations = append(declarations, trait)
+	}
+	for _, impl := range scope.Impls {
+		declarations = append(declarations, impl)
+	}
+	for _, statement := range scope.Statements {
+		declarations = append(declarations, statement)
+	}
+
+	resolve := func(node any, namespace *parser.Path, identifier *parser.Identifer) {
+		var identifiers []*parser.Identifer
+		identifiers = append(identifiers, namespace.Identifers...)
+		identifiers = append(identifiers, identifier)
+
+		if symbol := r.resolve(current, r.startCandidates(scope, current, identifiers[0]), identifiers, false); symbol != nil {
+			r.resolution.Paths[node] = symbol
+		}
+	}
+
+	for _, declaration := range declarations {
+		parser.Inspect(declaration, func(node any) bool {
+			switch node := node.(type) {
+			case *parser.PathExpression:
+				resolve(node, node.Namespace, node.Identifer)
+			case *parser.Type:
+				if node.Namespace != nil {
+					resolve(node, node.Namespace, node.Identifer)
+				}
+			}
+
+			return true
+		})
+	}
+}
+
+// startCandidates collects the namespaces the first segment of a relative
+// path can refer to: an imported namespace, a child of the current namespace
+// or a namespace below the root.
+func (r *resolver) startCandidates(scope *parser.Scope, current *namespace, first *parser.Identifer) []*namespace {
+	var candidates []*namespace
+
+	addCandidate := func(ns *namespace) {
+		for _, candidate := range candidates {
+			if candidate == ns {
+				return
+			}
+		}
+
+		candidates = append(candidates, ns)
+	}
+
+	if symbol, ok := r.resolution.Imports[scope][first.Name]; ok {
+		if symbol.Kind != NAMESPACE {
+			r.errorf(first.Pos, diagnostics.NOT_A_NAMESPACE, "%s is not a namespace", symbol.Name)
+			return nil
+		}
+
+		addCandidate(r.lookupNamespace(symbol.Name))
+	}
+
+	if child, ok := current.children[first.Name]; ok {
+		addCandidate(child)
+	}
+
+	if child, ok := r.root.children[first.Name]; ok {
+		addCandidate(child)
+	}
+
+	if len(candidates) == 0 {
+		return []*namespace{nil}
+	}
+
+	return candidates
+}
+
+func (r *resolver) lookupNamespace(name string) *namespace {
+	ns := r.root
+	for _, segment := range strings.Split(name, "::") {
+		ns = ns.children[segment]
+	}
+
+	return ns
+}
+
+// resolve walks the namespaces of a path starting at the given candidates for
+// its first segment. The last segment has to name a declaration, for imports
+// it may also name a namespace.
+func (r *resolver) resolve(
+	current *namespace,
+	candidates []*namespace,
+	identifiers []*parser.Identifer,
+	allowNamespace bool,
+) *Symbol {
+	first := identifiers[0]
+	path := identifiers[0].Name
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	if len(candidates) > 1 {
+		var names []string
+		for _, candidate := range candidates {
+			names = append(names, candidate.symbol.Name)
+		}
+
+		r.errorf(first.Pos, diagnostics.AMBIGUOUS_NAME, "Ambiguous namespace %s, it could refer to %s", first.Name, strings.Join(names, " or "))
+		return nil
+	}
+
+	ns := candidates[0]
+	if ns == nil {
+		if allowNamespace && len(identifiers) == 1 {
+			return r.member(current, r.root, identifiers[0], allowNamespace)
+		}
+
+		r.errorf(first.Pos, diagnostics.UNKNOWN_NAME, "Unknown namespace %s", first.Name)
+		return nil
+	}
+
+	if len(identifiers) == 1 {
+		return ns.symbol
+	}
+
+	for _, identifier := range identifiers[1 : len(identifiers)-1] {
+		child, ok := ns.children[identifier.Name]
+		if !ok {
+			r.errorf(identifier.Pos, diagnostics.UNKNOWN_NAME, "Namespace %s has no namespace %s", path, identifier.Name)
+			return nil
+		}
+
+		path = join(path, identifier.Name)
+		ns = child
+	}
+
+	return r.member(current, ns, identifiers[len(identifiers)-1], allowNamespace)
+}
+
+func (r *resolver) member(
+	current *namespace,
+	ns *namespace,
+	identifier *parser.Identifer,
+	allowNamespace bool,
+) *Symbol {
+	var candidates []*Symbol
+	candidates = append(candidates, ns.symbols[identifier.Name]...)
+
+	if child, ok := ns.children[identifier.Name]; ok && allowNamespace {
+		candidates = append(candidates, child.symbol)
+	}
+
+	name := join(ns.symbol.Name, identifier.Name)
+
+	switch len(candidates) {
+	case 0:
+		if ns == r.root {
+			r.errorf(identifier.Pos, diagnostics.UNKNOWN_NAME, "Unknown %s", name)
+		} else {
+			r.errorf(identifier.Pos, diagnostics.UNKNOWN_NAME, "Namespace %s has no member %s", ns.symbol.Name, identifier.Name)
+		}
+		return nil
+	case 1:
+	default:
+		var positions []string
+		for _, candidate := range candidates {
+			positions = append(positions, candidate.Pos.String())
+		}
+
+		err := r.errorf(identifier.Pos, diagnostics.AMBIGUOUS_NAME, "Ambiguous %s, it is declared at %s", name, strings.Join(positions, " and "))
+		for _, candidate := range candidates {
+			err.At(candidate.Pos, "declared here")
+		}
+		return nil
+	}
+
+	symbol := candidates[0]
+	if symbol.Visibility == parser.PRIVATE && symbol.Namespace != current.symbol.Name {
+		r.errorf(identifier.Pos, diagnostics.PRIVATE_NAME, "%s is private to namespace %s", symbol.Name, symbol.Namespace).
+			At(symbol.Pos, "declared here").
+			Helpf("Declare %s with pub to use it outside of namespace %s", identifier.Name, symbol.Namespace)
+		return nil
+	}
+
+	return symbol
+}
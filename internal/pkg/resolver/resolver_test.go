@@ -0,0 +1,228 @@
+package resolver_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/lexer"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/parser"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/resolver"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
+)
+
+type testStruct struct {
+	name   string
+	files  []string
+	errors []string
+}
+
+func testHelper(t *testing.T, tests []testStruct) {
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var programs []parser.Program
+			set := util.NewFileSet()
+			for _, file := range test.files {
+				program, errors := parser.Run(lexer.Run(set.AddFile("", file)))
+				if len(errors) > 0 {
+					t.Fatalf("unexpected parser errors: %s", errors)
+				}
+
+				programs = append(programs, program)
+			}
+
+			_, errors := resolver.Run(programs)
+
+			var msgs []string
+			for _, err := range errors {
+				msgs = append(msgs, err.Msg)
+			}
+
+			if !reflect.DeepEqual(msgs, test.errors) {
+				t.Errorf("\n%s\n%q\n%s\n%q", "---- EXPECTED ----", test.errors, "---- ACTUAL ----", msgs)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	testHelper(t, []testStruct{
+		{
+			"qualified path across files",
+			[]string{
+				"namespace math::consts\npub const pi = 3.14",
+				"const tau = math::consts::pi * 2",
+			},
+			nil,
+		},
+		{
+			"import namespace",
+			[]string{
+				"namespace math::consts\npub const pi = 3.14",
+				"import math::consts\nconst tau = consts::pi * 2",
+			},
+			nil,
+		},
+		{
+			"import namespace with alias",
+			[]string{
+				"namespace math::consts\npub const pi = 3.14",
+				"import math::consts as c\nconst tau = c::pi * 2",
+			},
+			nil,
+		},
+		{
+			"import from",
+			[]string{
+				"namespace math::consts\npub const pi = 3.14",
+				"import consts from math\nconst tau = consts::pi * 2",
+			},
+			nil,
+		},
+		{
+			"relative to current namespace",
+			[]string{
+				"namespace a\nconst x = b::y\nnamespace a::b\npub const y = 1",
+			},
+			nil,
+		},
+		{
+			"private in same namespace",
+			[]string{
+				"namespace a\nconst x = 1",
+				"namespace a\nconst y = a::x",
+			},
+			nil,
+		},
+	})
+}
+
+func TestResolveErrors(t *testing.T) {
+	testHelper(t, []testStruct{
+		{
+			"unknown namespace",
+			[]string{"const a = b::c"},
+			[]string{"Unknown namespace b"},
+		},
+		{
+			"unknown member",
+			[]string{"namespace a\npub const b = 1", "const c = a::d"},
+			[]string{"Namespace a has no member d"},
+		},
+		{
+			"unknown nested namespace",
+			[]string{"namespace a\npub const b = 1", "const c = a::x::b"},
+			[]string{"Namespace a has no namespace x"},
+		},
+		{
+			"unknown import",
+			[]string{"import a::b"},
+			[]string{"Unknown namespace a"},
+		},
+		{
+			"private member",
+			[]string{"namespace a\nconst b = 1", "const c = a::b"},
+			[]string{"a::b is private to namespace a"},
+		},
+		{
+			"duplicate declaration",
+			[]string{"namespace a\npub const b = 1", "namespace a\npub const b = 2", "const c = a::b"},
+			[]string{"Ambiguous a::b, it is declared at :2:11 and :2:11"},
+		},
+		{
+			"ambiguous namespace",
+			[]string{"namespace b\npub const x = 1\nnamespace a::b\npub const x = 1\nnamespace a\nconst y = b::x"},
+			[]string{"Ambiguous namespace b, it could refer to a::b or b"},
+		},
+		{
+			"duplicate import",
+			[]string{"namespace a\npub const x = 1\nnamespace b\npub const x = 1", "import a::x\nimport b::x"},
+			[]string{"x is imported more than once"},
+		},
+		{
+			"imported declaration used as namespace",
+			[]string{"namespace a\npub const x = 1", "import a::x\nconst y = x::z"},
+			[]string{"a::x is not a namespace"},
+		},
+	})
+}
+
+func TestLookalikes(t *testing.T) {
+	testHelper(t, []testStruct{
+		{
+			"confusable name declared last",
+			[]string{"const pas = 1\nconst \u0440\u0430\u0455 = 2"},
+			[]string{"\u0440\u0430\u0455 can be confused with pas"},
+		},
+		{
+			"confusable name declared first",
+			[]string{"const \u0440\u0430\u0455 = 1\nconst pas = 2"},
+			[]string{"\u0440\u0430\u0455 can be confused with pas"},
+		},
+		{
+			"across files",
+			[]string{"namespace a\nfn ｆｏｏ() {}", "namespace a\nconst foo = 1"},
+			[]string{"ｆｏｏ can be confused with foo"},
+		},
+		{
+			"both confusable",
+			[]string{"const \u0440\u0430\u0455 = 1\nconst ｐａｓ = 2"},
+			[]string{"ｐａｓ can be confused with \u0440\u0430\u0455", "\u0440\u0430\u0455 can be confused with ｐａｓ"},
+		},
+		{
+			"different namespaces",
+			[]string{"namespace a\nconst \u0440\u0430\u0455 = 1", "namespace b\nconst pas = 2"},
+			nil,
+		},
+	})
+}
+
+func TestResolveTypes(t *testing.T) {
+	testHelper(t, []testStruct{
+		{
+			"struct and trait paths",
+			[]string{
+				"namespace geo\npub struct Point { x: f64 }\npub trait Shape { fn area(self) -> f64 }",
+				"impl geo::Shape for geo::Point {\n\tfn area(self) -> f64 { }\n}\nfn origin() -> geo::Point {}",
+			},
+			nil,
+		},
+		{
+			"unknown struct",
+			[]string{"namespace geo\npub struct Point { x: f64 }", "const a = geo::Line { x: 1 }"},
+			[]string{"Namespace geo has no member Line"},
+		},
+	})
+}
+
+func TestResolveDiagnostics(t *testing.T) {
+	set := util.NewFileSet()
+
+	var programs []parser.Program
+	for _, file := range []string{"namespace a\nconst b = 1", "const c = a::b"} {
+		program, _ := parser.Run(lexer.Run(set.AddFile(fmt.Sprintf("file%d", len(programs)), file)))
+		programs = append(programs, program)
+	}
+
+	_, errors := resolver.Run(programs)
+	if len(errors) != 1 {
+		t.Fatalf("expected one error but got %s", errors)
+	}
+
+	want := "error[E0202]: a::b is private to namespace a\n" +
+		" --> file1:1:14\n" +
+		"  |\n" +
+		"1 | const c = a::b\n" +
+		"  |              ^ private\n" +
+		" ::: file0:2:7\n" +
+		"  |\n" +
+		"2 | const b = 1\n" +
+		"  |       - declared here\n" +
+		"  |\n" +
+		"  = help: Declare b with pub to use it outside of namespace a\n"
+
+	if got := diagnostics.Render(errors[0], diagnostics.Plain); got != want {
+		t.Errorf("\n%s\n%s\n%s\n%s", "---- EXPECTED ----", want, "---- ACTUAL ----", got)
+	}
+}
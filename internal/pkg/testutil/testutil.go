@@ -0,0 +1,110 @@
+// Package testutil runs the front end of the compiler for the tests of the
+// passes behind it. Every helper fails the test on the first pass that
+// reports an error, so a test never runs a pass on a program that an
+// earlier pass rejected.
+package testutil
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/analyzer"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/checker"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/consteval"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/ir"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/lexer"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/parser"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/resolver"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
+)
+
+// Front holds what the passes of the front end found out about a set of
+// programs, the fields of the passes that did not run are nil.
+type Front struct {
+	Resolution *resolver.Resolution
+	Constants  map[*parser.Constant]consteval.Value
+	Info       *checker.Info
+}
+
+// Parse parses every file, the first one is named main.ql and the others
+// file1.ql, file2.ql and so on.
+func Parse(t *testing.T, files ...string) []parser.Program {
+	t.Helper()
+
+	var programs []parser.Program
+	set := util.NewFileSet()
+	for idx, file := range files {
+		name := "main.ql"
+		if idx > 0 {
+			name = fmt.Sprintf("file%d.ql", idx)
+		}
+
+		program, errors := parser.Run(lexer.Run(set.AddFile(name, file)))
+		if len(errors) > 0 {
+			t.Fatalf("unexpected parser errors: %s", errors)
+		}
+		programs = append(programs, program)
+	}
+
+	return programs
+}
+
+// Resolve resolves the paths of the programs and runs the analyzer on them.
+func Resolve(t *testing.T, programs []parser.Program) *Front {
+	t.Helper()
+
+	resolution, errors := resolver.Run(programs)
+	fail(t, "resolver", errors)
+	fail(t, "analyzer", analyzer.Run(programs, resolution))
+
+	return &Front{Resolution: resolution}
+}
+
+// Evaluate also folds the constants of the programs.
+func Evaluate(t *testing.T, programs []parser.Program) *Front {
+	t.Helper()
+
+	front := Resolve(t, programs)
+
+	constants, errors := consteval.Run(programs, front.Resolution)
+	fail(t, "constant", errors)
+	front.Constants = constants
+
+	return front
+}
+
+// Check runs the whole front end, up to the type checker.
+func Check(t *testing.T, programs []parser.Program) *Front {
+	t.Helper()
+
+	front := Evaluate(t, programs)
+
+	info, errors := checker.Run(programs, front.Resolution, front.Constants)
+	fail(t, "type", errors)
+	front.Info = info
+
+	return front
+}
+
+// Build builds the IR of the files and optimises it with the given level.
+func Build(t *testing.T, level int, files ...string) *ir.Program {
+	t.Helper()
+
+	programs := Parse(t, files...)
+	front := Check(t, programs)
+
+	program, errors := ir.Build(programs, front.Info, front.Constants)
+	fail(t, "IR", errors)
+	ir.Optimize(program, level)
+
+	return program
+}
+
+func fail(t *testing.T, pass string, errors []diagnostics.Diagnostic) {
+	t.Helper()
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected %s errors: %s", pass, errors)
+	}
+}
@@ -0,0 +1,87 @@
+package types
+
+// AssignableTo reports if a value of type value can be used where a value of
+// type target is expected. These are the only conversions of the language,
+// there is no syntax to convert a value explicitly:
+//
+//   - Every type is assignable to itself.
+//   - Untyped integers are assignable to every numeric type and untyped
+//     floats to every float type, the value has to fit into the target.
+//   - Integers widen to integers with more bits of the same signedness and
+//     unsigned integers widen to signed integers with more bits.
+//   - f32 widens to f64 and f64 and num are interchangeable. Integers of up
+//     to 32 bits are assignable to num, the general number type, larger ones
+//     are not as num cannot hold all of their values exactly.
+//   - Structs are assignable to the traits they implement.
+//
+// Nothing converts to or from bool, sym and bin, and integers never narrow
+// or turn into f32 or f64. Invalid is assignable in both directions so that
+// one error does not cause others.
+func AssignableTo(value Type, target Type) bool {
+	if value == target || value == Invalid || target == Invalid {
+		return true
+	}
+
+	if structure, ok := value.(*Struct); ok {
+		trait, ok := target.(*Trait)
+		return ok && structure.Implements(trait)
+	}
+
+	from, ok := value.(*Basic)
+	if !ok {
+		return false
+	}
+
+	to, ok := target.(*Basic)
+	if !ok || to.IsUntyped() {
+		return false
+	}
+
+	switch {
+	case from.Kind == KIND_UNTYPED_INT:
+		return to.IsNumeric()
+	case from.Kind == KIND_UNTYPED_FLOAT:
+		return to.IsFloat()
+	case to.Kind == KIND_NUM:
+		return from.IsFloat() || from.IsInteger() && from.Bits <= 32
+	case from.Kind == KIND_NUM:
+		return to.Kind == KIND_F64
+	case from.Kind == KIND_F32:
+		return to.Kind == KIND_F64
+	case from.IsUnsigned() && to.IsInteger():
+		return from.Bits < to.Bits
+	case from.IsSigned() && to.IsSigned():
+		return from.Bits < to.Bits
+	}
+
+	return false
+}
+
+// Default returns the type an untyped number takes if nothing else decides
+// it: i64 for integers and num for floats.
+func Default(t Type) Type {
+	switch t {
+	case UntypedInt:
+		return I64
+	case UntypedFloat:
+		return Num
+	}
+
+	return t
+}
+
+// Unify returns the type both operands of a binary operator are converted
+// to, or nil if neither is assignable to the other. Two untyped numbers stay
+// untyped and become a float if one of them is.
+func Unify(left Type, right Type) Type {
+	switch {
+	case left == UntypedFloat && right == UntypedInt:
+		return UntypedFloat
+	case AssignableTo(left, right):
+		return right
+	case AssignableTo(right, left):
+		return left
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package types
+
+import (
+	"strings"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/parser"
+)
+
+// Struct is the type of a struct declaration. Two structs are only identical
+// if they come from the same declaration.
+type Struct struct {
+	Name string
+	// Namespace is the namespace the struct is declared in, its private
+	// fields can only be used there
+	Namespace string
+	Decl      *parser.Struct
+	Fields    []*Field
+	Methods   []*Method
+	Traits    []*Trait
+}
+
+type Field struct {
+	Name string
+	Decl *parser.StructField
+	Type Type
+}
+
+// Method is a function declared in an impl or trait block. Methods without
+// a self parameter are called on the type instead of on a value.
+type Method struct {
+	Name      string
+	Decl      *parser.Function
+	Signature *Function
+	Self      bool
+}
+
+// Trait is the type of a trait declaration. A value of a trait type holds a
+// value of any struct that implements the trait.
+type Trait struct {
+	Name    string
+	Decl    *parser.Trait
+	Methods []*Method
+}
+
+// Function is the signature of a function. The result of a function without
+// a return type is Void, the self parameter of a method is not listed.
+type Function struct {
+	Parameters []Type
+	Result     Type
+}
+
+func (structure *Struct) String() string {
+	return structure.Name
+}
+
+func (structure *Struct) Field(name string) *Field {
+	for _, field := range structure.Fields {
+		if field.Name == name {
+			return field
+		}
+	}
+
+	return nil
+}
+
+// Method looks up a method of the struct, falling back to the default
+// methods of the traits it implements.
+func (structure *Struct) Method(name string) *Method {
+	for _, method := range structure.Methods {
+		if method.Name == name {
+			return method
+		}
+	}
+
+	for _, trait := range structure.Traits {
+		if method := trait.Method(name); method != nil && method.Decl.Body != nil {
+			return method
+		}
+	}
+
+	return nil
+}
+
+func (structure *Struct) Implements(trait *Trait) bool {
+	for _, implemented := range structure.Traits {
+		if implemented == trait {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (trait *Trait) String() string {
+	return trait.Name
+}
+
+func (trait *Trait) Method(name string) *Method {
+	for _, method := range trait.Methods {
+		if method.Name == name {
+			return method
+		}
+	}
+
+	return nil
+}
+
+func (function *Function) String() string {
+	var parameters []string
+	for _, parameter := range function.Parameters {
+		parameters = append(parameters, parameter.String())
+	}
+
+	if function.Result == Void {
+		return "fn(" + strings.Join(parameters, ", ") + ")"
+	}
+
+	return "fn(" + strings.Join(parameters, ", ") + ") -> " + function.Result.String()
+}
@@ -0,0 +1,144 @@
+package types
+
+import (
+	"math/big"
+)
+
+type Type interface {
+	String() string
+}
+
+type BasicKind int
+
+const (
+	KIND_BOOL BasicKind = iota
+	KIND_U8
+	KIND_U16
+	KIND_U32
+	KIND_U64
+	KIND_I8
+	KIND_I16
+	KIND_I32
+	KIND_I64
+	KIND_F32
+	KIND_F64
+	KIND_NUM
+	KIND_SYM
+	KIND_BIN
+	KIND_NIL
+	KIND_UNTYPED_INT
+	KIND_UNTYPED_FLOAT
+	KIND_VOID
+	KIND_INVALID
+)
+
+// Basic is a primitive type. Next to the types that can be named in source
+// there are the types of nil and of untyped number constants, which take the
+// type of the context they are used in, void for expressions without a value
+// and invalid for expressions whose type could not be determined.
+//
+// num is the general number type, a 64 bit floating point number like f64.
+// bin is a sequence of bytes and the type of string literals, sym is the type
+// of symbols.
+type Basic struct {
+	Kind BasicKind
+	Name string
+	Bits int
+}
+
+var (
+	Bool         = &Basic{KIND_BOOL, "bool", 1}
+	U8           = &Basic{KIND_U8, "u8", 8}
+	U16          = &Basic{KIND_U16, "u16", 16}
+	U32          = &Basic{KIND_U32, "u32", 32}
+	U64          = &Basic{KIND_U64, "u64", 64}
+	I8           = &Basic{KIND_I8, "i8", 8}
+	I16          = &Basic{KIND_I16, "i16", 16}
+	I32          = &Basic{KIND_I32, "i32", 32}
+	I64          = &Basic{KIND_I64, "i64", 64}
+	F32          = &Basic{KIND_F32, "f32", 32}
+	F64          = &Basic{KIND_F64, "f64", 64}
+	Num          = &Basic{KIND_NUM, "num", 64}
+	Sym          = &Basic{KIND_SYM, "sym", 0}
+	Bin          = &Basic{KIND_BIN, "bin", 0}
+	Nil          = &Basic{KIND_NIL, "nil", 0}
+	UntypedInt   = &Basic{KIND_UNTYPED_INT, "untyped int", 0}
+	UntypedFloat = &Basic{KIND_UNTYPED_FLOAT, "untyped float", 0}
+	Void         = &Basic{KIND_VOID, "void", 0}
+	Invalid      = &Basic{KIND_INVALID, "invalid", 0}
+)
+
+var basics = map[string]*Basic{
+	"bool": Bool,
+	"u8":   U8,
+	"u16":  U16,
+	"u32":  U32,
+	"u64":  U64,
+	"i8":   I8,
+	"i16":  I16,
+	"i32":  I32,
+	"i64":  I64,
+	"f32":  F32,
+	"f64":  F64,
+	"num":  Num,
+	"sym":  Sym,
+	"bin":  Bin,
+}
+
+// LookupBasic returns the primitive type with the given name or nil.
+func LookupBasic(name string) *Basic {
+	return basics[name]
+}
+
+func (basic *Basic) String() string {
+	return basic.Name
+}
+
+func (basic *Basic) IsInteger() bool {
+	return basic.Kind >= KIND_U8 && basic.Kind <= KIND_I64 || basic.Kind == KIND_UNTYPED_INT
+}
+
+func (basic *Basic) IsSigned() bool {
+	return basic.Kind >= KIND_I8 && basic.Kind <= KIND_I64
+}
+
+func (basic *Basic) IsUnsigned() bool {
+	return basic.Kind >= KIND_U8 && basic.Kind <= KIND_U64
+}
+
+func (basic *Basic) IsFloat() bool {
+	return basic.Kind == KIND_F32 || basic.Kind == KIND_F64 || basic.Kind == KIND_NUM || basic.Kind == KIND_UNTYPED_FLOAT
+}
+
+func (basic *Basic) IsNumeric() bool {
+	return basic.IsInteger() || basic.IsFloat()
+}
+
+func (basic *Basic) IsUntyped() bool {
+	return basic.Kind == KIND_UNTYPED_INT || basic.Kind == KIND_UNTYPED_FLOAT
+}
+
+// MinInt returns the smallest value of a sized integer type.
+func (basic *Basic) MinInt() *big.Int {
+	if !basic.IsSigned() {
+		return big.NewInt(0)
+	}
+
+	return new(big.Int).Neg(new(big.Int).Lsh(big.NewInt(1), uint(basic.Bits-1)))
+}
+
+// MaxInt returns the largest value of a sized integer type.
+func (basic *Basic) MaxInt() *big.Int {
+	bits := basic.Bits
+	if basic.IsSigned() {
+		bits--
+	}
+
+	return new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(bits)), big.NewInt(1))
+}
+
+// Fits reports if an integer value can be represented by a sized integer
+// type.
+func (basic *Basic) Fits(value *big.Int) bool {
+	return value.Cmp(basic.MinInt()) >= 0 && value.Cmp(basic.MaxInt()) <= 0
+}
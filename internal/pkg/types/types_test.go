@@ -0,0 +1,53 @@
+package types_test
+
+import (
+	"testing"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/types"
+)
+
+func TestAssignableTo(t *testing.T) {
+	trait := &types.Trait{Name: "Shape"}
+	point := &types.Struct{Name: "Point", Traits: []*types.Trait{trait}}
+
+	tests := []struct {
+		value      types.Type
+		target     types.Type
+		assignable bool
+	}{
+		{types.U8, types.U8, true},
+		{types.U8, types.U16, true},
+		{types.U8, types.I16, true},
+		{types.I8, types.I64, true},
+		{types.U16, types.U8, false},
+		{types.U8, types.I8, false},
+		{types.I8, types.U64, false},
+		{types.F32, types.F64, true},
+		{types.F64, types.F32, false},
+		{types.F64, types.Num, true},
+		{types.Num, types.F64, true},
+		{types.I32, types.Num, true},
+		{types.U32, types.Num, true},
+		{types.I64, types.Num, false},
+		{types.U64, types.Num, false},
+		{types.F32, types.Num, true},
+		{types.I32, types.F64, false},
+		{types.UntypedInt, types.U8, true},
+		{types.UntypedInt, types.F32, true},
+		{types.UntypedFloat, types.F32, true},
+		{types.UntypedFloat, types.I32, false},
+		{types.UntypedInt, types.Bool, false},
+		{types.Bool, types.U8, false},
+		{types.Bin, types.Sym, false},
+		{point, trait, true},
+		{&types.Struct{Name: "Other"}, trait, false},
+		{trait, point, false},
+		{types.Invalid, types.Bool, true},
+	}
+
+	for _, test := range tests {
+		if actual := types.AssignableTo(test.value, test.target); actual != test.assignable {
+			t.Errorf("AssignableTo(%s, %s) = %t, expected %t", test.value, test.target, actual, test.assignable)
+		}
+	}
+}
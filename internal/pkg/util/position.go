@@ -1,9 +1,44 @@
 package util
 
+import "unicode/utf16"
+
+// Position is the place of a token in its file. Idx, Col and Len count
+// runes, Offset and Size count bytes and Col16 counts UTF-16 code units, as
+// editors and language servers do.
 type Position struct {
-	File string
-	Idx  int
-	Col  int
-	Row  int
-	Len  int
+	File   *File
+	Idx    int
+	Offset int
+	Col    int
+	Col16  int
+	Row    int
+	Len    int
+	Size   int
+}
+
+// Advance returns the position behind text, when text starts at pos. A
+// carriage return followed by a line feed is a single line break.
+func (pos Position) Advance(text string) Position {
+	var last rune
+	for _, ch := range text {
+		pos.Idx++
+
+		switch {
+		case ch == '\n' && last == '\r':
+		case ch == '\n' || ch == '\r':
+			pos.Row++
+			pos.Col = 0
+			pos.Col16 = 0
+		default:
+			pos.Col++
+			pos.Col16 += utf16.RuneLen(ch)
+		}
+
+		last = ch
+	}
+
+	pos.Offset += len(text)
+	pos.Len = 0
+	pos.Size = 0
+	return pos
 }
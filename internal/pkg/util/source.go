@@ -0,0 +1,161 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FileID identifies a file of a FileSet, the IDs start at 1.
+type FileID int
+
+// File is a source file. Its text is kept together with the offsets its
+// lines start at, so positions in it can be shown with the line they are on.
+type File struct {
+	ID   FileID
+	Name string
+	Text string
+	// lines holds the byte offset of the start of every line
+	lines []int
+}
+
+// FileSet owns all files of a compilation, every token refers to one of
+// them.
+type FileSet struct {
+	files []*File
+}
+
+func NewFileSet() *FileSet {
+	return &FileSet{}
+}
+
+// AddFile adds a file with the given name and text and gives it the next ID.
+func (set *FileSet) AddFile(name string, text string) *File {
+	file := &File{
+		ID:    FileID(len(set.files) + 1),
+		Name:  name,
+		Text:  text,
+		lines: []int{0},
+	}
+
+	// A carriage return followed by a line feed is a single line break
+	for idx := 0; idx < len(text); idx++ {
+		switch text[idx] {
+		case '\r':
+			if idx+1 < len(text) && text[idx+1] == '\n' {
+				idx++
+			}
+			fallthrough
+		case '\n':
+			file.lines = append(file.lines, idx+1)
+		}
+	}
+
+	set.files = append(set.files, file)
+	return file
+}
+
+// File returns the file with the given ID, or nil if there is none.
+func (set *FileSet) File(id FileID) *File {
+	if id < 1 || int(id) > len(set.files) {
+		return nil
+	}
+
+	return set.files[id-1]
+}
+
+func (set *FileSet) Files() []*File {
+	return set.files
+}
+
+// LineCount returns the number of lines of the file, a line break at its end
+// starts an empty last line.
+func (file *File) LineCount() int {
+	return len(file.lines)
+}
+
+// Line returns the text of a line without its line break, rows start at 0 like
+// the rows of positions.
+func (file *File) Line(row int) string {
+	if row < 0 || row >= len(file.lines) {
+		return ""
+	}
+
+	end := len(file.Text)
+	if row+1 < len(file.lines) {
+		end = file.lines[row+1]
+	}
+
+	return strings.TrimRight(file.Text[file.lines[row]:end], "\r\n")
+}
+
+// LineStart returns the byte offset the line starts at.
+func (file *File) LineStart(row int) int {
+	return file.lines[row]
+}
+
+func (pos Position) String() string {
+	name := ""
+	if pos.File != nil {
+		name = pos.File.Name
+	}
+
+	return fmt.Sprintf("%s:%d:%d", name, pos.Row+1, pos.Col+1)
+}
+
+// Span is the part of a file from Start up to End, which lies behind the last
+// character of the span.
+type Span struct {
+	Start Position
+	End   Position
+}
+
+// Span returns the span a token at pos covers.
+func (pos Position) Span() Span {
+	end := pos
+	if file := pos.File; file != nil && pos.Offset+pos.Size <= len(file.Text) {
+		end = pos.Advance(file.Text[pos.Offset : pos.Offset+pos.Size])
+	} else {
+		// Without the text the token is assumed to be on a single line
+		end.Idx += pos.Len
+		end.Offset += pos.Size
+		end.Col += pos.Len
+		end.Col16 += pos.Len
+		end.Len = 0
+		end.Size = 0
+	}
+
+	return Span{Start: pos, End: end}
+}
+
+func (span Span) String() string {
+	return span.Start.String()
+}
+
+// Excerpt returns the lines the span lies on, every line is preceded by its
+// number.
+func (span Span) Excerpt() string {
+	file := span.Start.File
+	if file == nil {
+		return ""
+	}
+
+	last := span.End.Row
+	if last > span.Start.Row && span.End.Col == 0 {
+		// A span that ends with a line break does not reach into the next line
+		last--
+	}
+
+	width := len(fmt.Sprint(last + 1))
+
+	var sb strings.Builder
+	for row := span.Start.Row; row <= last && row < file.LineCount(); row++ {
+		fmt.Fprintf(&sb, "%*d | %s\n", width, row+1, file.Line(row))
+	}
+
+	return sb.String()
+}
+
+// Render returns the location of the span followed by its excerpt.
+func (span Span) Render() string {
+	return span.String() + "\n" + span.Excerpt()
+}
@@ -0,0 +1,72 @@
+package util_test
+
+import (
+	"testing"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
+)
+
+func TestFileSet(t *testing.T) {
+	set := util.NewFileSet()
+	first := set.AddFile("a.ql", "let a = 1\r\nlet b = 2\rlet c = 3\n")
+	second := set.AddFile("b.ql", "")
+
+	if first.ID != 1 || second.ID != 2 || set.File(1) != first || set.File(2) != second || set.File(3) != nil {
+		t.Errorf("expected the files to have the IDs 1 and 2 but got %d and %d", first.ID, second.ID)
+	}
+
+	lines := []string{"let a = 1", "let b = 2", "let c = 3", ""}
+	if first.LineCount() != len(lines) {
+		t.Fatalf("expected %d lines but got %d", len(lines), first.LineCount())
+	}
+
+	for row, line := range lines {
+		if first.Line(row) != line {
+			t.Errorf("expected line %d to be %q but got %q", row+1, line, first.Line(row))
+		}
+	}
+
+	if first.LineStart(1) != 11 || first.LineStart(2) != 21 {
+		t.Errorf("expected the lines to start at 11 and 21 but got %d and %d", first.LineStart(1), first.LineStart(2))
+	}
+}
+
+func TestSpan(t *testing.T) {
+	file := util.NewFileSet().AddFile("main.ql", "fn main() {\r\n\t\"ä\r\n\tb\"\r\n}")
+
+	tests := []struct {
+		pos    util.Position
+		end    util.Position
+		render string
+	}{
+		{
+			util.Position{File: file, Idx: 0, Offset: 0, Len: 2, Size: 2},
+			util.Position{File: file, Idx: 2, Offset: 2, Col: 2, Col16: 2},
+			"main.ql:1:1\n1 | fn main() {\n",
+		},
+		{
+			util.Position{File: file, Idx: 14, Offset: 14, Col: 1, Col16: 1, Row: 1, Len: 7, Size: 8},
+			util.Position{File: file, Idx: 21, Offset: 22, Col: 3, Col16: 3, Row: 2},
+			"main.ql:2:2\n2 | \t\"ä\n3 | \tb\"\n",
+		},
+		{
+			util.Position{File: file, Idx: 11, Offset: 11, Col: 11, Col16: 11, Len: 2, Size: 2},
+			util.Position{File: file, Idx: 13, Offset: 13, Row: 1},
+			"main.ql:1:12\n1 | fn main() {\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.render, func(t *testing.T) {
+			span := test.pos.Span()
+
+			if span.Start != test.pos || span.End != test.end {
+				t.Errorf("expected the span to end at %+v but it ends at %+v", test.end, span.End)
+			}
+
+			if span.Render() != test.render {
+				t.Errorf("\n%s\n%s\n%s\n%s", "---- EXPECTED ----", test.render, "---- ACTUAL ----", span.Render())
+			}
+		})
+	}
+}
@@ -0,0 +1,436 @@
+package wasm
+
+import (
+	"math"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/consteval"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/types"
+)
+
+// Arithmetic that can fail is done by helper functions, which are added to
+// the module the first time they are used. Instead of the messages of the
+// other backends they trap with unreachable.
+
+// helper returns the index of a helper function, building it if it is new.
+func (g *generator) helper(name string, params []ValType, result ValType, build func(f *Function)) int64 {
+	if idx, ok := g.helpers[name]; ok {
+		return idx
+	}
+
+	names := []string{"a", "b", "c"}[:len(params)]
+	f := &Function{
+		Id:         g.unique(name),
+		Type:       g.module.TypeIndex(params, []ValType{result}),
+		LocalNames: append([]string{}, names...),
+	}
+
+	idx := int64(len(g.module.Imports) + len(g.module.Functions))
+	g.module.Functions = append(g.module.Functions, f)
+	g.helpers[name] = idx
+
+	build(f)
+	return idx
+}
+
+// pick returns the instruction for the representation of an integer.
+func pick(rep ValType, op32 Opcode, op64 Opcode) Opcode {
+	if rep == I64 {
+		return op64
+	}
+
+	return op32
+}
+
+// trap stops the program if the condition on top of the stack is true.
+func trap(f *Function) {
+	f.block(OP_IF, 0)
+	f.op(OP_UNREACHABLE)
+	f.op(OP_END)
+}
+
+// extend converts an integer to an i64.
+func extend(f *Function, basic *types.Basic) {
+	switch {
+	case rep(basic) == I64:
+	case basic.IsSigned():
+		f.op(OP_I64_EXTEND_I32_S)
+	default:
+		f.op(OP_I64_EXTEND_I32_U)
+	}
+}
+
+// wrap converts an i64 back into the representation of an integer.
+func wrap(f *Function, basic *types.Basic) {
+	if rep(basic) == I32 {
+		f.op(OP_I32_WRAP_I64)
+	}
+}
+
+// normalize sign extends the low bits of an i64 for signed types with less
+// than 64 bits.
+func normalize(f *Function, basic *types.Basic) {
+	if basic.IsSigned() && basic.Bits < 64 {
+		f.constant(I64, int64(64-basic.Bits), 0)
+		f.op(OP_I64_SHL)
+		f.constant(I64, int64(64-basic.Bits), 0)
+		f.op(OP_I64_SHR_S)
+	}
+}
+
+// mask returns the bits of an integer type.
+func mask(basic *types.Basic) int64 {
+	if basic.Bits == 64 {
+		return -1
+	}
+
+	return int64(1)<<basic.Bits - 1
+}
+
+// checkRange traps if the i64 in a local is out of the range of a type.
+func checkRange(f *Function, local int64, basic *types.Basic) {
+	if basic.IsSigned() {
+		f.op(OP_LOCAL_GET, local)
+		f.constant(I64, basic.MinInt().Int64(), 0)
+		f.op(OP_I64_LT_S)
+		f.op(OP_LOCAL_GET, local)
+		f.constant(I64, basic.MaxInt().Int64(), 0)
+		f.op(OP_I64_GT_S)
+		f.op(OP_I32_OR)
+	} else {
+		f.op(OP_LOCAL_GET, local)
+		f.constant(I64, basic.MaxInt().Int64(), 0)
+		f.op(OP_I64_GT_U)
+	}
+	trap(f)
+}
+
+var intOps = map[string][2]Opcode{
+	"add": {OP_I64_ADD, OP_I64_ADD},
+	"sub": {OP_I64_SUB, OP_I64_SUB},
+	"mul": {OP_I64_MUL, OP_I64_MUL},
+	"div": {OP_I64_DIV_S, OP_I64_DIV_U},
+}
+
+// intHelper returns the helper of add, sub, mul or div of an integer type.
+// Integers with less than 64 bits are computed as i64 and checked against
+// the range of their type.
+func (g *generator) intHelper(operator string, basic *types.Basic) int64 {
+	r := rep(basic)
+
+	return g.helper(operator+"_"+basic.Name, []ValType{r, r}, r, func(f *Function) {
+		op := intOps[operator][0]
+		if basic.IsUnsigned() {
+			op = intOps[operator][1]
+		}
+
+		if basic.Bits < 64 {
+			result := f.local("r", I64)
+			f.op(OP_LOCAL_GET, 0)
+			extend(f, basic)
+			f.op(OP_LOCAL_GET, 1)
+			extend(f, basic)
+			f.op(op)
+			f.op(OP_LOCAL_SET, result)
+			checkRange(f, result, basic)
+			f.op(OP_LOCAL_GET, result)
+			wrap(f, basic)
+			return
+		}
+
+		result := f.local("r", I64)
+		f.op(OP_LOCAL_GET, 0)
+		f.op(OP_LOCAL_GET, 1)
+		f.op(op)
+		f.op(OP_LOCAL_SET, result)
+
+		switch {
+		case operator == "div":
+			// Division by zero and MIN / -1 trap on their own
+		case operator == "mul":
+			// The product overflowed if dividing it does not give back the
+			// operand, the division traps for MIN / -1
+			f.op(OP_LOCAL_GET, 0)
+			f.op(OP_I64_EQZ)
+			f.block(OP_IF, I32)
+			f.constant(I32, 0, 0)
+			f.op(OP_ELSE)
+			f.op(OP_LOCAL_GET, result)
+			f.op(OP_LOCAL_GET, 0)
+			if basic.IsSigned() {
+				f.op(OP_I64_DIV_S)
+			} else {
+				f.op(OP_I64_DIV_U)
+			}
+			f.op(OP_LOCAL_GET, 1)
+			f.op(OP_I64_NE)
+			f.op(OP_END)
+			trap(f)
+		case basic.IsUnsigned() && operator == "add":
+			f.op(OP_LOCAL_GET, result)
+			f.op(OP_LOCAL_GET, 0)
+			f.op(OP_I64_LT_U)
+			trap(f)
+		case basic.IsUnsigned():
+			f.op(OP_LOCAL_GET, 0)
+			f.op(OP_LOCAL_GET, 1)
+			f.op(OP_I64_LT_U)
+			trap(f)
+		default:
+			// Signed overflow changes the sign against both operands of an
+			// addition and against the left operand of a subtraction
+			f.op(OP_LOCAL_GET, 0)
+			f.op(OP_LOCAL_GET, result)
+			f.op(OP_I64_XOR)
+			if operator == "add" {
+				f.op(OP_LOCAL_GET, 1)
+				f.op(OP_LOCAL_GET, result)
+			} else {
+				f.op(OP_LOCAL_GET, 0)
+				f.op(OP_LOCAL_GET, 1)
+			}
+			f.op(OP_I64_XOR)
+			f.op(OP_I64_AND)
+			f.constant(I64, 0, 0)
+			f.op(OP_I64_LT_S)
+			trap(f)
+		}
+
+		f.op(OP_LOCAL_GET, result)
+	})
+}
+
+// negHelper negates an integer by subtracting it from zero.
+func (g *generator) negHelper(basic *types.Basic) int64 {
+	r := rep(basic)
+	sub := g.intHelper("sub", basic)
+
+	return g.helper("neg_"+basic.Name, []ValType{r}, r, func(f *Function) {
+		f.constant(r, 0, 0)
+		f.op(OP_LOCAL_GET, 0)
+		f.op(OP_CALL, sub)
+	})
+}
+
+// powHelper squares the base only while bits of the exponent are left, so
+// an overflow of the square is an overflow of the result.
+func (g *generator) powHelper(basic *types.Basic) int64 {
+	r := rep(basic)
+	mul := g.intHelper("mul", basic)
+
+	return g.helper("pow_"+basic.Name, []ValType{r, r}, r, func(f *Function) {
+		result := f.local("r", r)
+
+		if basic.IsSigned() {
+			f.op(OP_LOCAL_GET, 1)
+			f.constant(r, 0, 0)
+			f.op(pick(r, OP_I32_LT_S, OP_I64_LT_S))
+			trap(f)
+		}
+
+		f.constant(r, 1, 0)
+		f.op(OP_LOCAL_SET, result)
+
+		f.block(OP_BLOCK, 0)
+		f.block(OP_LOOP, 0)
+		f.op(OP_LOCAL_GET, 1)
+		f.op(pick(r, OP_I32_EQZ, OP_I64_EQZ))
+		f.op(OP_BR_IF, 1)
+
+		f.op(OP_LOCAL_GET, 1)
+		f.constant(r, 1, 0)
+		f.op(pick(r, OP_I32_AND, OP_I64_AND))
+		if r == I64 {
+			f.op(OP_I64_EQZ)
+			f.op(OP_I32_EQZ)
+		}
+		f.block(OP_IF, 0)
+		f.op(OP_LOCAL_GET, result)
+		f.op(OP_LOCAL_GET, 0)
+		f.op(OP_CALL, mul)
+		f.op(OP_LOCAL_SET, result)
+		f.op(OP_END)
+
+		f.op(OP_LOCAL_GET, 1)
+		f.constant(r, 1, 0)
+		f.op(pick(r, OP_I32_SHR_U, OP_I64_SHR_U))
+		f.op(OP_LOCAL_TEE, 1)
+		f.op(pick(r, OP_I32_EQZ, OP_I64_EQZ))
+		f.op(OP_BR_IF, 1)
+
+		f.op(OP_LOCAL_GET, 0)
+		f.op(OP_LOCAL_GET, 0)
+		f.op(OP_CALL, mul)
+		f.op(OP_LOCAL_SET, 0)
+		f.op(OP_BR, 0)
+		f.op(OP_END)
+		f.op(OP_END)
+
+		f.op(OP_LOCAL_GET, result)
+	})
+}
+
+// shiftHelper returns the helper of a shift. The amount is an i64 and the
+// shift is computed on the i64 of the value. Amounts above
+// consteval.MAX_UNTYPED_BITS trap.
+func (g *generator) shiftHelper(operator string, basic *types.Basic) int64 {
+	r := rep(basic)
+	bits := int64(basic.Bits)
+
+	return g.helper(operator+"_"+basic.Name, []ValType{r, I64}, r, func(f *Function) {
+		x := f.local("x", I64)
+
+		f.op(OP_LOCAL_GET, 1)
+		f.constant(I64, 0, 0)
+		f.op(OP_I64_LT_S)
+		f.op(OP_LOCAL_GET, 1)
+		f.constant(I64, consteval.MAX_UNTYPED_BITS, 0)
+		f.op(OP_I64_GT_S)
+		f.op(OP_I32_OR)
+		trap(f)
+
+		f.op(OP_LOCAL_GET, 0)
+		extend(f, basic)
+		f.op(OP_LOCAL_SET, x)
+
+		switch operator {
+		case "shl":
+			f.op(OP_LOCAL_GET, x)
+			f.op(OP_I64_EQZ)
+			f.block(OP_IF, 0)
+			f.constant(r, 0, 0)
+			f.op(OP_RETURN)
+			f.op(OP_END)
+
+			f.op(OP_LOCAL_GET, 1)
+			f.constant(I64, bits, 0)
+			f.op(OP_I64_GE_S)
+			trap(f)
+
+			// Shifting out set bits, or bits unlike the sign, is an overflow
+			f.op(OP_LOCAL_GET, x)
+			if basic.IsSigned() {
+				f.constant(I64, -1, 0)
+				f.op(OP_I64_XOR)
+				f.op(OP_LOCAL_GET, x)
+				f.op(OP_LOCAL_GET, x)
+				f.constant(I64, 0, 0)
+				f.op(OP_I64_LT_S)
+				f.op(OP_SELECT)
+			}
+			f.constant(I64, basic.MaxInt().Int64(), 0)
+			if basic.IsUnsigned() && basic.Bits == 64 {
+				f.Body[len(f.Body)-1].Int = -1
+			}
+			f.op(OP_LOCAL_GET, 1)
+			f.op(OP_I64_SHR_U)
+			f.op(OP_I64_GT_U)
+			trap(f)
+
+			f.op(OP_LOCAL_GET, x)
+			f.op(OP_LOCAL_GET, 1)
+			f.op(OP_I64_SHL)
+		case "shr":
+			f.op(OP_LOCAL_GET, 1)
+			f.constant(I64, bits, 0)
+			f.op(OP_I64_GE_S)
+			f.block(OP_IF, 0)
+			f.constant(r, 0, 0)
+			f.op(OP_RETURN)
+			f.op(OP_END)
+
+			f.op(OP_LOCAL_GET, x)
+			f.constant(I64, mask(basic), 0)
+			f.op(OP_I64_AND)
+			f.op(OP_LOCAL_GET, 1)
+			f.op(OP_I64_SHR_U)
+			normalize(f, basic)
+		case "ashr":
+			f.op(OP_LOCAL_GET, 1)
+			f.constant(I64, bits-1, 0)
+			f.op(OP_I64_GT_S)
+			f.block(OP_IF, 0)
+			f.constant(I64, bits-1, 0)
+			f.op(OP_LOCAL_SET, 1)
+			f.op(OP_END)
+
+			f.op(OP_LOCAL_GET, x)
+			f.op(OP_LOCAL_GET, 1)
+			if basic.IsSigned() {
+				f.op(OP_I64_SHR_S)
+			} else {
+				f.op(OP_I64_SHR_U)
+			}
+		default:
+			// A rotation to the right is one to the left by the rest
+			f.op(OP_LOCAL_GET, 1)
+			f.constant(I64, bits, 0)
+			f.op(OP_I64_REM_U)
+			if operator == "cshr" {
+				f.op(OP_LOCAL_SET, 1)
+				f.constant(I64, bits, 0)
+				f.op(OP_LOCAL_GET, 1)
+				f.op(OP_I64_SUB)
+				f.constant(I64, bits, 0)
+				f.op(OP_I64_REM_U)
+			}
+			f.op(OP_LOCAL_SET, 1)
+
+			f.op(OP_LOCAL_GET, x)
+			f.constant(I64, mask(basic), 0)
+			f.op(OP_I64_AND)
+			f.op(OP_LOCAL_TEE, x)
+			f.op(OP_LOCAL_GET, 1)
+			f.op(OP_I64_SHL)
+			f.op(OP_LOCAL_GET, x)
+			f.constant(I64, bits, 0)
+			f.op(OP_LOCAL_GET, 1)
+			f.op(OP_I64_SUB)
+			f.op(OP_I64_SHR_U)
+			f.op(OP_I64_OR)
+			f.constant(I64, mask(basic), 0)
+			f.op(OP_I64_AND)
+			normalize(f, basic)
+		}
+
+		wrap(f, basic)
+	})
+}
+
+var floatOps = map[string][2]Opcode{
+	"add": {OP_F32_ADD, OP_F64_ADD},
+	"sub": {OP_F32_SUB, OP_F64_SUB},
+	"mul": {OP_F32_MUL, OP_F64_MUL},
+	"div": {OP_F32_DIV, OP_F64_DIV},
+}
+
+// floatHelper returns the helper of a float operation, which traps on
+// division by zero and on infinite results.
+func (g *generator) floatHelper(operator string, basic *types.Basic) int64 {
+	r := rep(basic)
+
+	return g.helper(operator+"_"+basic.Name, []ValType{r, r}, r, func(f *Function) {
+		result := f.local("r", r)
+		op, abs, eq := floatOps[operator][1], OP_F64_ABS, OP_F64_EQ
+		if r == F32 {
+			op, abs, eq = floatOps[operator][0], OP_F32_ABS, OP_F32_EQ
+		}
+
+		if operator == "div" {
+			f.op(OP_LOCAL_GET, 1)
+			f.constant(r, 0, 0)
+			f.op(eq)
+			trap(f)
+		}
+
+		f.op(OP_LOCAL_GET, 0)
+		f.op(OP_LOCAL_GET, 1)
+		f.op(op)
+		f.op(OP_LOCAL_TEE, result)
+		f.op(abs)
+		f.constant(r, 0, math.Inf(1))
+		f.op(eq)
+		trap(f)
+
+		f.op(OP_LOCAL_GET, result)
+	})
+}
@@ -0,0 +1,177 @@
+package wasm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+const (
+	SECTION_TYPE     = 1
+	SECTION_IMPORT   = 2
+	SECTION_FUNCTION = 3
+	SECTION_GLOBAL   = 6
+	SECTION_EXPORT   = 7
+	SECTION_START    = 8
+	SECTION_CODE     = 10
+)
+
+// Binary encodes a module in the binary format of WebAssembly 1.0.
+func (module *Module) Binary() []byte {
+	var out bytes.Buffer
+	out.Write([]byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00})
+
+	section := func(id byte, count int, write func(buf *bytes.Buffer)) {
+		if count == 0 {
+			return
+		}
+
+		var buf bytes.Buffer
+		writeUnsigned(&buf, uint64(count))
+		write(&buf)
+
+		out.WriteByte(id)
+		writeUnsigned(&out, uint64(buf.Len()))
+		out.Write(buf.Bytes())
+	}
+
+	section(SECTION_TYPE, len(module.Types), func(buf *bytes.Buffer) {
+		for _, t := range module.Types {
+			buf.WriteByte(0x60)
+			writeTypes(buf, t.Params)
+			writeTypes(buf, t.Results)
+		}
+	})
+
+	section(SECTION_IMPORT, len(module.Imports), func(buf *bytes.Buffer) {
+		for _, imported := range module.Imports {
+			writeName(buf, imported.Module)
+			writeName(buf, imported.Name)
+			buf.WriteByte(0x00)
+			writeUnsigned(buf, uint64(imported.Type))
+		}
+	})
+
+	section(SECTION_FUNCTION, len(module.Functions), func(buf *bytes.Buffer) {
+		for _, function := range module.Functions {
+			writeUnsigned(buf, uint64(function.Type))
+		}
+	})
+
+	section(SECTION_GLOBAL, len(module.Globals), func(buf *bytes.Buffer) {
+		for _, global := range module.Globals {
+			buf.WriteByte(byte(global.Type))
+			buf.WriteByte(0x01)
+			writeInstr(buf, global.Init)
+			buf.WriteByte(byte(OP_END))
+		}
+	})
+
+	section(SECTION_EXPORT, len(module.Exports), func(buf *bytes.Buffer) {
+		for _, export := range module.Exports {
+			writeName(buf, export.Name)
+			buf.WriteByte(0x00)
+			writeUnsigned(buf, uint64(export.Function))
+		}
+	})
+
+	if module.Start >= 0 {
+		var buf bytes.Buffer
+		writeUnsigned(&buf, uint64(module.Start))
+		out.WriteByte(SECTION_START)
+		writeUnsigned(&out, uint64(buf.Len()))
+		out.Write(buf.Bytes())
+	}
+
+	section(SECTION_CODE, len(module.Functions), func(buf *bytes.Buffer) {
+		for _, function := range module.Functions {
+			var body bytes.Buffer
+
+			// Locals are declared in runs of the same type
+			var runs [][2]int
+			for _, local := range function.Locals {
+				if len(runs) > 0 && runs[len(runs)-1][1] == int(local) {
+					runs[len(runs)-1][0]++
+				} else {
+					runs = append(runs, [2]int{1, int(local)})
+				}
+			}
+			writeUnsigned(&body, uint64(len(runs)))
+			for _, run := range runs {
+				writeUnsigned(&body, uint64(run[0]))
+				body.WriteByte(byte(run[1]))
+			}
+
+			for _, instr := range function.Body {
+				writeInstr(&body, instr)
+			}
+			body.WriteByte(byte(OP_END))
+
+			writeUnsigned(buf, uint64(body.Len()))
+			buf.Write(body.Bytes())
+		}
+	})
+
+	return out.Bytes()
+}
+
+func writeInstr(buf *bytes.Buffer, instr Instr) {
+	buf.WriteByte(byte(instr.Op))
+
+	switch opcodes[instr.Op].immediate {
+	case BLOCK:
+		if instr.Block == 0 {
+			buf.WriteByte(0x40)
+		} else {
+			buf.WriteByte(byte(instr.Block))
+		}
+	case INDEX:
+		writeUnsigned(buf, uint64(instr.Int))
+	case I32_CONST:
+		writeSigned(buf, int64(int32(instr.Int)))
+	case I64_CONST:
+		writeSigned(buf, instr.Int)
+	case F32_CONST:
+		binary.Write(buf, binary.LittleEndian, math.Float32bits(float32(instr.Float)))
+	case F64_CONST:
+		binary.Write(buf, binary.LittleEndian, math.Float64bits(instr.Float))
+	}
+}
+
+func writeTypes(buf *bytes.Buffer, types []ValType) {
+	writeUnsigned(buf, uint64(len(types)))
+	for _, t := range types {
+		buf.WriteByte(byte(t))
+	}
+}
+
+func writeName(buf *bytes.Buffer, name string) {
+	writeUnsigned(buf, uint64(len(name)))
+	buf.WriteString(name)
+}
+
+// writeUnsigned writes an unsigned LEB128 number.
+func writeUnsigned(buf *bytes.Buffer, value uint64) {
+	for {
+		b := byte(value & 0x7F)
+		value >>= 7
+		if value == 0 {
+			buf.WriteByte(b)
+			return
+		}
+		buf.WriteByte(b | 0x80)
+	}
+}
+
+// writeSigned writes a signed LEB128 number.
+func writeSigned(buf *bytes.Buffer, value int64) {
+	for {
+		b := byte(value & 0x7F)
+		value >>= 7
+		if (value == 0 && b&0x40 == 0) || (value == -1 && b&0x40 != 0) {
+			buf.WriteByte(b)
+			return
+		}
+		buf.WriteByte(b | 0x80)
+	}
+}
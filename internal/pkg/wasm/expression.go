@@ -0,0 +1,279 @@
+package wasm
+
+import (
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/ir"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/types"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
+)
+
+var operators = map[ir.Op]string{
+	ir.OP_ADD:  "add",
+	ir.OP_SUB:  "sub",
+	ir.OP_MUL:  "mul",
+	ir.OP_DIV:  "div",
+	ir.OP_POW:  "pow",
+	ir.OP_SHL:  "shl",
+	ir.OP_SHR:  "shr",
+	ir.OP_ASHR: "ashr",
+	ir.OP_CSHL: "cshl",
+	ir.OP_CSHR: "cshr",
+}
+
+var shifts = map[string]bool{
+	"shl":  true,
+	"shr":  true,
+	"ashr": true,
+	"cshl": true,
+	"cshr": true,
+}
+
+var bitwise = map[ir.Op][2]Opcode{
+	ir.OP_AND: {OP_I32_AND, OP_I64_AND},
+	ir.OP_OR:  {OP_I32_OR, OP_I64_OR},
+	ir.OP_XOR: {OP_I32_XOR, OP_I64_XOR},
+}
+
+// The comparisons of signed i32, unsigned i32, signed i64, unsigned i64, f32
+// and f64.
+var comparisons = map[ir.Op][6]Opcode{
+	ir.OP_EQ: {OP_I32_EQ, OP_I32_EQ, OP_I64_EQ, OP_I64_EQ, OP_F32_EQ, OP_F64_EQ},
+	ir.OP_NE: {OP_I32_NE, OP_I32_NE, OP_I64_NE, OP_I64_NE, OP_F32_NE, OP_F64_NE},
+	ir.OP_LT: {OP_I32_LT_S, OP_I32_LT_U, OP_I64_LT_S, OP_I64_LT_U, OP_F32_LT, OP_F64_LT},
+	ir.OP_LE: {OP_I32_LE_S, OP_I32_LE_U, OP_I64_LE_S, OP_I64_LE_U, OP_F32_LE, OP_F64_LE},
+	ir.OP_GT: {OP_I32_GT_S, OP_I32_GT_U, OP_I64_GT_S, OP_I64_GT_U, OP_F32_GT, OP_F64_GT},
+	ir.OP_GE: {OP_I32_GE_S, OP_I32_GE_U, OP_I64_GE_S, OP_I64_GE_U, OP_F32_GE, OP_F64_GE},
+}
+
+/* Instructions */
+
+// statement computes an instruction that is not computed where it is used.
+// Its value is kept in a local if it is used and dropped otherwise.
+func (g *generator) statement(instr *ir.Value) {
+	switch {
+	case g.uses[instr] > 0:
+		g.compute(instr)
+		g.function.op(OP_LOCAL_SET, g.local(instr, "t", g.valType(instr.Type, instr.Pos)))
+	case !pushes(instr.Type):
+		g.compute(instr)
+	case instr.Effect() != ir.PURE:
+		g.compute(instr)
+		g.function.op(OP_DROP)
+	}
+}
+
+// push pushes an operand of an instruction at pos: constants are pushed as
+// they are, deferred instructions are computed in place and all other
+// values are read from their local.
+func (g *generator) push(value *ir.Value, pos util.Position) {
+	switch {
+	case value.Op == ir.OP_CONST:
+		g.literal(value.Const, value.Type, pos)
+	case value.Op == ir.OP_FUNCTION:
+		g.errorf(value.Pos, diagnostics.UNSUPPORTED, "Function values are not supported by the WebAssembly backend")
+	case g.deferred[value]:
+		g.compute(value)
+	default:
+		g.function.op(OP_LOCAL_GET, g.locals[value])
+	}
+}
+
+// compute pushes the operands of an instruction and applies it.
+func (g *generator) compute(instr *ir.Value) {
+	for _, arg := range instr.Args {
+		g.push(arg, instr.Pos)
+	}
+
+	switch instr.Op {
+	case ir.OP_NEG, ir.OP_NOT:
+		if basic, ok := g.number(instr.Args[0].Type, instr.Pos); ok {
+			g.unary(instr.Op, basic)
+		}
+	case ir.OP_CONVERT:
+		g.valType(instr.Type, instr.Pos)
+		g.convert(instr.Args[0].Type, instr.Type)
+	case ir.OP_STRUCT, ir.OP_FIELD, ir.OP_INSERT, ir.OP_IS:
+		g.errorf(instr.Pos, diagnostics.UNSUPPORTED, "Structs are not supported by the WebAssembly backend")
+	case ir.OP_INDEX, ir.OP_SET_INDEX, ir.OP_FORMAT:
+		g.errorf(instr.Pos, diagnostics.UNSUPPORTED, "Byte strings are not supported by the WebAssembly backend")
+	case ir.OP_LOAD:
+		g.valType(instr.Global.Type, instr.Pos)
+		g.function.op(OP_GLOBAL_GET, g.globals[instr.Global])
+	case ir.OP_STORE:
+		g.valType(instr.Global.Type, instr.Pos)
+		g.function.op(OP_GLOBAL_SET, g.globals[instr.Global])
+	case ir.OP_CALL:
+		g.function.op(OP_CALL, g.functions[instr.Function])
+	case ir.OP_CALL_VALUE:
+		g.errorf(instr.Pos, diagnostics.UNSUPPORTED, "Function values are not supported by the WebAssembly backend")
+	case ir.OP_CALL_METHOD:
+		g.errorf(instr.Pos, diagnostics.UNSUPPORTED, "Methods are not supported by the WebAssembly backend")
+	default:
+		if basic, ok := g.number(instr.Args[0].Type, instr.Pos); ok {
+			g.binary(instr.Op, basic, instr.Pos)
+		}
+	}
+}
+
+// number returns the type of the operands of an operator, it reports an
+// error if the type is neither a number nor bool.
+func (g *generator) number(t types.Type, pos util.Position) (*types.Basic, bool) {
+	basic, ok := t.(*types.Basic)
+	if !ok || !(basic.IsNumeric() || basic == types.Bool) {
+		g.valType(t, pos)
+		return nil, false
+	}
+
+	return basic, true
+}
+
+func (g *generator) unary(op ir.Op, basic *types.Basic) {
+	switch {
+	case op == ir.OP_NEG && rep(basic) == F32:
+		g.function.op(OP_F32_NEG)
+	case op == ir.OP_NEG && rep(basic) == F64:
+		g.function.op(OP_F64_NEG)
+	case op == ir.OP_NEG:
+		g.function.op(OP_CALL, g.negHelper(basic))
+	case basic == types.Bool:
+		g.function.op(OP_I32_EQZ)
+	default:
+		// Flipping the bits keeps the representation of unsigned integers
+		// with less than 32 bits by flipping only their own bits
+		bits := int64(-1)
+		if basic.IsUnsigned() && basic.Bits < 32 {
+			bits = mask(basic)
+		}
+		g.function.constant(rep(basic), bits, 0)
+		g.function.op(pick(rep(basic), OP_I32_XOR, OP_I64_XOR))
+	}
+}
+
+// binary applies a comparison, bitwise or arithmetic operator to the two
+// values on the stack. Shifts take an i64 amount.
+func (g *generator) binary(op ir.Op, basic *types.Basic, pos util.Position) {
+	if ops, ok := comparisons[op]; ok {
+		g.function.op(ops[comparison(basic)])
+		return
+	}
+
+	if ops, ok := bitwise[op]; ok {
+		g.function.op(pick(rep(basic), ops[0], ops[1]))
+		return
+	}
+
+	g.arithmetic(operators[op], basic, pos)
+}
+
+// comparison returns the column of a type in the comparisons.
+func comparison(basic *types.Basic) int {
+	switch rep(basic) {
+	case I64:
+		if basic.IsSigned() {
+			return 2
+		}
+		return 3
+	case F32:
+		return 4
+	case F64:
+		return 5
+	}
+
+	if basic.IsSigned() {
+		return 0
+	}
+	return 1
+}
+
+// arithmetic applies an operator to the two values on the stack through
+// its checked helper. Divisions of 64 bit integers trap on their own.
+func (g *generator) arithmetic(operator string, basic *types.Basic, pos util.Position) {
+	switch {
+	case basic.IsFloat() && operator == "pow":
+		g.errorf(pos, diagnostics.UNSUPPORTED, "Powers of floats are not supported by the WebAssembly backend")
+	case basic.IsFloat():
+		g.function.op(OP_CALL, g.floatHelper(operator, basic))
+	case operator == "pow":
+		g.function.op(OP_CALL, g.powHelper(basic))
+	case operator == "div" && basic.Bits == 64 && basic.IsSigned():
+		g.function.op(OP_I64_DIV_S)
+	case operator == "div" && basic.Bits == 64:
+		g.function.op(OP_I64_DIV_U)
+	case shifts[operator]:
+		g.function.op(OP_CALL, g.shiftHelper(operator, basic))
+	default:
+		g.function.op(OP_CALL, g.intHelper(operator, basic))
+	}
+}
+
+/* Control flow */
+
+// copies stores the values a block passes to the phis of a successor.
+func (g *generator) copies(from *ir.Block, to *ir.Block) {
+	for _, instr := range to.Instrs {
+		if instr.Op != ir.OP_PHI {
+			break
+		}
+
+		for idx, block := range instr.Blocks {
+			if block == from {
+				g.push(instr.Args[idx], instr.Pos)
+				g.function.op(OP_LOCAL_SET, g.locals[instr])
+			}
+		}
+	}
+}
+
+// hasPhis reports whether a block starts with phis.
+func hasPhis(block *ir.Block) bool {
+	return len(block.Instrs) > 0 && block.Instrs[0].Op == ir.OP_PHI
+}
+
+// terminator ends a block. Branches to the next block fall through, the
+// value of a return in the last block is left on the stack as the result
+// of the function.
+func (g *generator) terminator(instr *ir.Value, block *ir.Block, last bool, depth func(target *ir.Block) int64) {
+	switch instr.Op {
+	case ir.OP_JUMP:
+		g.copies(block, instr.Blocks[0])
+		if depth(instr.Blocks[0]) > 0 {
+			g.function.op(OP_BR, depth(instr.Blocks[0]))
+		}
+	case ir.OP_BRANCH:
+		then, otherwise := instr.Blocks[0], instr.Blocks[1]
+		g.push(instr.Args[0], instr.Pos)
+
+		switch {
+		case !hasPhis(then) && !hasPhis(otherwise) && depth(then) == 0:
+			g.function.op(OP_I32_EQZ)
+			g.function.op(OP_BR_IF, depth(otherwise))
+		case !hasPhis(then) && !hasPhis(otherwise):
+			g.function.op(OP_BR_IF, depth(then))
+			if depth(otherwise) > 0 {
+				g.function.op(OP_BR, depth(otherwise))
+			}
+		default:
+			// Both edges are nested into an if, which adds a label
+			g.function.block(OP_IF, 0)
+			g.copies(block, then)
+			if depth(then) > 0 {
+				g.function.op(OP_BR, depth(then)+1)
+			}
+			g.function.op(OP_ELSE)
+			g.copies(block, otherwise)
+			if depth(otherwise) > 0 {
+				g.function.op(OP_BR, depth(otherwise)+1)
+			}
+			g.function.op(OP_END)
+		}
+	case ir.OP_RETURN:
+		if len(instr.Args) > 0 {
+			g.push(instr.Args[0], instr.Pos)
+		}
+		if !last {
+			g.function.op(OP_RETURN)
+		}
+	case ir.OP_UNREACHABLE:
+		g.function.op(OP_UNREACHABLE)
+	}
+}
@@ -0,0 +1,407 @@
+package wasm
+
+type ValType byte
+
+const (
+	I32 ValType = 0x7F
+	I64 ValType = 0x7E
+	F32 ValType = 0x7D
+	F64 ValType = 0x7C
+)
+
+func (t ValType) String() string {
+	switch t {
+	case I32:
+		return "i32"
+	case I64:
+		return "i64"
+	case F32:
+		return "f32"
+	case F64:
+		return "f64"
+	}
+
+	return "?"
+}
+
+type FuncType struct {
+	Params  []ValType
+	Results []ValType
+}
+
+// Import is a function the host provides.
+type Import struct {
+	Module string
+	Name   string
+	Type   int
+	// Id is the name of the function in the text format
+	Id string
+}
+
+// Function is a function of the module. Its params are the first of its
+// local names, Locals only lists the other locals.
+type Function struct {
+	Id         string
+	Type       int
+	LocalNames []string
+	Locals     []ValType
+	Body       []Instr
+}
+
+type Global struct {
+	Id   string
+	Type ValType
+	Init Instr
+}
+
+type Export struct {
+	Name     string
+	Function int
+}
+
+// Module is a WebAssembly module of functions and globals. Functions are
+// indexed after the imports, Start is the index of the function run when
+// the module is instantiated or -1.
+type Module struct {
+	Types     []FuncType
+	Imports   []*Import
+	Functions []*Function
+	Globals   []*Global
+	Exports   []Export
+	Start     int
+}
+
+// TypeIndex returns the index of a function type, adding it if it is new.
+func (module *Module) TypeIndex(params []ValType, results []ValType) int {
+	for idx, t := range module.Types {
+		if equal(t.Params, params) && equal(t.Results, results) {
+			return idx
+		}
+	}
+
+	module.Types = append(module.Types, FuncType{params, results})
+	return len(module.Types) - 1
+}
+
+func equal(a []ValType, b []ValType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Id returns the text format name of a function by its index.
+func (module *Module) Id(function int) string {
+	if function < len(module.Imports) {
+		return module.Imports[function].Id
+	}
+
+	return module.Functions[function-len(module.Imports)].Id
+}
+
+/* Instructions */
+
+type Opcode byte
+
+// Instr is an instruction with its immediate: a constant, an index, a label
+// depth or the result type of a block. Blocks have no result if Block is 0.
+type Instr struct {
+	Op    Opcode
+	Int   int64
+	Float float64
+	Block ValType
+}
+
+const (
+	OP_UNREACHABLE Opcode = 0x00
+	OP_BLOCK       Opcode = 0x02
+	OP_LOOP        Opcode = 0x03
+	OP_IF          Opcode = 0x04
+	OP_ELSE        Opcode = 0x05
+	OP_END         Opcode = 0x0B
+	OP_BR          Opcode = 0x0C
+	OP_BR_IF       Opcode = 0x0D
+	OP_RETURN      Opcode = 0x0F
+	OP_CALL        Opcode = 0x10
+	OP_DROP        Opcode = 0x1A
+	OP_SELECT      Opcode = 0x1B
+	OP_LOCAL_GET   Opcode = 0x20
+	OP_LOCAL_SET   Opcode = 0x21
+	OP_LOCAL_TEE   Opcode = 0x22
+	OP_GLOBAL_GET  Opcode = 0x23
+	OP_GLOBAL_SET  Opcode = 0x24
+	OP_I32_CONST   Opcode = 0x41
+	OP_I64_CONST   Opcode = 0x42
+	OP_F32_CONST   Opcode = 0x43
+	OP_F64_CONST   Opcode = 0x44
+
+	OP_I32_EQZ  Opcode = 0x45
+	OP_I32_EQ   Opcode = 0x46
+	OP_I32_NE   Opcode = 0x47
+	OP_I32_LT_S Opcode = 0x48
+	OP_I32_LT_U Opcode = 0x49
+	OP_I32_GT_S Opcode = 0x4A
+	OP_I32_GT_U Opcode = 0x4B
+	OP_I32_LE_S Opcode = 0x4C
+	OP_I32_LE_U Opcode = 0x4D
+	OP_I32_GE_S Opcode = 0x4E
+	OP_I32_GE_U Opcode = 0x4F
+	OP_I64_EQZ  Opcode = 0x50
+	OP_I64_EQ   Opcode = 0x51
+	OP_I64_NE   Opcode = 0x52
+	OP_I64_LT_S Opcode = 0x53
+	OP_I64_LT_U Opcode = 0x54
+	OP_I64_GT_S Opcode = 0x55
+	OP_I64_GT_U Opcode = 0x56
+	OP_I64_LE_S Opcode = 0x57
+	OP_I64_LE_U Opcode = 0x58
+	OP_I64_GE_S Opcode = 0x59
+	OP_I64_GE_U Opcode = 0x5A
+	OP_F32_EQ   Opcode = 0x5B
+	OP_F32_NE   Opcode = 0x5C
+	OP_F32_LT   Opcode = 0x5D
+	OP_F32_GT   Opcode = 0x5E
+	OP_F32_LE   Opcode = 0x5F
+	OP_F32_GE   Opcode = 0x60
+	OP_F64_EQ   Opcode = 0x61
+	OP_F64_NE   Opcode = 0x62
+	OP_F64_LT   Opcode = 0x63
+	OP_F64_GT   Opcode = 0x64
+	OP_F64_LE   Opcode = 0x65
+	OP_F64_GE   Opcode = 0x66
+
+	OP_I32_ADD   Opcode = 0x6A
+	OP_I32_SUB   Opcode = 0x6B
+	OP_I32_MUL   Opcode = 0x6C
+	OP_I32_DIV_S Opcode = 0x6D
+	OP_I32_DIV_U Opcode = 0x6E
+	OP_I32_AND   Opcode = 0x71
+	OP_I32_OR    Opcode = 0x72
+	OP_I32_XOR   Opcode = 0x73
+	OP_I32_SHL   Opcode = 0x74
+	OP_I32_SHR_S Opcode = 0x75
+	OP_I32_SHR_U Opcode = 0x76
+	OP_I32_ROTL  Opcode = 0x77
+	OP_I32_ROTR  Opcode = 0x78
+	OP_I64_ADD   Opcode = 0x7C
+	OP_I64_SUB   Opcode = 0x7D
+	OP_I64_MUL   Opcode = 0x7E
+	OP_I64_DIV_S Opcode = 0x7F
+	OP_I64_DIV_U Opcode = 0x80
+	OP_I64_REM_U Opcode = 0x82
+	OP_I64_AND   Opcode = 0x83
+	OP_I64_OR    Opcode = 0x84
+	OP_I64_XOR   Opcode = 0x85
+	OP_I64_SHL   Opcode = 0x86
+	OP_I64_SHR_S Opcode = 0x87
+	OP_I64_SHR_U Opcode = 0x88
+	OP_I64_ROTL  Opcode = 0x89
+	OP_I64_ROTR  Opcode = 0x8A
+	OP_F32_ABS   Opcode = 0x8B
+	OP_F32_NEG   Opcode = 0x8C
+	OP_F32_ADD   Opcode = 0x92
+	OP_F32_SUB   Opcode = 0x93
+	OP_F32_MUL   Opcode = 0x94
+	OP_F32_DIV   Opcode = 0x95
+	OP_F64_ABS   Opcode = 0x99
+	OP_F64_NEG   Opcode = 0x9A
+	OP_F64_ADD   Opcode = 0xA0
+	OP_F64_SUB   Opcode = 0xA1
+	OP_F64_MUL   Opcode = 0xA2
+	OP_F64_DIV   Opcode = 0xA3
+
+	OP_I32_WRAP_I64      Opcode = 0xA7
+	OP_I64_EXTEND_I32_S  Opcode = 0xAC
+	OP_I64_EXTEND_I32_U  Opcode = 0xAD
+	OP_F32_CONVERT_I32_S Opcode = 0xB2
+	OP_F32_CONVERT_I32_U Opcode = 0xB3
+	OP_F32_CONVERT_I64_S Opcode = 0xB4
+	OP_F32_CONVERT_I64_U Opcode = 0xB5
+	OP_F32_DEMOTE_F64    Opcode = 0xB6
+	OP_F64_CONVERT_I32_S Opcode = 0xB7
+	OP_F64_CONVERT_I32_U Opcode = 0xB8
+	OP_F64_CONVERT_I64_S Opcode = 0xB9
+	OP_F64_CONVERT_I64_U Opcode = 0xBA
+	OP_F64_PROMOTE_F32   Opcode = 0xBB
+	OP_I32_EXTEND8_S     Opcode = 0xC0
+	OP_I32_EXTEND16_S    Opcode = 0xC1
+)
+
+type immediate int
+
+const (
+	NONE immediate = iota
+	BLOCK
+	INDEX
+	I32_CONST
+	I64_CONST
+	F32_CONST
+	F64_CONST
+)
+
+type opcodeInfo struct {
+	name      string
+	immediate immediate
+}
+
+var opcodes = map[Opcode]opcodeInfo{
+	OP_UNREACHABLE: {"unreachable", NONE},
+	OP_BLOCK:       {"block", BLOCK},
+	OP_LOOP:        {"loop", BLOCK},
+	OP_IF:          {"if", BLOCK},
+	OP_ELSE:        {"else", NONE},
+	OP_END:         {"end", NONE},
+	OP_BR:          {"br", INDEX},
+	OP_BR_IF:       {"br_if", INDEX},
+	OP_RETURN:      {"return", NONE},
+	OP_CALL:        {"call", INDEX},
+	OP_DROP:        {"drop", NONE},
+	OP_SELECT:      {"select", NONE},
+	OP_LOCAL_GET:   {"local.get", INDEX},
+	OP_LOCAL_SET:   {"local.set", INDEX},
+	OP_LOCAL_TEE:   {"local.tee", INDEX},
+	OP_GLOBAL_GET:  {"global.get", INDEX},
+	OP_GLOBAL_SET:  {"global.set", INDEX},
+	OP_I32_CONST:   {"i32.const", I32_CONST},
+	OP_I64_CONST:   {"i64.const", I64_CONST},
+	OP_F32_CONST:   {"f32.const", F32_CONST},
+	OP_F64_CONST:   {"f64.const", F64_CONST},
+
+	OP_I32_EQZ:  {"i32.eqz", NONE},
+	OP_I32_EQ:   {"i32.eq", NONE},
+	OP_I32_NE:   {"i32.ne", NONE},
+	OP_I32_LT_S: {"i32.lt_s", NONE},
+	OP_I32_LT_U: {"i32.lt_u", NONE},
+	OP_I32_GT_S: {"i32.gt_s", NONE},
+	OP_I32_GT_U: {"i32.gt_u", NONE},
+	OP_I32_LE_S: {"i32.le_s", NONE},
+	OP_I32_LE_U: {"i32.le_u", NONE},
+	OP_I32_GE_S: {"i32.ge_s", NONE},
+	OP_I32_GE_U: {"i32.ge_u", NONE},
+	OP_I64_EQZ:  {"i64.eqz", NONE},
+	OP_I64_EQ:   {"i64.eq", NONE},
+	OP_I64_NE:   {"i64.ne", NONE},
+	OP_I64_LT_S: {"i64.lt_s", NONE},
+	OP_I64_LT_U: {"i64.lt_u", NONE},
+	OP_I64_GT_S: {"i64.gt_s", NONE},
+	OP_I64_GT_U: {"i64.gt_u", NONE},
+	OP_I64_LE_S: {"i64.le_s", NONE},
+	OP_I64_LE_U: {"i64.le_u", NONE},
+	OP_I64_GE_S: {"i64.ge_s", NONE},
+	OP_I64_GE_U: {"i64.ge_u", NONE},
+	OP_F32_EQ:   {"f32.eq", NONE},
+	OP_F32_NE:   {"f32.ne", NONE},
+	OP_F32_LT:   {"f32.lt", NONE},
+	OP_F32_GT:   {"f32.gt", NONE},
+	OP_F32_LE:   {"f32.le", NONE},
+	OP_F32_GE:   {"f32.ge", NONE},
+	OP_F64_EQ:   {"f64.eq", NONE},
+	OP_F64_NE:   {"f64.ne", NONE},
+	OP_F64_LT:   {"f64.lt", NONE},
+	OP_F64_GT:   {"f64.gt", NONE},
+	OP_F64_LE:   {"f64.le", NONE},
+	OP_F64_GE:   {"f64.ge", NONE},
+
+	OP_I32_ADD:   {"i32.add", NONE},
+	OP_I32_SUB:   {"i32.sub", NONE},
+	OP_I32_MUL:   {"i32.mul", NONE},
+	OP_I32_DIV_S: {"i32.div_s", NONE},
+	OP_I32_DIV_U: {"i32.div_u", NONE},
+	OP_I32_AND:   {"i32.and", NONE},
+	OP_I32_OR:    {"i32.or", NONE},
+	OP_I32_XOR:   {"i32.xor", NONE},
+	OP_I32_SHL:   {"i32.shl", NONE},
+	OP_I32_SHR_S: {"i32.shr_s", NONE},
+	OP_I32_SHR_U: {"i32.shr_u", NONE},
+	OP_I32_ROTL:  {"i32.rotl", NONE},
+	OP_I32_ROTR:  {"i32.rotr", NONE},
+	OP_I64_ADD:   {"i64.add", NONE},
+	OP_I64_SUB:   {"i64.sub", NONE},
+	OP_I64_MUL:   {"i64.mul", NONE},
+	OP_I64_DIV_S: {"i64.div_s", NONE},
+	OP_I64_DIV_U: {"i64.div_u", NONE},
+	OP_I64_REM_U: {"i64.rem_u", NONE},
+	OP_I64_AND:   {"i64.and", NONE},
+	OP_I64_OR:    {"i64.or", NONE},
+	OP_I64_XOR:   {"i64.xor", NONE},
+	OP_I64_SHL:   {"i64.shl", NONE},
+	OP_I64_SHR_S: {"i64.shr_s", NONE},
+	OP_I64_SHR_U: {"i64.shr_u", NONE},
+	OP_I64_ROTL:  {"i64.rotl", NONE},
+	OP_I64_ROTR:  {"i64.rotr", NONE},
+	OP_F32_ABS:   {"f32.abs", NONE},
+	OP_F32_NEG:   {"f32.neg", NONE},
+	OP_F32_ADD:   {"f32.add", NONE},
+	OP_F32_SUB:   {"f32.sub", NONE},
+	OP_F32_MUL:   {"f32.mul", NONE},
+	OP_F32_DIV:   {"f32.div", NONE},
+	OP_F64_ABS:   {"f64.abs", NONE},
+	OP_F64_NEG:   {"f64.neg", NONE},
+	OP_F64_ADD:   {"f64.add", NONE},
+	OP_F64_SUB:   {"f64.sub", NONE},
+	OP_F64_MUL:   {"f64.mul", NONE},
+	OP_F64_DIV:   {"f64.div", NONE},
+
+	OP_I32_WRAP_I64:      {"i32.wrap_i64", NONE},
+	OP_I64_EXTEND_I32_S:  {"i64.extend_i32_s", NONE},
+	OP_I64_EXTEND_I32_U:  {"i64.extend_i32_u", NONE},
+	OP_F32_CONVERT_I32_S: {"f32.convert_i32_s", NONE},
+	OP_F32_CONVERT_I32_U: {"f32.convert_i32_u", NONE},
+	OP_F32_CONVERT_I64_S: {"f32.convert_i64_s", NONE},
+	OP_F32_CONVERT_I64_U: {"f32.convert_i64_u", NONE},
+	OP_F32_DEMOTE_F64:    {"f32.demote_f64", NONE},
+	OP_F64_CONVERT_I32_S: {"f64.convert_i32_s", NONE},
+	OP_F64_CONVERT_I32_U: {"f64.convert_i32_u", NONE},
+	OP_F64_CONVERT_I64_S: {"f64.convert_i64_s", NONE},
+	OP_F64_CONVERT_I64_U: {"f64.convert_i64_u", NONE},
+	OP_F64_PROMOTE_F32:   {"f64.promote_f32", NONE},
+	OP_I32_EXTEND8_S:     {"i32.extend8_s", NONE},
+	OP_I32_EXTEND16_S:    {"i32.extend16_s", NONE},
+}
+
+func (op Opcode) String() string {
+	return opcodes[op].name
+}
+
+/* Building functions */
+
+func (function *Function) op(op Opcode, immediates ...int64) {
+	instr := Instr{Op: op}
+	if len(immediates) > 0 {
+		instr.Int = immediates[0]
+	}
+
+	function.Body = append(function.Body, instr)
+}
+
+func (function *Function) block(op Opcode, result ValType) {
+	function.Body = append(function.Body, Instr{Op: op, Block: result})
+}
+
+// constant pushes a number of any type, integers are given as their bits.
+func (function *Function) constant(t ValType, bits int64, value float64) {
+	switch t {
+	case I32:
+		function.Body = append(function.Body, Instr{Op: OP_I32_CONST, Int: bits})
+	case I64:
+		function.Body = append(function.Body, Instr{Op: OP_I64_CONST, Int: bits})
+	case F32:
+		function.Body = append(function.Body, Instr{Op: OP_F32_CONST, Float: value})
+	case F64:
+		function.Body = append(function.Body, Instr{Op: OP_F64_CONST, Float: value})
+	}
+}
+
+// local adds a local and returns its index.
+func (function *Function) local(name string, t ValType) int64 {
+	function.LocalNames = append(function.LocalNames, name)
+	function.Locals = append(function.Locals, t)
+	return int64(len(function.LocalNames) - 1)
+}
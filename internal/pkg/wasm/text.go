@@ -0,0 +1,151 @@
+package wasm
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Text writes a module in the WebAssembly text format. Functions, locals
+// and globals are referred to by their names.
+func (module *Module) Text() string {
+	var sb strings.Builder
+
+	sb.WriteString("(module\n")
+
+	for idx, t := range module.Types {
+		fmt.Fprintf(&sb, "  (type (;%d;) (func%s))\n", idx, signature(t, nil))
+	}
+
+	for _, imported := range module.Imports {
+		fmt.Fprintf(&sb, "  (import %s %s (func $%s (type %d)%s))\n", quote(imported.Module), quote(imported.Name), imported.Id, imported.Type, signature(module.Types[imported.Type], nil))
+	}
+
+	for _, global := range module.Globals {
+		fmt.Fprintf(&sb, "  (global $%s (mut %s) (%s))\n", global.Id, global.Type, module.instr(global.Init, nil))
+	}
+
+	for _, function := range module.Functions {
+		t := module.Types[function.Type]
+		fmt.Fprintf(&sb, "  (func $%s (type %d)%s\n", function.Id, function.Type, signature(t, function.LocalNames))
+
+		for idx, local := range function.Locals {
+			fmt.Fprintf(&sb, "    (local $%s %s)\n", function.LocalNames[len(t.Params)+idx], local)
+		}
+
+		indent := 2
+		for _, instr := range function.Body {
+			if instr.Op == OP_END || instr.Op == OP_ELSE {
+				indent--
+			}
+
+			sb.WriteString(strings.Repeat("  ", indent))
+			sb.WriteString(module.instr(instr, function))
+			sb.WriteString("\n")
+
+			switch instr.Op {
+			case OP_BLOCK, OP_LOOP, OP_IF, OP_ELSE:
+				indent++
+			}
+		}
+		sb.WriteString("  )\n")
+	}
+
+	for _, export := range module.Exports {
+		fmt.Fprintf(&sb, "  (export %s (func $%s))\n", quote(export.Name), module.Id(export.Function))
+	}
+
+	if module.Start >= 0 {
+		fmt.Fprintf(&sb, "  (start $%s)\n", module.Id(module.Start))
+	}
+
+	sb.WriteString(")\n")
+	return sb.String()
+}
+
+// signature writes params and results, naming the params if names are
+// given.
+func signature(t FuncType, names []string) string {
+	var sb strings.Builder
+
+	for idx, param := range t.Params {
+		if names != nil {
+			fmt.Fprintf(&sb, " (param $%s %s)", names[idx], param)
+		} else {
+			fmt.Fprintf(&sb, " (param %s)", param)
+		}
+	}
+
+	for _, result := range t.Results {
+		fmt.Fprintf(&sb, " (result %s)", result)
+	}
+
+	return sb.String()
+}
+
+func (module *Module) instr(instr Instr, function *Function) string {
+	name := instr.Op.String()
+
+	switch opcodes[instr.Op].immediate {
+	case BLOCK:
+		if instr.Block != 0 {
+			return name + " (result " + instr.Block.String() + ")"
+		}
+	case INDEX:
+		switch instr.Op {
+		case OP_CALL:
+			return name + " $" + module.Id(int(instr.Int))
+		case OP_LOCAL_GET, OP_LOCAL_SET, OP_LOCAL_TEE:
+			return name + " $" + function.LocalNames[instr.Int]
+		case OP_GLOBAL_GET, OP_GLOBAL_SET:
+			return name + " $" + module.Globals[instr.Int].Id
+		}
+		return name + " " + strconv.FormatInt(instr.Int, 10)
+	case I32_CONST:
+		return name + " " + strconv.FormatInt(int64(int32(instr.Int)), 10)
+	case I64_CONST:
+		return name + " " + strconv.FormatInt(instr.Int, 10)
+	case F32_CONST:
+		return name + " " + float(instr.Float, 32)
+	case F64_CONST:
+		return name + " " + float(instr.Float, 64)
+	}
+
+	return name
+}
+
+func float(value float64, bits int) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "inf"
+	case math.IsInf(value, -1):
+		return "-inf"
+	case math.IsNaN(value):
+		return "nan"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, bits)
+}
+
+// quote writes a string, escaping everything that is not printable ASCII.
+func quote(value string) string {
+	var sb strings.Builder
+
+	sb.WriteByte('"')
+	for idx := 0; idx < len(value); idx++ {
+		c := value[idx]
+		switch {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c >= 0x20 && c < 0x7F:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "\\%02x", c)
+		}
+	}
+	sb.WriteByte('"')
+
+	return sb.String()
+}
@@ -0,0 +1,409 @@
+package wasm
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/consteval"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/ir"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/types"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/util"
+)
+
+// The module external functions are imported from.
+const IMPORT_MODULE = "env"
+
+type generator struct {
+	program *ir.Program
+	module  *Module
+
+	// Function indices of the functions of the program and helpers, global
+	// indices of the global bindings
+	functions map[*ir.Function]int64
+	helpers   map[string]int64
+	globals   map[*ir.Global]int64
+	taken     map[string]bool
+
+	// The function that is generated, the local indices of its values and
+	// the values that are computed where they are used
+	function *Function
+	locals   map[*ir.Value]int64
+	counts   map[string]int
+	uses     map[*ir.Value]int
+	deferred map[*ir.Value]bool
+
+	errors []diagnostics.Diagnostic
+	failed map[util.Position]bool
+}
+
+// Generate translates the IR of a program into a WebAssembly module.
+// Numbers map to the number types of WebAssembly, bool and integers with less
+// than 32 bits are i32s. External functions are imported from the env module,
+// public functions and main are exported and the initialiser of the global
+// bindings is the start function. Structs, traits, sym, bin and function
+// values have no representation yet and are reported as errors where they
+// are used. Functions that main, the public functions and the initialiser
+// never call are left out.
+func Generate(program *ir.Program) (*Module, []diagnostics.Diagnostic) {
+	g := generator{
+		program:   program,
+		module:    &Module{Start: -1},
+		functions: map[*ir.Function]int64{},
+		helpers:   map[string]int64{},
+		globals:   map[*ir.Global]int64{},
+		taken:     map[string]bool{},
+		failed:    map[util.Position]bool{},
+	}
+
+	for _, global := range program.Globals {
+		t, _ := represent(global.Type)
+		g.globals[global] = int64(len(g.module.Globals))
+		g.module.Globals = append(g.module.Globals, &Global{g.unique(global.Name), t, zero(t)})
+	}
+
+	var functions []*ir.Function
+	used := used(program)
+	for _, function := range program.Functions {
+		switch {
+		case !used[function]:
+		case function.External:
+			g.functions[function] = int64(len(g.module.Imports))
+			g.module.Imports = append(g.module.Imports, &Import{IMPORT_MODULE, function.Decl.Identifer.Name, g.signature(function), g.unique(function.Name)})
+		case function != program.Init:
+			functions = append(functions, function)
+		}
+	}
+
+	for _, function := range functions {
+		g.functions[function] = int64(len(g.module.Imports) + len(g.module.Functions))
+		g.module.Functions = append(g.module.Functions, &Function{Id: g.unique(function.Name), Type: g.signature(function)})
+
+		switch {
+		case function.Public:
+			g.module.Exports = append(g.module.Exports, Export{function.Name, int(g.functions[function])})
+		case function == program.Main:
+			g.module.Exports = append(g.module.Exports, Export{"main", int(g.functions[function])})
+		}
+	}
+
+	for _, function := range functions {
+		g.generateFunction(function, g.module.Functions[g.functions[function]-int64(len(g.module.Imports))])
+	}
+	g.generateInit()
+
+	return g.module, g.errors
+}
+
+// used returns the functions that are generated: main, the public
+// functions, the initialiser of the global bindings and every function they
+// call, so functions and methods that are never called cannot fail a module.
+func used(program *ir.Program) map[*ir.Function]bool {
+	used := map[*ir.Function]bool{}
+	var queue []*ir.Function
+	visit := func(function *ir.Function) {
+		if function != nil && !used[function] {
+			used[function] = true
+			queue = append(queue, function)
+		}
+	}
+
+	visit(program.Main)
+	visit(program.Init)
+	for _, function := range program.Functions {
+		if function.Public {
+			visit(function)
+		}
+	}
+
+	for len(queue) > 0 {
+		function := queue[0]
+		queue = queue[1:]
+
+		for _, block := range function.Blocks {
+			for _, instr := range block.Instrs {
+				if instr.Op == ir.OP_CALL {
+					visit(instr.Function)
+				}
+				for _, arg := range instr.Args {
+					if arg.Op == ir.OP_FUNCTION {
+						visit(arg.Function)
+					}
+				}
+			}
+		}
+	}
+
+	return used
+}
+
+// errorf reports an error, only the first error at a position is reported
+// as the others follow from it.
+func (g *generator) errorf(pos util.Position, code string, format string, a ...any) {
+	if g.failed[pos] {
+		return
+	}
+
+	g.failed[pos] = true
+	g.errors = append(g.errors, diagnostics.Errorf(pos, code, format, a...))
+}
+
+// unique returns a name for the text format that is not used yet.
+func (g *generator) unique(name string) string {
+	name = textName(name)
+
+	candidate := name
+	for idx := 2; g.taken[candidate]; idx++ {
+		candidate = name + "_" + strconv.Itoa(idx)
+	}
+
+	g.taken[candidate] = true
+	return candidate
+}
+
+// textName replaces the characters that cannot be part of a name in the
+// text format.
+func textName(name string) string {
+	return strings.Map(func(c rune) rune {
+		if c > ' ' && c < 0x7F && !strings.ContainsRune("\"(),;[]{}", c) {
+			return c
+		}
+		return '_'
+	}, name)
+}
+
+/* Types */
+
+// rep returns the value type that represents a primitive type.
+func rep(basic *types.Basic) ValType {
+	switch {
+	case basic.Kind == types.KIND_F32:
+		return F32
+	case basic.IsFloat():
+		return F64
+	case basic.IsInteger() && basic.Bits == 64:
+		return I64
+	}
+
+	return I32
+}
+
+// represent returns the value type of a type, or 0 if it has no value. It
+// reports false for types without a representation, whose values are i32s.
+func represent(t types.Type) (ValType, bool) {
+	if !pushes(t) {
+		return 0, true
+	}
+
+	if basic, ok := t.(*types.Basic); ok && (basic.IsNumeric() || basic == types.Bool || basic == types.Nil) {
+		return rep(basic), true
+	}
+
+	return I32, false
+}
+
+// valType returns the value type of a type and reports an error at the
+// position of its use if it has no representation.
+func (g *generator) valType(t types.Type, pos util.Position) ValType {
+	valType, ok := represent(t)
+	if !ok {
+		g.errorf(pos, diagnostics.UNSUPPORTED, "Type %s is not supported by the WebAssembly backend", t)
+	}
+
+	return valType
+}
+
+func pushes(t types.Type) bool {
+	return t != nil && t != types.Void
+}
+
+func zero(t ValType) Instr {
+	switch t {
+	case I64:
+		return Instr{Op: OP_I64_CONST}
+	case F32:
+		return Instr{Op: OP_F32_CONST}
+	case F64:
+		return Instr{Op: OP_F64_CONST}
+	}
+
+	return Instr{Op: OP_I32_CONST}
+}
+
+// signature returns the index of the function type of a function.
+func (g *generator) signature(function *ir.Function) int {
+	var params []ValType
+	for _, param := range function.Params {
+		params = append(params, g.valType(param.Type, param.Pos))
+	}
+
+	var results []ValType
+	if result := g.valType(function.Result, function.Decl.Identifer.Pos); result != 0 {
+		results = append(results, result)
+	}
+
+	return g.module.TypeIndex(params, results)
+}
+
+/* Functions */
+
+// local adds a local of the current function for a value.
+func (g *generator) local(value *ir.Value, name string, t ValType) int64 {
+	name = textName(name)
+	g.counts[name]++
+	if g.counts[name] > 1 {
+		name += "_" + strconv.Itoa(g.counts[name])
+	}
+
+	g.locals[value] = g.function.local(name, t)
+	return g.locals[value]
+}
+
+// generateFunction writes the blocks of a function in reverse postorder.
+// Every block but the first is the end of a wasm block that encloses the
+// blocks before it, so branches to it leave that wasm block. As there are
+// no loops, all branches go to later blocks.
+func (g *generator) generateFunction(decl *ir.Function, function *Function) {
+	g.function = function
+	g.locals = map[*ir.Value]int64{}
+	g.counts = map[string]int{}
+	g.uses = decl.Uses()
+	g.deferred = decl.Deferred(nil)
+
+	// Parameters are the first locals, they are declared by the signature
+	for _, param := range decl.Params {
+		name := textName(param.Name)
+		if g.counts[name]++; g.counts[name] > 1 {
+			name += "_" + strconv.Itoa(g.counts[name])
+		}
+
+		g.locals[param] = int64(len(function.LocalNames))
+		function.LocalNames = append(function.LocalNames, name)
+	}
+
+	order := decl.Order()
+	index := map[*ir.Block]int{}
+	for idx, block := range order {
+		index[block] = idx
+		for _, instr := range block.Instrs {
+			if instr.Op == ir.OP_PHI {
+				g.local(instr, "t", g.valType(instr.Type, instr.Pos))
+			}
+		}
+	}
+
+	for range len(order) - 1 {
+		function.block(OP_BLOCK, 0)
+	}
+
+	for idx, block := range order {
+		if idx > 0 {
+			function.op(OP_END)
+		}
+
+		// depth returns the label depth of a branch to a later block
+		depth := func(target *ir.Block) int64 {
+			return int64(index[target] - idx - 1)
+		}
+
+		for _, instr := range block.Instrs {
+			switch {
+			case instr.Op == ir.OP_PHI || g.deferred[instr]:
+			case instr.IsTerminator():
+				g.terminator(instr, block, idx == len(order)-1, depth)
+			default:
+				g.statement(instr)
+			}
+		}
+	}
+}
+
+// generateInit stores the values of the global bindings in the start
+// function, if there are any.
+func (g *generator) generateInit() {
+	function := &Function{Id: g.unique(g.program.Init.Name), Type: g.module.TypeIndex(nil, nil)}
+	g.generateFunction(g.program.Init, function)
+
+	if len(function.Body) == 0 {
+		return
+	}
+
+	g.module.Start = len(g.module.Imports) + len(g.module.Functions)
+	g.module.Functions = append(g.module.Functions, function)
+}
+
+/* Values */
+
+// literal pushes a constant as the type the type checker gave it.
+func (g *generator) literal(constant consteval.Value, t types.Type, pos util.Position) {
+	basic, ok := t.(*types.Basic)
+	if !ok || basic == types.Nil {
+		g.function.constant(I32, 0, 0)
+		return
+	}
+
+	if constant.Type.IsUntyped() && basic.IsNumeric() && !basic.IsUntyped() {
+		converted, err := consteval.Convert(constant, basic)
+		if err != nil {
+			g.errorf(pos, diagnostics.CodeOf(err), "%s", err)
+			g.function.constant(rep(basic), 0, 0)
+			return
+		}
+		constant = converted
+	}
+
+	switch {
+	case basic.IsSigned():
+		g.function.constant(rep(basic), constant.Int.Int64(), 0)
+	case basic.IsInteger():
+		g.function.constant(rep(basic), int64(constant.Int.Uint64()), 0)
+	case basic.IsFloat():
+		g.function.constant(rep(basic), 0, constant.Float)
+	case basic == types.Bool:
+		if constant.Bool {
+			g.function.constant(I32, 1, 0)
+		} else {
+			g.function.constant(I32, 0, 0)
+		}
+	default:
+		g.valType(basic, pos)
+		g.function.constant(I32, 0, 0)
+	}
+}
+
+// convert converts the number on top of the stack to another type.
+func (g *generator) convert(from types.Type, to types.Type) {
+	source, ok := from.(*types.Basic)
+	target, isBasic := to.(*types.Basic)
+	if !ok || !isBasic || from == to || !source.IsNumeric() {
+		return
+	}
+
+	switch {
+	case target.IsFloat() && source.IsInteger():
+		ops := map[[2]ValType][2]Opcode{
+			{I32, F32}: {OP_F32_CONVERT_I32_S, OP_F32_CONVERT_I32_U},
+			{I64, F32}: {OP_F32_CONVERT_I64_S, OP_F32_CONVERT_I64_U},
+			{I32, F64}: {OP_F64_CONVERT_I32_S, OP_F64_CONVERT_I32_U},
+			{I64, F64}: {OP_F64_CONVERT_I64_S, OP_F64_CONVERT_I64_U},
+		}[[2]ValType{rep(source), rep(target)}]
+
+		if source.IsSigned() {
+			g.function.op(ops[0])
+		} else {
+			g.function.op(ops[1])
+		}
+	case rep(source) == F32 && rep(target) == F64:
+		g.function.op(OP_F64_PROMOTE_F32)
+	case rep(source) == F64 && rep(target) == F32:
+		g.function.op(OP_F32_DEMOTE_F64)
+	case rep(source) == I64 && rep(target) == I32:
+		g.function.op(OP_I32_WRAP_I64)
+	case rep(source) == I32 && rep(target) == I64:
+		if source.IsSigned() {
+			g.function.op(OP_I64_EXTEND_I32_S)
+		} else {
+			g.function.op(OP_I64_EXTEND_I32_U)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package wasm_test
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/diagnostics"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/testutil"
+	"github.com/henryk-kramer/quartz-lang/internal/pkg/wasm"
+)
+
+type testStruct struct {
+	input string
+	want  string
+}
+
+// generate generates the module of the input after running the
+// optimisations of the given level on its IR.
+func generate(t *testing.T, input string, level int) (*wasm.Module, []diagnostics.Diagnostic) {
+	return wasm.Generate(testutil.Build(t, level, input))
+}
+
+// The host instantiates the module with external functions that return
+// their first argument and prints the result of main or the trap.
+const host = `
+const fs = require("fs");
+const env = new Proxy({}, { get: () => (...args) => args[0] });
+try {
+	const instance = new WebAssembly.Instance(new WebAssembly.Module(fs.readFileSync(process.argv[1])), { env });
+	console.log(String(instance.exports.main()));
+} catch (err) {
+	console.log(err instanceof WebAssembly.RuntimeError ? "trap: " + err.message : String(err));
+}
+`
+
+// run runs the binary module with node and returns the result of main or
+// the trap it stopped with.
+func run(t *testing.T, input string, level int) string {
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node is not installed")
+	}
+
+	module, errors := generate(t, input, level)
+	if len(errors) > 0 {
+		t.Fatalf("unexpected generator errors: %s", errors)
+	}
+
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "main.wasm")
+	if err := os.WriteFile(binary, module.Binary(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := exec.Command(node, "-e", host, binary).CombinedOutput()
+	if err != nil {
+		t.Fatalf("node failed: %s\n%s", err, output)
+	}
+
+	return strings.TrimSpace(string(output))
+}
+
+func testHelper(t *testing.T, tests []testStruct) {
+	for _, level := range []int{0, 2} {
+		for _, test := range tests {
+			t.Run(fmt.Sprintf("O%d/%s", level, test.input), func(t *testing.T) {
+				if actual := run(t, test.input, level); actual != test.want {
+					t.Errorf("expected %q but got %q", test.want, actual)
+				}
+			})
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	module, errors := generate(t, "ext fn abs(a: i32) -> i32\npub fn add(a: u32, b: u32) -> u32 { a + b }\nfn twice(x: f64) -> f64 { x * 2 }\nfn unused() -> i32 { 1 }\nfn main() -> f64 {\n\tabs(1)\n\ttwice(1.5)\n}", 0)
+	if len(errors) > 0 {
+		t.Fatalf("unexpected generator errors: %s", errors)
+	}
+	text := module.Text()
+
+	for _, want := range []string{
+		"(import \"env\" \"abs\" (func $abs (type 0) (param i32) (result i32)))",
+		"(func $add (type 1) (param $a i32) (param $b i32) (result i32)\n    local.get $a\n    local.get $b\n    call $add_u32\n  )",
+		"(func $twice (type 2) (param $x f64) (result f64)\n    local.get $x\n    f64.const 2\n    call $mul_f64\n  )",
+		"(export \"add\" (func $add))",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in the text\n%s", want, text)
+		}
+	}
+
+	if strings.Contains(text, "(export \"twice\"") {
+		t.Errorf("expected private functions not to be exported")
+	}
+
+	if strings.Contains(text, "$unused") {
+		t.Errorf("expected functions that are never called not to be generated")
+	}
+
+	if binary := module.Binary(); !strings.HasPrefix(string(binary), "\x00asm\x01\x00\x00\x00") {
+		t.Errorf("expected the binary to start with the header, got % x", binary[:8])
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	tests := []testStruct{
+		{"struct P { x: i32 }\npub fn f(p: P) -> i32 { p.x }", "main.ql:2:10: Type P is not supported by the WebAssembly backend"},
+		{"pub fn f(b: bin) -> u8 { b[0] }", "main.ql:1:10: Type bin is not supported by the WebAssembly backend"},
+		{"struct P { x: i32 }\nimpl P {\n\tfn get(self) -> i32 { self.x }\n}\nfn main() -> i32 { P { x: 1 }.get() }", "main.ql:3:9: Type P is not supported by the WebAssembly backend"},
+		{"pub fn f(x: f64) -> f64 { x ^ 2.0 }", "main.ql:1:29: Powers of floats are not supported by the WebAssembly backend"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			_, errors := generate(t, test.input, 0)
+			if len(errors) == 0 || errors[0].Error() != test.want {
+				t.Errorf("expected %q but got %v", test.want, errors)
+			}
+		})
+	}
+}
+
+func TestExpressions(t *testing.T) {
+	testHelper(t, []testStruct{
+		{"fn main() -> i64 { 1 + 2 * 3 }", "7"},
+		{"fn main() -> u8 {\n\tlet a: u8 = 200\n\ta + 55\n}", "255"},
+		{"fn main() -> i64 {\n\tlet a: i8 = -5\n\tlet b: i64 = 3\n\ta * b + 20\n}", "5"},
+		{"fn main() -> i32 {\n\tlet a: num = 7.5\n\tif a / 2 > 3.7 { 1 } else { 0 }\n}", "1"},
+		{"fn main() -> f32 {\n\tlet a: f32 = 1.5\n\ta * a\n}", "2.25"},
+		{"fn main() -> u32 {\n\tlet a: u32 = 4000000000\n\ta / 3\n}", "1333333333"},
+		{"fn main() -> u8 {\n\tlet a: u8 = 1\n\ta cshr 1\n}", "128"},
+		{"fn main() -> i8 {\n\tlet a: i8 = -128\n\t(a ashr 2) + 40\n}", "8"},
+		{"fn main() -> i8 {\n\tlet a: i8 = -1\n\ta shr 1\n}", "127"},
+		{"fn main() -> i16 {\n\tlet a: i16 = 3\n\ta shl 13\n}", "24576"},
+		{"fn main() -> u64 {\n\tlet a: u64 = 1\n\ta cshl 65\n}", "2"},
+		{"fn main() -> u8 {\n\tlet a: u8 = 5\n\tnot a\n}", "250"},
+		{"fn main() -> i64 {\n\tlet a: i64 = 3\n\ta ^ 4\n}", "81"},
+		{"fn main() -> i32 {\n\tlet a: i32 = 5\n\t-a\n}", "-5"},
+		{"ext fn id(a: i32) -> i32\nfn main() -> i32 { id(41) + 1 }", "42"},
+		{"const N: u8 = 7\nlet! g = N * 2\nfn main() -> u8 {\n\tg = g + 1\n\tg + N\n}", "22"},
+
+		// Traps
+		{"fn main() -> u8 {\n\tlet a: u8 = 200\n\ta + 56\n}", "trap: unreachable"},
+		{"fn main() -> i32 {\n\tlet a: i32 = 2147483647\n\ta + 1\n}", "trap: unreachable"},
+		{"fn main() -> i64 {\n\tlet a: i64 = 9223372036854775807\n\ta + 1\n}", "trap: unreachable"},
+		{"fn main() -> u64 {\n\tlet a: u64 = 4294967296\n\ta * a\n}", "trap: unreachable"},
+		{"fn main() -> i8 {\n\tlet a: i8 = 64\n\ta shl 1\n}", "trap: unreachable"},
+		{"fn main() -> i64 {\n\tlet a = 0\n\t1 / a\n}", "trap: divide by zero"},
+	})
+}
+
+func TestControlFlow(t *testing.T) {
+	testHelper(t, []testStruct{
+		{"fn main() -> i64 {\n\tlet a = 5\n\tcond {\n\t\ta < 3 -> 1\n\t\ta < 10 -> 2\n\t\telse -> 3\n\t}\n}", "2"},
+		{"fn main() -> i64 {\n\tlet a = -7\n\tcase a {\n\t\t0 -> 1\n\t\t-7 -> 2\n\t\tn -> 3\n\t}\n}", "2"},
+		{"fn main() -> i64 {\n\tlet a = 9\n\tcase a {\n\t\t0 -> 1\n\t\tn -> n * 2\n\t}\n}", "18"},
+		{"fn main() -> i32 {\n\tlet a: i32 = 4\n\tcase a {\n\t\t0 -> 0\n\t\tn -> n + 1\n\t}\n}", "5"},
+		{"fn f(n: i64) -> i64 {\n\tif n < 2 {\n\t\treturn n\n\t}\n\tf(n - 1) + f(n - 2)\n}\nfn main() -> i64 { f(12) }", "144"},
+		{"fn main() -> i32 {\n\tlet a = true\n\tif a and not false { 1 } else { 0 }\n}", "1"},
+		{"fn main() -> i32 {\n\tlet a: i32 = 0\n\tif a != 0 && 1 / a > 0 { 1 } else { 2 }\n}", "2"},
+		{"let! g: i64 = 1\nfn main() -> i64 {\n\tlet a = 5\n\tlet b = if a > 3 {\n\t\tg = 10\n\t\ta\n\t} else { 0 }\n\tb + g\n}", "15"},
+	})
+}
+
+func TestStructs(t *testing.T) {
+	shapes := "struct P { x: i32, y: i32 }\ntrait Shape {\n\tfn area(self) -> i32\n\tfn sides(self) -> i32 { 4 }\n}\nimpl Shape for P {\n\tfn area(self) -> i32 { self.x * self.y }\n}\n"
+
+	testHelper(t, []testStruct{
+		{shapes + "fn main() -> i32 { 3 }", "3"},
+		{shapes + "fn unused(p: P) -> i32 { p.x }\nfn main() -> i32 { 3 }", "3"},
+		{shapes + "impl P {\n\tfn square(n: i32) -> i32 { n * n }\n}\nfn main() -> i32 { P.square(5) }", "25"},
+	})
+}